@@ -0,0 +1,66 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterImageBuilderSpec defines the desired state of ClusterImageBuilder
+type ClusterImageBuilderSpec struct {
+	ImageBuilderSpec `json:",inline"`
+	// Namespace runs the composer virtual machine and holds the subscription
+	// secret, tenants do not need any access to it
+	//+kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+}
+
+// ClusterImageBuilderStatus defines the observed state of ClusterImageBuilder
+type ClusterImageBuilderStatus struct {
+	// ImageBuilder is the namespaced ImageBuilder running the composer of this
+	// cluster builder
+	//+optional
+	ImageBuilder string `json:"imageBuilder,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster,categories=osbuild,shortName=cib
+//+kubebuilder:printcolumn:name="Namespace",type="string",JSONPath=".spec.namespace"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ClusterImageBuilder is a cluster-scoped builder any namespace may target
+type ClusterImageBuilder struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterImageBuilderSpec   `json:"spec,omitempty"`
+	Status ClusterImageBuilderStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterImageBuilderList contains a list of ClusterImageBuilder
+type ClusterImageBuilderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterImageBuilder `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterImageBuilder{}, &ClusterImageBuilderList{})
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// Condition types reported on ImageBuilderImage
+const (
+	// ConditionReady is True once the image has been built successfully, so
+	// `kubectl wait --for=condition=Ready` can be used to block on a build
+	ConditionReady = "Ready"
+	// ConditionFailed is True once the build reached a terminal failure and
+	// will not make any more progress on its own
+	ConditionFailed = "Failed"
+	// ConditionQuotaExceeded is True while a new build is held back by the
+	// ImageBuilderPolicy quotas of the namespace, its reason names the quota
+	ConditionQuotaExceeded = "QuotaExceeded"
+	// ConditionBuilderSelectionFailed is True while no single ImageBuilder
+	// can be selected for the image, its message lists the candidates
+	ConditionBuilderSelectionFailed = "BuilderSelectionFailed"
+	// ConditionResourceConflict is True while a generated resource has fields
+	// owned by another field manager, its message lists them
+	ConditionResourceConflict = "ResourceConflict"
+)
+
+// Condition types reported on ImageBuilder, on top of Ready
+const (
+	// ConditionUpgrading is True while composer is being upgraded, the
+	// builder not starting new builds
+	ConditionUpgrading = "Upgrading"
+	// ConditionComposerReachable is True while composer answers its
+	// /api/status endpoint
+	ConditionComposerReachable = "ComposerReachable"
+)
+
+// Reasons of the Ready, ComposerReachable and Upgrading conditions of
+// ImageBuilder
+const (
+	// ReasonComposerReady means composer serves its API
+	ReasonComposerReady = "ComposerReady"
+	// ReasonComposerResponding means composer answered the last probe
+	ReasonComposerResponding = "ComposerResponding"
+	// ReasonComposerUnavailable means composer does not answer yet
+	ReasonComposerUnavailable = "ComposerUnavailable"
+	// ReasonDraining means the builder waits for the in-flight composes to
+	// finish before composer is upgraded
+	ReasonDraining = "Draining"
+	// ReasonRollingComposer means the builder is recreated with the new
+	// version of composer
+	ReasonRollingComposer = "RollingComposer"
+	// ReasonUpgradeSucceeded means composer runs the requested version
+	ReasonUpgradeSucceeded = "UpgradeSucceeded"
+	// ReasonComposerDeploying means the Deployment of composer is not
+	// available yet
+	ReasonComposerDeploying = "ComposerDeploying"
+	// ReasonArchitectureMismatch means the virtual machine runs on a node of
+	// another architecture than spec.architecture
+	ReasonArchitectureMismatch = "ArchitectureMismatch"
+)
+
+// Reasons of the Ready condition of ImageBuilderSource
+const (
+	// ReasonSourcePushed means every builder of the namespace has the source
+	ReasonSourcePushed = "SourcePushed"
+	// ReasonSourceRejected means composer refused the source, also failing
+	// the builds of the images using it
+	ReasonSourceRejected = "SourceRejected"
+	// ReasonNoBuilders means the namespace has no ImageBuilder to push the
+	// source to
+	ReasonNoBuilders = "NoBuilders"
+)
+
+// Reasons of the Ready and Failed conditions, while the build makes progress
+const (
+	// ReasonWaitingForBuilder means the selected ImageBuilder does not serve
+	// the composer API yet
+	ReasonWaitingForBuilder = "WaitingForBuilder"
+	// ReasonWaitingForVolume means the shared volume of the build does not exist
+	ReasonWaitingForVolume = "WaitingForVolume"
+	// ReasonPipelineRunPending means the PipelineRun was created but not started
+	ReasonPipelineRunPending = "PipelineRunPending"
+	// ReasonJobSuspended means the build Job was created but not resumed
+	ReasonJobSuspended = "JobSuspended"
+	// ReasonBuildRunning means the PipelineRun or Job is running
+	ReasonBuildRunning = "BuildRunning"
+	// ReasonQuotaExceeded means the build is held back by a quota
+	ReasonQuotaExceeded = "QuotaExceeded"
+	// ReasonBuildSuspended means no new build is created while spec.suspend
+	// is set
+	ReasonBuildSuspended = "BuildSuspended"
+	// ReasonBuildQueued means the build waits for its builder, which runs as
+	// many builds as it may
+	ReasonBuildQueued = "BuildQueued"
+	// ReasonBuilderSelectionFailed means no ImageBuilder could be selected,
+	// see the BuilderSelectionFailed condition
+	ReasonBuilderSelectionFailed = "BuilderSelectionFailed"
+	// ReasonWaitingForTemplate means the ConfigMap or Secret holding a
+	// blueprint template does not exist yet
+	ReasonWaitingForTemplate = "WaitingForTemplate"
+	// ReasonDryRun means the blueprints and the resources of the build were
+	// rendered in status.plan but no build was started
+	ReasonDryRun = "DryRun"
+	// ReasonWaitingForParent means the image of spec.ostree.parentImage did
+	// not publish a commit yet
+	ReasonWaitingForParent = "WaitingForParent"
+	// ReasonWaitingForSource means an ImageBuilderSource of spec.repositories
+	// does not exist yet
+	ReasonWaitingForSource = "WaitingForSource"
+	// ReasonWaitingForFDOServer means spec.fdo.manufacturingServerSelector
+	// does not select exactly one Service
+	ReasonWaitingForFDOServer = "WaitingForFDOServer"
+	// ReasonWaitingForArchitectures means the images building
+	// spec.architectures did not all start building
+	ReasonWaitingForArchitectures = "WaitingForArchitectures"
+	// ReasonWaitingForPullSecret means the pull Secret of an embedded
+	// container does not exist yet, or holds no registry credentials
+	ReasonWaitingForPullSecret = "WaitingForPullSecret"
+)
+
+// Reasons of the Ready and Failed conditions, once the build is done
+const (
+	// ReasonBuildSucceeded means the image was built and its artifacts served
+	ReasonBuildSucceeded = "BuildSucceeded"
+	// ReasonBuildFailed means the build failed outside of the compose
+	ReasonBuildFailed = "BuildFailed"
+	// ReasonDepsolveFailed means composer could not resolve the packages of
+	// a blueprint
+	ReasonDepsolveFailed = "DepsolveFailed"
+	// ReasonComposeFailed means composer failed to build the image
+	ReasonComposeFailed = "ComposeFailed"
+	// ReasonUploadFailed means the artifacts could not be downloaded from
+	// composer to the shared volume
+	ReasonUploadFailed = "UploadFailed"
+	// ReasonBuildCancelled means the PipelineRun was cancelled
+	ReasonBuildCancelled = "BuildCancelled"
+	// ReasonBuildTimedOut means the build, or one of its steps, ran longer
+	// than its timeout
+	ReasonBuildTimedOut = "BuildTimedOut"
+)
+
+// Reasons of the Ready and Failed conditions, when the spec can not be built
+// as is
+const (
+	// ReasonSpecInvalid means the spec did not pass validation
+	ReasonSpecInvalid = "SpecInvalid"
+	// ReasonBlueprintInvalid means a rendered blueprint is not valid TOML
+	ReasonBlueprintInvalid = "BlueprintInvalid"
+	// ReasonInvalidResourceName means the name template produced invalid names
+	ReasonInvalidResourceName = "InvalidResourceName"
+	// ReasonNameCollision means a generated name belongs to another resource
+	ReasonNameCollision = "NameCollision"
+	// ReasonBuilderNotAllowed means the ImageBuilder refuses the namespace
+	ReasonBuilderNotAllowed = "BuilderNotAllowed"
+	// ReasonComposeTypeUnsupported means composer on the ImageBuilder does
+	// not build spec.composeType
+	ReasonComposeTypeUnsupported = "ComposeTypeUnsupported"
+	// ReasonRegistryAuthUnsupported means an embedded container has a pull
+	// Secret but the ImageBuilder runs composer in a virtual machine
+	ReasonRegistryAuthUnsupported = "RegistryAuthUnsupported"
+	// ReasonExecutorUnavailable means spec.executor is tekton but Tekton is
+	// not installed in the cluster, or the build needs a Tekton feature the
+	// job executor does not have
+	ReasonExecutorUnavailable = "ExecutorUnavailable"
+	// ReasonResourceConflict means a generated resource was modified by
+	// someone else, see the ResourceConflict condition
+	ReasonResourceConflict = "ResourceConflict"
+)
+
+// Reasons of the BuilderSelectionFailed condition
+const (
+	ReasonBuilderNotFound   = "BuilderNotFound"
+	ReasonNoDefaultBuilder  = "NoDefaultBuilder"
+	ReasonNoMatchingBuilder = "NoMatchingBuilder"
+	ReasonAmbiguousBuilder  = "AmbiguousBuilder"
+	ReasonBuilderSelected   = "BuilderSelected"
+)
+
+// Reasons of the ResourceConflict condition
+const (
+	ReasonFieldManagerConflict = "FieldManagerConflict"
+	ReasonNoConflict           = "NoConflict"
+)
+
+// Reasons of the events emitted for ImageBuilderImage, on top of the
+// condition reasons
+const (
+	EventBlueprintChanged = "BlueprintChanged"
+	EventBuildTriggered   = "BuildTriggered"
+	EventBuildSuperseded  = "BuildSuperseded"
+	EventCallbackFailed   = "CallbackFailed"
+	EventBuildSucceeded   = "BuildSucceeded"
+	EventBuildFailed      = "BuildFailed"
+	EventCleanupFailed    = "CleanupFailed"
+	EventBlueprintPushed  = "BlueprintPushed"
+	EventComposeStarted   = "ComposeStarted"
+	EventComposeFinished  = "ComposeFinished"
+	EventBuildScheduled   = "BuildScheduled"
+)
+
+// Reasons of the events emitted for ImageBuilder, on top of the condition
+// reasons
+const (
+	EventBlueprintsRestored = "BlueprintsRestored"
+)
@@ -17,6 +17,8 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -32,16 +34,294 @@ type ImageBuilderSpec struct {
 	SubscriptionSecretName string `json:"subscriptionSecret,omitempty"`
 	ServicePort            int32  `json:"servicePort,omitempty"`
 	SshKey                 string `json:"sshKey,omitempty"`
+	// Default marks the builder used by the images that do not reference one,
+	// there can be only one default builder per namespace
+	//+optional
+	Default bool `json:"default,omitempty"`
+	// AllowedNamespaces selects the namespaces, besides its own, whose images
+	// may use this builder
+	//+optional
+	AllowedNamespaces *metav1.LabelSelector `json:"allowedNamespaces,omitempty"`
+	// ComposerVersion is the version of the osbuild-composer package
+	// installed in the builder, e.g. 98-1.el9, the latest one available when
+	// empty. Changing it drains the builder and rolls composer.
+	//+optional
+	//+kubebuilder:validation:Pattern=`^[0-9A-Za-z._+~-]+$`
+	ComposerVersion string `json:"composerVersion,omitempty"`
+	// UpgradeDrainTimeout bounds the wait for the in-flight composes before
+	// composer is rolled, defaults to 2h
+	//+optional
+	UpgradeDrainTimeout *metav1.Duration `json:"upgradeDrainTimeout,omitempty"`
+	// Architecture is the architecture of the builder, and of the images it
+	// builds. Its virtual machine and the build pods of its images are
+	// scheduled on nodes of this architecture, any node when empty. It can
+	// not be changed.
+	//+optional
+	Architecture Architecture `json:"architecture,omitempty"`
+	// Runtime is what runs composer, a virtual machine when empty
+	//+optional
+	Runtime ComposerRuntime `json:"runtime,omitempty"`
+	// Composer configures composer when it runs as a Deployment
+	//+optional
+	Composer *ComposerDeployment `json:"composer,omitempty"`
+	// OSTreeRepository serves the edge commits built by the images of the
+	// namespace of the builder from a single ostree repository, so devices
+	// can upgrade from it
+	//+optional
+	OSTreeRepository *OSTreeRepository `json:"ostreeRepository,omitempty"`
+	// API is how the controller and the build steps reach the composer
+	// API, the Service of the builder over plain HTTP when empty
+	//+optional
+	API *ComposerAPI `json:"api,omitempty"`
+	// APIFlavor is the composer API the controller and the build steps use,
+	// the weldr API of on-premise composers or the Cloud API
+	//+kubebuilder:default=weldr
+	//+optional
+	APIFlavor APIFlavor `json:"apiFlavor,omitempty"`
+	// Distribution is the distribution composed with the Cloud API when the
+	// blueprint sets no distro, e.g. rhel-9
+	//+optional
+	Distribution string `json:"distribution,omitempty"`
+	// MaxConcurrentBuilds is the number of builds of the images using this
+	// builder that may run at the same time, the other ones being queued.
+	// Unlimited when not set.
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	MaxConcurrentBuilds *int32 `json:"maxConcurrentBuilds,omitempty"`
 }
 
+//+kubebuilder:validation:Enum=weldr;cloud
+
+// APIFlavor is the composer API a builder is used with
+type APIFlavor string
+
+const (
+	// APIFlavorWeldr stores the blueprints and sources in composer, the
+	// composes naming the blueprint
+	APIFlavorWeldr APIFlavor = "weldr"
+	// APIFlavorCloud sends the blueprint and repositories with every compose
+	// request of the Cloud API (v2)
+	APIFlavorCloud APIFlavor = "cloud"
+)
+
+// ComposerAPI secures the requests to the composer API. Composer itself
+// serves plain HTTP, URL is then usually a proxy in front of it terminating
+// TLS and checking the credentials.
+type ComposerAPI struct {
+	// URL is the root of the API, e.g. https://composer.example.com, the
+	// /api/v1 path, or /api/image-builder-composer/v2 with the cloud
+	// flavor, being appended
+	//+kubebuilder:validation:Pattern=`^https?://`
+	//+optional
+	URL string `json:"url,omitempty"`
+	// CABundle selects the key of a ConfigMap of the namespace of the
+	// builder holding the PEM certificates the endpoint is verified with,
+	// the ones of the system when empty
+	//+optional
+	CABundle *corev1.ConfigMapKeySelector `json:"caBundle,omitempty"`
+	// ClientCertSecret is a kubernetes.io/tls Secret of the namespace of the
+	// builder whose tls.crt and tls.key authenticate the clients
+	//+optional
+	ClientCertSecret string `json:"clientCertSecret,omitempty"`
+	// BearerToken selects the key of a Secret of the namespace of the
+	// builder holding the token sent in the Authorization header
+	//+optional
+	BearerToken *corev1.SecretKeySelector `json:"bearerToken,omitempty"`
+}
+
+// OSTreeRepository is the ostree repository served by a builder
+type OSTreeRepository struct {
+	// StorageSize is the size of the volume holding the repository, defaults
+	// to 10Gi
+	//+optional
+	StorageSize *resource.Quantity `json:"storageSize,omitempty"`
+	// StorageClassName of the volume holding the repository
+	//+optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessModes of the volume holding the repository, defaults to
+	// ReadWriteMany as the builds write to it while it is served
+	//+optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=VirtualMachine;Deployment
+
+// ComposerRuntime is what runs composer
+type ComposerRuntime string
+
+const (
+	// RuntimeVirtualMachine installs composer in a KubeVirt virtual machine
+	RuntimeVirtualMachine ComposerRuntime = "VirtualMachine"
+	// RuntimeDeployment runs the composer and worker containers in the pod
+	// of a Deployment
+	RuntimeDeployment ComposerRuntime = "Deployment"
+)
+
+// Defaults of the ImageBuilder spec
+const (
+	DefaultSubscriptionSecretName       = "osbuild-subscription-secret"
+	DefaultServicePort            int32 = 8080
+)
+
+// ComposerDeployment configures composer and its workers running as a
+// Deployment
+type ComposerDeployment struct {
+	// Image is the osbuild-composer container image, defaults to
+	// ghcr.io/osbuild/osbuild-composer
+	//+optional
+	Image string `json:"image,omitempty"`
+	// WorkerImage is the osbuild-worker container image, defaults to
+	// ghcr.io/osbuild/osbuild-worker
+	//+optional
+	WorkerImage string `json:"workerImage,omitempty"`
+	// Workers is the number of workers running composes, defaults to 1
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	Workers *int32 `json:"workers,omitempty"`
+	// Resources of the composer container
+	//+optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// WorkerResources of every worker container
+	//+optional
+	WorkerResources corev1.ResourceRequirements `json:"workerResources,omitempty"`
+	// StorageSize is the size of the volume holding the state of composer
+	// and its composes, defaults to 30Gi
+	//+optional
+	StorageSize *resource.Quantity `json:"storageSize,omitempty"`
+	// StorageClassName of the volume holding the state of composer
+	//+optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// Config is the osbuild-composer.toml configuration of composer
+	//+optional
+	Config string `json:"config,omitempty"`
+	// WorkerConfig is the osbuild-worker.toml configuration of the workers,
+	// a [containers] table reading the registry credentials of the embedded
+	// containers being added unless it has one
+	//+optional
+	WorkerConfig string `json:"workerConfig,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=amd64;arm64;s390x
+
+// Architecture is a CPU architecture, named like the kubernetes.io/arch node
+// label
+type Architecture string
+
+const (
+	// ArchitectureAMD64 is x86_64
+	ArchitectureAMD64 Architecture = "amd64"
+	// ArchitectureARM64 is aarch64
+	ArchitectureARM64 Architecture = "arm64"
+	// ArchitectureS390X is IBM Z
+	ArchitectureS390X Architecture = "s390x"
+)
+
+// Architectures are the architectures builders can run on
+var Architectures = []Architecture{ArchitectureAMD64, ArchitectureARM64, ArchitectureS390X}
+
 // ImageBuilderStatus defines the observed state of ImageBuilder
 type ImageBuilderStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// Inventory summarizes the blueprints and composes stored by composer
+	//+optional
+	Inventory *ComposerInventory `json:"inventory,omitempty"`
+	// Conditions are the Ready, ComposerReachable and Upgrading conditions
+	// of the builder
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// ComposerVersion is the version reported by the running composer
+	//+optional
+	ComposerVersion string `json:"composerVersion,omitempty"`
+	// UpgradeStartedAt is when the builder started draining for the current
+	// upgrade of composer
+	//+optional
+	UpgradeStartedAt *metav1.Time `json:"upgradeStartedAt,omitempty"`
+	// Architecture is the architecture of the node running the virtual
+	// machine of the builder
+	//+optional
+	Architecture Architecture `json:"architecture,omitempty"`
+	// LastRestore reports the last time blueprints missing from composer
+	// were pushed again from the ImageBuilderImages built by the builder
+	//+optional
+	LastRestore *BlueprintRestore `json:"lastRestore,omitempty"`
+	// ComposeTypes are the compose types enabled in composer for the
+	// distribution and architecture of the builder
+	//+optional
+	ComposeTypes []string `json:"composeTypes,omitempty"`
+	// Runtime is what runs composer
+	//+optional
+	Runtime ComposerRuntime `json:"runtime,omitempty"`
+	// ReadyWorkers is the number of ready workers of a composer running as
+	// a Deployment
+	//+optional
+	ReadyWorkers int32 `json:"readyWorkers,omitempty"`
+	// Distros are the distributions composer builds images of
+	//+optional
+	Distros []string `json:"distros,omitempty"`
+	// Health reports the probes of the composer API
+	//+optional
+	Health *ComposerHealth `json:"health,omitempty"`
+	// OSTreeRepositoryURL is the URL of the ostree repository served by the
+	// builder
+	//+optional
+	OSTreeRepositoryURL string `json:"ostreeRepositoryURL,omitempty"`
+}
+
+// ComposerHealth reports the probes of the /api/status endpoint of composer
+type ComposerHealth struct {
+	// API is the version of the weldr API served by composer
+	//+optional
+	API string `json:"api,omitempty"`
+	// Backend is the name of the backend serving the API
+	//+optional
+	Backend string `json:"backend,omitempty"`
+	// LastProbeTime is when the API was last probed
+	LastProbeTime metav1.Time `json:"lastProbeTime"`
+	// LastReachableTime is when the API last answered
+	//+optional
+	LastReachableTime *metav1.Time `json:"lastReachableTime,omitempty"`
+	// ConsecutiveFailures counts the probes that failed since the API last
+	// answered
+	//+optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+}
+
+// BlueprintRestore describes blueprints pushed again to a composer that lost
+// them, e.g. after its VM or disk was replaced
+type BlueprintRestore struct {
+	// Time is when the blueprints were pushed
+	Time metav1.Time `json:"time"`
+	// Blueprints lists, up to 20, the restored blueprints
+	Blueprints []string `json:"blueprints"`
+	// Count is the number of restored blueprints
+	Count int32 `json:"count"`
+}
+
+// ComposerInventory summarizes the state of composer, so drift between the
+// cluster and the builder can be spotted
+type ComposerInventory struct {
+	Blueprints       int32 `json:"blueprints"`
+	QueuedComposes   int32 `json:"queuedComposes"`
+	RunningComposes  int32 `json:"runningComposes"`
+	FinishedComposes int32 `json:"finishedComposes"`
+	FailedComposes   int32 `json:"failedComposes"`
+	// OrphanedBlueprints lists, up to 20, the blueprints that are not
+	// rendered by any ImageBuilderImage
+	//+optional
+	OrphanedBlueprints []string `json:"orphanedBlueprints,omitempty"`
+	// OrphanedComposes is the number of composes of orphaned blueprints
+	OrphanedComposes int32 `json:"orphanedComposes"`
+	// LastUpdated is when composer was last queried
+	LastUpdated metav1.Time `json:"lastUpdated"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=osbuild,shortName=ib
 
 // ImageBuilder is the Schema for the imagebuilders API
 type ImageBuilder struct {
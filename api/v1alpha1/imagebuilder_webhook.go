@@ -0,0 +1,186 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var imagebuilderlog = logf.Log.WithName("imagebuilder-resource")
+
+// SetupWebhookWithManager will setup the manager to manage the webhooks.
+// multiTenant tells if the operator runs in multi-tenant mode, where every
+// namespace may have its own default builder.
+func (r *ImageBuilder) SetupWebhookWithManager(mgr ctrl.Manager, multiTenant bool) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&imageBuilderDefaulter{}).
+		WithValidator(&imageBuilderValidator{client: mgr.GetClient(), multiTenant: multiTenant}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-osbuild-rh-ecosystem-edge-io-v1alpha1-imagebuilder,mutating=true,failurePolicy=fail,sideEffects=None,groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilders,verbs=create,versions=v1alpha1,name=mimagebuilder.kb.io,admissionReviewVersions=v1
+
+//+kubebuilder:object:generate=false
+
+// imageBuilderDefaulter fills in the runtime, port and subscription Secret
+// the controller would default on creation
+type imageBuilderDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &imageBuilderDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *imageBuilderDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	imageBuilder := obj.(*ImageBuilder)
+	imagebuilderlog.Info("default", "name", imageBuilder.Name)
+	spec := &imageBuilder.Spec
+	if spec.Runtime == "" {
+		spec.Runtime = RuntimeVirtualMachine
+	}
+	if spec.ServicePort == 0 {
+		spec.ServicePort = DefaultServicePort
+	}
+	// only the virtual machine is registered with subscription-manager
+	if spec.Runtime == RuntimeVirtualMachine && spec.SubscriptionSecretName == "" {
+		spec.SubscriptionSecretName = DefaultSubscriptionSecretName
+	}
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-osbuild-rh-ecosystem-edge-io-v1alpha1-imagebuilder,mutating=false,failurePolicy=fail,sideEffects=None,groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilders,verbs=create;update,versions=v1alpha1,name=vimagebuilder.kb.io,admissionReviewVersions=v1
+
+//+kubebuilder:object:generate=false
+
+// imageBuilderValidator makes sure there is at most one default builder, per
+// namespace in multi-tenant mode, and that the architecture of a builder does
+// not change
+type imageBuilderValidator struct {
+	client      client.Client
+	multiTenant bool
+}
+
+var _ webhook.CustomValidator = &imageBuilderValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *imageBuilderValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	imageBuilder := obj.(*ImageBuilder)
+	imagebuilderlog.Info("validate create", "name", imageBuilder.Name)
+	if err := validateRuntime(&imageBuilder.Spec); err != nil {
+		return nil, err
+	}
+	if err := validateAPI(&imageBuilder.Spec); err != nil {
+		return nil, err
+	}
+	return nil, v.validateDefault(ctx, imageBuilder)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *imageBuilderValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	imageBuilder := newObj.(*ImageBuilder)
+	imagebuilderlog.Info("validate update", "name", imageBuilder.Name)
+	// the virtual machine is not moved to nodes of another architecture
+	if old := oldObj.(*ImageBuilder); old.Spec.Architecture != imageBuilder.Spec.Architecture {
+		return nil, fmt.Errorf("spec.architecture can not be changed from %q to %q, create another ImageBuilder", old.Spec.Architecture, imageBuilder.Spec.Architecture)
+	}
+	if err := validateRuntime(&imageBuilder.Spec); err != nil {
+		return nil, err
+	}
+	if err := validateAPI(&imageBuilder.Spec); err != nil {
+		return nil, err
+	}
+	return nil, v.validateDefault(ctx, imageBuilder)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *imageBuilderValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// composerVersionRe matches the versions of RPM packages, the version being
+// written in the cloud-init of the virtual machine
+var composerVersionRe = regexp.MustCompile(`^[0-9A-Za-z._+~-]+$`)
+
+// validateRuntime rejects the settings of the runtime a builder does not use
+func validateRuntime(spec *ImageBuilderSpec) error {
+	if spec.ComposerVersion != "" && !composerVersionRe.MatchString(spec.ComposerVersion) {
+		return fmt.Errorf("spec.composerVersion %q is not a package version, e.g. 98-1.el9", spec.ComposerVersion)
+	}
+	if spec.Runtime == RuntimeDeployment {
+		if spec.ComposerVersion != "" {
+			return fmt.Errorf("spec.composerVersion only applies to the VirtualMachine runtime, set spec.composer.image instead")
+		}
+		return nil
+	}
+	if spec.Composer != nil {
+		return fmt.Errorf("spec.composer only applies to the Deployment runtime")
+	}
+	return nil
+}
+
+// validateAPI makes sure the certificates of spec.api are used over HTTPS,
+// and that the Cloud API is told what distribution to compose
+func validateAPI(spec *ImageBuilderSpec) error {
+	if spec.APIFlavor == APIFlavorCloud && spec.Distribution == "" {
+		return fmt.Errorf("spec.distribution is required with the cloud spec.apiFlavor")
+	}
+	api := spec.API
+	if api == nil || strings.HasPrefix(api.URL, "https://") {
+		return nil
+	}
+	if api.CABundle != nil || api.ClientCertSecret != "" {
+		return fmt.Errorf("spec.api.caBundle and spec.api.clientCertSecret need an https:// spec.api.url, composer only serves plain HTTP")
+	}
+	return nil
+}
+
+// validateDefault rejects a second default builder. The images of every
+// namespace may use the default builder of another namespace unless the
+// operator runs in multi-tenant mode, so there is only one per cluster then.
+func (v *imageBuilderValidator) validateDefault(ctx context.Context, imageBuilder *ImageBuilder) error {
+	if !imageBuilder.Spec.Default {
+		return nil
+	}
+	imageBuilders := ImageBuilderList{}
+	listOptions := []client.ListOption{}
+	if v.multiTenant {
+		listOptions = append(listOptions, client.InNamespace(imageBuilder.Namespace))
+	}
+	if err := v.client.List(ctx, &imageBuilders, listOptions...); err != nil {
+		return err
+	}
+	for _, other := range imageBuilders.Items {
+		if !other.Spec.Default || (other.Namespace == imageBuilder.Namespace && other.Name == imageBuilder.Name) {
+			continue
+		}
+		if v.multiTenant {
+			return fmt.Errorf("ImageBuilder %s is already the default builder of namespace %s, unset its spec.default first", other.Name, imageBuilder.Namespace)
+		}
+		return fmt.Errorf("ImageBuilder %s/%s is already the default builder of the cluster, unset its spec.default first", other.Namespace, other.Name)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("ImageBuilder webhook", func() {
+	ctx := context.Background()
+
+	It("defaults to a virtual machine registered with subscription-manager", func() {
+		imageBuilder := &ImageBuilder{ObjectMeta: metav1.ObjectMeta{Name: "builder"}}
+		Expect((&imageBuilderDefaulter{}).Default(ctx, imageBuilder)).To(Succeed())
+		Expect(imageBuilder.Spec.Runtime).To(Equal(RuntimeVirtualMachine))
+		Expect(imageBuilder.Spec.ServicePort).To(Equal(DefaultServicePort))
+		Expect(imageBuilder.Spec.SubscriptionSecretName).To(Equal(DefaultSubscriptionSecretName))
+	})
+
+	It("registers no Deployment runtime with subscription-manager", func() {
+		imageBuilder := &ImageBuilder{Spec: ImageBuilderSpec{Runtime: RuntimeDeployment}}
+		Expect((&imageBuilderDefaulter{}).Default(ctx, imageBuilder)).To(Succeed())
+		Expect(imageBuilder.Spec.SubscriptionSecretName).To(BeEmpty())
+	})
+
+	Context("when validating", func() {
+		var validator *imageBuilderValidator
+		var namespace string
+
+		BeforeEach(func() {
+			validator = &imageBuilderValidator{client: k8sClient}
+			namespace = createNamespace(ctx, nil)
+		})
+
+		It("allows a single default builder per cluster", func() {
+			Expect(k8sClient.Create(ctx, &ImageBuilder{
+				ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: namespace},
+				Spec:       ImageBuilderSpec{Default: true},
+			})).To(Succeed())
+
+			// every namespace may use it
+			other := &ImageBuilder{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: createNamespace(ctx, nil)},
+				Spec:       ImageBuilderSpec{Default: true},
+			}
+			_, err := validator.ValidateCreate(ctx, other)
+			Expect(err).To(MatchError(ContainSubstring("is already the default builder of the cluster")))
+		})
+
+		It("allows a single default builder per namespace in multi-tenant mode", func() {
+			validator.multiTenant = true
+			Expect(k8sClient.Create(ctx, &ImageBuilder{
+				ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: namespace},
+				Spec:       ImageBuilderSpec{Default: true},
+			})).To(Succeed())
+
+			other := &ImageBuilder{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: namespace},
+				Spec:       ImageBuilderSpec{Default: true},
+			}
+			_, err := validator.ValidateCreate(ctx, other)
+			Expect(err).To(MatchError(ContainSubstring("ImageBuilder builder is already the default builder of namespace %s", namespace)))
+
+			// the default builders of other namespaces do not count
+			other.Namespace = createNamespace(ctx, nil)
+			_, err = validator.ValidateCreate(ctx, other)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("keeps the architecture of a builder", func() {
+			old := &ImageBuilder{
+				ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: namespace},
+				Spec:       ImageBuilderSpec{Architecture: ArchitectureAMD64},
+			}
+			imageBuilder := old.DeepCopy()
+			imageBuilder.Spec.Architecture = ArchitectureARM64
+			_, err := validator.ValidateUpdate(ctx, old, imageBuilder)
+			Expect(err).To(MatchError(ContainSubstring("spec.architecture can not be changed")))
+		})
+
+		It("rejects the settings of the runtime the builder does not use", func() {
+			_, err := validator.ValidateCreate(ctx, &ImageBuilder{
+				ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: namespace},
+				Spec:       ImageBuilderSpec{Runtime: RuntimeDeployment, ComposerVersion: "v1"},
+			})
+			Expect(err).To(MatchError(ContainSubstring("spec.composerVersion only applies to the VirtualMachine runtime")))
+		})
+
+		It("requires the distribution composed with the Cloud API", func() {
+			_, err := validator.ValidateCreate(ctx, &ImageBuilder{
+				ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: namespace},
+				Spec:       ImageBuilderSpec{APIFlavor: APIFlavorCloud},
+			})
+			Expect(err).To(MatchError(ContainSubstring("spec.distribution is required")))
+		})
+	})
+})
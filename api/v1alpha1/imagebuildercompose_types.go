@@ -0,0 +1,113 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:validation:Enum=PipelineRun;Job
+
+// BuildKind is the kind of the resource running a build
+type BuildKind string
+
+const (
+	BuildKindPipelineRun BuildKind = "PipelineRun"
+	BuildKindJob         BuildKind = "Job"
+)
+
+// ImageBuilderComposeSpec is a build of an ImageBuilderImage, created by the
+// operator when it starts the build
+type ImageBuilderComposeSpec struct {
+	// Image is the name of the ImageBuilderImage built
+	Image string `json:"image"`
+	// Build is the name of the PipelineRun or Job of the build
+	Build string `json:"build"`
+	// BuildKind is the kind of the build, PipelineRun or Job
+	BuildKind BuildKind `json:"buildKind"`
+	// Generation is the generation of the image built
+	Generation int64 `json:"generation"`
+	// BlueprintVersion is the version the blueprints were pushed with
+	//+optional
+	BlueprintVersion string `json:"blueprintVersion,omitempty"`
+	// ComposeType is the type of the image composed
+	//+optional
+	ComposeType ComposeType `json:"composeType,omitempty"`
+	// BuildRecord is the immutable ConfigMap recording the inputs of the build
+	//+optional
+	BuildRecord string `json:"buildRecord,omitempty"`
+}
+
+// ImageBuilderComposeStatus is the outcome of the build
+type ImageBuilderComposeStatus struct {
+	// ComposeIDs are the UUIDs of the composes started by the build
+	//+optional
+	ComposeIDs []string `json:"composeIDs,omitempty"`
+	// Composes are the composes of the build in composer, while it is the
+	// current build of the image
+	//+optional
+	//+listType=map
+	//+listMapKey=blueprint
+	Composes []ComposeStatus `json:"composes,omitempty"`
+	// StartTime is when the build started
+	//+optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is when the build ended
+	//+optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Result is the outcome of the build
+	//+optional
+	Result BuildResult `json:"result,omitempty"`
+	// Message tells why the build failed
+	//+optional
+	Message string `json:"message,omitempty"`
+	// Logs is the location of the compose logs on the web server of the
+	// image, while the artifacts of the build are served
+	//+optional
+	Logs string `json:"logs,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=osbuild,shortName=ibc
+//+kubebuilder:printcolumn:name="Image",type="string",JSONPath=".spec.image"
+//+kubebuilder:printcolumn:name="Build",type="string",JSONPath=".spec.build"
+//+kubebuilder:printcolumn:name="Result",type="string",JSONPath=".status.result"
+//+kubebuilder:printcolumn:name="Compose",type="string",JSONPath=".status.composeIDs[0]",priority=1
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ImageBuilderCompose is the Schema for the imagebuildercomposes API
+type ImageBuilderCompose struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ImageBuilderComposeSpec   `json:"spec,omitempty"`
+	Status ImageBuilderComposeStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ImageBuilderComposeList contains a list of ImageBuilderCompose
+type ImageBuilderComposeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImageBuilderCompose `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImageBuilderCompose{}, &ImageBuilderComposeList{})
+}
@@ -17,12 +17,22 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// ImageBuilderReference names an ImageBuilder
+type ImageBuilderReference struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the image
+	//+optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
 // ImageBuilderImageSpec defines the desired state of ImageBuilderImage
 type ImageBuilderImageSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
@@ -35,18 +45,1312 @@ type ImageBuilderImageSpec struct {
 	BlueprintTemplate         string `json:"blueprintTemplate,omitempty"`
 	BlueprintIsoTemplate      string `json:"blueprintIsoTemplate,omitempty"`
 	ImageBuilder              string `json:"imageBuilder,omitempty"`
-	SharedVolume              string `json:"persistentVolumeName,omitempty"`
-	IsoTarget                 string `json:"isoTarget,omitempty"`
+	// SshKeySecretRef reads the ssh key of userName from a key of a Secret
+	// instead of sshKey, the blueprints then being stored in Secrets
+	//+optional
+	SshKeySecretRef *corev1.SecretKeySelector `json:"sshKeySecretRef,omitempty"`
+	// TemplateSecrets are keys of Secrets made available to the blueprint
+	// templates as .Secrets.<name>, the blueprints then being stored in Secrets
+	//+optional
+	TemplateSecrets []TemplateSecret `json:"templateSecrets,omitempty"`
+	// Secrets holds the values of templateSecrets while the templates are
+	// rendered, it is never stored
+	Secrets map[string]string `json:"-"`
+	// BlueprintTemplateRef reads the commit blueprint template from a key of
+	// a ConfigMap or Secret instead of blueprintTemplate
+	//+optional
+	BlueprintTemplateRef *TemplateReference `json:"blueprintTemplateRef,omitempty"`
+	// BlueprintIsoTemplateRef reads the installer blueprint template from a
+	// key of a ConfigMap or Secret instead of blueprintIsoTemplate
+	//+optional
+	BlueprintIsoTemplateRef *TemplateReference `json:"blueprintIsoTemplateRef,omitempty"`
+	// ImageBuilderNamespace is the namespace of ImageBuilder, defaults to the
+	// namespace of the image
+	//+optional
+	ImageBuilderNamespace string `json:"imageBuilderNamespace,omitempty"`
+	// ImageBuilderRef is the ImageBuilder to use, in the namespace of the
+	// image unless namespace is set. It replaces imageBuilder and
+	// imageBuilderNamespace
+	//+optional
+	ImageBuilderRef *ImageBuilderReference `json:"imageBuilderRef,omitempty"`
+	// ImageBuilderSelector selects the ImageBuilder by its labels when none
+	// is referenced, instead of the one marked as default
+	//+optional
+	ImageBuilderSelector *metav1.LabelSelector `json:"imageBuilderSelector,omitempty"`
+	// FDO configures the FIDO Device Onboarding of the simplified
+	// installer, replacing fdoManufacturingServerUrl
+	//+optional
+	FDO *FDO `json:"fdo,omitempty"`
+	// DiunPubKeyHash and DiunPubKeyRootCerts hold the values of
+	// fdo.diunPubKey while the templates are rendered, they are never stored
+	DiunPubKeyHash      string `json:"-"`
+	DiunPubKeyRootCerts string `json:"-"`
+	// ClusterImageBuilder is the cluster-scoped builder to use, it takes
+	// precedence over ImageBuilder
+	//+optional
+	ClusterImageBuilder string `json:"clusterImageBuilder,omitempty"`
+	SharedVolume        string `json:"persistentVolumeName,omitempty"`
+	IsoTarget           string `json:"isoTarget,omitempty"`
+	// ComposeType is the type of image composed from the blueprint. Only
+	// edge-commit composes are followed by an installer
+	//+optional
+	//+kubebuilder:default=edge-commit
+	ComposeType ComposeType `json:"composeType,omitempty"`
+	// Executor runs the build, a Tekton PipelineRun or a Kubernetes Job. When
+	// empty, Tekton is used if it is installed in the cluster.
+	//+optional
+	Executor Executor `json:"executor,omitempty"`
+	// SharedVolumeSize is the size of the PersistentVolumeClaim created for
+	// the image when persistentVolumeName is not set, defaults to 20Gi
+	//+optional
+	SharedVolumeSize *resource.Quantity `json:"sharedVolumeSize,omitempty"`
+	// StorageClassName is the storage class of the PersistentVolumeClaim
+	// created for the image, the default class of the cluster when empty
+	//+optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessModes are the access modes of the PersistentVolumeClaim created
+	// for the image, defaults to ReadWriteOnce
+	//+optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	// Storage selects the kind of volume the build works in, defaults to the
+	// PersistentVolumeClaim named by persistentVolumeName
+	//+optional
+	Storage *ImageStorage `json:"storage,omitempty"`
+	// ComposeTimeouts bound the stages of the generated pipeline
+	//+optional
+	ComposeTimeouts *ComposeTimeouts `json:"composeTimeouts,omitempty"`
+	// Retries retry the requests of the generated pipeline to composer that
+	// failed transiently
+	//+optional
+	Retries *NetworkRetries `json:"retries,omitempty"`
+	// UploadTargets are the registries, buckets and volumes the artifacts are
+	// pushed to once they are built, the same artifacts being pushed to all
+	// of them
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	UploadTargets []UploadTarget `json:"uploadTargets,omitempty"`
+	// Upload uploads the image of ami, vhd and gce composes to its cloud
+	// with the upload providers of composer
+	//+optional
+	Upload *CloudUpload `json:"upload,omitempty"`
+	// Callbacks are HTTP endpoints notified of the state transitions of the
+	// builds of the image
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Callbacks []BuildCallback `json:"callbacks,omitempty"`
+	// Scripts are inline steps run around the compose by the generated
+	// pipeline
+	//+optional
+	Scripts *ComposeScripts `json:"scripts,omitempty"`
+	// PipelineRef is a pipeline, written by the user, that builds the image
+	// instead of the generated one
+	//+optional
+	PipelineRef *ImagePipelineReference `json:"pipelineRef,omitempty"`
+	// Hooks are Tasks run before and after the build by the generated pipeline
+	//+optional
+	Hooks *BuildHooks `json:"hooks,omitempty"`
+	// Profile adds one of the built-in blueprints maintained by the operator
+	// to the commit blueprint
+	//+kubebuilder:validation:Enum=minimal;kiosk;gateway
+	//+optional
+	Profile string `json:"profile,omitempty"`
+	// Packages are added to the commit blueprint generated when
+	// blueprintTemplate is not set, as are the following customizations
+	//+optional
+	Packages []string `json:"packages,omitempty"`
+	// Users are created in the image
+	//+optional
+	Users []BlueprintUser `json:"users,omitempty"`
+	// Kernel customizes the kernel command line
+	//+optional
+	Kernel *KernelCustomization `json:"kernel,omitempty"`
+	// Services are the systemd units enabled in the image
+	//+optional
+	Services *ServicesCustomization `json:"services,omitempty"`
+	// Firewall opens ports of the image
+	//+optional
+	Firewall *FirewallCustomization `json:"firewall,omitempty"`
+	// Filesystem sets the minimum size of mount points of disk images
+	//+optional
+	Filesystem []FilesystemCustomization `json:"filesystem,omitempty"`
+	// EmbeddedContainers are container images embedded into the edge commit,
+	// for the workloads of devices running offline
+	//+optional
+	EmbeddedContainers []EmbeddedContainer `json:"embeddedContainers,omitempty"`
+	// Kickstart is embedded into the Anaconda installer ISO of the
+	// edge-installer target and of image-installer images
+	//+optional
+	Kickstart string `json:"kickstart,omitempty"`
+	// KickstartRef reads the kickstart from a key of a ConfigMap or Secret
+	// instead of kickstart
+	//+optional
+	KickstartRef *TemplateReference `json:"kickstartRef,omitempty"`
+	// ISO customizes the installer ISO the kickstart is embedded into
+	//+optional
+	ISO *ISOCustomization `json:"iso,omitempty"`
+	// OSTree selects the ref of the edge commit and the commit it upgrades
+	//+optional
+	OSTree *OSTreeCompose `json:"ostree,omitempty"`
+	// Repositories are the ImageBuilderSources of the namespace pushed to
+	// composer before the blueprints are composed
+	//+optional
+	//+listType=set
+	Repositories []string `json:"repositories,omitempty"`
+	// BuildPod adjusts the pods running the build steps and serving the
+	// artifacts, on top of the defaults of the operator
+	//+optional
+	BuildPod *BuildPodSettings `json:"buildPod,omitempty"`
+	// DryRun renders the blueprints and the resources of the build and
+	// publishes them in the plan ConfigMap of status.plan, without creating,
+	// updating or deleting any of them
+	//+optional
+	DryRun bool `json:"dryRun,omitempty"`
+	// ExposeArtifact serves the artifacts of the last successful build with a
+	// web server and publishes in status.artifactURL the URL the installer,
+	// or the image of the other compose types, is downloaded from
+	//+optional
+	ExposeArtifact bool `json:"exposeArtifact,omitempty"`
+	// ForceOwnership takes back the fields of the generated Tasks and
+	// Pipeline modified by someone else instead of reporting a conflict
+	//+optional
+	ForceOwnership bool `json:"forceOwnership,omitempty"`
+	// HistoryLimit is how many builds status.history keeps, the PipelineRuns,
+	// Jobs and composes of the older ones being deleted
+	//+optional
+	//+kubebuilder:default=10
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	HistoryLimit int32 `json:"historyLimit,omitempty"`
+	// BuildGeneration is a counter starting a new build of the image when it
+	// changes, e.g. is increased, without changing its blueprints
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	BuildGeneration int64 `json:"buildGeneration,omitempty"`
+	// Schedule is a cron expression, in UTC, at which the image is built
+	// again, e.g. to pick up the updates of its packages
+	//+optional
+	Schedule string `json:"schedule,omitempty"`
+	// Suspend stops creating new builds of the image, a running build being
+	// left to finish, and skips the times spec.schedule is due, until it is
+	// cleared
+	//+optional
+	Suspend bool `json:"suspend,omitempty"`
+	// Architectures builds the image for each architecture, by an
+	// ImageBuilderImage named <name>-<amd64|arm64|s390x> owned by this one
+	// and built by an ImageBuilder of that architecture
+	//+optional
+	//+listType=set
+	//+kubebuilder:validation:MaxItems=3
+	Architectures []ImageArchitecture `json:"architectures,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=x86_64;aarch64;s390x
+
+// ImageArchitecture is the architecture of an image, named like composer
+// names it
+type ImageArchitecture string
+
+const (
+	ImageArchitectureX86_64  ImageArchitecture = "x86_64"
+	ImageArchitectureAArch64 ImageArchitecture = "aarch64"
+	ImageArchitectureS390X   ImageArchitecture = "s390x"
+)
+
+// NodeArchitecture is the architecture of the builders of the images of an
+// architecture
+func (a ImageArchitecture) NodeArchitecture() Architecture {
+	switch a {
+	case ImageArchitectureX86_64:
+		return ArchitectureAMD64
+	case ImageArchitectureAArch64:
+		return ArchitectureARM64
+	}
+	return Architecture(a)
+}
+
+// FDO is the FIDO Device Onboarding configuration of the simplified installer
+type FDO struct {
+	// ManufacturingServerURL is the URL of the FDO manufacturing server
+	//+optional
+	ManufacturingServerURL string `json:"manufacturingServerUrl,omitempty"`
+	// ManufacturingServerSelector discovers the manufacturing server among
+	// the Services of the namespace of the image instead of setting its URL
+	//+optional
+	ManufacturingServerSelector *metav1.LabelSelector `json:"manufacturingServerSelector,omitempty"`
+	// DIUNPubKey is how the installer verifies the public key of the DIUN
+	// service of the manufacturing server, not at all when not set
+	//+optional
+	DIUNPubKey *DIUNPubKey `json:"diunPubKey,omitempty"`
+}
+
+// DIUNPubKey verifies the public key of the DIUN service with one of a hash,
+// root certificates, or not at all
+type DIUNPubKey struct {
+	// Hash selects the key of a Secret of the namespace of the image holding
+	// the hash of the public key
+	//+optional
+	Hash *corev1.SecretKeySelector `json:"hash,omitempty"`
+	// RootCerts selects the key of a Secret of the namespace of the image
+	// holding the PEM root certificates the public key is verified with
+	//+optional
+	RootCerts *corev1.SecretKeySelector `json:"rootCerts,omitempty"`
+	// Insecure trusts any public key
+	//+optional
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// TemplateSecret is a key of a Secret of the namespace of the image used as
+// a template value
+type TemplateSecret struct {
+	// Name is the name of the value in the templates, e.g. token for
+	// {{ .Secrets.token }}
+	//+kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	Name string `json:"name"`
+	// SecretKeyRef is the key of the Secret holding the value
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef"`
+}
+
+// TemplateReference selects the key of a ConfigMap or Secret of the namespace
+// of the image holding a blueprint template. Exactly one of them is set.
+type TemplateReference struct {
+	// ConfigMapKeyRef is a key of a ConfigMap
+	//+optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// SecretKeyRef is a key of a Secret, for templates embedding credentials
+	//+optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// BlueprintUser is a user created in the image
+type BlueprintUser struct {
+	// Name is the login of the user
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Key is the ssh public key of the user
+	//+optional
+	Key string `json:"key,omitempty"`
+	// Groups the user is a member of, e.g. wheel
+	//+optional
+	Groups []string `json:"groups,omitempty"`
+}
+
+// KernelCustomization customizes the kernel command line
+type KernelCustomization struct {
+	// Append is added to the kernel command line
+	//+kubebuilder:validation:MinLength=1
+	Append string `json:"append"`
+}
+
+// ServicesCustomization lists systemd units to enable
+type ServicesCustomization struct {
+	// Enabled are the units enabled at boot
+	//+optional
+	Enabled []string `json:"enabled,omitempty"`
+}
+
+// FirewallCustomization lists ports opened in the firewall
+type FirewallCustomization struct {
+	// Ports are opened as <port>:<protocol> or <service>:<protocol>, e.g.
+	// 22:tcp or imap:tcp
+	//+optional
+	Ports []string `json:"ports,omitempty"`
+}
+
+// EmbeddedContainer is a container image embedded into the edge commit
+type EmbeddedContainer struct {
+	// Source is the reference the image is pulled from, e.g.
+	// quay.io/example/app:v1
+	//+kubebuilder:validation:MinLength=1
+	Source string `json:"source"`
+	// Name is the name of the image in the container storage of the image,
+	// defaults to source
+	//+optional
+	Name string `json:"name,omitempty"`
+	// TLSVerify set to false pulls from a registry without a valid
+	// certificate
+	//+optional
+	TLSVerify *bool `json:"tlsVerify,omitempty"`
+	// PullSecret is a kubernetes.io/dockerconfigjson Secret of the namespace
+	// of the image with the credentials of the registry
+	//+optional
+	PullSecret *corev1.LocalObjectReference `json:"pullSecret,omitempty"`
+}
+
+// ISOCustomization customizes the installer ISO
+type ISOCustomization struct {
+	// VolumeLabel replaces the volume label of the ISO
+	//+optional
+	//+kubebuilder:validation:MaxLength=32
+	//+kubebuilder:validation:Pattern=`^[A-Za-z0-9_.-]+$`
+	VolumeLabel string `json:"volumeLabel,omitempty"`
+	// KernelArgs are appended to the kernel command line of the installer
+	//+optional
+	KernelArgs string `json:"kernelArgs,omitempty"`
+}
+
+// FilesystemCustomization is the minimum size of a mount point
+type FilesystemCustomization struct {
+	// Mountpoint is the absolute path of the mount point, e.g. /var
+	//+kubebuilder:validation:MinLength=1
+	Mountpoint string `json:"mountpoint"`
+	// MinSize is the minimum size of the filesystem
+	MinSize resource.Quantity `json:"minSize"`
+}
+
+// ImagePipelineReference is a Tekton Pipeline building images. It is run
+// with the blueprintName, apiEndpoint and generation params, and the
+// blueprints, shared-volume and optional image-volume workspaces.
+type ImagePipelineReference struct {
+	// Name is the name of the Pipeline
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Namespace is the namespace of the Pipeline, resolved with the Tekton
+	// cluster resolver, defaults to the namespace of the image
+	//+optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// BuildHooks are user Tasks spliced into the generated pipeline. The hooks
+// of a list run in parallel.
+type BuildHooks struct {
+	// PreBuild hooks run before the first task of the build
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	PreBuild []BuildHook `json:"preBuild,omitempty"`
+	// PostBuild hooks run once the artifacts of the build are available
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	PostBuild []BuildHook `json:"postBuild,omitempty"`
+}
+
+// StepImage names a helper image run by the generated steps
+type StepImage string
+
+// Helper images run by the generated steps
+const (
+	// StepImageUBI runs the shell steps, e.g. preparing the volume
+	StepImageUBI StepImage = "ubi"
+	// StepImageComposerCLI talks to composer
+	StepImageComposerCLI StepImage = "composer-cli"
+	// StepImageOras pushes the artifacts to registries
+	StepImageOras StepImage = "oras"
+	// StepImageAWSCLI uploads the artifacts to S3
+	StepImageAWSCLI StepImage = "aws-cli"
+	// StepImageOSTree publishes the edge commits to the ostree repository
+	StepImageOSTree StepImage = "ostree"
+	// StepImageNginx serves the artifacts
+	StepImageNginx StepImage = "nginx"
+	// StepImageLorax embeds the kickstart into the installer ISO with
+	// mkksiso
+	StepImageLorax StepImage = "lorax"
+	// StepImagePython writes the compose requests of the Cloud API
+	StepImagePython StepImage = "python"
+)
+
+// StepImages lists the helper images that can be replaced
+var StepImages = []StepImage{StepImageUBI, StepImageComposerCLI, StepImageOras, StepImageAWSCLI, StepImageOSTree, StepImageNginx, StepImageLorax, StepImagePython}
+
+// BuildPodSettings adjust the pods of a build. Set on an image, they are
+// merged with the defaults of the operator: maps and lists are merged, the
+// resources of the image replace the default ones.
+type BuildPodSettings struct {
+	// StepImages replaces the helper images, keyed by ubi, composer-cli,
+	// oras, aws-cli, ostree, nginx, lorax or python, e.g. with the references of a
+	// mirror registry in disconnected clusters
+	//+optional
+	StepImages map[StepImage]string `json:"stepImages,omitempty"`
+	// ImagePullSecrets are the Secrets of the namespace of the image used to
+	// pull the helper images
+	//+optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Resources are the requests and limits of the generated steps that do
+	// not set their own
+	//+optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// NodeSelector is added to the architecture selector of the builder
+	//+optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations let the pods run on tainted nodes
+	//+optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+}
+
+// BuildHook runs a Task of the namespace of the image, or one fetched by a
+// Tekton resolver. Exactly one of task and resolver is set.
+type BuildHook struct {
+	// Name identifies the hook, its pipeline task is named pre-<name> or
+	// post-<name>
+	//+kubebuilder:validation:MaxLength=58
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+	// Task is the name of the Task to run
+	//+optional
+	Task string `json:"task,omitempty"`
+	// Resolver fetches the Task to run with a Tekton remote resolver, e.g.
+	// bundles, git or hub
+	//+optional
+	Resolver string `json:"resolver,omitempty"`
+	// ResolverParams are the params of the resolver, e.g. the bundle, name
+	// and kind of the Task
+	//+optional
+	ResolverParams []HookParam `json:"resolverParams,omitempty"`
+	// Params are passed to the Task, their values can reference the
+	// pipeline params, e.g. $(params.blueprintName)
+	//+optional
+	Params []HookParam `json:"params,omitempty"`
+	// Workspaces are the pipeline workspaces, blueprints or shared-volume,
+	// bound to the workspace of the Task with the same name
+	//+optional
+	Workspaces []string `json:"workspaces,omitempty"`
+}
+
+// HookParam is a string param of a hook Task
+type HookParam struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ComposeTimeouts configure how the generated pipeline waits for composer.
+// A step running longer than the timeout of its stage fails the build.
+type ComposeTimeouts struct {
+	// PollInterval is the time between two checks of a running compose,
+	// defaults to 30s
+	//+optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+	// Depsolve bounds the steps starting the composes, composer depsolving
+	// the blueprints before it queues them
+	//+optional
+	Depsolve *metav1.Duration `json:"depsolve,omitempty"`
+	// Build bounds the steps waiting for the composes to finish
+	//+optional
+	Build *metav1.Duration `json:"build,omitempty"`
+	// Upload bounds the steps downloading and extracting the artifacts
+	//+optional
+	Upload *metav1.Duration `json:"upload,omitempty"`
+	// Total bounds the whole build once it is started, the PipelineRun or
+	// Job included, defaults to the timeout of Tekton for PipelineRuns and
+	// to none for Jobs
+	//+optional
+	Total *metav1.Duration `json:"total,omitempty"`
+}
+
+// UploadTarget is a destination of the artifacts of the image
+type UploadTarget struct {
+	// Name identifies the target in the status
+	//+kubebuilder:validation:MaxLength=40
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+	// Registry pushes the edge commit and installer as an OCI artifact
+	//+optional
+	Registry *RegistryUploadTarget `json:"registry,omitempty"`
+	// S3 copies the artifacts to an S3 bucket
+	//+optional
+	S3 *S3UploadTarget `json:"s3,omitempty"`
+	// PVC copies the artifacts to a PersistentVolumeClaim of the namespace
+	// of the image
+	//+optional
+	PVC *PVCUploadTarget `json:"pvc,omitempty"`
+}
+
+// CloudUpload is where composer uploads the image of a compose, with one of
+// aws, azure or gcp set
+type CloudUpload struct {
+	// ImageName is the name of the image in the cloud, defaults to
+	// <image>-<generation>
+	//+optional
+	ImageName string `json:"imageName,omitempty"`
+	// CredentialsSecret is a Secret of the namespace of the image holding the
+	// credentials of the cloud: its AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+	// and optional AWS_SESSION_TOKEN keys for aws, AZURE_STORAGE_ACCESS_KEY
+	// for azure and GOOGLE_APPLICATION_CREDENTIALS, the JSON key of a service
+	// account, for gcp. Only the weldr API takes credentials, the Cloud API
+	// uploading with the ones of the composer workers.
+	//+optional
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+	// AWS imports the image of ami composes as an AMI
+	//+optional
+	AWS *AWSUpload `json:"aws,omitempty"`
+	// Azure uploads the image of vhd composes to Azure
+	//+optional
+	Azure *AzureUpload `json:"azure,omitempty"`
+	// GCP imports the image of gce composes as a Compute Engine image
+	//+optional
+	GCP *GCPUpload `json:"gcp,omitempty"`
+}
+
+// AWSUpload is the region an AMI is imported to
+type AWSUpload struct {
+	//+kubebuilder:validation:MinLength=1
+	Region string `json:"region"`
+	// Bucket is the S3 bucket the image is imported from, required by the
+	// weldr API
+	//+optional
+	Bucket string `json:"bucket,omitempty"`
+	// ShareWithAccounts are the AWS accounts the AMI is shared with, only
+	// supported by the Cloud API
+	//+optional
+	ShareWithAccounts []string `json:"shareWithAccounts,omitempty"`
+}
+
+// AzureUpload is where the image of a vhd compose is uploaded, the storage
+// container for the weldr API and the resource group for the Cloud API
+type AzureUpload struct {
+	// StorageAccount and Container are the storage the weldr API uploads the
+	// VHD to
+	//+optional
+	StorageAccount string `json:"storageAccount,omitempty"`
+	//+optional
+	Container string `json:"container,omitempty"`
+	// TenantID, SubscriptionID and ResourceGroup are where the Cloud API
+	// creates the image
+	//+optional
+	TenantID string `json:"tenantID,omitempty"`
+	//+optional
+	SubscriptionID string `json:"subscriptionID,omitempty"`
+	//+optional
+	ResourceGroup string `json:"resourceGroup,omitempty"`
+	// Location is the location of the image created by the Cloud API,
+	// defaults to the one of the resource group
+	//+optional
+	Location string `json:"location,omitempty"`
+}
+
+// GCPUpload is where a Compute Engine image is imported
+type GCPUpload struct {
+	// Region is the region of the bucket and of the image
+	//+kubebuilder:validation:MinLength=1
+	Region string `json:"region"`
+	// Bucket is the storage bucket the image is imported from, required by
+	// the weldr API
+	//+optional
+	Bucket string `json:"bucket,omitempty"`
+	// ShareWithAccounts are the accounts the image is shared with, e.g.
+	// user:alice@example.com, only supported by the Cloud API
+	//+optional
+	ShareWithAccounts []string `json:"shareWithAccounts,omitempty"`
+}
+
+// S3UploadTarget is a location of an S3 bucket
+type S3UploadTarget struct {
+	//+kubebuilder:validation:MinLength=1
+	Bucket string `json:"bucket"`
+	// Prefix is the key prefix of the artifacts, defaults to
+	// <image>/<generation>
+	//+optional
+	Prefix string `json:"prefix,omitempty"`
+	// Region is the region of the bucket
+	//+optional
+	Region string `json:"region,omitempty"`
+	// Endpoint is the URL of an S3 compatible service other than AWS
+	//+optional
+	//+kubebuilder:validation:Pattern=`^https?://`
+	Endpoint string `json:"endpoint,omitempty"`
+	// CredentialsSecret is a Secret of the namespace of the image whose
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY keys are the credentials
+	// of the bucket
+	//+optional
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+}
+
+// PVCUploadTarget is a directory of a PersistentVolumeClaim
+type PVCUploadTarget struct {
+	//+kubebuilder:validation:MinLength=1
+	ClaimName string `json:"claimName"`
+	// Path is the directory of the claim the artifacts are copied to,
+	// defaults to <image>/<generation>
+	//+optional
+	Path string `json:"path,omitempty"`
+}
+
+// RegistryUploadTarget is an OCI registry repository
+type RegistryUploadTarget struct {
+	// Repository is the repository the artifact is pushed to, e.g.
+	// quay.io/example/edge-image
+	//+kubebuilder:validation:MinLength=1
+	Repository string `json:"repository"`
+	// Tag is the tag of the artifact, defaults to the generation of the image
+	//+optional
+	Tag string `json:"tag,omitempty"`
+	// CredentialsSecret is a kubernetes.io/dockerconfigjson Secret of the
+	// namespace of the image with the credentials of the registry
+	//+optional
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+	// Insecure pushes over plain HTTP
+	//+optional
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=Transient;AllErrors
+
+// RetryCondition selects the failed requests that are retried
+type RetryCondition string
+
+const (
+	// RetryOnTransient retries timeouts, refused connections and the 408,
+	// 429, 500, 502, 503 and 504 HTTP statuses
+	RetryOnTransient RetryCondition = "Transient"
+	// RetryOnAllErrors retries any failed request
+	RetryOnAllErrors RetryCondition = "AllErrors"
+)
+
+// NetworkRetries are the retries of the network-facing steps of the
+// generated pipeline. A compose that failed is not started again.
+type NetworkRetries struct {
+	// BlueprintPush is the number of retries of the requests pushing the
+	// blueprints edited by spec.scripts.preCompose, the operator pushing the
+	// blueprints itself otherwise
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=10
+	//+optional
+	BlueprintPush int32 `json:"blueprintPush,omitempty"`
+	// ComposeStart is the number of retries of the requests starting the
+	// composes
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=10
+	//+optional
+	ComposeStart int32 `json:"composeStart,omitempty"`
+	// Download is the number of Tekton retries of the tasks downloading the
+	// artifacts
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=10
+	//+optional
+	Download int32 `json:"download,omitempty"`
+	// RetryOn selects the failed requests that are retried
+	//+kubebuilder:default=Transient
+	//+optional
+	RetryOn RetryCondition `json:"retryOn,omitempty"`
+	// Delay is the time between two attempts of a request, which backs off
+	// exponentially when unset
+	//+optional
+	Delay *metav1.Duration `json:"delay,omitempty"`
+	// StatusChecks is the number of status checks of a running compose that
+	// may fail in a row before the build fails, defaults to 10. The poll
+	// interval doubles after each failed check, up to 5m.
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	//+optional
+	StatusChecks int32 `json:"statusChecks,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=Queued;Started;Succeeded;Failed
+
+// BuildEvent is a state transition of a build
+type BuildEvent string
+
+const (
+	BuildEventQueued    BuildEvent = "Queued"
+	BuildEventStarted   BuildEvent = "Started"
+	BuildEventSucceeded BuildEvent = "Succeeded"
+	BuildEventFailed    BuildEvent = "Failed"
+)
+
+//+kubebuilder:validation:Enum=json;slack
+
+// CallbackFormat is the body POSTed to a callback
+type CallbackFormat string
+
+const (
+	// CallbackFormatJSON is the JSON description of the build
+	CallbackFormatJSON CallbackFormat = "json"
+	// CallbackFormatSlack is a message summarizing the build, accepted by
+	// the incoming webhooks of Slack and compatible chats
+	CallbackFormatSlack CallbackFormat = "slack"
+)
+
+// BuildCallback is an HTTP endpoint receiving a POST with a JSON description
+// of the build on its state transitions
+type BuildCallback struct {
+	// Name identifies the callback in the status
+	//+kubebuilder:validation:MaxLength=40
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+	//+optional
+	//+kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url,omitempty"`
+	// URLSecret is the key of a Secret of the namespace of the image holding
+	// the URL instead of url, e.g. of a Slack incoming webhook
+	//+optional
+	URLSecret *corev1.SecretKeySelector `json:"urlSecret,omitempty"`
+	// Format is the body POSTed, the JSON description of the build or a Slack
+	// message
+	//+optional
+	//+kubebuilder:default=json
+	Format CallbackFormat `json:"format,omitempty"`
+	// HeadersSecret is a Secret of the namespace of the image whose keys and
+	// values are sent as HTTP headers, e.g. Authorization
+	//+optional
+	HeadersSecret string `json:"headersSecret,omitempty"`
+	// SigningSecret is a Secret of the namespace of the image whose key is
+	// the HMAC-SHA256 key signing the payloads
+	//+optional
+	SigningSecret string `json:"signingSecret,omitempty"`
+	// Events are the transitions notified, all of them when empty
+	//+optional
+	Events []BuildEvent `json:"events,omitempty"`
+}
+
+// CallbackStatus is the last event delivered to a callback
+type CallbackStatus struct {
+	// Name is the name of the callback
+	Name string `json:"name"`
+	// Event is the last event delivered
+	//+optional
+	Event BuildEvent `json:"event,omitempty"`
+	// Generation is the generation of the image built by the notified build
+	//+optional
+	Generation int64 `json:"generation,omitempty"`
+	// LastDeliveryTime is when the last event was delivered
+	//+optional
+	LastDeliveryTime *metav1.Time `json:"lastDeliveryTime,omitempty"`
+	// Message is the error of the last delivery, which is retried
+	//+optional
+	Message string `json:"message,omitempty"`
+}
+
+// ComposeScripts are small steps added to the generated tasks. They run in
+// the directory of the build, with the blueprints pushed to composer in its
+// blueprints directory.
+type ComposeScripts struct {
+	// PreCompose steps run before the commit compose starts, e.g. to edit the
+	// blueprints, which are then pushed again
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	PreCompose []ScriptStep `json:"preCompose,omitempty"`
+	// PostCompose steps run once the artifacts are downloaded, before they
+	// are described
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	PostCompose []ScriptStep `json:"postCompose,omitempty"`
+}
+
+// ScriptStep is a script run in a container image
+type ScriptStep struct {
+	// Name identifies the step, named pre-compose-<name> or post-compose-<name>
+	//+kubebuilder:validation:MaxLength=50
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+	// Image is the container image running the script
+	//+kubebuilder:validation:MinLength=1
+	Image string `json:"image"`
+	// Script is the content of the script, starting with a shebang to use
+	// another interpreter than sh
+	//+kubebuilder:validation:MinLength=1
+	Script string `json:"script"`
+}
+
+// StorageType is the kind of volume used by a build
+// +kubebuilder:validation:Enum=persistentVolumeClaim;emptyDir
+type StorageType string
+
+const (
+	// StoragePersistentVolumeClaim builds in an existing PersistentVolumeClaim
+	// and serves its artifacts
+	StoragePersistentVolumeClaim StorageType = "persistentVolumeClaim"
+	// StorageEmptyDir builds in an emptyDir volume, removed with the build
+	StorageEmptyDir StorageType = "emptyDir"
+)
+
+// ImageStorage is the volume a build works in
+type ImageStorage struct {
+	// Type is the kind of volume. An emptyDir volume only lives as long as
+	// the pod of the build, whose tasks then run as a single TaskRun, and no
+	// artifacts are served.
+	//+kubebuilder:default=persistentVolumeClaim
+	//+optional
+	Type StorageType `json:"type,omitempty"`
+	// SizeLimit is the size limit of an emptyDir volume
+	//+optional
+	SizeLimit *resource.Quantity `json:"sizeLimit,omitempty"`
+}
+
+// Built-in profiles accepted by spec.profile
+const (
+	// ProfileMinimal is a minimal edge system running containers with podman
+	ProfileMinimal = "minimal"
+	// ProfileKiosk starts a full screen browser session with auto-login
+	ProfileKiosk = "kiosk"
+	// ProfileGateway is an industrial gateway routing between its interfaces
+	ProfileGateway = "gateway"
+)
+
+//+kubebuilder:validation:Enum=RenderingBlueprint;PushingBlueprint;Depsolving;Building;Uploading;Verifying
+
+// BuildStage is the part of the build pipeline that is currently executing
+type BuildStage string
+
+const (
+	StageRenderingBlueprint BuildStage = "RenderingBlueprint"
+	StagePushingBlueprint   BuildStage = "PushingBlueprint"
+	StageDepsolving         BuildStage = "Depsolving"
+	StageBuilding           BuildStage = "Building"
+	StageUploading          BuildStage = "Uploading"
+	StageVerifying          BuildStage = "Verifying"
+)
+
+// StorageStrategy is how the pods of a build share its volume
+// +kubebuilder:validation:Enum=Shared;NodePinned
+type StorageStrategy string
+
+const (
+	// StorageShared is used for ReadWriteMany volumes, mounted from any node
+	StorageShared StorageStrategy = "Shared"
+	// StorageNodePinned is used for ReadWriteOnce volumes, all the pods of
+	// the image are scheduled on the node of the first one
+	StorageNodePinned StorageStrategy = "NodePinned"
+	// StorageEphemeral is used for emptyDir volumes, private to the build pod
+	StorageEphemeral StorageStrategy = "Ephemeral"
+)
+
+//+kubebuilder:validation:Enum=edge-commit;edge-container;qcow2;ami;vhd;gce;vmdk;openstack;image-installer
+
+// ComposeType is a type of image osbuild-composer builds from a blueprint
+type ComposeType string
+
+const (
+	ComposeEdgeCommit     ComposeType = "edge-commit"
+	ComposeEdgeContainer  ComposeType = "edge-container"
+	ComposeQcow2          ComposeType = "qcow2"
+	ComposeAMI            ComposeType = "ami"
+	ComposeVHD            ComposeType = "vhd"
+	ComposeGCE            ComposeType = "gce"
+	ComposeVMDK           ComposeType = "vmdk"
+	ComposeOpenStack      ComposeType = "openstack"
+	ComposeImageInstaller ComposeType = "image-installer"
+)
+
+// ComposeTypes are the compose types supported by spec.composeType
+var ComposeTypes = []ComposeType{ComposeEdgeCommit, ComposeEdgeContainer, ComposeQcow2, ComposeAMI,
+	ComposeVHD, ComposeGCE, ComposeVMDK, ComposeOpenStack, ComposeImageInstaller}
+
+//+kubebuilder:validation:Enum=tekton;job
+
+// Executor is what runs the steps of a build
+type Executor string
+
+const (
+	ExecutorTekton Executor = "tekton"
+	ExecutorJob    Executor = "job"
+)
+
+//+kubebuilder:validation:Enum=commit;installer;image;metadata;logs
+
+// ArtifactType is the kind of file produced by a build
+type ArtifactType string
+
+const (
+	ArtifactCommit    ArtifactType = "commit"
+	ArtifactInstaller ArtifactType = "installer"
+	// ArtifactImage is the image of a compose other than edge-commit, e.g. a
+	// disk image or a container archive
+	ArtifactImage    ArtifactType = "image"
+	ArtifactMetadata ArtifactType = "metadata"
+	ArtifactLogs     ArtifactType = "logs"
+)
+
+//+kubebuilder:validation:Enum=Pending;Queued;Running;Succeeded;Failed
+
+// BuildQueueStatus is the place of a build waiting for its builder
+type BuildQueueStatus struct {
+	// Builder is the ImageBuilder the build waits for, as namespace/name
+	Builder string `json:"builder"`
+	// Since is when the build started waiting, the builds waiting for the
+	// longest starting first
+	Since metav1.Time `json:"since"`
+	// Position is the place of the build in the queue of the builder, 1
+	// being the next build to start
+	Position int32 `json:"position"`
+}
+
+// BuildPhase is the state of a build, as shown by kubectl get
+type BuildPhase string
+
+const (
+	// PhasePending means the build did not start, e.g. waiting for its
+	// builder, a quota or the PipelineRun to be started
+	PhasePending BuildPhase = "Pending"
+	// PhaseQueued means the build waits for a slot of its builder, or its
+	// composes wait in the queue of composer
+	PhaseQueued BuildPhase = "Queued"
+	// PhaseRunning means the build runs, composer building or the pipeline
+	// pushing the blueprints or downloading the artifacts
+	PhaseRunning BuildPhase = "Running"
+	// PhaseSucceeded means the build produced its artifacts
+	PhaseSucceeded BuildPhase = "Succeeded"
+	// PhaseFailed means the build will not make any more progress, see the
+	// reason of the Failed condition
+	PhaseFailed BuildPhase = "Failed"
+)
+
+// ComposeStatus is a compose started by a build
+type ComposeStatus struct {
+	// Blueprint is the blueprint being composed
+	Blueprint string `json:"blueprint"`
+	// ID is the UUID of the compose in composer
+	ID string `json:"id"`
+	// ComposeType is the type of the compose, e.g. edge-commit
+	//+optional
+	ComposeType string `json:"composeType,omitempty"`
+	// QueueStatus is the state of the compose in composer: WAITING,
+	// RUNNING, FINISHED or FAILED
+	QueueStatus string `json:"queueStatus"`
+	// Created is when composer queued the compose
+	Created metav1.Time `json:"created"`
+	// LogConfigMap is the ConfigMap holding the log of the compose once it
+	// failed, in its compose.log key
+	//+optional
+	LogConfigMap string `json:"logConfigMap,omitempty"`
+}
+
+// BuildArtifact is a file produced by the last successful build
+type BuildArtifact struct {
+	Type ArtifactType `json:"type"`
+	// Name is the file name of the artifact
+	Name string `json:"name"`
+	// MediaType is the media type of the file
+	//+optional
+	MediaType string `json:"mediaType,omitempty"`
+	// ComposeType is the type of the compose that produced the file
+	//+optional
+	ComposeType string `json:"composeType,omitempty"`
+	// ComposeID is the ID of the compose that produced the file
+	//+optional
+	ComposeID string `json:"composeID,omitempty"`
+	// Digest is the sha256 digest of the file, as sha256:<hex>
+	//+optional
+	Digest string `json:"digest,omitempty"`
+	// Size is the size of the file in bytes
+	//+optional
+	Size int64 `json:"size,omitempty"`
+	// Location is the path of the artifact on the web server of the image,
+	// empty when it was not kept after the build
+	//+optional
+	Location string `json:"location,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=Pending;Succeeded;Failed
+
+// UploadState is the state of the upload of the artifacts to a target
+type UploadState string
+
+const (
+	UploadPending   UploadState = "Pending"
+	UploadSucceeded UploadState = "Succeeded"
+	UploadFailed    UploadState = "Failed"
+)
+
+// UploadStatus is the upload of the artifacts of the current build to one of
+// the upload targets
+type UploadStatus struct {
+	// Name is the name of the upload target
+	Name  string      `json:"name"`
+	State UploadState `json:"state"`
+	// Reference is the location the artifacts are pushed to
+	//+optional
+	Reference string `json:"reference,omitempty"`
+	// Digest is the digest of the pushed manifest
+	//+optional
+	Digest string `json:"digest,omitempty"`
+	// URL is where the artifacts of a successful upload are, the registry
+	// reference pinned to the digest, or the s3:// or pvc:// location
+	//+optional
+	URL string `json:"url,omitempty"`
+	// Message tells why the upload failed
+	//+optional
+	Message string `json:"message,omitempty"`
+}
+
+// ArchitectureStatus is the build of an image for one of spec.architectures
+type ArchitectureStatus struct {
+	Architecture ImageArchitecture `json:"architecture"`
+	// Image is the ImageBuilderImage building the architecture
+	Image string `json:"image"`
+	// Phase is the phase of the build of the architecture
+	//+optional
+	Phase BuildPhase `json:"phase,omitempty"`
+	// Message is the message of the Ready condition of the image
+	//+optional
+	Message string `json:"message,omitempty"`
+	// Artifacts are the files produced by the last successful build of the
+	// architecture, served by the web deployment of the image
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Artifacts []BuildArtifact `json:"artifacts,omitempty"`
+	// Uploads are the uploads of the artifacts of the architecture
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Uploads []UploadStatus `json:"uploads,omitempty"`
+}
+
+// OSTreeCompose are the ostree options of the compose of an edge commit
+type OSTreeCompose struct {
+	// Ref is the ref of the commit, defaults to the ref of the parent image
+	// or of the distribution
+	//+optional
+	Ref string `json:"ref,omitempty"`
+	// ParentRef is the ref, or commit, of url the commit upgrades
+	//+optional
+	ParentRef string `json:"parentRef,omitempty"`
+	// URL is the repository holding parentRef
+	//+optional
+	//+kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url,omitempty"`
+	// ParentImage is an ImageBuilderImage of the namespace whose commit,
+	// published to the ostree repository of its builder, the commit upgrades.
+	// It replaces parentRef and url.
+	//+optional
+	ParentImage string `json:"parentImage,omitempty"`
+}
+
+// OSTreeCommit is an edge commit published to the ostree repository of the
+// builder
+type OSTreeCommit struct {
+	// URL is the URL of the repository, the remote of the devices
+	URL string `json:"url"`
+	// Ref is the ref of the commit in the repository
+	//+optional
+	Ref string `json:"ref,omitempty"`
+	// Commit is the checksum of the commit
+	//+optional
+	Commit string `json:"commit,omitempty"`
 }
 
 // ImageBuilderImageStatus defines the observed state of ImageBuilderImage
+//+kubebuilder:validation:Enum=Running;Succeeded;Failed;Superseded
+
+// BuildResult is the outcome of a build of the history
+type BuildResult string
+
+const (
+	BuildRunning    BuildResult = "Running"
+	BuildSucceeded  BuildResult = "Succeeded"
+	BuildFailed     BuildResult = "Failed"
+	BuildSuperseded BuildResult = "Superseded"
+)
+
+// BuildPlan is what a dry run of the image would create
+type BuildPlan struct {
+	// ConfigMap holds the rendered blueprints and the manifests of the
+	// resources, in a Secret of the same name when the blueprints embed the
+	// values of Secrets
+	ConfigMap string `json:"configMap"`
+	// Secret tells the plan is stored in a Secret
+	//+optional
+	Secret bool `json:"secret,omitempty"`
+	// Resources are the kind and name of the resources the build would create
+	//+optional
+	Resources []string `json:"resources,omitempty"`
+	// Hash is the hash of the content of the plan
+	Hash string `json:"hash"`
+	// RenderTime is when the plan last changed
+	RenderTime metav1.Time `json:"renderTime"`
+}
+
+// CloudImage is an image composer uploaded to a cloud
+type CloudImage struct {
+	// Provider is the cloud, aws, azure or gcp
+	Provider string `json:"provider"`
+	// ComposeID is the UUID of the compose uploaded
+	ComposeID string `json:"composeID"`
+	// Generation is the generation of the image built
+	Generation int64 `json:"generation"`
+	// Status is the state of the upload in composer: WAITING, RUNNING,
+	// FINISHED or FAILED
+	Status string `json:"status"`
+	// ImageName is the name of the image in the cloud
+	//+optional
+	ImageName string `json:"imageName,omitempty"`
+	// ImageID is the AMI ID, the Azure image or the Compute Engine image,
+	// only reported by the Cloud API
+	//+optional
+	ImageID string `json:"imageID,omitempty"`
+	// Region is the region of the image
+	//+optional
+	Region string `json:"region,omitempty"`
+	// ProjectID is the Google Cloud project of the image
+	//+optional
+	ProjectID string `json:"projectID,omitempty"`
+}
+
+// BuildHistoryEntry summarizes a build of the image, its ImageBuilderCompose
+// of the same name reporting it
+type BuildHistoryEntry struct {
+	// Build is the name of the PipelineRun or Job of the build
+	Build string `json:"build"`
+	// Generation is the generation of the image built
+	Generation int64 `json:"generation"`
+	// BlueprintVersion is the version the blueprints were pushed with
+	//+optional
+	BlueprintVersion string `json:"blueprintVersion,omitempty"`
+	// ComposeType is the type of the image composed
+	//+optional
+	ComposeType ComposeType `json:"composeType,omitempty"`
+	Result      BuildResult `json:"result"`
+}
+
 type ImageBuilderImageStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the most recent generation reconciled by the controller
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// PipelineRun is the name of the PipelineRun building this image
+	//+optional
+	PipelineRun string `json:"pipelineRun,omitempty"`
+	// Job is the name of the Job building this image, with the job executor
+	//+optional
+	Job string `json:"job,omitempty"`
+	// BuildRecord is the immutable ConfigMap recording the inputs of the current build
+	//+optional
+	BuildRecord string `json:"buildRecord,omitempty"`
+	// BlueprintVersion is the version the blueprints of the current build
+	// were pushed to composer with, bumped by every build
+	//+optional
+	BlueprintVersion string `json:"blueprintVersion,omitempty"`
+	// BuildNumber counts the PipelineRuns created for the image, the last one
+	// being named after it
+	//+optional
+	BuildNumber int64 `json:"buildNumber,omitempty"`
+	// LastScheduledBuildTime is the last time spec.schedule started a build
+	//+optional
+	LastScheduledBuildTime *metav1.Time `json:"lastScheduledBuildTime,omitempty"`
+	// LastSkippedBuildTime is the last time spec.schedule was due while
+	// spec.suspend was set
+	//+optional
+	LastSkippedBuildTime *metav1.Time `json:"lastSkippedBuildTime,omitempty"`
+	// NextBuildTime is the next time spec.schedule starts a build
+	//+optional
+	NextBuildTime *metav1.Time `json:"nextBuildTime,omitempty"`
+	// History lists the last builds, newest first, up to spec.historyLimit
+	//+optional
+	History []BuildHistoryEntry `json:"history,omitempty"`
+	// BlueprintHash is the hash of the rendered blueprints of the last reconcile
+	//+optional
+	BlueprintHash string `json:"blueprintHash,omitempty"`
+	// Plan is what the build would create, while spec.dryRun is set
+	//+optional
+	Plan *BuildPlan `json:"plan,omitempty"`
+	// BlueprintConfigMap is the immutable ConfigMap holding the exact blueprints
+	// sent to composer by the current build
+	//+optional
+	BlueprintConfigMap string `json:"blueprintConfigMap,omitempty"`
+	// BlueprintSecret is the immutable Secret holding the blueprints of the
+	// current build instead of BlueprintConfigMap when they embed the values
+	// of Secrets
+	//+optional
+	BlueprintSecret string `json:"blueprintSecret,omitempty"`
+	// BlueprintDiff is a unified diff between the blueprints of the current build
+	// and the ones rendered from the latest generation
+	//+optional
+	BlueprintDiff string `json:"blueprintDiff,omitempty"`
+	// Stage is the build stage currently executing, empty when no build is running
+	//+optional
+	Stage BuildStage `json:"stage,omitempty"`
+	// Progress is a rough completion percentage of the build, based on the
+	// pipeline tasks and steps that have finished
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=100
+	Progress int32 `json:"progress,omitempty"`
+	// ArtifactsGeneration is the generation of the last successful build,
+	// whose artifacts are served by the web deployment
+	//+optional
+	ArtifactsGeneration int64 `json:"artifactsGeneration,omitempty"`
+	// Artifacts are the files produced by the last successful build
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Artifacts []BuildArtifact `json:"artifacts,omitempty"`
+	// Uploads are the uploads of the artifacts of the current build, one per
+	// upload target
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Uploads []UploadStatus `json:"uploads,omitempty"`
+	// CloudImage is the image the last successful build uploaded with
+	// spec.upload
+	//+optional
+	CloudImage *CloudImage `json:"cloudImage,omitempty"`
+	// OSTree is the last edge commit published to the ostree repository of
+	// the builder
+	//+optional
+	OSTree *OSTreeCommit `json:"ostree,omitempty"`
+	// Callbacks are the deliveries of the build events to the callbacks
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Callbacks []CallbackStatus `json:"callbacks,omitempty"`
+	// CloudEvents is the delivery of the build events to the CloudEvents sink
+	// of the operator, named by its URI
+	//+optional
+	CloudEvents *CallbackStatus `json:"cloudEvents,omitempty"`
+	// Kafka is the delivery of the build events to the Kafka topic of the
+	// operator, named by the topic and its bridge
+	//+optional
+	Kafka *CallbackStatus `json:"kafka,omitempty"`
+	// Phase summarizes the state of the current build
+	//+optional
+	Phase BuildPhase `json:"phase,omitempty"`
+	// Queue tells where the build waits while its builder runs as many
+	// builds as spec.maxConcurrentBuilds of the builder allows
+	//+optional
+	Queue *BuildQueueStatus `json:"queue,omitempty"`
+	// ArtifactsURL is the URL of the web server serving the artifacts with
+	// spec.exposeArtifact, from its Route, or of its Service on clusters
+	// without routes
+	//+optional
+	ArtifactsURL string `json:"artifactsURL,omitempty"`
+	// ArtifactURL is the URL of the main artifact of the last successful
+	// build, with spec.exposeArtifact
+	//+optional
+	ArtifactURL string `json:"artifactURL,omitempty"`
+	// FDOManufacturingServerURL is the URL of the FDO manufacturing server
+	// the installer onboards with, from spec.fdo
+	//+optional
+	FDOManufacturingServerURL string `json:"fdoManufacturingServerUrl,omitempty"`
+	// Architectures are the builds of spec.architectures
+	//+optional
+	//+listType=map
+	//+listMapKey=architecture
+	Architectures []ArchitectureStatus `json:"architectures,omitempty"`
+	// Composes are the composes started in composer by the current build
+	//+optional
+	//+listType=map
+	//+listMapKey=blueprint
+	Composes []ComposeStatus `json:"composes,omitempty"`
+	// BuildDuration is the time the last successful build took
+	//+optional
+	BuildDuration *metav1.Duration `json:"buildDuration,omitempty"`
+	// BuilderVersion is the version of the composer that ran the last
+	// successful build
+	//+optional
+	BuilderVersion string `json:"builderVersion,omitempty"`
+	// StorageStrategy is how the build pods share the volume, chosen from
+	// its access modes
+	//+optional
+	StorageStrategy StorageStrategy `json:"storageStrategy,omitempty"`
+	// Conditions holds the Ready and Failed conditions of the image build
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=osbuild,shortName=ibi
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+//+kubebuilder:printcolumn:name="Stage",type="string",JSONPath=".status.stage"
+//+kubebuilder:printcolumn:name="Progress",type="integer",JSONPath=".status.progress",priority=1
+//+kubebuilder:printcolumn:name="Compose",type="string",JSONPath=".status.composes[0].id",priority=1
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // ImageBuilderImage is the Schema for the imagebuilderimages API
 type ImageBuilderImage struct {
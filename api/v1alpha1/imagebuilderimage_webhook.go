@@ -0,0 +1,881 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var imagebuilderimagelog = logf.Log.WithName("imagebuilderimage-resource")
+
+// installer targets supported by spec.isoTarget
+var isoTargets = []string{"edge-installer", "edge-simplified-installer"}
+
+// composeTypeNames lists the supported compose types for validation errors
+func composeTypeNames() []string {
+	names := []string{}
+	for _, composeType := range ComposeTypes {
+		names = append(names, string(composeType))
+	}
+	return names
+}
+
+// SetupWebhookWithManager will setup the manager to manage the webhooks
+func (r *ImageBuilderImage) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&imageBuilderImageDefaulter{}).
+		WithValidator(&imageBuilderImageValidator{client: mgr.GetClient()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-osbuild-rh-ecosystem-edge-io-v1alpha1-imagebuilderimage,mutating=true,failurePolicy=fail,sideEffects=None,groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilderimages,verbs=create,versions=v1alpha1,name=mimagebuilderimage.kb.io,admissionReviewVersions=v1
+
+//+kubebuilder:object:generate=false
+
+// imageBuilderImageDefaulter fills in the fields the controller would default,
+// so the stored spec shows what is built. It only runs on create, as a spec
+// that changes rebuilds the image.
+type imageBuilderImageDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &imageBuilderImageDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *imageBuilderImageDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	image := obj.(*ImageBuilderImage)
+	imagebuilderimagelog.Info("default", "name", image.Name)
+	image.Spec.Default(image.Name)
+	return nil
+}
+
+// Default sets the blueprint name to name, the compose type to edge-commit
+// and the installer of edge-commit composes to the simplified installer
+func (s *ImageBuilderImageSpec) Default(name string) {
+	if s.Name == "" {
+		s.Name = name
+	}
+	if s.ComposeType == "" {
+		s.ComposeType = ComposeEdgeCommit
+	}
+	if s.ComposeType == ComposeEdgeCommit && s.IsoTarget == "" {
+		s.IsoTarget = "edge-simplified-installer"
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-osbuild-rh-ecosystem-edge-io-v1alpha1-imagebuilderimage,mutating=false,failurePolicy=fail,sideEffects=None,groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilderimages,verbs=create;update,versions=v1alpha1,name=vimagebuilderimage.kb.io,admissionReviewVersions=v1
+
+//+kubebuilder:object:generate=false
+
+// imageBuilderImageValidator rejects specs that would only fail once the
+// compose is running, and references to builders that do not exist
+type imageBuilderImageValidator struct {
+	client client.Client
+}
+
+var _ webhook.CustomValidator = &imageBuilderImageValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *imageBuilderImageValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	image := obj.(*ImageBuilderImage)
+	imagebuilderimagelog.Info("validate create", "name", image.Name)
+	return nil, v.validate(ctx, image, nil)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *imageBuilderImageValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	image := newObj.(*ImageBuilderImage)
+	imagebuilderimagelog.Info("validate update", "name", image.Name)
+	return nil, v.validate(ctx, image, oldObj.(*ImageBuilderImage))
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *imageBuilderImageValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *imageBuilderImageValidator) validate(ctx context.Context, image *ImageBuilderImage, old *ImageBuilderImage) error {
+	errs := image.Spec.Validate(field.NewPath("spec"))
+	builderErrs, err := v.validateBuilderRef(ctx, image, old)
+	if err != nil {
+		return err
+	}
+	errs = append(errs, builderErrs...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("ImageBuilderImage").GroupKind(), image.Name, errs)
+}
+
+// validateBuilderRef rejects a spec.clusterImageBuilder or spec.imageBuilder
+// that does not exist, or whose spec.allowedNamespaces does not select the
+// namespace of the image. The reference is only checked when it is set or
+// changed, so images keep updating once their builder is gone.
+func (v *imageBuilderImageValidator) validateBuilderRef(ctx context.Context, image *ImageBuilderImage, old *ImageBuilderImage) (field.ErrorList, error) {
+	errs := field.ErrorList{}
+	spec := &image.Spec
+	name, namespace := spec.BuilderReference()
+	if old != nil {
+		oldName, oldNamespace := old.Spec.BuilderReference()
+		if old.Spec.ClusterImageBuilder == spec.ClusterImageBuilder && oldName == name && oldNamespace == namespace {
+			return errs, nil
+		}
+	}
+	if spec.ClusterImageBuilder != "" {
+		clusterImageBuilder := ClusterImageBuilder{}
+		err := v.client.Get(ctx, client.ObjectKey{Name: spec.ClusterImageBuilder}, &clusterImageBuilder)
+		if apierrors.IsNotFound(err) {
+			return append(errs, field.Invalid(field.NewPath("spec", "clusterImageBuilder"), spec.ClusterImageBuilder,
+				fmt.Sprintf("ClusterImageBuilder %s does not exist, create it first", spec.ClusterImageBuilder))), nil
+		}
+		return errs, err
+	}
+	if name == "" {
+		return errs, nil
+	}
+	if namespace == "" {
+		namespace = image.Namespace
+	}
+	imageBuilder := ImageBuilder{}
+	err := v.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &imageBuilder)
+	if apierrors.IsNotFound(err) {
+		if spec.ImageBuilderRef != nil {
+			return append(errs, field.Invalid(field.NewPath("spec", "imageBuilderRef"), *spec.ImageBuilderRef,
+				fmt.Sprintf("ImageBuilder %s does not exist in namespace %s, create it first", name, namespace))), nil
+		}
+		return append(errs, field.Invalid(field.NewPath("spec", "imageBuilder"), spec.ImageBuilder,
+			fmt.Sprintf("ImageBuilder %s does not exist in namespace %s, create it first or set spec.imageBuilderNamespace", name, namespace))), nil
+	}
+	if err != nil || namespace == image.Namespace || imageBuilder.Spec.AllowedNamespaces == nil {
+		return errs, err
+	}
+	// the multi-tenant mode is only known to the controller, which still
+	// fails the images of the namespaces it does not allow
+	selector, err := metav1.LabelSelectorAsSelector(imageBuilder.Spec.AllowedNamespaces)
+	if err != nil {
+		return errs, err
+	}
+	imageNamespace := corev1.Namespace{}
+	if err := v.client.Get(ctx, client.ObjectKey{Name: image.Namespace}, &imageNamespace); err != nil {
+		return errs, err
+	}
+	if !selector.Matches(labels.Set(imageNamespace.Labels)) {
+		builderPath := field.NewPath("spec", "imageBuilder")
+		if spec.ImageBuilderRef != nil {
+			builderPath = field.NewPath("spec", "imageBuilderRef")
+		}
+		errs = append(errs, field.Forbidden(builderPath,
+			fmt.Sprintf("ImageBuilder %s/%s does not allow namespace %s, see its spec.allowedNamespaces", namespace, name, image.Namespace)))
+	}
+	return errs, nil
+}
+
+// BuilderReference returns the name and namespace of the ImageBuilder named
+// by imageBuilderRef, or by imageBuilder and imageBuilderNamespace, the
+// namespace being empty for the one of the image
+func (s *ImageBuilderImageSpec) BuilderReference() (string, string) {
+	if s.ImageBuilderRef != nil {
+		return s.ImageBuilderRef.Name, s.ImageBuilderRef.Namespace
+	}
+	return s.ImageBuilder, s.ImageBuilderNamespace
+}
+
+// validateBuilderSelection rejects the ways of selecting the builder that
+// can not be combined
+func (s *ImageBuilderImageSpec) validateBuilderSelection(specPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	if s.ImageBuilderRef != nil && (s.ImageBuilder != "" || s.ImageBuilderNamespace != "") {
+		errs = append(errs, field.Forbidden(specPath.Child("imageBuilderRef"),
+			"imageBuilderRef replaces imageBuilder and imageBuilderNamespace, only set one of them"))
+	}
+	if len(s.Architectures) > 0 {
+		architecturesPath := specPath.Child("architectures")
+		if s.ClusterImageBuilder != "" || s.ImageBuilder != "" || s.ImageBuilderRef != nil {
+			errs = append(errs, field.Forbidden(architecturesPath,
+				"each architecture is built by an ImageBuilder of that architecture, select them with imageBuilderSelector instead of clusterImageBuilder, imageBuilder and imageBuilderRef"))
+		}
+		seen := map[ImageArchitecture]bool{}
+		for i, architecture := range s.Architectures {
+			if seen[architecture] {
+				errs = append(errs, field.Duplicate(architecturesPath.Index(i), architecture))
+			}
+			seen[architecture] = true
+		}
+	}
+	if s.ImageBuilderSelector == nil {
+		return errs
+	}
+	selectorPath := specPath.Child("imageBuilderSelector")
+	if s.ClusterImageBuilder != "" || s.ImageBuilder != "" || s.ImageBuilderRef != nil {
+		errs = append(errs, field.Forbidden(selectorPath,
+			"the selector only applies when no builder is referenced, unset clusterImageBuilder, imageBuilder and imageBuilderRef"))
+	}
+	if _, err := metav1.LabelSelectorAsSelector(s.ImageBuilderSelector); err != nil {
+		errs = append(errs, field.Invalid(selectorPath, s.ImageBuilderSelector, err.Error()))
+	}
+	return errs
+}
+
+// Validate checks the fields composer would only reject deep into the
+// installer build. It is used by the admission webhook, and again by the
+// controller as the webhook may be disabled.
+func (s *ImageBuilderImageSpec) Validate(specPath *field.Path) field.ErrorList {
+	errs := s.validateBuilderSelection(specPath)
+	isoTarget := s.IsoTarget
+	if isoTarget != "" {
+		supported := false
+		for _, target := range isoTargets {
+			supported = supported || target == isoTarget
+		}
+		if !supported {
+			errs = append(errs, field.NotSupported(specPath.Child("isoTarget"), isoTarget, isoTargets))
+		}
+	}
+	if s.ComposeType != "" {
+		supported := false
+		for _, composeType := range ComposeTypes {
+			supported = supported || composeType == s.ComposeType
+		}
+		if !supported {
+			errs = append(errs, field.NotSupported(specPath.Child("composeType"), s.ComposeType, composeTypeNames()))
+		}
+	}
+	// the installer is only built from edge-commit composes
+	installer := s.ComposeType == "" || s.ComposeType == ComposeEdgeCommit
+	if !installer && isoTarget != "" {
+		errs = append(errs, field.Forbidden(specPath.Child("isoTarget"),
+			fmt.Sprintf("no installer is built for the %s compose type", s.ComposeType)))
+	}
+	if !installer && s.BlueprintIsoTemplate != "" {
+		errs = append(errs, field.Forbidden(specPath.Child("blueprintIsoTemplate"),
+			fmt.Sprintf("no installer is built for the %s compose type", s.ComposeType)))
+	}
+	if !installer && s.BlueprintIsoTemplateRef != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("blueprintIsoTemplateRef"),
+			fmt.Sprintf("no installer is built for the %s compose type", s.ComposeType)))
+	}
+	// the default installer blueprint always sets both fields for the
+	// simplified installer, which is also the default target
+	simplifiedInstaller := installer && (isoTarget == "" || isoTarget == "edge-simplified-installer") &&
+		s.BlueprintIsoTemplate == "" && s.BlueprintIsoTemplateRef == nil
+	if s.InstallationDevice != "" {
+		errs = append(errs, validateDevicePath(specPath.Child("installationDevice"), s.InstallationDevice)...)
+	} else if simplifiedInstaller {
+		errs = append(errs, field.Required(specPath.Child("installationDevice"),
+			"the edge-simplified-installer target needs the disk to install to, e.g. /dev/vda"))
+	}
+	switch {
+	case s.FDO != nil && s.FdoManufacturingServerUrl != "":
+		errs = append(errs, field.Forbidden(specPath.Child("fdoManufacturingServerUrl"), "replaced by spec.fdo.manufacturingServerUrl, they can not be set together"))
+	case s.FDO != nil && !installer:
+		errs = append(errs, field.Forbidden(specPath.Child("fdo"),
+			fmt.Sprintf("no installer is built for the %s compose type", s.ComposeType)))
+	case s.FDO != nil:
+		errs = append(errs, validateFDO(specPath.Child("fdo"), s.FDO)...)
+	case s.FdoManufacturingServerUrl != "":
+		errs = append(errs, validateServerURL(specPath.Child("fdoManufacturingServerUrl"), s.FdoManufacturingServerUrl)...)
+	case simplifiedInstaller:
+		errs = append(errs, field.Required(specPath.Child("fdo"),
+			"the edge-simplified-installer target needs the FDO manufacturing server, e.g. fdo.manufacturingServerUrl: http://fdo-manufacturing.example.com:8080"))
+	}
+	if s.Profile == ProfileKiosk && (s.UserName == "" || s.UserName == "root") {
+		errs = append(errs, field.Invalid(specPath.Child("userName"), s.UserName,
+			"the kiosk profile logs in automatically with spec.userName, which must be set to a user other than root"))
+	}
+	if s.SharedVolume != "" || (s.Storage != nil && s.Storage.Type == StorageEmptyDir) {
+		// the claim is only created for the default persistentVolumeName
+		for _, claimField := range []struct {
+			name string
+			set  bool
+		}{
+			{"sharedVolumeSize", s.SharedVolumeSize != nil},
+			{"storageClassName", s.StorageClassName != nil},
+			{"accessModes", len(s.AccessModes) > 0},
+		} {
+			if claimField.set {
+				errs = append(errs, field.Forbidden(specPath.Child(claimField.name),
+					"only applies to the PersistentVolumeClaim created when spec.persistentVolumeName is not set"))
+			}
+		}
+	}
+	if s.SharedVolumeSize != nil && s.SharedVolumeSize.Sign() <= 0 {
+		errs = append(errs, field.Invalid(specPath.Child("sharedVolumeSize"), s.SharedVolumeSize.String(),
+			"must be greater than zero"))
+	}
+	if s.Storage != nil {
+		storagePath := specPath.Child("storage")
+		switch {
+		case s.Storage.Type == StorageEmptyDir && s.SharedVolume != "":
+			errs = append(errs, field.Forbidden(specPath.Child("persistentVolumeName"),
+				"an emptyDir build does not use a PersistentVolumeClaim"))
+		case s.Storage.Type != StorageEmptyDir && s.Storage.SizeLimit != nil:
+			errs = append(errs, field.Forbidden(storagePath.Child("sizeLimit"),
+				"the size limit only applies to emptyDir volumes"))
+		}
+		if s.Storage.SizeLimit != nil && s.Storage.SizeLimit.Sign() <= 0 {
+			errs = append(errs, field.Invalid(storagePath.Child("sizeLimit"), s.Storage.SizeLimit.String(),
+				"must be greater than zero"))
+		}
+	}
+	for i, callback := range s.Callbacks {
+		callbackPath := specPath.Child("callbacks").Index(i)
+		switch {
+		case callback.URL == "" && callback.URLSecret == nil:
+			errs = append(errs, field.Required(callbackPath.Child("url"), "one of url or urlSecret is required"))
+		case callback.URL != "" && callback.URLSecret != nil:
+			errs = append(errs, field.Forbidden(callbackPath.Child("urlSecret"), "can not be set along with url"))
+		}
+	}
+	if s.Hooks != nil {
+		hooksPath := specPath.Child("hooks")
+		if s.PipelineRef != nil {
+			errs = append(errs, field.Forbidden(hooksPath, "hooks are only added to the generated pipeline, not to spec.pipelineRef"))
+		}
+		errs = append(errs, s.validateHooks(hooksPath.Child("preBuild"), s.Hooks.PreBuild)...)
+		errs = append(errs, s.validateHooks(hooksPath.Child("postBuild"), s.Hooks.PostBuild)...)
+	}
+	if t := s.ComposeTimeouts; t != nil {
+		timeoutsPath := specPath.Child("composeTimeouts")
+		names := []string{"pollInterval", "depsolve", "build", "upload", "total"}
+		for i, duration := range []*metav1.Duration{t.PollInterval, t.Depsolve, t.Build, t.Upload, t.Total} {
+			if duration != nil && duration.Duration <= 0 {
+				errs = append(errs, field.Invalid(timeoutsPath.Child(names[i]), duration.Duration.String(), "must be greater than zero"))
+			}
+		}
+		if t.PollInterval != nil && t.PollInterval.Duration > 0 && t.PollInterval.Duration < time.Second {
+			errs = append(errs, field.Invalid(timeoutsPath.Child("pollInterval"), t.PollInterval.Duration.String(), "must be at least 1s"))
+		}
+	}
+	if s.Retries != nil && s.Retries.Delay != nil && s.Retries.Delay.Duration < time.Second {
+		errs = append(errs, field.Invalid(specPath.Child("retries", "delay"), s.Retries.Delay.Duration.String(), "must be at least 1s"))
+	}
+	for i, target := range s.UploadTargets {
+		targetPath := specPath.Child("uploadTargets").Index(i)
+		if s.PipelineRef != nil {
+			errs = append(errs, field.Forbidden(targetPath, "uploads are only added to the generated pipeline, not to spec.pipelineRef"))
+		}
+		destinations := 0
+		for _, set := range []bool{target.Registry != nil, target.S3 != nil, target.PVC != nil} {
+			if set {
+				destinations++
+			}
+		}
+		if destinations == 0 {
+			errs = append(errs, field.Required(targetPath.Child("registry"), "the destination of the upload is required, one of registry, s3 or pvc"))
+		} else if destinations > 1 {
+			errs = append(errs, field.Invalid(targetPath, target.Name, "only one of registry, s3 or pvc may be set"))
+		}
+	}
+	if s.Upload != nil {
+		errs = append(errs, s.validateUpload(specPath.Child("upload"))...)
+	}
+	if s.OSTree != nil {
+		ostreePath := specPath.Child("ostree")
+		if s.ComposeType != "" && s.ComposeType != ComposeEdgeCommit && s.ComposeType != ComposeEdgeContainer {
+			errs = append(errs, field.Forbidden(ostreePath, "ostree options only apply to edge-commit and edge-container composes"))
+		}
+		if s.OSTree.ParentImage != "" && (s.OSTree.ParentRef != "" || s.OSTree.URL != "") {
+			errs = append(errs, field.Forbidden(ostreePath.Child("parentImage"), "the parent of parentImage replaces parentRef and url"))
+		}
+		if s.OSTree.URL != "" && s.OSTree.ParentRef == "" {
+			errs = append(errs, field.Required(ostreePath.Child("parentRef"), "the ref of url to upgrade is required"))
+		}
+	}
+	if s.BuildPod != nil {
+		stepImagesPath := specPath.Child("buildPod", "stepImages")
+		names := []string{}
+		for _, name := range StepImages {
+			names = append(names, string(name))
+		}
+		for name, reference := range s.BuildPod.StepImages {
+			known := false
+			for _, stepImage := range StepImages {
+				known = known || stepImage == name
+			}
+			if !known {
+				errs = append(errs, field.NotSupported(stepImagesPath, name, names))
+			} else if reference == "" {
+				errs = append(errs, field.Required(stepImagesPath.Key(string(name)), "the reference of the image is required"))
+			}
+		}
+	}
+	if s.Scripts != nil && s.PipelineRef != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("scripts"), "scripts are only added to the generated pipeline, not to spec.pipelineRef"))
+	}
+	if s.Executor == ExecutorJob {
+		// these are Tekton tasks and pipelines, which the Job does not run
+		if s.PipelineRef != nil {
+			errs = append(errs, field.Forbidden(specPath.Child("pipelineRef"), "a Tekton pipeline can not be run by the job executor"))
+		}
+		if s.Hooks != nil {
+			errs = append(errs, field.Forbidden(specPath.Child("hooks"), "hooks are Tekton tasks, which the job executor does not run"))
+		}
+		if len(s.UploadTargets) > 0 {
+			errs = append(errs, field.Forbidden(specPath.Child("uploadTargets"), "uploads are only supported by the tekton executor"))
+		}
+	}
+	if s.Schedule != "" {
+		if _, err := ParseSchedule(s.Schedule); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("schedule"), s.Schedule, err.Error()))
+		}
+	}
+	errs = append(errs, s.validateCustomizations(specPath)...)
+	errs = append(errs, s.validateSecretRefs(specPath)...)
+	errs = append(errs, validateTemplateRef(specPath.Child("blueprintTemplateRef"), s.BlueprintTemplateRef,
+		specPath.Child("blueprintTemplate"), s.BlueprintTemplate)...)
+	errs = append(errs, validateTemplateRef(specPath.Child("blueprintIsoTemplateRef"), s.BlueprintIsoTemplateRef,
+		specPath.Child("blueprintIsoTemplate"), s.BlueprintIsoTemplate)...)
+	errs = append(errs, validateTemplateRef(specPath.Child("kickstartRef"), s.KickstartRef,
+		specPath.Child("kickstart"), s.Kickstart)...)
+	errs = append(errs, s.validateKickstart(specPath)...)
+	if s.BlueprintTemplate != "" {
+		errs = append(errs, lintTemplate(specPath.Child("blueprintTemplate"), s.BlueprintTemplate, s)...)
+	}
+	if s.BlueprintIsoTemplate != "" {
+		errs = append(errs, lintTemplate(specPath.Child("blueprintIsoTemplate"), s.BlueprintIsoTemplate, s)...)
+	}
+	return errs
+}
+
+// validateHooks makes sure the hooks bind workspaces that exist and that
+// their pods can share
+func (s *ImageBuilderImageSpec) validateHooks(hooksPath *field.Path, hooks []BuildHook) field.ErrorList {
+	errs := field.ErrorList{}
+	workspaces := []string{"blueprints", "shared-volume"}
+	for i, hook := range hooks {
+		hookPath := hooksPath.Index(i)
+		switch {
+		case hook.Task == "" && hook.Resolver == "":
+			errs = append(errs, field.Required(hookPath.Child("task"), "one of task or resolver is required"))
+		case hook.Task != "" && hook.Resolver != "":
+			errs = append(errs, field.Forbidden(hookPath.Child("resolver"), "can not be set along with task"))
+		case hook.Resolver == "" && len(hook.ResolverParams) > 0:
+			errs = append(errs, field.Forbidden(hookPath.Child("resolverParams"), "only apply to a Task fetched by a resolver"))
+		}
+		for j, workspace := range hook.Workspaces {
+			workspacePath := hookPath.Child("workspaces").Index(j)
+			switch {
+			case workspace != workspaces[0] && workspace != workspaces[1]:
+				errs = append(errs, field.NotSupported(workspacePath, workspace, workspaces))
+			case workspace == "shared-volume" && s.Storage != nil && s.Storage.Type == StorageEmptyDir:
+				errs = append(errs, field.Forbidden(workspacePath, "an emptyDir volume is not shared with the pods of the hooks"))
+			}
+		}
+	}
+	return errs
+}
+
+// validateSecretRefs checks the Secrets read while rendering the templates
+func (s *ImageBuilderImageSpec) validateSecretRefs(specPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	if ref := s.SshKeySecretRef; ref != nil {
+		if s.SshKey != "" {
+			errs = append(errs, field.Forbidden(specPath.Child("sshKeySecretRef"), "can not be set along with spec.sshKey"))
+		}
+		if ref.Name == "" || ref.Key == "" {
+			errs = append(errs, field.Required(specPath.Child("sshKeySecretRef"), "the name and key of the Secret are required"))
+		}
+	}
+	names := map[string]bool{}
+	for i, secret := range s.TemplateSecrets {
+		secretPath := specPath.Child("templateSecrets").Index(i)
+		if names[secret.Name] {
+			errs = append(errs, field.Duplicate(secretPath.Child("name"), secret.Name))
+		}
+		names[secret.Name] = true
+		if secret.SecretKeyRef.Name == "" || secret.SecretKeyRef.Key == "" {
+			errs = append(errs, field.Required(secretPath.Child("secretKeyRef"), "the name and key of the Secret are required"))
+		}
+	}
+	return errs
+}
+
+// validateTemplateRef makes sure a template reference selects a single key
+// and is not set along with the inline template
+func validateTemplateRef(refPath *field.Path, ref *TemplateReference, inlinePath *field.Path, inline string) field.ErrorList {
+	errs := field.ErrorList{}
+	if ref == nil {
+		return errs
+	}
+	if inline != "" {
+		errs = append(errs, field.Forbidden(refPath, fmt.Sprintf("can not be set along with %s", inlinePath)))
+	}
+	switch {
+	case ref.ConfigMapKeyRef == nil && ref.SecretKeyRef == nil:
+		errs = append(errs, field.Required(refPath, "one of configMapKeyRef or secretKeyRef is required"))
+	case ref.ConfigMapKeyRef != nil && ref.SecretKeyRef != nil:
+		errs = append(errs, field.Forbidden(refPath.Child("secretKeyRef"), "can not be set along with configMapKeyRef"))
+	case ref.ConfigMapKeyRef != nil && (ref.ConfigMapKeyRef.Name == "" || ref.ConfigMapKeyRef.Key == ""):
+		errs = append(errs, field.Required(refPath.Child("configMapKeyRef"), "the name and key of the ConfigMap are required"))
+	case ref.SecretKeyRef != nil && (ref.SecretKeyRef.Name == "" || ref.SecretKeyRef.Key == ""):
+		errs = append(errs, field.Required(refPath.Child("secretKeyRef"), "the name and key of the Secret are required"))
+	}
+	return errs
+}
+
+// validateKickstart rejects a kickstart for the images without an Anaconda
+// installer ISO, and ISO customizations without a kickstart
+func (s *ImageBuilderImageSpec) validateKickstart(specPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	kickstart := s.Kickstart != "" || s.KickstartRef != nil
+	// the simplified installer is not an Anaconda installer
+	anaconda := s.ComposeType == ComposeImageInstaller ||
+		((s.ComposeType == "" || s.ComposeType == ComposeEdgeCommit) && s.IsoTarget == "edge-installer")
+	if kickstart && !anaconda {
+		kickstartPath := specPath.Child("kickstart")
+		if s.KickstartRef != nil {
+			kickstartPath = specPath.Child("kickstartRef")
+		}
+		errs = append(errs, field.Forbidden(kickstartPath,
+			"the kickstart is only embedded into the installers of image-installer images and of the edge-installer target"))
+	}
+	if s.ISO == nil {
+		return errs
+	}
+	if !kickstart {
+		errs = append(errs, field.Forbidden(specPath.Child("iso"), "only applies to the ISO the kickstart is embedded into, set kickstart or kickstartRef"))
+	}
+	if strings.ContainsAny(s.ISO.KernelArgs, "\n\r") {
+		errs = append(errs, field.Invalid(specPath.Child("iso", "kernelArgs"), s.ISO.KernelArgs, "must be a single line"))
+	}
+	return errs
+}
+
+// uploadProviders are the clouds spec.upload uploads the image of a compose
+// type to
+var uploadProviders = map[ComposeType]string{
+	ComposeAMI: "aws",
+	ComposeVHD: "azure",
+	ComposeGCE: "gcp",
+}
+
+// validateUpload makes sure spec.upload sets the cloud of the compose type,
+// and only it
+func (s *ImageBuilderImageSpec) validateUpload(uploadPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	upload := s.Upload
+	if s.PipelineRef != nil {
+		errs = append(errs, field.Forbidden(uploadPath, "the upload is only requested by the generated pipeline, not by spec.pipelineRef"))
+	}
+	expected, ok := uploadProviders[s.ComposeType]
+	if !ok {
+		composeType := s.ComposeType
+		if composeType == "" {
+			composeType = ComposeEdgeCommit
+		}
+		return append(errs, field.Forbidden(uploadPath,
+			fmt.Sprintf("only the images of ami, vhd and gce composes are uploaded, not the ones of %s composes", composeType)))
+	}
+	providers := map[string]bool{"aws": upload.AWS != nil, "azure": upload.Azure != nil, "gcp": upload.GCP != nil}
+	for _, provider := range []string{"aws", "azure", "gcp"} {
+		switch {
+		case provider == expected && !providers[provider]:
+			errs = append(errs, field.Required(uploadPath.Child(provider),
+				fmt.Sprintf("the image of %s composes is uploaded to %s", s.ComposeType, provider)))
+		case provider != expected && providers[provider]:
+			errs = append(errs, field.Forbidden(uploadPath.Child(provider),
+				fmt.Sprintf("the image of %s composes is uploaded to %s", s.ComposeType, expected)))
+		}
+	}
+	if azure := upload.Azure; azure != nil {
+		weldr := azure.StorageAccount != "" && azure.Container != ""
+		cloud := azure.TenantID != "" && azure.SubscriptionID != "" && azure.ResourceGroup != ""
+		if !weldr && !cloud {
+			errs = append(errs, field.Required(uploadPath.Child("azure"),
+				"storageAccount and container are required by the weldr API, tenantID, subscriptionID and resourceGroup by the Cloud API"))
+		}
+	}
+	return errs
+}
+
+// firewallPortRe matches the <port>:<protocol> entries of the firewall, the
+// port being a number, a range or a service name
+var firewallPortRe = regexp.MustCompile(`^([0-9]+(-[0-9]+)?|[a-z][-a-z0-9]*):(tcp|udp)$`)
+
+// validateCustomizations checks the structured customizations, which are only
+// added to the generated commit blueprint
+func (s *ImageBuilderImageSpec) validateCustomizations(specPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	set := []string{}
+	if len(s.Packages) > 0 {
+		set = append(set, "packages")
+	}
+	if len(s.Users) > 0 {
+		set = append(set, "users")
+	}
+	if s.Kernel != nil {
+		set = append(set, "kernel")
+	}
+	if s.Services != nil {
+		set = append(set, "services")
+	}
+	if s.Firewall != nil {
+		set = append(set, "firewall")
+	}
+	if len(s.Filesystem) > 0 {
+		set = append(set, "filesystem")
+	}
+	if len(s.EmbeddedContainers) > 0 {
+		set = append(set, "embeddedContainers")
+	}
+	if s.BlueprintTemplate != "" || s.BlueprintTemplateRef != nil {
+		for _, name := range set {
+			errs = append(errs, field.Forbidden(specPath.Child(name),
+				"only applies to the generated blueprint, add it to the blueprint template instead"))
+		}
+	}
+	if s.Services != nil && s.Profile != "" {
+		errs = append(errs, field.Forbidden(specPath.Child("services"),
+			fmt.Sprintf("the %s profile already enables its services", s.Profile)))
+	}
+	for i, name := range s.Packages {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, field.Required(specPath.Child("packages").Index(i), "the package name is empty"))
+		}
+	}
+	users := map[string]bool{}
+	for i, user := range s.Users {
+		if users[user.Name] {
+			errs = append(errs, field.Duplicate(specPath.Child("users").Index(i).Child("name"), user.Name))
+		}
+		users[user.Name] = true
+	}
+	if s.Firewall != nil {
+		for i, port := range s.Firewall.Ports {
+			if !firewallPortRe.MatchString(port) {
+				errs = append(errs, field.Invalid(specPath.Child("firewall", "ports").Index(i), port,
+					"must be <port>:<protocol> or <service>:<protocol>, e.g. 22:tcp"))
+			}
+		}
+	}
+	// composer only embeds containers into ostree commits
+	if len(s.EmbeddedContainers) > 0 && s.ComposeType != "" && s.ComposeType != ComposeEdgeCommit && s.ComposeType != ComposeEdgeContainer {
+		errs = append(errs, field.Forbidden(specPath.Child("embeddedContainers"),
+			fmt.Sprintf("containers are only embedded into edge commits, not into %s images", s.ComposeType)))
+	}
+	names := map[string]bool{}
+	for i, container := range s.EmbeddedContainers {
+		containerPath := specPath.Child("embeddedContainers").Index(i)
+		if strings.TrimSpace(container.Source) == "" || strings.ContainsAny(container.Source, " \t\n") {
+			errs = append(errs, field.Invalid(containerPath.Child("source"), container.Source, "must be an image reference, e.g. quay.io/example/app:v1"))
+		}
+		name := container.Name
+		if name == "" {
+			name = container.Source
+		}
+		if names[name] {
+			errs = append(errs, field.Duplicate(containerPath.Child("name"), name))
+		}
+		names[name] = true
+		if container.PullSecret != nil && container.PullSecret.Name == "" {
+			errs = append(errs, field.Required(containerPath.Child("pullSecret", "name"), "the name of the Secret is required"))
+		}
+	}
+	for i, filesystem := range s.Filesystem {
+		filesystemPath := specPath.Child("filesystem").Index(i)
+		if !path.IsAbs(filesystem.Mountpoint) {
+			errs = append(errs, field.Invalid(filesystemPath.Child("mountpoint"), filesystem.Mountpoint, "must be an absolute path"))
+		}
+		if filesystem.MinSize.Sign() <= 0 {
+			errs = append(errs, field.Invalid(filesystemPath.Child("minSize"), filesystem.MinSize.String(), "must be greater than zero"))
+		}
+	}
+	return errs
+}
+
+// lintTemplate parses a blueprint template and makes sure every field it
+// references exists on the spec, including the ones in branches the current
+// spec does not execute, then renders it with the spec to catch the remaining
+// errors
+func lintTemplate(fieldPath *field.Path, text string, spec *ImageBuilderImageSpec) field.ErrorList {
+	errs := field.ErrorList{}
+	// the values of the Secrets are only read by the controller
+	secrets := map[string]string{}
+	for _, secret := range spec.TemplateSecrets {
+		secrets[secret.Name] = ""
+	}
+	templ, err := template.New(fieldPath.String()).Option("missingkey=error").Funcs(TemplateFuncs(secrets)).Parse(text)
+	if err != nil {
+		return append(errs, field.Invalid(fieldPath, field.OmitValueType{}, "template does not parse: "+err.Error()))
+	}
+	specType := reflect.TypeOf(*spec)
+	available := []string{}
+	for i := 0; i < specType.NumField(); i++ {
+		available = append(available, "."+specType.Field(i).Name)
+	}
+	fields := map[string]bool{}
+	templateFields(templ.Tree.Root, true, fields)
+	unknown := []string{}
+	for name := range fields {
+		if _, ok := specType.FieldByName(name); !ok {
+			unknown = append(unknown, "."+name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, field.Invalid(fieldPath, name,
+			fmt.Sprintf("template references an unknown field, available fields are %s", strings.Join(available, ", "))))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	spec = spec.DeepCopy()
+	if spec.Name == "" {
+		spec.Name = "image"
+	}
+	spec.Secrets = secrets
+	if err := templ.Execute(io.Discard, spec); err != nil {
+		errs = append(errs, field.Invalid(fieldPath, field.OmitValueType{}, "template does not render: "+err.Error()))
+	}
+	return errs
+}
+
+// templateFields collects the fields of the spec referenced by a template
+// node, either through $ or through dot while it still is the spec
+func templateFields(node parse.Node, dotIsSpec bool, fields map[string]bool) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			templateFields(child, dotIsSpec, fields)
+		}
+	case *parse.ActionNode:
+		templateFields(node.Pipe, dotIsSpec, fields)
+	case *parse.TemplateNode:
+		templateFields(node.Pipe, dotIsSpec, fields)
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, command := range node.Cmds {
+			templateFields(command, dotIsSpec, fields)
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			templateFields(arg, dotIsSpec, fields)
+		}
+	case *parse.ChainNode:
+		templateFields(node.Node, dotIsSpec, fields)
+	case *parse.FieldNode:
+		if dotIsSpec {
+			fields[node.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		if node.Ident[0] == "$" && len(node.Ident) > 1 {
+			fields[node.Ident[1]] = true
+		}
+	case *parse.IfNode:
+		templateFields(node.Pipe, dotIsSpec, fields)
+		templateFields(node.List, dotIsSpec, fields)
+		templateFields(node.ElseList, dotIsSpec, fields)
+	case *parse.RangeNode:
+		// dot is the current element inside range and with
+		templateFields(node.Pipe, dotIsSpec, fields)
+		templateFields(node.List, false, fields)
+		templateFields(node.ElseList, dotIsSpec, fields)
+	case *parse.WithNode:
+		templateFields(node.Pipe, dotIsSpec, fields)
+		templateFields(node.List, false, fields)
+		templateFields(node.ElseList, dotIsSpec, fields)
+	}
+}
+
+// validateDevicePath accepts absolute, clean paths below /dev
+func validateDevicePath(fieldPath *field.Path, device string) field.ErrorList {
+	errs := field.ErrorList{}
+	switch {
+	case !strings.HasPrefix(device, "/dev/"):
+		errs = append(errs, field.Invalid(fieldPath, device, "must be a device path starting with /dev/, e.g. /dev/vda or /dev/disk/by-id/<id>"))
+	case path.Clean(device) != device:
+		errs = append(errs, field.Invalid(fieldPath, device, "must be a clean path, without trailing /, // or .. elements, e.g. "+path.Clean(device)))
+	case strings.ContainsAny(device, " \t\n\"'"):
+		errs = append(errs, field.Invalid(fieldPath, device, "must not contain spaces or quotes"))
+	}
+	return errs
+}
+
+// validateFDO makes sure the manufacturing server is either set or
+// discovered, and the public key of the DIUN service verified one way
+func validateFDO(fdoPath *field.Path, fdo *FDO) field.ErrorList {
+	errs := field.ErrorList{}
+	switch {
+	case fdo.ManufacturingServerURL != "" && fdo.ManufacturingServerSelector != nil:
+		errs = append(errs, field.Forbidden(fdoPath.Child("manufacturingServerSelector"), "can not be set along with manufacturingServerUrl"))
+	case fdo.ManufacturingServerURL != "":
+		errs = append(errs, validateServerURL(fdoPath.Child("manufacturingServerUrl"), fdo.ManufacturingServerURL)...)
+	case fdo.ManufacturingServerSelector != nil:
+		if _, err := metav1.LabelSelectorAsSelector(fdo.ManufacturingServerSelector); err != nil {
+			errs = append(errs, field.Invalid(fdoPath.Child("manufacturingServerSelector"), fdo.ManufacturingServerSelector, err.Error()))
+		}
+	default:
+		errs = append(errs, field.Required(fdoPath.Child("manufacturingServerUrl"), "either manufacturingServerUrl or manufacturingServerSelector must be set"))
+	}
+	if key := fdo.DIUNPubKey; key != nil {
+		methods := 0
+		for _, set := range []bool{key.Hash != nil, key.RootCerts != nil, key.Insecure} {
+			if set {
+				methods++
+			}
+		}
+		if methods != 1 {
+			errs = append(errs, field.Invalid(fdoPath.Child("diunPubKey"), field.OmitValueType{}, "exactly one of hash, rootCerts and insecure must be set"))
+		}
+	}
+	return errs
+}
+
+// validateServerURL accepts absolute http and https URLs
+func validateServerURL(fieldPath *field.Path, value string) field.ErrorList {
+	errs := field.ErrorList{}
+	serverURL, err := url.Parse(value)
+	switch {
+	case err != nil:
+		errs = append(errs, field.Invalid(fieldPath, value, "must be a valid URL: "+err.Error()))
+	case serverURL.Scheme != "http" && serverURL.Scheme != "https":
+		errs = append(errs, field.Invalid(fieldPath, value, "must be an http:// or https:// URL, e.g. http://fdo-manufacturing.example.com:8080"))
+	case serverURL.Host == "":
+		errs = append(errs, field.Invalid(fieldPath, value, "must include a host name"))
+	case strings.ContainsAny(value, " \t\n\"'"):
+		errs = append(errs, field.Invalid(fieldPath, value, "must not contain spaces or quotes"))
+	}
+	return errs
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("ImageBuilderImage webhook", func() {
+	ctx := context.Background()
+
+	Context("when defaulting", func() {
+		It("builds an edge-commit with its simplified installer", func() {
+			image := &ImageBuilderImage{ObjectMeta: metav1.ObjectMeta{Name: "edge"}}
+			Expect((&imageBuilderImageDefaulter{}).Default(ctx, image)).To(Succeed())
+			Expect(image.Spec.Name).To(Equal("edge"))
+			Expect(image.Spec.ComposeType).To(Equal(ComposeEdgeCommit))
+			Expect(image.Spec.IsoTarget).To(Equal("edge-simplified-installer"))
+		})
+
+		It("keeps the fields that are set and builds no installer of other compose types", func() {
+			image := &ImageBuilderImage{
+				ObjectMeta: metav1.ObjectMeta{Name: "edge"},
+				Spec:       ImageBuilderImageSpec{Name: "blueprint", ComposeType: ComposeQcow2},
+			}
+			Expect((&imageBuilderImageDefaulter{}).Default(ctx, image)).To(Succeed())
+			Expect(image.Spec.Name).To(Equal("blueprint"))
+			Expect(image.Spec.IsoTarget).To(BeEmpty())
+		})
+	})
+
+	Context("when validating the builder selection", func() {
+		var spec ImageBuilderImageSpec
+
+		fields := func(errs field.ErrorList) []string {
+			names := []string{}
+			for _, err := range errs {
+				names = append(names, err.Field)
+			}
+			return names
+		}
+
+		BeforeEach(func() {
+			spec = ImageBuilderImageSpec{ComposeType: ComposeQcow2}
+		})
+
+		It("accepts a single way of selecting the builder", func() {
+			spec.ImageBuilderRef = &ImageBuilderReference{Name: "builder", Namespace: "shared"}
+			Expect(spec.Validate(field.NewPath("spec"))).To(BeEmpty())
+		})
+
+		It("rejects imageBuilderRef along with imageBuilder", func() {
+			spec.ImageBuilderRef = &ImageBuilderReference{Name: "builder"}
+			spec.ImageBuilder = "builder"
+			Expect(fields(spec.Validate(field.NewPath("spec")))).To(Equal([]string{"spec.imageBuilderRef"}))
+		})
+
+		It("rejects imageBuilderSelector along with a referenced builder", func() {
+			spec.ImageBuilderSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"size": "large"}}
+			spec.ClusterImageBuilder = "builder"
+			Expect(fields(spec.Validate(field.NewPath("spec")))).To(Equal([]string{"spec.imageBuilderSelector"}))
+		})
+
+		It("rejects architectures along with a referenced builder", func() {
+			spec.Architectures = []ImageArchitecture{ImageArchitectureX86_64, ImageArchitectureAArch64}
+			spec.ImageBuilder = "builder"
+			Expect(fields(spec.Validate(field.NewPath("spec")))).To(Equal([]string{"spec.architectures"}))
+		})
+
+		It("rejects the architectures listed twice", func() {
+			spec.Architectures = []ImageArchitecture{ImageArchitectureX86_64, ImageArchitectureAArch64, ImageArchitectureX86_64}
+			Expect(fields(spec.Validate(field.NewPath("spec")))).To(Equal([]string{"spec.architectures[2]"}))
+		})
+	})
+
+	Context("when validating the referenced builder", func() {
+		var validator *imageBuilderImageValidator
+		var namespace string
+
+		newImage := func(spec ImageBuilderImageSpec) *ImageBuilderImage {
+			spec.ComposeType = ComposeQcow2
+			return &ImageBuilderImage{
+				ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: namespace},
+				Spec:       spec,
+			}
+		}
+
+		BeforeEach(func() {
+			validator = &imageBuilderImageValidator{client: k8sClient}
+			namespace = createNamespace(ctx, nil)
+			Expect(k8sClient.Create(ctx, &ImageBuilder{
+				ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: namespace},
+			})).To(Succeed())
+		})
+
+		It("accepts an existing builder", func() {
+			_, err := validator.ValidateCreate(ctx, newImage(ImageBuilderImageSpec{ImageBuilder: "builder"}))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rejects a missing builder", func() {
+			_, err := validator.ValidateCreate(ctx, newImage(ImageBuilderImageSpec{ImageBuilder: "missing"}))
+			Expect(err).To(MatchError(ContainSubstring("ImageBuilder missing does not exist in namespace %s", namespace)))
+		})
+
+		It("rejects a missing builder of imageBuilderRef", func() {
+			_, err := validator.ValidateCreate(ctx, newImage(ImageBuilderImageSpec{ImageBuilderRef: &ImageBuilderReference{Name: "missing"}}))
+			Expect(err).To(MatchError(ContainSubstring("ImageBuilder missing does not exist in namespace %s", namespace)))
+		})
+
+		It("rejects a missing ClusterImageBuilder", func() {
+			_, err := validator.ValidateCreate(ctx, newImage(ImageBuilderImageSpec{ClusterImageBuilder: "missing"}))
+			Expect(err).To(MatchError(ContainSubstring("ClusterImageBuilder missing does not exist")))
+		})
+
+		It("rejects the images of the namespaces the builder does not allow", func() {
+			builder := ImageBuilder{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "builder"}, &builder)).To(Succeed())
+			builder.Spec.AllowedNamespaces = &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": namespace}}
+			Expect(k8sClient.Update(ctx, &builder)).To(Succeed())
+			spec := ImageBuilderImageSpec{ImageBuilderRef: &ImageBuilderReference{Name: "builder", Namespace: namespace}}
+
+			allowed := newImage(spec)
+			allowed.Namespace = createNamespace(ctx, map[string]string{"tenant": namespace})
+			_, err := validator.ValidateCreate(ctx, allowed)
+			Expect(err).NotTo(HaveOccurred())
+
+			denied := newImage(spec)
+			denied.Namespace = createNamespace(ctx, nil)
+			_, err = validator.ValidateCreate(ctx, denied)
+			Expect(err).To(MatchError(ContainSubstring("ImageBuilder %s/builder does not allow namespace %s", namespace, denied.Namespace)))
+		})
+
+		It("keeps accepting the updates of an image whose builder is gone", func() {
+			old := newImage(ImageBuilderImageSpec{ImageBuilder: "missing"})
+			image := old.DeepCopy()
+			image.Spec.Name = "edge-v2"
+			_, err := validator.ValidateUpdate(ctx, old, image)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
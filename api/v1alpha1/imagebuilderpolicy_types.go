@@ -0,0 +1,74 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ImageBuilderPolicySpec defines the build quotas of the namespace of the
+// policy. When several policies exist in a namespace the most restrictive
+// value of every quota applies.
+type ImageBuilderPolicySpec struct {
+	// MaxBuildsPerDay is the number of builds that may be started in the last 24 hours
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	MaxBuildsPerDay *int32 `json:"maxBuildsPerDay,omitempty"`
+	// MaxConcurrentBuilds is the number of builds that may be running, the
+	// pending PipelineRuns and suspended Jobs not counting
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	MaxConcurrentBuilds *int32 `json:"maxConcurrentBuilds,omitempty"`
+	// MaxArtifactStorage is the total size of the volumes holding the artifacts
+	// of the images of the namespace
+	//+optional
+	MaxArtifactStorage *resource.Quantity `json:"maxArtifactStorage,omitempty"`
+}
+
+// ImageBuilderPolicyStatus defines the observed state of ImageBuilderPolicy
+type ImageBuilderPolicyStatus struct {
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=osbuild,shortName=ibp
+//+kubebuilder:printcolumn:name="Builds/day",type="integer",JSONPath=".spec.maxBuildsPerDay"
+//+kubebuilder:printcolumn:name="Concurrent",type="integer",JSONPath=".spec.maxConcurrentBuilds"
+//+kubebuilder:printcolumn:name="Storage",type="string",JSONPath=".spec.maxArtifactStorage"
+
+// ImageBuilderPolicy is the Schema for the imagebuilderpolicies API
+type ImageBuilderPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ImageBuilderPolicySpec   `json:"spec,omitempty"`
+	Status ImageBuilderPolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ImageBuilderPolicyList contains a list of ImageBuilderPolicy
+type ImageBuilderPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImageBuilderPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImageBuilderPolicy{}, &ImageBuilderPolicyList{})
+}
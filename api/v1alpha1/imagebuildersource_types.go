@@ -0,0 +1,102 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:validation:Enum=yum-baseurl;yum-mirrorlist;yum-metalink
+
+// SourceType is how composer reads the url of a source
+type SourceType string
+
+const (
+	SourceBaseURL    SourceType = "yum-baseurl"
+	SourceMirrorlist SourceType = "yum-mirrorlist"
+	SourceMetalink   SourceType = "yum-metalink"
+)
+
+// ImageBuilderSourceSpec is an RPM repository composer depsolves the
+// blueprints of the images of the namespace against, next to the repositories
+// of the distribution
+type ImageBuilderSourceSpec struct {
+	// ID is the id of the source in composer, defaults to the name of the
+	// ImageBuilderSource
+	//+optional
+	//+kubebuilder:validation:Pattern=`^[A-Za-z0-9_.-]+$`
+	ID string `json:"id,omitempty"`
+	// Type tells if URL is the base URL, a mirror list or a metalink of the
+	// repository
+	//+kubebuilder:default=yum-baseurl
+	Type SourceType `json:"type,omitempty"`
+	//+kubebuilder:validation:MinLength=1
+	URL string `json:"url"`
+	// CheckGPG verifies the signatures of the packages of the repository
+	//+optional
+	CheckGPG bool `json:"checkGPG,omitempty"`
+	// CheckSSL verifies the certificate of the repository, defaults to true
+	//+optional
+	CheckSSL *bool `json:"checkSSL,omitempty"`
+	// GPGKeys are the URLs or armored public keys the packages are signed with
+	//+optional
+	GPGKeys []string `json:"gpgKeys,omitempty"`
+	// Distros restricts the source to the composes of these distributions
+	//+optional
+	Distros []string `json:"distros,omitempty"`
+}
+
+// ImageBuilderSourceStatus defines the observed state of ImageBuilderSource
+type ImageBuilderSourceStatus struct {
+	// Builders are the ImageBuilders of the namespace composer has the source
+	//+optional
+	Builders []string `json:"builders,omitempty"`
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=osbuild,shortName=ibs
+//+kubebuilder:printcolumn:name="URL",type="string",JSONPath=".spec.url"
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+
+// ImageBuilderSource is the Schema for the imagebuildersources API
+type ImageBuilderSource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ImageBuilderSourceSpec   `json:"spec,omitempty"`
+	Status ImageBuilderSourceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ImageBuilderSourceList contains a list of ImageBuilderSource
+type ImageBuilderSourceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImageBuilderSource `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImageBuilderSource{}, &ImageBuilderSourceList{})
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ImagePromotionSpec copies an artifact already pushed to an upload target of
+// an image to another repository, tag or bucket, or points an ostree ref at
+// the edge commit the image published, without building it again. Exactly
+// one of to, s3 and ostreeRef is set.
+type ImagePromotionSpec struct {
+	// Image is the ImageBuilderImage of the namespace whose artifact is promoted
+	//+kubebuilder:validation:MinLength=1
+	Image string `json:"image"`
+	// From is the registry or s3 upload target of the image the artifact was
+	// pushed to, required by to and s3
+	//+optional
+	From string `json:"from,omitempty"`
+	// Digest is the digest of the artifact promoted to a registry, defaults
+	// to the digest pushed to the upload target by the current build of the
+	// image
+	//+optional
+	//+kubebuilder:validation:Pattern=`^sha256:[a-f0-9]{64}$`
+	Digest string `json:"digest,omitempty"`
+	// To is the repository and tag the artifact of a registry upload target
+	// is copied to, the tag defaulting to the one of the upload target
+	//+optional
+	To *RegistryUploadTarget `json:"to,omitempty"`
+	// S3 is the bucket the artifacts of an s3 upload target are copied to,
+	// the prefix defaulting to the one they were uploaded to
+	//+optional
+	S3 *S3UploadTarget `json:"s3,omitempty"`
+	// OSTreeRef is a ref of the ostree repository of the builder of the
+	// image pointed at the edge commit the image published, e.g. a release
+	// channel such as rhel/9/x86_64/edge-production
+	//+optional
+	//+kubebuilder:validation:Pattern=`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`
+	OSTreeRef string `json:"ostreeRef,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=Pending;Running;Succeeded;Failed
+
+// PromotionState is the state of a promotion
+type PromotionState string
+
+const (
+	PromotionPending   PromotionState = "Pending"
+	PromotionRunning   PromotionState = "Running"
+	PromotionSucceeded PromotionState = "Succeeded"
+	PromotionFailed    PromotionState = "Failed"
+)
+
+// ImagePromotionStatus defines the observed state of ImagePromotion
+type ImagePromotionStatus struct {
+	//+optional
+	State PromotionState `json:"state,omitempty"`
+	// Digest is the digest being promoted, or the checksum of the promoted
+	// edge commit, resolved once so that the promoted artifact is the one
+	// that was tested
+	//+optional
+	Digest string `json:"digest,omitempty"`
+	// Source is the reference the artifact is copied from, the s3://
+	// location of the artifacts or the ostree ref of the edge commit
+	//+optional
+	Source string `json:"source,omitempty"`
+	// Reference is the reference, s3:// location or ostree ref the artifact
+	// is promoted to
+	//+optional
+	Reference string `json:"reference,omitempty"`
+	// TaskRun is the TaskRun promoting the artifact
+	//+optional
+	TaskRun string `json:"taskRun,omitempty"`
+	// Message tells why the promotion is pending or failed
+	//+optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=osbuild,shortName=ipr
+//+kubebuilder:printcolumn:name="Image",type="string",JSONPath=".spec.image"
+//+kubebuilder:printcolumn:name="Reference",type="string",JSONPath=".status.reference"
+//+kubebuilder:printcolumn:name="State",type="string",JSONPath=".status.state"
+
+// ImagePromotion is the Schema for the imagepromotions API
+type ImagePromotion struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ImagePromotionSpec   `json:"spec,omitempty"`
+	Status ImagePromotionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ImagePromotionList contains a list of ImagePromotion
+type ImagePromotionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImagePromotion `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImagePromotion{}, &ImagePromotionList{})
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleMacros are the shorthands of the standard cron expressions
+var scheduleMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Schedule is a parsed cron expression, the minutes, hours, days, months and
+// weekdays it matches being set bits
+// +kubebuilder:object:generate=false
+type Schedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// a day matches when it matches both the day of the month and the day of
+	// the week if one of them is *, either of them otherwise, as with cron
+	anyDay bool
+}
+
+// ParseSchedule parses a standard cron expression of five fields, minute,
+// hour, day of the month, month and day of the week, or one of the @hourly,
+// @daily, @weekly, @monthly and @yearly shorthands
+func ParseSchedule(expression string) (*Schedule, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, ok := scheduleMacros[expression]; ok {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, minute, hour, day of the month, month and day of the week, got %d", len(fields))
+	}
+	schedule := Schedule{
+		anyDay: strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	if schedule.minutes, err = parseScheduleField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if schedule.hours, err = parseScheduleField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if schedule.days, err = parseScheduleField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of the month: %w", err)
+	}
+	if schedule.months, err = parseScheduleField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	// 7 is sunday too
+	if schedule.weekdays, err = parseScheduleField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, fmt.Errorf("day of the week: %w", err)
+	}
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays = schedule.weekdays&^(1<<7) | 1
+	}
+	// e.g. february 30th
+	if schedule.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("%q never matches", expression)
+	}
+	return &schedule, nil
+}
+
+// parseScheduleField parses a comma separated list of values, ranges and
+// steps between min and max, names being the lowercase names of the values
+func parseScheduleField(value string, min int, max int, names map[string]int) (uint64, error) {
+	parse := func(text string) (int, error) {
+		if number, ok := names[strings.ToLower(text)]; ok {
+			return number, nil
+		}
+		number, err := strconv.Atoi(text)
+		if err != nil || number < min || number > max {
+			return 0, fmt.Errorf("%q is not a value between %d and %d", text, min, max)
+		}
+		return number, nil
+	}
+	var bits uint64
+	for _, part := range strings.Split(value, ",") {
+		values, step := part, 1
+		stepped := false
+		if i := strings.Index(part, "/"); i >= 0 {
+			number, err := strconv.Atoi(part[i+1:])
+			if err != nil || number <= 0 {
+				return 0, fmt.Errorf("%q is not a valid step", part[i+1:])
+			}
+			values, step, stepped = part[:i], number, true
+		}
+		first, last := min, max
+		switch i := strings.Index(values, "-"); {
+		case values == "*":
+		case i >= 0:
+			var err error
+			if first, err = parse(values[:i]); err != nil {
+				return 0, err
+			}
+			if last, err = parse(values[i+1:]); err != nil {
+				return 0, err
+			}
+			if first > last {
+				return 0, fmt.Errorf("%q is not an increasing range", values)
+			}
+		default:
+			var err error
+			if first, err = parse(values); err != nil {
+				return 0, err
+			}
+			// a/n starts at a
+			last = first
+			if stepped {
+				last = max
+			}
+		}
+		for number := first; number <= last; number += step {
+			bits |= 1 << uint(number)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t matching the schedule, in UTC, or the
+// zero time if there is none in the next 5 years
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hours&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay tells if the day of t matches the schedule
+func (s *Schedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.anyDay {
+		return day && weekday
+	}
+	return day || weekday
+}
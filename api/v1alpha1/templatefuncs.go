@@ -0,0 +1,124 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// TemplateFuncs are the functions available to the blueprint templates, a
+// subset of the sprig library with the same names and argument order, plus
+// secret which reads the values of spec.templateSecrets
+func TemplateFuncs(secrets map[string]string) template.FuncMap {
+	return template.FuncMap{
+		"default": func(fallback interface{}, value interface{}) interface{} {
+			if empty(value) {
+				return fallback
+			}
+			return value
+		},
+		"empty": empty,
+		"quote": func(value interface{}) string {
+			return strconv.Quote(fmt.Sprint(value))
+		},
+		"squote": func(value interface{}) string {
+			return "'" + fmt.Sprint(value) + "'"
+		},
+		"trim":      strings.TrimSpace,
+		"upper":     strings.ToUpper,
+		"lower":     strings.ToLower,
+		"contains":  func(substr string, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix": func(prefix string, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix": func(suffix string, s string) bool { return strings.HasSuffix(s, suffix) },
+		"replace":   func(old string, new string, s string) string { return strings.ReplaceAll(s, old, new) },
+		"join": func(sep string, list interface{}) string {
+			return strings.Join(stringList(list), sep)
+		},
+		"splitList": func(sep string, s string) []string { return strings.Split(s, sep) },
+		"indent": func(spaces int, s string) string {
+			return indent(spaces, s)
+		},
+		"nindent": func(spaces int, s string) string {
+			return "\n" + indent(spaces, s)
+		},
+		"b64enc": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"b64dec": func(s string) (string, error) {
+			decoded, err := base64.StdEncoding.DecodeString(s)
+			return string(decoded), err
+		},
+		// JSON strings are valid TOML basic strings, so toJson also quotes
+		// values for the blueprints
+		"toJson": func(value interface{}) (string, error) {
+			encoded, err := json.Marshal(value)
+			return string(encoded), err
+		},
+		"secret": func(name string) (string, error) {
+			value, ok := secrets[name]
+			if !ok {
+				return "", fmt.Errorf("secret %q is not one of spec.templateSecrets", name)
+			}
+			return value, nil
+		},
+	}
+}
+
+// empty tells if a value is nil, the zero value of its type or an empty
+// collection
+func empty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// stringList formats the elements of a slice, or the value itself when it is
+// not one
+func stringList(list interface{}) []string {
+	if values, ok := list.([]string); ok {
+		return values
+	}
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return []string{fmt.Sprint(list)}
+	}
+	elements := make([]string, v.Len())
+	for i := range elements {
+		elements[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return elements
+}
+
+// indent prefixes every line of s with spaces
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
@@ -22,57 +22,1696 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSUpload) DeepCopyInto(out *AWSUpload) {
+	*out = *in
+	if in.ShareWithAccounts != nil {
+		in, out := &in.ShareWithAccounts, &out.ShareWithAccounts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSUpload.
+func (in *AWSUpload) DeepCopy() *AWSUpload {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSUpload)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArchitectureStatus) DeepCopyInto(out *ArchitectureStatus) {
+	*out = *in
+	if in.Artifacts != nil {
+		in, out := &in.Artifacts, &out.Artifacts
+		*out = make([]BuildArtifact, len(*in))
+		copy(*out, *in)
+	}
+	if in.Uploads != nil {
+		in, out := &in.Uploads, &out.Uploads
+		*out = make([]UploadStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArchitectureStatus.
+func (in *ArchitectureStatus) DeepCopy() *ArchitectureStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ArchitectureStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureUpload) DeepCopyInto(out *AzureUpload) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureUpload.
+func (in *AzureUpload) DeepCopy() *AzureUpload {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureUpload)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlueprintRestore) DeepCopyInto(out *BlueprintRestore) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	if in.Blueprints != nil {
+		in, out := &in.Blueprints, &out.Blueprints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlueprintRestore.
+func (in *BlueprintRestore) DeepCopy() *BlueprintRestore {
+	if in == nil {
+		return nil
+	}
+	out := new(BlueprintRestore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlueprintUser) DeepCopyInto(out *BlueprintUser) {
+	*out = *in
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlueprintUser.
+func (in *BlueprintUser) DeepCopy() *BlueprintUser {
+	if in == nil {
+		return nil
+	}
+	out := new(BlueprintUser)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildArtifact) DeepCopyInto(out *BuildArtifact) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildArtifact.
+func (in *BuildArtifact) DeepCopy() *BuildArtifact {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildArtifact)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildCallback) DeepCopyInto(out *BuildCallback) {
+	*out = *in
+	if in.URLSecret != nil {
+		in, out := &in.URLSecret, &out.URLSecret
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Events != nil {
+		in, out := &in.Events, &out.Events
+		*out = make([]BuildEvent, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildCallback.
+func (in *BuildCallback) DeepCopy() *BuildCallback {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildCallback)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildHistoryEntry) DeepCopyInto(out *BuildHistoryEntry) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildHistoryEntry.
+func (in *BuildHistoryEntry) DeepCopy() *BuildHistoryEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildHistoryEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildHook) DeepCopyInto(out *BuildHook) {
+	*out = *in
+	if in.ResolverParams != nil {
+		in, out := &in.ResolverParams, &out.ResolverParams
+		*out = make([]HookParam, len(*in))
+		copy(*out, *in)
+	}
+	if in.Params != nil {
+		in, out := &in.Params, &out.Params
+		*out = make([]HookParam, len(*in))
+		copy(*out, *in)
+	}
+	if in.Workspaces != nil {
+		in, out := &in.Workspaces, &out.Workspaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildHook.
+func (in *BuildHook) DeepCopy() *BuildHook {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildHook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildHooks) DeepCopyInto(out *BuildHooks) {
+	*out = *in
+	if in.PreBuild != nil {
+		in, out := &in.PreBuild, &out.PreBuild
+		*out = make([]BuildHook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PostBuild != nil {
+		in, out := &in.PostBuild, &out.PostBuild
+		*out = make([]BuildHook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildHooks.
+func (in *BuildHooks) DeepCopy() *BuildHooks {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildHooks)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildPlan) DeepCopyInto(out *BuildPlan) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.RenderTime.DeepCopyInto(&out.RenderTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildPlan.
+func (in *BuildPlan) DeepCopy() *BuildPlan {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildPlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildPodSettings) DeepCopyInto(out *BuildPodSettings) {
+	*out = *in
+	if in.StepImages != nil {
+		in, out := &in.StepImages, &out.StepImages
+		*out = make(map[StepImage]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]v1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(v1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildPodSettings.
+func (in *BuildPodSettings) DeepCopy() *BuildPodSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildPodSettings)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildQueueStatus) DeepCopyInto(out *BuildQueueStatus) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildQueueStatus.
+func (in *BuildQueueStatus) DeepCopy() *BuildQueueStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildQueueStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CallbackStatus) DeepCopyInto(out *CallbackStatus) {
+	*out = *in
+	if in.LastDeliveryTime != nil {
+		in, out := &in.LastDeliveryTime, &out.LastDeliveryTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CallbackStatus.
+func (in *CallbackStatus) DeepCopy() *CallbackStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CallbackStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CloudImage) DeepCopyInto(out *CloudImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloudImage.
+func (in *CloudImage) DeepCopy() *CloudImage {
+	if in == nil {
+		return nil
+	}
+	out := new(CloudImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CloudUpload) DeepCopyInto(out *CloudUpload) {
+	*out = *in
+	if in.AWS != nil {
+		in, out := &in.AWS, &out.AWS
+		*out = new(AWSUpload)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Azure != nil {
+		in, out := &in.Azure, &out.Azure
+		*out = new(AzureUpload)
+		**out = **in
+	}
+	if in.GCP != nil {
+		in, out := &in.GCP, &out.GCP
+		*out = new(GCPUpload)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloudUpload.
+func (in *CloudUpload) DeepCopy() *CloudUpload {
+	if in == nil {
+		return nil
+	}
+	out := new(CloudUpload)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterImageBuilder) DeepCopyInto(out *ClusterImageBuilder) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterImageBuilder.
+func (in *ClusterImageBuilder) DeepCopy() *ClusterImageBuilder {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterImageBuilder)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterImageBuilder) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterImageBuilderList) DeepCopyInto(out *ClusterImageBuilderList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterImageBuilder, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterImageBuilderList.
+func (in *ClusterImageBuilderList) DeepCopy() *ClusterImageBuilderList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterImageBuilderList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterImageBuilderList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterImageBuilderSpec) DeepCopyInto(out *ClusterImageBuilderSpec) {
+	*out = *in
+	in.ImageBuilderSpec.DeepCopyInto(&out.ImageBuilderSpec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterImageBuilderSpec.
+func (in *ClusterImageBuilderSpec) DeepCopy() *ClusterImageBuilderSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterImageBuilderSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterImageBuilderStatus) DeepCopyInto(out *ClusterImageBuilderStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterImageBuilderStatus.
+func (in *ClusterImageBuilderStatus) DeepCopy() *ClusterImageBuilderStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterImageBuilderStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComposeScripts) DeepCopyInto(out *ComposeScripts) {
+	*out = *in
+	if in.PreCompose != nil {
+		in, out := &in.PreCompose, &out.PreCompose
+		*out = make([]ScriptStep, len(*in))
+		copy(*out, *in)
+	}
+	if in.PostCompose != nil {
+		in, out := &in.PostCompose, &out.PostCompose
+		*out = make([]ScriptStep, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComposeScripts.
+func (in *ComposeScripts) DeepCopy() *ComposeScripts {
+	if in == nil {
+		return nil
+	}
+	out := new(ComposeScripts)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComposeStatus) DeepCopyInto(out *ComposeStatus) {
+	*out = *in
+	in.Created.DeepCopyInto(&out.Created)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComposeStatus.
+func (in *ComposeStatus) DeepCopy() *ComposeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ComposeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComposeTimeouts) DeepCopyInto(out *ComposeTimeouts) {
+	*out = *in
+	if in.PollInterval != nil {
+		in, out := &in.PollInterval, &out.PollInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Depsolve != nil {
+		in, out := &in.Depsolve, &out.Depsolve
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Build != nil {
+		in, out := &in.Build, &out.Build
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Upload != nil {
+		in, out := &in.Upload, &out.Upload
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Total != nil {
+		in, out := &in.Total, &out.Total
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComposeTimeouts.
+func (in *ComposeTimeouts) DeepCopy() *ComposeTimeouts {
+	if in == nil {
+		return nil
+	}
+	out := new(ComposeTimeouts)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComposerAPI) DeepCopyInto(out *ComposerAPI) {
+	*out = *in
+	if in.CABundle != nil {
+		in, out := &in.CABundle, &out.CABundle
+		*out = new(v1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BearerToken != nil {
+		in, out := &in.BearerToken, &out.BearerToken
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComposerAPI.
+func (in *ComposerAPI) DeepCopy() *ComposerAPI {
+	if in == nil {
+		return nil
+	}
+	out := new(ComposerAPI)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComposerDeployment) DeepCopyInto(out *ComposerDeployment) {
+	*out = *in
+	if in.Workers != nil {
+		in, out := &in.Workers, &out.Workers
+		*out = new(int32)
+		**out = **in
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	in.WorkerResources.DeepCopyInto(&out.WorkerResources)
+	if in.StorageSize != nil {
+		in, out := &in.StorageSize, &out.StorageSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComposerDeployment.
+func (in *ComposerDeployment) DeepCopy() *ComposerDeployment {
+	if in == nil {
+		return nil
+	}
+	out := new(ComposerDeployment)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComposerHealth) DeepCopyInto(out *ComposerHealth) {
+	*out = *in
+	in.LastProbeTime.DeepCopyInto(&out.LastProbeTime)
+	if in.LastReachableTime != nil {
+		in, out := &in.LastReachableTime, &out.LastReachableTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComposerHealth.
+func (in *ComposerHealth) DeepCopy() *ComposerHealth {
+	if in == nil {
+		return nil
+	}
+	out := new(ComposerHealth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComposerInventory) DeepCopyInto(out *ComposerInventory) {
+	*out = *in
+	if in.OrphanedBlueprints != nil {
+		in, out := &in.OrphanedBlueprints, &out.OrphanedBlueprints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastUpdated.DeepCopyInto(&out.LastUpdated)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComposerInventory.
+func (in *ComposerInventory) DeepCopy() *ComposerInventory {
+	if in == nil {
+		return nil
+	}
+	out := new(ComposerInventory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DIUNPubKey) DeepCopyInto(out *DIUNPubKey) {
+	*out = *in
+	if in.Hash != nil {
+		in, out := &in.Hash, &out.Hash
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RootCerts != nil {
+		in, out := &in.RootCerts, &out.RootCerts
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DIUNPubKey.
+func (in *DIUNPubKey) DeepCopy() *DIUNPubKey {
+	if in == nil {
+		return nil
+	}
+	out := new(DIUNPubKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EmbeddedContainer) DeepCopyInto(out *EmbeddedContainer) {
+	*out = *in
+	if in.TLSVerify != nil {
+		in, out := &in.TLSVerify, &out.TLSVerify
+		*out = new(bool)
+		**out = **in
+	}
+	if in.PullSecret != nil {
+		in, out := &in.PullSecret, &out.PullSecret
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EmbeddedContainer.
+func (in *EmbeddedContainer) DeepCopy() *EmbeddedContainer {
+	if in == nil {
+		return nil
+	}
+	out := new(EmbeddedContainer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FDO) DeepCopyInto(out *FDO) {
+	*out = *in
+	if in.ManufacturingServerSelector != nil {
+		in, out := &in.ManufacturingServerSelector, &out.ManufacturingServerSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DIUNPubKey != nil {
+		in, out := &in.DIUNPubKey, &out.DIUNPubKey
+		*out = new(DIUNPubKey)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FDO.
+func (in *FDO) DeepCopy() *FDO {
+	if in == nil {
+		return nil
+	}
+	out := new(FDO)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FilesystemCustomization) DeepCopyInto(out *FilesystemCustomization) {
+	*out = *in
+	out.MinSize = in.MinSize.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FilesystemCustomization.
+func (in *FilesystemCustomization) DeepCopy() *FilesystemCustomization {
+	if in == nil {
+		return nil
+	}
+	out := new(FilesystemCustomization)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FirewallCustomization) DeepCopyInto(out *FirewallCustomization) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FirewallCustomization.
+func (in *FirewallCustomization) DeepCopy() *FirewallCustomization {
+	if in == nil {
+		return nil
+	}
+	out := new(FirewallCustomization)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCPUpload) DeepCopyInto(out *GCPUpload) {
+	*out = *in
+	if in.ShareWithAccounts != nil {
+		in, out := &in.ShareWithAccounts, &out.ShareWithAccounts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCPUpload.
+func (in *GCPUpload) DeepCopy() *GCPUpload {
+	if in == nil {
+		return nil
+	}
+	out := new(GCPUpload)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HookParam) DeepCopyInto(out *HookParam) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HookParam.
+func (in *HookParam) DeepCopy() *HookParam {
+	if in == nil {
+		return nil
+	}
+	out := new(HookParam)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ISOCustomization) DeepCopyInto(out *ISOCustomization) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ISOCustomization.
+func (in *ISOCustomization) DeepCopy() *ISOCustomization {
+	if in == nil {
+		return nil
+	}
+	out := new(ISOCustomization)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImageBuilder) DeepCopyInto(out *ImageBuilder) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilder.
+func (in *ImageBuilder) DeepCopy() *ImageBuilder {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilder)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageBuilder) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderCompose) DeepCopyInto(out *ImageBuilderCompose) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderCompose.
+func (in *ImageBuilderCompose) DeepCopy() *ImageBuilderCompose {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderCompose)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageBuilderCompose) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderComposeList) DeepCopyInto(out *ImageBuilderComposeList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ImageBuilderCompose, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderComposeList.
+func (in *ImageBuilderComposeList) DeepCopy() *ImageBuilderComposeList {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderComposeList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageBuilderComposeList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderComposeSpec) DeepCopyInto(out *ImageBuilderComposeSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderComposeSpec.
+func (in *ImageBuilderComposeSpec) DeepCopy() *ImageBuilderComposeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderComposeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderComposeStatus) DeepCopyInto(out *ImageBuilderComposeStatus) {
+	*out = *in
+	if in.ComposeIDs != nil {
+		in, out := &in.ComposeIDs, &out.ComposeIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Composes != nil {
+		in, out := &in.Composes, &out.Composes
+		*out = make([]ComposeStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderComposeStatus.
+func (in *ImageBuilderComposeStatus) DeepCopy() *ImageBuilderComposeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderComposeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderImage) DeepCopyInto(out *ImageBuilderImage) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderImage.
+func (in *ImageBuilderImage) DeepCopy() *ImageBuilderImage {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageBuilderImage) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderImageList) DeepCopyInto(out *ImageBuilderImageList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ImageBuilderImage, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderImageList.
+func (in *ImageBuilderImageList) DeepCopy() *ImageBuilderImageList {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderImageList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageBuilderImageList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderImageSpec) DeepCopyInto(out *ImageBuilderImageSpec) {
+	*out = *in
+	if in.SshKeySecretRef != nil {
+		in, out := &in.SshKeySecretRef, &out.SshKeySecretRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TemplateSecrets != nil {
+		in, out := &in.TemplateSecrets, &out.TemplateSecrets
+		*out = make([]TemplateSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.BlueprintTemplateRef != nil {
+		in, out := &in.BlueprintTemplateRef, &out.BlueprintTemplateRef
+		*out = new(TemplateReference)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BlueprintIsoTemplateRef != nil {
+		in, out := &in.BlueprintIsoTemplateRef, &out.BlueprintIsoTemplateRef
+		*out = new(TemplateReference)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImageBuilderRef != nil {
+		in, out := &in.ImageBuilderRef, &out.ImageBuilderRef
+		*out = new(ImageBuilderReference)
+		**out = **in
+	}
+	if in.ImageBuilderSelector != nil {
+		in, out := &in.ImageBuilderSelector, &out.ImageBuilderSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FDO != nil {
+		in, out := &in.FDO, &out.FDO
+		*out = new(FDO)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SharedVolumeSize != nil {
+		in, out := &in.SharedVolumeSize, &out.SharedVolumeSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.AccessModes != nil {
+		in, out := &in.AccessModes, &out.AccessModes
+		*out = make([]v1.PersistentVolumeAccessMode, len(*in))
+		copy(*out, *in)
+	}
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		*out = new(ImageStorage)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ComposeTimeouts != nil {
+		in, out := &in.ComposeTimeouts, &out.ComposeTimeouts
+		*out = new(ComposeTimeouts)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Retries != nil {
+		in, out := &in.Retries, &out.Retries
+		*out = new(NetworkRetries)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UploadTargets != nil {
+		in, out := &in.UploadTargets, &out.UploadTargets
+		*out = make([]UploadTarget, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Upload != nil {
+		in, out := &in.Upload, &out.Upload
+		*out = new(CloudUpload)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Callbacks != nil {
+		in, out := &in.Callbacks, &out.Callbacks
+		*out = make([]BuildCallback, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Scripts != nil {
+		in, out := &in.Scripts, &out.Scripts
+		*out = new(ComposeScripts)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PipelineRef != nil {
+		in, out := &in.PipelineRef, &out.PipelineRef
+		*out = new(ImagePipelineReference)
+		**out = **in
+	}
+	if in.Hooks != nil {
+		in, out := &in.Hooks, &out.Hooks
+		*out = new(BuildHooks)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Packages != nil {
+		in, out := &in.Packages, &out.Packages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Users != nil {
+		in, out := &in.Users, &out.Users
+		*out = make([]BlueprintUser, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Kernel != nil {
+		in, out := &in.Kernel, &out.Kernel
+		*out = new(KernelCustomization)
+		**out = **in
+	}
+	if in.Services != nil {
+		in, out := &in.Services, &out.Services
+		*out = new(ServicesCustomization)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Firewall != nil {
+		in, out := &in.Firewall, &out.Firewall
+		*out = new(FirewallCustomization)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Filesystem != nil {
+		in, out := &in.Filesystem, &out.Filesystem
+		*out = make([]FilesystemCustomization, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EmbeddedContainers != nil {
+		in, out := &in.EmbeddedContainers, &out.EmbeddedContainers
+		*out = make([]EmbeddedContainer, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.KickstartRef != nil {
+		in, out := &in.KickstartRef, &out.KickstartRef
+		*out = new(TemplateReference)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ISO != nil {
+		in, out := &in.ISO, &out.ISO
+		*out = new(ISOCustomization)
+		**out = **in
+	}
+	if in.OSTree != nil {
+		in, out := &in.OSTree, &out.OSTree
+		*out = new(OSTreeCompose)
+		**out = **in
+	}
+	if in.Repositories != nil {
+		in, out := &in.Repositories, &out.Repositories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.BuildPod != nil {
+		in, out := &in.BuildPod, &out.BuildPod
+		*out = new(BuildPodSettings)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Architectures != nil {
+		in, out := &in.Architectures, &out.Architectures
+		*out = make([]ImageArchitecture, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderImageSpec.
+func (in *ImageBuilderImageSpec) DeepCopy() *ImageBuilderImageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderImageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderImageStatus) DeepCopyInto(out *ImageBuilderImageStatus) {
+	*out = *in
+	if in.LastScheduledBuildTime != nil {
+		in, out := &in.LastScheduledBuildTime, &out.LastScheduledBuildTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSkippedBuildTime != nil {
+		in, out := &in.LastSkippedBuildTime, &out.LastSkippedBuildTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextBuildTime != nil {
+		in, out := &in.NextBuildTime, &out.NextBuildTime
+		*out = (*in).DeepCopy()
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]BuildHistoryEntry, len(*in))
+		copy(*out, *in)
+	}
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		*out = new(BuildPlan)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Artifacts != nil {
+		in, out := &in.Artifacts, &out.Artifacts
+		*out = make([]BuildArtifact, len(*in))
+		copy(*out, *in)
+	}
+	if in.Uploads != nil {
+		in, out := &in.Uploads, &out.Uploads
+		*out = make([]UploadStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.CloudImage != nil {
+		in, out := &in.CloudImage, &out.CloudImage
+		*out = new(CloudImage)
+		**out = **in
+	}
+	if in.OSTree != nil {
+		in, out := &in.OSTree, &out.OSTree
+		*out = new(OSTreeCommit)
+		**out = **in
+	}
+	if in.Callbacks != nil {
+		in, out := &in.Callbacks, &out.Callbacks
+		*out = make([]CallbackStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.CloudEvents != nil {
+		in, out := &in.CloudEvents, &out.CloudEvents
+		*out = new(CallbackStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Kafka != nil {
+		in, out := &in.Kafka, &out.Kafka
+		*out = new(CallbackStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Queue != nil {
+		in, out := &in.Queue, &out.Queue
+		*out = new(BuildQueueStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Architectures != nil {
+		in, out := &in.Architectures, &out.Architectures
+		*out = make([]ArchitectureStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Composes != nil {
+		in, out := &in.Composes, &out.Composes
+		*out = make([]ComposeStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.BuildDuration != nil {
+		in, out := &in.BuildDuration, &out.BuildDuration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderImageStatus.
+func (in *ImageBuilderImageStatus) DeepCopy() *ImageBuilderImageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderImageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderList) DeepCopyInto(out *ImageBuilderList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ImageBuilder, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderList.
+func (in *ImageBuilderList) DeepCopy() *ImageBuilderList {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageBuilderList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderPolicy) DeepCopyInto(out *ImageBuilderPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderPolicy.
+func (in *ImageBuilderPolicy) DeepCopy() *ImageBuilderPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageBuilderPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderPolicyList) DeepCopyInto(out *ImageBuilderPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ImageBuilderPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderPolicyList.
+func (in *ImageBuilderPolicyList) DeepCopy() *ImageBuilderPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageBuilderPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderPolicySpec) DeepCopyInto(out *ImageBuilderPolicySpec) {
+	*out = *in
+	if in.MaxBuildsPerDay != nil {
+		in, out := &in.MaxBuildsPerDay, &out.MaxBuildsPerDay
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxConcurrentBuilds != nil {
+		in, out := &in.MaxConcurrentBuilds, &out.MaxConcurrentBuilds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxArtifactStorage != nil {
+		in, out := &in.MaxArtifactStorage, &out.MaxArtifactStorage
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderPolicySpec.
+func (in *ImageBuilderPolicySpec) DeepCopy() *ImageBuilderPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderPolicyStatus) DeepCopyInto(out *ImageBuilderPolicyStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderPolicyStatus.
+func (in *ImageBuilderPolicyStatus) DeepCopy() *ImageBuilderPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderReference) DeepCopyInto(out *ImageBuilderReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderReference.
+func (in *ImageBuilderReference) DeepCopy() *ImageBuilderReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderSource) DeepCopyInto(out *ImageBuilderSource) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderSource.
+func (in *ImageBuilderSource) DeepCopy() *ImageBuilderSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageBuilderSource) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderSourceList) DeepCopyInto(out *ImageBuilderSourceList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ImageBuilderSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderSourceList.
+func (in *ImageBuilderSourceList) DeepCopy() *ImageBuilderSourceList {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderSourceList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageBuilderSourceList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderSourceSpec) DeepCopyInto(out *ImageBuilderSourceSpec) {
+	*out = *in
+	if in.CheckSSL != nil {
+		in, out := &in.CheckSSL, &out.CheckSSL
+		*out = new(bool)
+		**out = **in
+	}
+	if in.GPGKeys != nil {
+		in, out := &in.GPGKeys, &out.GPGKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Distros != nil {
+		in, out := &in.Distros, &out.Distros
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderSourceSpec.
+func (in *ImageBuilderSourceSpec) DeepCopy() *ImageBuilderSourceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderSourceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderSourceStatus) DeepCopyInto(out *ImageBuilderSourceStatus) {
+	*out = *in
+	if in.Builders != nil {
+		in, out := &in.Builders, &out.Builders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderSourceStatus.
+func (in *ImageBuilderSourceStatus) DeepCopy() *ImageBuilderSourceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderSourceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderSpec) DeepCopyInto(out *ImageBuilderSpec) {
+	*out = *in
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UpgradeDrainTimeout != nil {
+		in, out := &in.UpgradeDrainTimeout, &out.UpgradeDrainTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Composer != nil {
+		in, out := &in.Composer, &out.Composer
+		*out = new(ComposerDeployment)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OSTreeRepository != nil {
+		in, out := &in.OSTreeRepository, &out.OSTreeRepository
+		*out = new(OSTreeRepository)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.API != nil {
+		in, out := &in.API, &out.API
+		*out = new(ComposerAPI)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MaxConcurrentBuilds != nil {
+		in, out := &in.MaxConcurrentBuilds, &out.MaxConcurrentBuilds
+		*out = new(int32)
+		**out = **in
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilder.
-func (in *ImageBuilder) DeepCopy() *ImageBuilder {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderSpec.
+func (in *ImageBuilderSpec) DeepCopy() *ImageBuilderSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(ImageBuilder)
+	out := new(ImageBuilderSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *ImageBuilder) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageBuilderStatus) DeepCopyInto(out *ImageBuilderStatus) {
+	*out = *in
+	if in.Inventory != nil {
+		in, out := &in.Inventory, &out.Inventory
+		*out = new(ComposerInventory)
+		(*in).DeepCopyInto(*out)
 	}
-	return nil
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.UpgradeStartedAt != nil {
+		in, out := &in.UpgradeStartedAt, &out.UpgradeStartedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.LastRestore != nil {
+		in, out := &in.LastRestore, &out.LastRestore
+		*out = new(BlueprintRestore)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ComposeTypes != nil {
+		in, out := &in.ComposeTypes, &out.ComposeTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Distros != nil {
+		in, out := &in.Distros, &out.Distros
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Health != nil {
+		in, out := &in.Health, &out.Health
+		*out = new(ComposerHealth)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderStatus.
+func (in *ImageBuilderStatus) DeepCopy() *ImageBuilderStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageBuilderStatus)
+	in.DeepCopyInto(out)
+	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ImageBuilderImage) DeepCopyInto(out *ImageBuilderImage) {
+func (in *ImagePipelineReference) DeepCopyInto(out *ImagePipelineReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePipelineReference.
+func (in *ImagePipelineReference) DeepCopy() *ImagePipelineReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePipelineReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePromotion) DeepCopyInto(out *ImagePromotion) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	out.Status = in.Status
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderImage.
-func (in *ImageBuilderImage) DeepCopy() *ImageBuilderImage {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePromotion.
+func (in *ImagePromotion) DeepCopy() *ImagePromotion {
 	if in == nil {
 		return nil
 	}
-	out := new(ImageBuilderImage)
+	out := new(ImagePromotion)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *ImageBuilderImage) DeepCopyObject() runtime.Object {
+func (in *ImagePromotion) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -80,31 +1719,31 @@ func (in *ImageBuilderImage) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ImageBuilderImageList) DeepCopyInto(out *ImageBuilderImageList) {
+func (in *ImagePromotionList) DeepCopyInto(out *ImagePromotionList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]ImageBuilderImage, len(*in))
+		*out = make([]ImagePromotion, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderImageList.
-func (in *ImageBuilderImageList) DeepCopy() *ImageBuilderImageList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePromotionList.
+func (in *ImagePromotionList) DeepCopy() *ImagePromotionList {
 	if in == nil {
 		return nil
 	}
-	out := new(ImageBuilderImageList)
+	out := new(ImagePromotionList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *ImageBuilderImageList) DeepCopyObject() runtime.Object {
+func (in *ImagePromotionList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -112,93 +1751,322 @@ func (in *ImageBuilderImageList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ImageBuilderImageSpec) DeepCopyInto(out *ImageBuilderImageSpec) {
+func (in *ImagePromotionSpec) DeepCopyInto(out *ImagePromotionSpec) {
 	*out = *in
+	if in.To != nil {
+		in, out := &in.To, &out.To
+		*out = new(RegistryUploadTarget)
+		**out = **in
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3UploadTarget)
+		**out = **in
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderImageSpec.
-func (in *ImageBuilderImageSpec) DeepCopy() *ImageBuilderImageSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePromotionSpec.
+func (in *ImagePromotionSpec) DeepCopy() *ImagePromotionSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(ImageBuilderImageSpec)
+	out := new(ImagePromotionSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ImageBuilderImageStatus) DeepCopyInto(out *ImageBuilderImageStatus) {
+func (in *ImagePromotionStatus) DeepCopyInto(out *ImagePromotionStatus) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderImageStatus.
-func (in *ImageBuilderImageStatus) DeepCopy() *ImageBuilderImageStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePromotionStatus.
+func (in *ImagePromotionStatus) DeepCopy() *ImagePromotionStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(ImageBuilderImageStatus)
+	out := new(ImagePromotionStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ImageBuilderList) DeepCopyInto(out *ImageBuilderList) {
+func (in *ImageStorage) DeepCopyInto(out *ImageStorage) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ListMeta.DeepCopyInto(&out.ListMeta)
-	if in.Items != nil {
-		in, out := &in.Items, &out.Items
-		*out = make([]ImageBuilder, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
+	if in.SizeLimit != nil {
+		in, out := &in.SizeLimit, &out.SizeLimit
+		x := (*in).DeepCopy()
+		*out = &x
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderList.
-func (in *ImageBuilderList) DeepCopy() *ImageBuilderList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageStorage.
+func (in *ImageStorage) DeepCopy() *ImageStorage {
 	if in == nil {
 		return nil
 	}
-	out := new(ImageBuilderList)
+	out := new(ImageStorage)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *ImageBuilderList) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KernelCustomization) DeepCopyInto(out *KernelCustomization) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KernelCustomization.
+func (in *KernelCustomization) DeepCopy() *KernelCustomization {
+	if in == nil {
+		return nil
 	}
-	return nil
+	out := new(KernelCustomization)
+	in.DeepCopyInto(out)
+	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ImageBuilderSpec) DeepCopyInto(out *ImageBuilderSpec) {
+func (in *NetworkRetries) DeepCopyInto(out *NetworkRetries) {
 	*out = *in
+	if in.Delay != nil {
+		in, out := &in.Delay, &out.Delay
+		*out = new(metav1.Duration)
+		**out = **in
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderSpec.
-func (in *ImageBuilderSpec) DeepCopy() *ImageBuilderSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkRetries.
+func (in *NetworkRetries) DeepCopy() *NetworkRetries {
 	if in == nil {
 		return nil
 	}
-	out := new(ImageBuilderSpec)
+	out := new(NetworkRetries)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ImageBuilderStatus) DeepCopyInto(out *ImageBuilderStatus) {
+func (in *OSTreeCommit) DeepCopyInto(out *OSTreeCommit) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBuilderStatus.
-func (in *ImageBuilderStatus) DeepCopy() *ImageBuilderStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSTreeCommit.
+func (in *OSTreeCommit) DeepCopy() *OSTreeCommit {
 	if in == nil {
 		return nil
 	}
-	out := new(ImageBuilderStatus)
+	out := new(OSTreeCommit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSTreeCompose) DeepCopyInto(out *OSTreeCompose) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSTreeCompose.
+func (in *OSTreeCompose) DeepCopy() *OSTreeCompose {
+	if in == nil {
+		return nil
+	}
+	out := new(OSTreeCompose)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSTreeRepository) DeepCopyInto(out *OSTreeRepository) {
+	*out = *in
+	if in.StorageSize != nil {
+		in, out := &in.StorageSize, &out.StorageSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.AccessModes != nil {
+		in, out := &in.AccessModes, &out.AccessModes
+		*out = make([]v1.PersistentVolumeAccessMode, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSTreeRepository.
+func (in *OSTreeRepository) DeepCopy() *OSTreeRepository {
+	if in == nil {
+		return nil
+	}
+	out := new(OSTreeRepository)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PVCUploadTarget) DeepCopyInto(out *PVCUploadTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PVCUploadTarget.
+func (in *PVCUploadTarget) DeepCopy() *PVCUploadTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(PVCUploadTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryUploadTarget) DeepCopyInto(out *RegistryUploadTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryUploadTarget.
+func (in *RegistryUploadTarget) DeepCopy() *RegistryUploadTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryUploadTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3UploadTarget) DeepCopyInto(out *S3UploadTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3UploadTarget.
+func (in *S3UploadTarget) DeepCopy() *S3UploadTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(S3UploadTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScriptStep) DeepCopyInto(out *ScriptStep) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScriptStep.
+func (in *ScriptStep) DeepCopy() *ScriptStep {
+	if in == nil {
+		return nil
+	}
+	out := new(ScriptStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServicesCustomization) DeepCopyInto(out *ServicesCustomization) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServicesCustomization.
+func (in *ServicesCustomization) DeepCopy() *ServicesCustomization {
+	if in == nil {
+		return nil
+	}
+	out := new(ServicesCustomization)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TemplateReference) DeepCopyInto(out *TemplateReference) {
+	*out = *in
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(v1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TemplateReference.
+func (in *TemplateReference) DeepCopy() *TemplateReference {
+	if in == nil {
+		return nil
+	}
+	out := new(TemplateReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TemplateSecret) DeepCopyInto(out *TemplateSecret) {
+	*out = *in
+	in.SecretKeyRef.DeepCopyInto(&out.SecretKeyRef)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TemplateSecret.
+func (in *TemplateSecret) DeepCopy() *TemplateSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(TemplateSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UploadStatus) DeepCopyInto(out *UploadStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UploadStatus.
+func (in *UploadStatus) DeepCopy() *UploadStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(UploadStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UploadTarget) DeepCopyInto(out *UploadTarget) {
+	*out = *in
+	if in.Registry != nil {
+		in, out := &in.Registry, &out.Registry
+		*out = new(RegistryUploadTarget)
+		**out = **in
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3UploadTarget)
+		**out = **in
+	}
+	if in.PVC != nil {
+		in, out := &in.PVC, &out.PVC
+		*out = new(PVCUploadTarget)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UploadTarget.
+func (in *UploadTarget) DeepCopy() *UploadTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(UploadTarget)
 	in.DeepCopyInto(out)
 	return out
 }
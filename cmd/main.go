@@ -19,13 +19,18 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -33,6 +38,7 @@ import (
 
 	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
 	"github.com/kwozyman/osbuild-operator/internal/controller"
+	"github.com/kwozyman/osbuild-operator/internal/server"
 
 	//+kubebuilder:scaffold:imports
 	routev1 "github.com/openshift/api/route/v1"
@@ -59,11 +65,90 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var propagateLabels string
+	var propagateAnnotations string
+	var nameTemplate string
+	var multiTenant bool
+	var sharedBuilderNamespace string
+	var apiAddr string
+	var apiTokenFile string
+	var apiKubernetesAuth bool
+	var gcInterval time.Duration
+	var gcDelete bool
+	var exportInterval time.Duration
+	var cloudEventsSink string
+	var kafkaBridge string
+	var kafkaTopic string
+	var kafkaSecretDir string
+	var callbackAllowedCIDRs string
+	var callbackDeniedCIDRs string
+	var deliveryWorkers int
+	var stepImagesFile string
+	var buildPodDefaultsFile string
+	var maxConcurrentImages int
+	var maxConcurrentBuilders int
+	var requeueBaseDelay time.Duration
+	var requeueMaxDelay time.Duration
+	var requeueQPS float64
+	var requeueBurst int
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&propagateLabels, "propagate-labels", "",
+		"Comma separated ImageBuilderImage label keys copied to the generated resources, a trailing * matches a prefix.")
+	flag.StringVar(&propagateAnnotations, "propagate-annotations", "",
+		"Comma separated ImageBuilderImage annotation keys copied to the generated resources, a trailing * matches a prefix.")
+	flag.StringVar(&nameTemplate, "name-template", controller.DefaultNameTemplate,
+		"Go template naming the resources generated for an ImageBuilderImage, using .Image, .Namespace, .Generation and .Resource.")
+	flag.StringVar(&apiAddr, "api-bind-address", "0",
+		"The address the read only builds and artifacts API binds to. Set to 0 to disable the API.")
+	flag.StringVar(&apiTokenFile, "api-token-file", "",
+		"File holding the bearer tokens accepted by the builds and artifacts API, one per line.")
+	flag.BoolVar(&apiKubernetesAuth, "api-kubernetes-auth", false,
+		"Also accept Kubernetes tokens allowed to list ImageBuilderImages on the builds and artifacts API.")
+	flag.BoolVar(&multiTenant, "multi-tenant", false,
+		"Only let ImageBuilderImages use the ImageBuilders of their own namespace or of the shared builder namespace.")
+	flag.StringVar(&sharedBuilderNamespace, "shared-builder-namespace", "",
+		"Namespace whose ImageBuilders every namespace may use in multi-tenant mode.")
+	flag.DurationVar(&gcInterval, "orphan-collection-interval", time.Hour,
+		"How often to look for resources generated for ImageBuilderImages that no longer exist. Set to 0 to disable.")
+	flag.BoolVar(&gcDelete, "orphan-collection-delete", false,
+		"Delete the orphaned resources instead of only reporting them in the osbuild_operator_orphaned_resources metric.")
+	flag.DurationVar(&exportInterval, "export-interval", 0,
+		"How often to store the export bundle of every image in the osbuild-export-<image> ConfigMap of its namespace. 0 disables it.")
+	flag.StringVar(&cloudEventsSink, "cloudevents-sink", "",
+		"URI the build lifecycle events are sent to as CloudEvents, e.g. a Knative Eventing broker. Empty disables them.")
+	flag.StringVar(&kafkaBridge, "kafka-bridge-url", "",
+		"URL of the Kafka HTTP bridge the build events are published through. Empty disables them.")
+	flag.StringVar(&kafkaTopic, "kafka-topic", "osbuild-builds",
+		"Kafka topic the build events are published to.")
+	flag.StringVar(&kafkaSecretDir, "kafka-secret-dir", "",
+		"Directory of the mounted Secret with the ca.crt, tls.crt, tls.key, username and password of the Kafka bridge.")
+	flag.StringVar(&callbackAllowedCIDRs, "callback-allowed-cidrs", "",
+		"Comma separated CIDRs the callbacks of the ImageBuilderImages may reach, no other address being reached when set.")
+	flag.StringVar(&callbackDeniedCIDRs, "callback-denied-cidrs", strings.Join(controller.DefaultCallbackDeniedCIDRs, ","),
+		"Comma separated CIDRs the callbacks of the ImageBuilderImages may not reach, unless in --callback-allowed-cidrs.")
+	flag.IntVar(&deliveryWorkers, "delivery-workers", controller.DefaultDeliveryWorkers,
+		"How many build events are delivered at once to the callbacks and sinks.")
+	flag.StringVar(&stepImagesFile, "step-images-file", "",
+		"JSON file mapping the helper images of the build pods to their reference per architecture. Empty uses the default references.")
+	flag.StringVar(&buildPodDefaultsFile, "build-pod-defaults-file", "",
+		"JSON file with the default step images, image pull secrets, resources, node selector and tolerations of the build pods. "+
+			"The RELATED_IMAGE_<NAME> environment variables also replace the step images.")
+	flag.IntVar(&maxConcurrentImages, "max-concurrent-image-reconciles", 1,
+		"How many ImageBuilderImages are reconciled at once.")
+	flag.IntVar(&maxConcurrentBuilders, "max-concurrent-builder-reconciles", 1,
+		"How many ImageBuilders are reconciled at once.")
+	flag.DurationVar(&requeueBaseDelay, "requeue-base-delay", controller.DefaultRequeueBaseDelay,
+		"How long to wait before reconciling again an ImageBuilder or ImageBuilderImage whose reconcile failed, doubling with every failure.")
+	flag.DurationVar(&requeueMaxDelay, "requeue-max-delay", controller.DefaultRequeueMaxDelay,
+		"The longest wait before reconciling again an ImageBuilder or ImageBuilderImage whose reconcile failed.")
+	flag.Float64Var(&requeueQPS, "requeue-qps", controller.DefaultRequeueQPS,
+		"How many ImageBuilders, and ImageBuilderImages, are requeued per second at most.")
+	flag.IntVar(&requeueBurst, "requeue-burst", controller.DefaultRequeueBurst,
+		"How many ImageBuilders, and ImageBuilderImages, are requeued at once above --requeue-qps.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -79,39 +164,183 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "4a45954c.rh-ecosystem-edge.io",
-		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
-		// when the Manager ends. This requires the binary to immediately end when the
-		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
-		// speeds up voluntary leader transitions as the new leader don't have to wait
-		// LeaseDuration time first.
-		//
-		// In the default scaffold provided, the program ends immediately after
-		// the manager stops, so would be fine to enable this option. However,
-		// if you are doing or is intended to do any operation such as perform cleanups
-		// after the manager stops then its usage might be unsafe.
-		// LeaderElectionReleaseOnCancel: true,
+		// the program ends once the manager stops, the next replica takes
+		// over without waiting for the lease to expire
+		LeaderElectionReleaseOnCancel: true,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// without Tekton, builds run as Kubernetes Jobs
+	tekton := true
+	if _, err := mgr.GetRESTMapper().RESTMapping(schema.GroupKind{Group: "tekton.dev", Kind: "PipelineRun"}, "v1"); err != nil {
+		if !meta.IsNoMatchError(err) {
+			setupLog.Error(err, "unable to look up Tekton PipelineRuns")
+			os.Exit(1)
+		}
+		setupLog.Info("Tekton is not installed, images are built by Kubernetes Jobs")
+		tekton = false
+	}
+
+	var kafkaSink *controller.KafkaSink
+	if kafkaBridge != "" {
+		kafkaSink, err = controller.NewKafkaSink(kafkaBridge, kafkaTopic, kafkaSecretDir)
+		if err != nil {
+			setupLog.Error(err, "unable to set up Kafka sink")
+			os.Exit(1)
+		}
+	}
+
+	callbackFilter, err := controller.NewDestinationFilter(callbackAllowedCIDRs, callbackDeniedCIDRs)
+	if err != nil {
+		setupLog.Error(err, "unable to parse the callback CIDRs")
+		os.Exit(1)
+	}
+	deliveries := controller.NewDeliveries(deliveryWorkers)
+	if err = mgr.Add(deliveries); err != nil {
+		setupLog.Error(err, "unable to set up the deliveries of the build events")
+		os.Exit(1)
+	}
+
+	var stepImages *controller.ImageResolver
+	if stepImagesFile != "" {
+		stepImages, err = controller.LoadImageResolver(stepImagesFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load step images")
+			os.Exit(1)
+		}
+	}
+
+	buildPodDefaults, err := controller.LoadBuildPodDefaults(buildPodDefaultsFile)
+	if err != nil {
+		setupLog.Error(err, "unable to load build pod defaults")
+		os.Exit(1)
+	}
+
 	if err = (&controller.ImageBuilderReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("imagebuilder-controller"),
+
+		MaxConcurrentReconciles: maxConcurrentBuilders,
+		RateLimiter:             controller.NewRateLimiter(requeueBaseDelay, requeueMaxDelay, requeueQPS, requeueBurst),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ImageBuilder")
 		os.Exit(1)
 	}
 	if err = (&controller.ImageBuilderImageReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("imagebuilderimage-controller"),
+
+		PropagateLabels:      splitList(propagateLabels),
+		PropagateAnnotations: splitList(propagateAnnotations),
+		NameTemplate:         nameTemplate,
+
+		MultiTenant:            multiTenant,
+		SharedBuilderNamespace: sharedBuilderNamespace,
+
+		Deliveries:      deliveries,
+		CallbackFilter:  callbackFilter,
+		CloudEventsSink: cloudEventsSink,
+		Kafka:           kafkaSink,
+		Images:          stepImages,
+		BuildPod:        buildPodDefaults,
+		Tekton:          tekton,
+
+		MaxConcurrentReconciles: maxConcurrentImages,
+		RateLimiter:             controller.NewRateLimiter(requeueBaseDelay, requeueMaxDelay, requeueQPS, requeueBurst),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ImageBuilderImage")
+		os.Exit(1)
+	}
+	if err = (&controller.ClusterImageBuilderReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ImageBuilderImage")
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterImageBuilder")
+		os.Exit(1)
+	}
+	if err = (&controller.ImageBuilderComposeReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+		Tekton: tekton,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ImageBuilderCompose")
+		os.Exit(1)
+	}
+	if err = (&controller.ImageBuilderSourceReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ImageBuilderSource")
+		os.Exit(1)
+	}
+	// promotions run PipelineRuns
+	if !tekton {
+		setupLog.Info("Tekton is not installed, ImagePromotions are not reconciled")
+	} else if err = (&controller.ImagePromotionReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ImagePromotion")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&osbuildv1alpha1.ImageBuilder{}).SetupWebhookWithManager(mgr, multiTenant); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ImageBuilder")
+			os.Exit(1)
+		}
+		if err = (&osbuildv1alpha1.ImageBuilderImage{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ImageBuilderImage")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
+	if apiAddr != "0" {
+		clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to create clientset for API server")
+			os.Exit(1)
+		}
+		if err := mgr.Add(&server.Server{
+			Client:         mgr.GetClient(),
+			Clientset:      clientset,
+			Address:        apiAddr,
+			TokenFile:      apiTokenFile,
+			NameTemplate:   nameTemplate,
+			KubernetesAuth: apiKubernetesAuth,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up API server")
+			os.Exit(1)
+		}
+	}
+
+	if gcInterval > 0 {
+		if err := mgr.Add(&controller.OrphanCollector{
+			Client:   mgr.GetClient(),
+			Reader:   mgr.GetAPIReader(),
+			Interval: gcInterval,
+			DryRun:   !gcDelete,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up orphan collector")
+			os.Exit(1)
+		}
+	}
+
+	if exportInterval > 0 {
+		if err := mgr.Add(&controller.Exporter{
+			Client:   mgr.GetClient(),
+			Interval: exportInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up exporter")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -127,3 +356,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitList parses a comma separated flag value
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,493 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package composer is a small client of the osbuild-composer weldr API served
+// by the ImageBuilder virtual machines, and of its Cloud API
+package composer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// Queue statuses of a compose
+const (
+	StatusWaiting  = "WAITING"
+	StatusRunning  = "RUNNING"
+	StatusFinished = "FINISHED"
+	StatusFailed   = "FAILED"
+)
+const blueprintPageSize = 100
+
+// Client talks to the weldr API of one ImageBuilder, or to its Cloud API
+type Client struct {
+	// Endpoint is the API root, e.g. http://builder.namespace:8080/api/v1
+	Endpoint   string
+	HTTPClient *http.Client
+	// Token is sent as a bearer token when set
+	Token string
+	// Cloud is set when Endpoint serves the Cloud API, which stores no
+	// blueprints nor sources and does not list its composes
+	Cloud bool
+}
+
+// Credentials verify an HTTPS endpoint and authenticate the client to it,
+// usually a proxy in front of composer, which only serves plain HTTP
+type Credentials struct {
+	// CA holds the PEM certificates the endpoint is verified with, the ones
+	// of the system when empty
+	CA []byte
+	// Certificate and Key are the PEM client certificate and its key
+	Certificate []byte
+	Key         []byte
+	// Token is sent as a bearer token
+	Token string
+}
+
+// ComposeInfo describes a compose as listed by the queue endpoints
+type ComposeInfo struct {
+	ID          string  `json:"id"`
+	Blueprint   string  `json:"blueprint"`
+	Version     string  `json:"version"`
+	ComposeType string  `json:"compose_type"`
+	QueueStatus string  `json:"queue_status"`
+	JobCreated  float64 `json:"job_created"`
+	ImageSize   int64   `json:"image_size,omitempty"`
+}
+
+// Created returns the time composer queued the compose
+func (c ComposeInfo) Created() time.Time {
+	return time.Unix(0, int64(c.JobCreated*1e9))
+}
+
+// ComposeRequest starts the compose of a blueprint
+type ComposeRequest struct {
+	BlueprintName string `json:"blueprint_name"`
+	ComposeType   string `json:"compose_type"`
+	Branch        string `json:"branch,omitempty"`
+	// OSTree is the commit installer and edge composes are built from or on
+	OSTree *OSTreeOptions `json:"ostree,omitempty"`
+	// Upload uploads the image to a cloud once it is built
+	Upload *Upload `json:"upload,omitempty"`
+}
+
+// OSTreeOptions selects the ostree commit of a compose
+type OSTreeOptions struct {
+	Ref    string `json:"ref,omitempty"`
+	Parent string `json:"parent,omitempty"`
+	URL    string `json:"url,omitempty"`
+}
+
+// Queue lists the composes waiting for and being built
+type Queue struct {
+	New []ComposeInfo `json:"new"`
+	Run []ComposeInfo `json:"run"`
+}
+
+// APIError is an error reported by composer
+type APIError struct {
+	StatusCode int
+	Errors     []APIErrorDetail `json:"errors"`
+}
+
+// APIErrorDetail is one of the errors of an APIError
+type APIErrorDetail struct {
+	ID  string `json:"id"`
+	Msg string `json:"msg"`
+}
+
+func (e *APIError) Error() string {
+	messages := []string{}
+	for _, err := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", err.ID, err.Msg))
+	}
+	if len(messages) == 0 {
+		return fmt.Sprintf("composer returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("composer returned %d: %s", e.StatusCode, strings.Join(messages, ", "))
+}
+
+// NewClient returns a client of the weldr API served at endpoint, or of the
+// Cloud API when endpoint ends with CloudAPIPath
+func NewClient(endpoint string) *Client {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	return &Client{
+		Endpoint:   endpoint,
+		HTTPClient: &http.Client{Timeout: defaultTimeout},
+		Cloud:      strings.HasSuffix(endpoint, CloudAPIPath),
+	}
+}
+
+// NewClientWithCredentials returns a client of the API served at endpoint
+// with credentials
+func NewClientWithCredentials(endpoint string, credentials Credentials) (*Client, error) {
+	c := NewClient(endpoint)
+	c.Token = credentials.Token
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(credentials.CA) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(credentials.CA) {
+			return nil, fmt.Errorf("no certificate found in the CA bundle")
+		}
+	}
+	if len(credentials.Certificate) > 0 {
+		certificate, err := tls.X509KeyPair(credentials.Certificate, credentials.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	// the proxy settings and timeouts of the default transport are kept
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	c.HTTPClient.Transport = transport
+	return c, nil
+}
+
+// Queue returns the composes that are not done yet
+func (c *Client) Queue(ctx context.Context) (*Queue, error) {
+	queue := Queue{}
+	if c.Cloud {
+		return &queue, nil
+	}
+	if err := c.do(ctx, http.MethodGet, "/compose/queue", &queue); err != nil {
+		return nil, err
+	}
+	return &queue, nil
+}
+
+// Finished returns the composes that were built successfully
+func (c *Client) Finished(ctx context.Context) ([]ComposeInfo, error) {
+	finished := struct {
+		Finished []ComposeInfo `json:"finished"`
+	}{}
+	if c.Cloud {
+		return nil, nil
+	}
+	if err := c.do(ctx, http.MethodGet, "/compose/finished", &finished); err != nil {
+		return nil, err
+	}
+	return finished.Finished, nil
+}
+
+// Failed returns the composes that failed
+func (c *Client) Failed(ctx context.Context) ([]ComposeInfo, error) {
+	failed := struct {
+		Failed []ComposeInfo `json:"failed"`
+	}{}
+	if c.Cloud {
+		return nil, nil
+	}
+	if err := c.do(ctx, http.MethodGet, "/compose/failed", &failed); err != nil {
+		return nil, err
+	}
+	return failed.Failed, nil
+}
+
+// Blueprints returns the names of the stored blueprints
+func (c *Client) Blueprints(ctx context.Context) ([]string, error) {
+	blueprints := []string{}
+	if c.Cloud {
+		return blueprints, nil
+	}
+	for offset := 0; ; {
+		page := struct {
+			Blueprints []string `json:"blueprints"`
+			Total      int      `json:"total"`
+		}{}
+		if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/blueprints/list?offset=%d&limit=%d", offset, blueprintPageSize), &page); err != nil {
+			return nil, err
+		}
+		blueprints = append(blueprints, page.Blueprints...)
+		offset += len(page.Blueprints)
+		if len(page.Blueprints) == 0 || offset >= page.Total {
+			return blueprints, nil
+		}
+	}
+}
+
+// ComposeTypes returns the enabled compose types of the default distribution
+// and architecture of composer, none with the Cloud API
+func (c *Client) ComposeTypes(ctx context.Context) ([]string, error) {
+	if c.Cloud {
+		return nil, nil
+	}
+	response := struct {
+		Types []struct {
+			Name    string `json:"name"`
+			Enabled bool   `json:"enabled"`
+		} `json:"types"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/compose/types", &response); err != nil {
+		return nil, err
+	}
+	types := []string{}
+	for _, composeType := range response.Types {
+		if composeType.Enabled {
+			types = append(types, composeType.Name)
+		}
+	}
+	return types, nil
+}
+
+// Distros returns the distributions composer builds images of, none with the
+// Cloud API
+func (c *Client) Distros(ctx context.Context) ([]string, error) {
+	if c.Cloud {
+		return nil, nil
+	}
+	response := struct {
+		Distros []string `json:"distros"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/distros/list", &response); err != nil {
+		return nil, err
+	}
+	return response.Distros, nil
+}
+
+// Status describes the composer serving the API
+type Status struct {
+	API     string `json:"api"`
+	Backend string `json:"backend"`
+	// Build is the version of composer
+	Build string `json:"build"`
+}
+
+// Status returns the version of composer, served next to the versioned API
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	if c.Cloud {
+		return c.cloudStatus(ctx)
+	}
+	status := Status{}
+	if err := c.doURL(ctx, http.MethodGet, strings.TrimSuffix(c.Endpoint, "/v1")+"/status", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// PushBlueprint stores a TOML blueprint, replacing the one of the same name
+func (c *Client) PushBlueprint(ctx context.Context, blueprint string) error {
+	if c.Cloud {
+		return nil
+	}
+	return c.send(ctx, http.MethodPost, c.Endpoint+"/blueprints/new", "text/x-toml", strings.NewReader(blueprint), nil)
+}
+
+// Source is a repository composer depsolves blueprints against
+type Source struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	URL      string   `json:"url"`
+	CheckGPG bool     `json:"check_gpg"`
+	CheckSSL bool     `json:"check_ssl"`
+	System   bool     `json:"system"`
+	GPGKeys  []string `json:"gpgkeys,omitempty"`
+	Distros  []string `json:"distros,omitempty"`
+}
+
+// PushSource stores a source, replacing the one of the same ID
+func (c *Client) PushSource(ctx context.Context, source Source) error {
+	if c.Cloud {
+		return nil
+	}
+	body, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, http.MethodPost, c.Endpoint+"/projects/source/new", "application/json", bytes.NewReader(body), nil)
+}
+
+// DeleteSource removes a source that is not one of the system sources
+func (c *Client) DeleteSource(ctx context.Context, id string) error {
+	if c.Cloud {
+		return nil
+	}
+	return c.do(ctx, http.MethodDelete, "/projects/source/delete/"+id, nil)
+}
+
+// StartCompose queues a compose and returns its ID
+func (c *Client) StartCompose(ctx context.Context, request ComposeRequest) (string, error) {
+	if c.Cloud {
+		return "", cloudUnsupported("composes are started with CloudCompose")
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	response := struct {
+		BuildID string `json:"build_id"`
+	}{}
+	if err := c.send(ctx, http.MethodPost, c.Endpoint+"/compose", "application/json", bytes.NewReader(body), &response); err != nil {
+		return "", err
+	}
+	return response.BuildID, nil
+}
+
+// ComposeStatus returns the state of a compose, its QueueStatus being one of
+// the Status constants
+func (c *Client) ComposeStatus(ctx context.Context, id string) (*ComposeInfo, error) {
+	if c.Cloud {
+		return c.cloudComposeStatus(ctx, id)
+	}
+	response := struct {
+		UUIDs []ComposeInfo `json:"uuids"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/compose/status/"+id, &response); err != nil {
+		return nil, err
+	}
+	if len(response.UUIDs) == 0 {
+		return nil, fmt.Errorf("compose %s not found", id)
+	}
+	return &response.UUIDs[0], nil
+}
+
+// Logs returns the tarball of the logs of a finished or failed compose, the
+// caller closes it. The Cloud API returns them as JSON.
+func (c *Client) Logs(ctx context.Context, id string) (io.ReadCloser, error) {
+	if c.Cloud {
+		return c.download(ctx, "/composes/"+id+"/logs")
+	}
+	return c.download(ctx, "/compose/logs/"+id)
+}
+
+// Log returns the end of the osbuild output of a compose, up to size
+// kilobytes, or the start of its logs with the Cloud API
+func (c *Client) Log(ctx context.Context, id string, size int) (string, error) {
+	path := fmt.Sprintf("/compose/log/%s?size=%d", id, size)
+	if c.Cloud {
+		path = "/composes/" + id + "/logs"
+	}
+	body, err := c.download(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, int64(size)*1024))
+	return string(data), err
+}
+
+// Image returns the artifact of a finished compose, the caller closes it.
+// Images are usually large, so the client should not have a timeout.
+func (c *Client) Image(ctx context.Context, id string) (io.ReadCloser, error) {
+	if c.Cloud {
+		return c.download(ctx, "/composes/"+id+"/download")
+	}
+	return c.download(ctx, "/compose/image/"+id)
+}
+
+// Cancel stops a waiting or running compose, which the Cloud API can not
+func (c *Client) Cancel(ctx context.Context, id string) error {
+	if c.Cloud {
+		return cloudUnsupported("composes can not be cancelled")
+	}
+	return c.do(ctx, http.MethodDelete, "/compose/cancel/"+id, nil)
+}
+
+// Delete removes composes and their artifacts
+func (c *Client) Delete(ctx context.Context, ids ...string) error {
+	if c.Cloud {
+		// the Cloud API deletes one compose at a time
+		for _, id := range ids {
+			if err := c.do(ctx, http.MethodDelete, "/composes/"+id, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return c.do(ctx, http.MethodDelete, "/compose/delete/"+strings.Join(ids, ","), nil)
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, result interface{}) error {
+	return c.doURL(ctx, method, c.Endpoint+path, result)
+}
+
+func (c *Client) doURL(ctx context.Context, method string, url string, result interface{}) error {
+	return c.send(ctx, method, url, "", nil, result)
+}
+
+// download streams the body of a successful response
+func (c *Client) download(ctx context.Context, path string) (io.ReadCloser, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(request)
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusOK {
+		return response.Body, nil
+	}
+	defer response.Body.Close()
+	apiError := APIError{StatusCode: response.StatusCode}
+	if responseBody, err := io.ReadAll(response.Body); err == nil {
+		json.Unmarshal(responseBody, &apiError)
+		apiError.Errors = append(apiError.Errors, cloudErrors(responseBody)...)
+	}
+	return nil, &apiError
+}
+
+func (c *Client) send(ctx context.Context, method string, url string, contentType string, body io.Reader, result interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	c.authorize(request)
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	// weldr reports some errors with a 200 status and an errors list
+	apiError := APIError{StatusCode: response.StatusCode}
+	if err := json.Unmarshal(responseBody, &apiError); err == nil && len(apiError.Errors) > 0 {
+		return &apiError
+	}
+	// the Cloud API answers 201 to the composes it queued
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return &APIError{StatusCode: response.StatusCode, Errors: cloudErrors(responseBody)}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(responseBody, result)
+}
+
+// authorize adds the bearer token of the client to a request
+func (c *Client) authorize(request *http.Request) {
+	if c.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package composer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// API paths appended to the root of composer
+const (
+	WeldrAPIPath = "/api/v1"
+	CloudAPIPath = "/api/image-builder-composer/v2"
+)
+
+// LocalUploadTarget keeps the image of a compose of the Cloud API in
+// composer, where it is downloaded like the images of the weldr API
+const LocalUploadTarget = "local"
+
+// CloudComposeRequest starts a compose with the Cloud API, which takes the
+// blueprint and the repositories with every request
+type CloudComposeRequest struct {
+	Distribution string            `json:"distribution"`
+	ImageRequest CloudImageRequest `json:"image_request"`
+	// Blueprint is the JSON form of the TOML blueprint
+	Blueprint json.RawMessage `json:"blueprint,omitempty"`
+}
+
+// CloudImageRequest describes the image composed and where it is uploaded
+type CloudImageRequest struct {
+	Architecture  string              `json:"architecture"`
+	ImageType     string              `json:"image_type"`
+	Repositories  []CloudRepository   `json:"repositories"`
+	OSTree        *OSTreeOptions      `json:"ostree,omitempty"`
+	UploadTargets []CloudUploadTarget `json:"upload_targets,omitempty"`
+}
+
+// CloudRepository is a repository the blueprint is depsolved against, the
+// Cloud API having no default ones
+type CloudRepository struct {
+	BaseURL    string `json:"baseurl,omitempty"`
+	Mirrorlist string `json:"mirrorlist,omitempty"`
+	Metalink   string `json:"metalink,omitempty"`
+	CheckGPG   bool   `json:"check_gpg"`
+	// GPGKey holds the armored keys or the URL the packages are verified with
+	GPGKey    string `json:"gpgkey,omitempty"`
+	IgnoreSSL bool   `json:"ignore_ssl,omitempty"`
+	RHSM      bool   `json:"rhsm"`
+}
+
+// CloudUploadTarget is where composer puts the image of a compose
+type CloudUploadTarget struct {
+	Type          string          `json:"type"`
+	UploadOptions json.RawMessage `json:"upload_options"`
+}
+
+// LocalUpload keeps the image of a compose in composer
+func LocalUpload() []CloudUploadTarget {
+	return []CloudUploadTarget{{Type: LocalUploadTarget, UploadOptions: json.RawMessage("{}")}}
+}
+
+// cloudStatuses map the image statuses of the Cloud API to the queue statuses
+// of the weldr API
+var cloudStatuses = map[string]string{
+	"pending":     StatusWaiting,
+	"building":    StatusRunning,
+	"uploading":   StatusRunning,
+	"registering": StatusRunning,
+	"success":     StatusFinished,
+	"failure":     StatusFailed,
+}
+
+// CloudCompose queues a compose with the Cloud API and returns its ID
+func (c *Client) CloudCompose(ctx context.Context, request CloudComposeRequest) (string, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	response := struct {
+		ID string `json:"id"`
+	}{}
+	if err := c.send(ctx, http.MethodPost, c.Endpoint+"/compose", "application/json", bytes.NewReader(body), &response); err != nil {
+		return "", err
+	}
+	return response.ID, nil
+}
+
+// cloudComposeStatus returns the state of a compose of the Cloud API
+func (c *Client) cloudComposeStatus(ctx context.Context, id string) (*ComposeInfo, error) {
+	response := struct {
+		ImageStatus struct {
+			Status string `json:"status"`
+		} `json:"image_status"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/composes/"+id, &response); err != nil {
+		return nil, err
+	}
+	return &ComposeInfo{ID: id, QueueStatus: cloudStatuses[response.ImageStatus.Status]}, nil
+}
+
+// cloudStatus reads the version of the Cloud API from its OpenAPI document,
+// the Cloud API not reporting the version of composer
+func (c *Client) cloudStatus(ctx context.Context) (*Status, error) {
+	document := struct {
+		Info struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/openapi", &document); err != nil {
+		return nil, err
+	}
+	return &Status{API: document.Info.Version, Backend: document.Info.Title}, nil
+}
+
+// cloudErrors are the errors of a response of the Cloud API, which reports
+// one error with its code and reason
+func cloudErrors(body []byte) []APIErrorDetail {
+	response := struct {
+		Kind   string `json:"kind"`
+		Code   string `json:"code"`
+		Reason string `json:"reason"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil || response.Kind != "Error" {
+		return nil
+	}
+	return []APIErrorDetail{{ID: response.Code, Msg: response.Reason}}
+}
+
+// cloudUnsupported is the error of the weldr requests the Cloud API has no
+// equivalent of
+func cloudUnsupported(message string) error {
+	return &APIError{StatusCode: http.StatusMethodNotAllowed, Errors: []APIErrorDetail{{ID: "CloudAPI", Msg: message}}}
+}
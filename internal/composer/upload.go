@@ -0,0 +1,142 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package composer
+
+import (
+	"context"
+	"net/http"
+)
+
+// Upload providers of the weldr API and upload target types of the Cloud API
+const (
+	ProviderAWS   = "aws"
+	ProviderAzure = "azure"
+	ProviderGCP   = "gcp"
+)
+
+// Upload uploads the image of a compose of the weldr API to a cloud,
+// Settings holding the options and credentials of the provider
+type Upload struct {
+	ImageName string            `json:"image_name"`
+	Provider  string            `json:"provider"`
+	Settings  map[string]string `json:"settings"`
+}
+
+// UploadInfo is the upload of the image of a compose to a cloud
+type UploadInfo struct {
+	Provider string
+	// Status is one of the Status constants
+	Status    string
+	ImageName string
+	// ImageID is the AMI ID, the Azure image or the GCE image, only reported
+	// by the Cloud API
+	ImageID   string
+	Region    string
+	ProjectID string
+}
+
+// cloudUploadStatuses map the upload statuses of the Cloud API to the queue
+// statuses of the weldr API
+var cloudUploadStatuses = map[string]string{
+	"pending": StatusWaiting,
+	"running": StatusRunning,
+	"success": StatusFinished,
+	"failure": StatusFailed,
+}
+
+// Uploads returns the uploads of the image of a compose to a cloud
+func (c *Client) Uploads(ctx context.Context, id string) ([]UploadInfo, error) {
+	if c.Cloud {
+		return c.cloudUploads(ctx, id)
+	}
+	response := struct {
+		Uploads []struct {
+			Status       string            `json:"status"`
+			ProviderName string            `json:"provider_name"`
+			ImageName    string            `json:"image_name"`
+			Settings     map[string]string `json:"settings"`
+		} `json:"uploads"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/compose/info/"+id, &response); err != nil {
+		return nil, err
+	}
+	uploads := []UploadInfo{}
+	for _, upload := range response.Uploads {
+		uploads = append(uploads, UploadInfo{
+			Provider:  upload.ProviderName,
+			Status:    upload.Status,
+			ImageName: upload.ImageName,
+			Region:    upload.Settings["region"],
+		})
+	}
+	return uploads, nil
+}
+
+// cloudUploads returns the uploads of a compose of the Cloud API to a cloud,
+// leaving out the image kept in composer
+func (c *Client) cloudUploads(ctx context.Context, id string) ([]UploadInfo, error) {
+	response := struct {
+		ImageStatus struct {
+			UploadStatuses []struct {
+				Status  string `json:"status"`
+				Type    string `json:"type"`
+				Options struct {
+					AMI       string `json:"ami"`
+					Region    string `json:"region"`
+					ImageName string `json:"image_name"`
+					ProjectID string `json:"project_id"`
+				} `json:"options"`
+			} `json:"upload_statuses"`
+		} `json:"image_status"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/composes/"+id, &response); err != nil {
+		return nil, err
+	}
+	uploads := []UploadInfo{}
+	for _, upload := range response.ImageStatus.UploadStatuses {
+		if upload.Type == LocalUploadTarget {
+			continue
+		}
+		info := UploadInfo{
+			Provider:  upload.Type,
+			Status:    cloudUploadStatuses[upload.Status],
+			ImageName: upload.Options.ImageName,
+			ImageID:   upload.Options.ImageName,
+			Region:    upload.Options.Region,
+			ProjectID: upload.Options.ProjectID,
+		}
+		if upload.Type == ProviderAWS {
+			info.ImageID = upload.Options.AMI
+		}
+		uploads = append(uploads, info)
+	}
+	return uploads, nil
+}
+
+// CloudUploadOptions are the options of the aws, azure and gcp upload
+// targets of the Cloud API, each using some of them
+type CloudUploadOptions struct {
+	Region            string   `json:"region,omitempty"`
+	Bucket            string   `json:"bucket,omitempty"`
+	ShareWithAccounts []string `json:"share_with_accounts,omitempty"`
+	SnapshotName      string   `json:"snapshot_name,omitempty"`
+	ImageName         string   `json:"image_name,omitempty"`
+	TenantID          string   `json:"tenant_id,omitempty"`
+	SubscriptionID    string   `json:"subscription_id,omitempty"`
+	ResourceGroup     string   `json:"resource_group,omitempty"`
+	Location          string   `json:"location,omitempty"`
+}
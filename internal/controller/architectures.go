@@ -0,0 +1,253 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// architectureLabel is the architecture of the images an ImageBuilder builds,
+// selected by the images building one of spec.architectures
+const architectureLabel = "osbuild-operator-architecture"
+
+// parentImageLabel names the image an image builds one of the architectures of
+const parentImageLabel = "osbuild-operator-parent-image"
+
+// builderArchitecture is the architecture of a builder, from its spec or the
+// node running it, empty while it is not known
+func builderArchitecture(builder *osbuildv1alpha1.ImageBuilder) osbuildv1alpha1.Architecture {
+	if builder.Spec.Architecture != "" {
+		return builder.Spec.Architecture
+	}
+	return builder.Status.Architecture
+}
+
+// labelArchitecture labels a builder with its architecture once it is known
+func (r *ImageBuilderReconciler) labelArchitecture(ctx context.Context, builder *osbuildv1alpha1.ImageBuilder) error {
+	architecture := builderArchitecture(builder)
+	if architecture == "" || builder.Labels[architectureLabel] == string(architecture) {
+		return nil
+	}
+	patch := client.MergeFrom(builder.DeepCopy())
+	if builder.Labels == nil {
+		builder.Labels = map[string]string{}
+	}
+	builder.Labels[architectureLabel] = string(architecture)
+	return r.Patch(ctx, builder, patch)
+}
+
+// architectureImageName is the name of the image building one architecture
+// of an image
+func architectureImageName(name string, architecture osbuildv1alpha1.ImageArchitecture) string {
+	return fmt.Sprintf("%s-%s", name, architecture.NodeArchitecture())
+}
+
+// architectureImageSpec is the spec of the image building one architecture of
+// an image: its spec, selecting a builder of the architecture, with the
+// locations set for its uploads suffixed by the architecture. Registry tags
+// default to <generation>-<architecture>, with the generation of the image.
+func architectureImageSpec(image *osbuildv1alpha1.ImageBuilderImage, architecture osbuildv1alpha1.ImageArchitecture) osbuildv1alpha1.ImageBuilderImageSpec {
+	spec := image.Spec.DeepCopy()
+	spec.Architectures = nil
+	// the blueprints are named alike on every builder
+	if spec.Name == "" {
+		spec.Name = image.Name
+	}
+	if spec.ImageBuilderSelector == nil {
+		spec.ImageBuilderSelector = &metav1.LabelSelector{}
+	}
+	if spec.ImageBuilderSelector.MatchLabels == nil {
+		spec.ImageBuilderSelector.MatchLabels = map[string]string{}
+	}
+	spec.ImageBuilderSelector.MatchLabels[architectureLabel] = string(architecture.NodeArchitecture())
+	for _, target := range spec.UploadTargets {
+		switch {
+		case target.Registry != nil && target.Registry.Tag == "":
+			target.Registry.Tag = fmt.Sprintf("%d-%s", image.Generation, architecture)
+		case target.Registry != nil:
+			target.Registry.Tag = fmt.Sprintf("%s-%s", target.Registry.Tag, architecture)
+		case target.S3 != nil && target.S3.Prefix != "":
+			target.S3.Prefix = strings.TrimSuffix(target.S3.Prefix, "/") + "/" + string(architecture)
+		case target.PVC != nil && target.PVC.Path != "":
+			target.PVC.Path = strings.TrimSuffix(target.PVC.Path, "/") + "/" + string(architecture)
+		}
+	}
+	return *spec
+}
+
+// reconcileArchitectures builds an image with spec.architectures by an image
+// per architecture, deleting the ones of the architectures that were removed,
+// and reports their builds in its status
+func (r *ImageBuilderImageReconciler) reconcileArchitectures(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	children, err := r.architectureImages(ctx, image)
+	if err != nil {
+		logger.Error(err, "Could not list the images of the architectures")
+		return ctrl.Result{}, err
+	}
+	wanted := map[string]bool{}
+	for _, architecture := range image.Spec.Architectures {
+		wanted[architectureImageName(image.Name, architecture)] = true
+	}
+	for name, child := range children {
+		if wanted[name] {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Deleting ImageBuilderImage %s of an architecture removed from spec.architectures", name))
+		if err := r.Delete(ctx, child); client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "Could not delete the image of an architecture")
+			return ctrl.Result{}, err
+		}
+	}
+
+	statuses := []osbuildv1alpha1.ArchitectureStatus{}
+	failed, waiting := []string{}, []string{}
+	running, succeeded := 0, 0
+	for _, architecture := range image.Spec.Architectures {
+		child := &osbuildv1alpha1.ImageBuilderImage{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      architectureImageName(image.Name, architecture),
+				Namespace: image.Namespace,
+				Labels: mergeMaps(image.Labels, map[string]string{
+					parentImageLabel:  image.Name,
+					architectureLabel: string(architecture.NodeArchitecture()),
+				}),
+				Annotations: mergeMaps(r.resourceAnnotations(image), map[string]string{
+					RebuildAnnotation: image.Annotations[RebuildAnnotation],
+				}),
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(image, osbuildv1alpha1.GroupVersion.WithKind("ImageBuilderImage")),
+				},
+			},
+			Spec: architectureImageSpec(image, architecture),
+		}
+		// the image of the architecture may be one the user created
+		if _, ok := children[child.Name]; !ok {
+			existing := osbuildv1alpha1.ImageBuilderImage{}
+			err := r.Get(ctx, client.ObjectKeyFromObject(child), &existing)
+			if err == nil {
+				message := fmt.Sprintf("ImageBuilderImage %s of architecture %s already exists and was not created for this image", child.Name, architecture)
+				logger.Error(nil, message)
+				setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonNameCollision, message)
+				setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonNameCollision, message)
+				return ctrl.Result{}, updateImageStatus(ctx, r.Client, image)
+			}
+			if !errors.IsNotFound(err) {
+				logger.Error(err, "Could not get the image of an architecture")
+				return ctrl.Result{}, err
+			}
+		}
+		if err := ApplyObject(ctx, r.Client, child, image.Spec.ForceOwnership); err != nil {
+			if conflicts := fieldConflicts(err); conflicts != "" {
+				return r.resourceConflict(ctx, image, child, conflicts)
+			}
+			return ctrl.Result{}, err
+		}
+		status := osbuildv1alpha1.ArchitectureStatus{
+			Architecture: architecture,
+			Image:        child.Name,
+			Phase:        osbuildv1alpha1.PhasePending,
+		}
+		if existing, ok := children[child.Name]; ok {
+			if existing.Status.Phase != "" {
+				status.Phase = existing.Status.Phase
+			}
+			if ready := meta.FindStatusCondition(existing.Status.Conditions, osbuildv1alpha1.ConditionReady); ready != nil {
+				status.Message = ready.Message
+			}
+			status.Artifacts = existing.Status.Artifacts
+			status.Uploads = existing.Status.Uploads
+		}
+		switch status.Phase {
+		case osbuildv1alpha1.PhaseFailed:
+			failed = append(failed, fmt.Sprintf("%s: %s", architecture, status.Message))
+		case osbuildv1alpha1.PhaseSucceeded:
+			succeeded++
+		case osbuildv1alpha1.PhaseRunning, osbuildv1alpha1.PhaseQueued:
+			running++
+		default:
+			waiting = append(waiting, string(architecture))
+		}
+		statuses = append(statuses, status)
+	}
+	image.Status.Architectures = statuses
+
+	switch {
+	case len(failed) > 0:
+		message := fmt.Sprintf("The build failed for %s", strings.Join(failed, "; "))
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildFailed, message)
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonBuildFailed, message)
+	case succeeded == len(statuses):
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionTrue, osbuildv1alpha1.ReasonBuildSucceeded,
+			fmt.Sprintf("The image was built for %d architectures", succeeded))
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildSucceeded, "")
+	case running > 0:
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildRunning,
+			fmt.Sprintf("%d of %d architectures built, %d building", succeeded, len(statuses), running))
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildRunning, "")
+	default:
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForArchitectures,
+			fmt.Sprintf("Waiting for the builds of %s to start", strings.Join(waiting, ", ")))
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForArchitectures, "")
+	}
+	// the status of the images of the architectures reconciles the image again
+	return ctrl.Result{}, updateImageStatus(ctx, r.Client, image)
+}
+
+// architectureImages returns the images building the architectures of an
+// image, by name
+func (r *ImageBuilderImageReconciler) architectureImages(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage) (map[string]*osbuildv1alpha1.ImageBuilderImage, error) {
+	images := osbuildv1alpha1.ImageBuilderImageList{}
+	if err := r.List(ctx, &images, client.InNamespace(image.Namespace), client.MatchingLabels{parentImageLabel: image.Name}); err != nil {
+		return nil, err
+	}
+	children := map[string]*osbuildv1alpha1.ImageBuilderImage{}
+	for i := range images.Items {
+		if metav1.IsControlledBy(&images.Items[i], image) {
+			children[images.Items[i].Name] = &images.Items[i]
+		}
+	}
+	return children, nil
+}
+
+// deleteArchitectureImages deletes the images building the architectures of
+// an image that no longer sets spec.architectures
+func (r *ImageBuilderImageReconciler) deleteArchitectureImages(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage) error {
+	logger := log.FromContext(ctx)
+	children, err := r.architectureImages(ctx, image)
+	if err != nil {
+		return err
+	}
+	for name, child := range children {
+		logger.Info(fmt.Sprintf("Deleting ImageBuilderImage %s, spec.architectures is not set anymore", name))
+		if err := r.Delete(ctx, child); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	image.Status.Architectures = nil
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+)
+
+var _ = Describe("Images of several architectures", func() {
+	ctx := context.Background()
+	var reconciler *ImageBuilderImageReconciler
+	var image *osbuildv1alpha1.ImageBuilderImage
+
+	BeforeEach(func() {
+		reconciler = &ImageBuilderImageReconciler{Client: k8sClient, Recorder: record.NewFakeRecorder(10)}
+		image = &osbuildv1alpha1.ImageBuilderImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: createNamespace(ctx)},
+			Spec: osbuildv1alpha1.ImageBuilderImageSpec{
+				ComposeType:   osbuildv1alpha1.ComposeQcow2,
+				Architectures: []osbuildv1alpha1.ImageArchitecture{osbuildv1alpha1.ImageArchitectureX86_64, osbuildv1alpha1.ImageArchitectureAArch64},
+			},
+		}
+		Expect(k8sClient.Create(ctx, image)).To(Succeed())
+	})
+
+	It("creates an image per architecture", func() {
+		_, err := reconciler.reconcileArchitectures(ctx, image)
+		Expect(err).NotTo(HaveOccurred())
+
+		children, err := reconciler.architectureImages(ctx, image)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(children).To(HaveKey("edge-amd64"))
+		Expect(children).To(HaveKey("edge-arm64"))
+		Expect(children["edge-arm64"].Spec.Architectures).To(BeEmpty())
+		Expect(children["edge-arm64"].Spec.ImageBuilderSelector.MatchLabels).To(HaveKeyWithValue(architectureLabel, "arm64"))
+		Expect(image.Status.Architectures).To(HaveLen(2))
+	})
+
+	It("leaves alone an image of the same name it did not create", func() {
+		existing := &osbuildv1alpha1.ImageBuilderImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge-arm64", Namespace: image.Namespace},
+			Spec:       osbuildv1alpha1.ImageBuilderImageSpec{ComposeType: osbuildv1alpha1.ComposeQcow2, Name: "mine"},
+		}
+		Expect(k8sClient.Create(ctx, existing)).To(Succeed())
+
+		_, err := reconciler.reconcileArchitectures(ctx, image)
+		Expect(err).NotTo(HaveOccurred())
+
+		failed := meta.FindStatusCondition(image.Status.Conditions, osbuildv1alpha1.ConditionFailed)
+		Expect(failed).NotTo(BeNil())
+		Expect(failed.Status).To(Equal(metav1.ConditionTrue))
+		Expect(failed.Reason).To(Equal(osbuildv1alpha1.ReasonNameCollision))
+		Expect(failed.Message).To(ContainSubstring("edge-arm64"))
+
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(existing), existing)).To(Succeed())
+		Expect(existing.Spec.Name).To(Equal("mine"))
+		Expect(existing.OwnerReferences).To(BeEmpty())
+		Expect(existing.Labels).NotTo(HaveKey(parentImageLabel))
+	})
+})
@@ -0,0 +1,255 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"strconv"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Results of the pipelines describing the build: the artifacts as a JSON
+// array of BuildArtifact without location, and the composer version
+const (
+	artifactsResult      = "artifacts"
+	builderVersionResult = "builderVersion"
+)
+
+// artifactsTaskName is the pipeline task describing the artifacts
+const artifactsTaskName = "describe-artifacts"
+
+// Annotations describing an artifact, set on the artifacts pushed to OCI
+// registries next to the standard org.opencontainers.image ones
+const (
+	ArtifactSizeAnnotation           = "osbuild.rh-ecosystem-edge.io/size"
+	ArtifactComposeTypeAnnotation    = "osbuild.rh-ecosystem-edge.io/compose-type"
+	ArtifactComposeIDAnnotation      = "osbuild.rh-ecosystem-edge.io/compose-id"
+	ArtifactBuildDurationAnnotation  = "osbuild.rh-ecosystem-edge.io/build-duration"
+	ArtifactBuilderVersionAnnotation = "osbuild.rh-ecosystem-edge.io/builder-version"
+	ArtifactMediaTypeAnnotation      = "osbuild.rh-ecosystem-edge.io/media-type"
+)
+
+// Provenance annotations of the manifests pushed to OCI registries, tracing
+// them back to the build that produced them
+const (
+	// ArtifactSourceAnnotation is the <namespace>/<name> of the ImageBuilderImage
+	ArtifactSourceAnnotation        = "osbuild.rh-ecosystem-edge.io/source"
+	ArtifactSourceUIDAnnotation     = "osbuild.rh-ecosystem-edge.io/source-uid"
+	ArtifactGenerationAnnotation    = "osbuild.rh-ecosystem-edge.io/generation"
+	ArtifactBlueprintHashAnnotation = "osbuild.rh-ecosystem-edge.io/blueprint-hash"
+	ArtifactOstreeCommitAnnotation  = "osbuild.rh-ecosystem-edge.io/ostree-commit"
+	ArtifactCreatedAnnotation       = "org.opencontainers.image.created"
+)
+
+// describeArtifactsScript fetches the compose logs and the composer version,
+// which the Cloud API does not report, and describes every file the build produced. The description is also stored
+// next to the artifacts, with the annotations used when pushing them.
+const describeArtifactsScript = `#!/bin/bash
+set -e
+dir=/workspace/shared-volume/$(params.blueprintName)
+api="$(params.apiEndpoint)"
+compose_id=$(jq -r '.build_id // ""' "${dir}/compose.json")
+installer_compose_id=$(jq -r '.build_id // ""' "${dir}/compose-iso.json" 2>/dev/null || true)
+ostree_commit=$(jq -r '."ostree-commit" // ""' "${dir}/commit.json" 2>/dev/null || true)
+created=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+builder_version=""
+if [ "${api_flavor}" = cloud ]; then
+  /usr/bin/curl --silent --fail "${api}/composes/${compose_id}/logs" --output "${dir}/compose-logs.json" || rm -f "${dir}/compose-logs.json"
+else
+  /usr/bin/curl --silent --fail "${api}/compose/logs/${compose_id}" --output "${dir}/compose-logs.tar" || rm -f "${dir}/compose-logs.tar"
+  builder_version=$(/usr/bin/curl --silent --fail "${api%/v1}/status" | jq -r '.build // ""')
+fi
+printf '%s' "${builder_version}" | tee $(results.builderVersion.path)
+echo
+entries=""
+describe() {
+  [ -f "${dir}/$2" ] || return 0
+  digest=$(sha256sum "${dir}/$2" | cut -d' ' -f1)
+  size=$(stat -c %s "${dir}/$2")
+  entries="${entries:+${entries},}{\"type\":\"$1\",\"name\":\"$2\",\"mediaType\":\"$3\",\"composeType\":\"$4\",\"composeID\":\"$5\",\"digest\":\"sha256:${digest}\",\"size\":${size}}"
+}
+describe commit edge-commit.tar application/x-tar edge-commit "${compose_id}"
+describe image "${image_file}" "${image_media_type}" "${compose_type}" "${compose_id}"
+describe installer installer.iso application/x-iso9660-image "${target}" "${installer_compose_id}"
+describe metadata compose.json application/json "${compose_type}" "${compose_id}"
+describe metadata commit.json application/json edge-commit "${compose_id}"
+describe metadata compose-iso.json application/json "${target}" "${installer_compose_id}"
+describe logs compose-logs.tar application/x-tar "${compose_type}" "${compose_id}"
+describe logs compose-logs.json application/json "${compose_type}" "${compose_id}"
+printf '[%s]' "${entries}" | tee $(results.artifacts.path) "${dir}/artifacts.json"
+# annotations of the artifacts pushed to registries, in the oras format, the
+# manifest carrying the provenance of the build
+jq --arg version "${builder_version}" --arg created "${created}" --arg commit "${ostree_commit}" \
+  --arg source "${source}" --arg uid "${source_uid}" --arg generation "${generation}" --arg hash "${blueprint_hash}" '(map({(.name): {
+  "org.opencontainers.image.title": .name,
+  "org.opencontainers.image.created": $created,
+  "osbuild.rh-ecosystem-edge.io/size": (.size | tostring),
+  "osbuild.rh-ecosystem-edge.io/media-type": .mediaType,
+  "osbuild.rh-ecosystem-edge.io/compose-type": .composeType,
+  "osbuild.rh-ecosystem-edge.io/compose-id": .composeID,
+  "osbuild.rh-ecosystem-edge.io/builder-version": $version
+}}) | add) + {"$manifest": ({
+  "org.opencontainers.image.created": $created,
+  "osbuild.rh-ecosystem-edge.io/builder-version": $version,
+  "osbuild.rh-ecosystem-edge.io/source": $source,
+  "osbuild.rh-ecosystem-edge.io/source-uid": $uid,
+  "osbuild.rh-ecosystem-edge.io/generation": $generation,
+  "osbuild.rh-ecosystem-edge.io/blueprint-hash": $hash,
+  "osbuild.rh-ecosystem-edge.io/compose-id": (map(select(.type == "commit" or .type == "image")) | first | .composeID // ""),
+  "osbuild.rh-ecosystem-edge.io/ostree-commit": $commit
+} | with_entries(select(.value != "")))}' "${dir}/artifacts.json" > "${dir}/annotations.json"
+`
+
+// describeArtifactsStep lists the artifacts of the build in the results of
+// its task
+func (r *imageReconcile) describeArtifactsStep() tektonv1.Step {
+	return tektonv1.Step{
+		Name:   artifactsTaskName,
+		Image:  utilsImage,
+		Script: describeArtifactsScript,
+		Env: append([]corev1.EnvVar{
+			{
+				Name:  "target",
+				Value: r.isoTarget,
+			},
+			{
+				Name:  "api_flavor",
+				Value: string(r.apiFlavor),
+			},
+		}, composeImageEnv(r.composeType)...),
+	}
+}
+
+// setProvenance gives the step describing the artifacts the image and
+// generation being built, recorded in the annotations of the pushed manifests
+func setProvenance(pipeline *tektonv1.Pipeline, image *osbuildv1alpha1.ImageBuilderImage) {
+	env := []corev1.EnvVar{
+		{
+			Name:  "source",
+			Value: image.Namespace + "/" + image.Name,
+		},
+		{
+			Name:  "source_uid",
+			Value: string(image.UID),
+		},
+		{
+			Name:  "generation",
+			Value: strconv.FormatInt(image.Generation, 10),
+		},
+		{
+			Name:  "blueprint_hash",
+			Value: image.Status.BlueprintHash,
+		},
+	}
+	for _, task := range pipeline.Spec.Tasks {
+		if task.TaskSpec == nil {
+			continue
+		}
+		for i := range task.TaskSpec.Steps {
+			if task.TaskSpec.Steps[i].Name == artifactsTaskName {
+				task.TaskSpec.Steps[i].Env = append(task.TaskSpec.Steps[i].Env, env...)
+			}
+		}
+	}
+}
+
+// artifactsTaskResults declares the results written by describeArtifactsStep
+func artifactsTaskResults() []tektonv1.TaskResult {
+	return []tektonv1.TaskResult{
+		{
+			Name:        artifactsResult,
+			Description: "JSON list of the artifacts produced by the build",
+		},
+		{
+			Name:        builderVersionResult,
+			Description: "Version of the composer that built the image",
+		},
+	}
+}
+
+// artifactsPipelineResults exposes the results of the pipeline task
+// describing the artifacts
+func artifactsPipelineResults(taskName string) []tektonv1.PipelineResult {
+	results := []tektonv1.PipelineResult{}
+	for _, result := range artifactsTaskResults() {
+		results = append(results, tektonv1.PipelineResult{
+			Name:        result.Name,
+			Description: result.Description,
+			Value:       *tektonv1.NewStructuredValues("$(tasks." + taskName + ".results." + result.Name + ")"),
+		})
+	}
+	return results
+}
+
+// setBuildArtifacts records the artifacts listed by a successful build, with
+// the duration of the build and the version of the composer. Artifacts stored
+// on the shared volume are located relative to the root of the web server of
+// the image.
+func setBuildArtifacts(image *osbuildv1alpha1.ImageBuilderImage, pipelineRun *tektonv1.PipelineRun, served bool) {
+	image.Status.Artifacts = nil
+	image.Status.BuilderVersion = ""
+	image.Status.BuildDuration = nil
+	if pipelineRun.Status.StartTime != nil && pipelineRun.Status.CompletionTime != nil {
+		image.Status.BuildDuration = &metav1.Duration{
+			Duration: pipelineRun.Status.CompletionTime.Sub(pipelineRun.Status.StartTime.Time),
+		}
+	}
+	for _, result := range pipelineRun.Status.Results {
+		switch result.Name {
+		case builderVersionResult:
+			image.Status.BuilderVersion = result.Value.StringVal
+		case artifactsResult:
+			artifacts := []osbuildv1alpha1.BuildArtifact{}
+			if err := json.Unmarshal([]byte(result.Value.StringVal), &artifacts); err != nil {
+				continue
+			}
+			for i := range artifacts {
+				if served {
+					artifacts[i].Location = "/" + artifacts[i].Name
+				}
+			}
+			image.Status.Artifacts = artifacts
+		}
+	}
+}
+
+// ArtifactAnnotations describes an artifact of the last successful build of
+// an image, for the registries and dashboards filtering on them
+func ArtifactAnnotations(image *osbuildv1alpha1.ImageBuilderImage, artifact osbuildv1alpha1.BuildArtifact) map[string]string {
+	annotations := map[string]string{
+		ArtifactSizeAnnotation:        strconv.FormatInt(artifact.Size, 10),
+		ArtifactMediaTypeAnnotation:   artifact.MediaType,
+		ArtifactComposeTypeAnnotation: artifact.ComposeType,
+		ArtifactSourceAnnotation:      image.Namespace + "/" + image.Name,
+		ArtifactSourceUIDAnnotation:   string(image.UID),
+		ArtifactGenerationAnnotation:  strconv.FormatInt(image.Status.ArtifactsGeneration, 10),
+	}
+	if artifact.ComposeID != "" {
+		annotations[ArtifactComposeIDAnnotation] = artifact.ComposeID
+	}
+	if image.Status.BuildDuration != nil {
+		annotations[ArtifactBuildDurationAnnotation] = image.Status.BuildDuration.Duration.String()
+	}
+	if image.Status.BuilderVersion != "" {
+		annotations[ArtifactBuilderVersionAnnotation] = image.Status.BuilderVersion
+	}
+	return annotations
+}
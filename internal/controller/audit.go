@@ -0,0 +1,228 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/registry"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const buildRecordAnnotation = "osbuild.rh-ecosystem-edge.io/build-record"
+const buildRecordLabel = "osbuild-operator-build-record"
+
+// stepImageDigestsAnnotation maps the step images of a build to the
+// image@sha256 references its pods ran, the data of the build record being
+// immutable
+const stepImageDigestsAnnotation = "osbuild.rh-ecosystem-edge.io/step-image-digests"
+
+// resolveTimeout bounds the resolution of an embedded container
+const resolveTimeout = 10 * time.Second
+
+// containerDigest is an embedded container of a build, resolved to the
+// digest of its manifest when the build started
+type containerDigest struct {
+	Source string `json:"source"`
+	Name   string `json:"name,omitempty"`
+	// Image is the image@sha256 reference of the source
+	Image string `json:"image,omitempty"`
+	// Error tells why the source could not be resolved, composer pulling it
+	// anyway
+	Error string `json:"error,omitempty"`
+}
+
+// BuildRecord returns an immutable ConfigMap recording the exact inputs of a
+// build, so any artifact can be traced back to what produced it. The blueprints
+// are either in blueprintConfigMap or, when they embed credentials, in
+// blueprintSecret.
+func (r *ImageBuilderImageReconciler) BuildRecord(objectMeta metav1.ObjectMeta, image osbuildv1alpha1.ImageBuilderImage, imageBuilder osbuildv1alpha1.ImageBuilder, pipelineRunName string, blueprintConfigMap string, blueprintSecret string) (corev1.ConfigMap, error) {
+	spec, err := json.Marshal(image.Spec)
+	if err != nil {
+		return corev1.ConfigMap{}, err
+	}
+	objectMeta.Labels = mergeMaps(objectMeta.Labels, map[string]string{
+		buildRecordLabel: "true",
+	})
+	record := corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: objectMeta,
+		Immutable:  pointer.Bool(true),
+		Data: map[string]string{
+			"image":                  image.Name,
+			"imageUID":               string(image.UID),
+			"generation":             strconv.FormatInt(image.Generation, 10),
+			"spec.json":              string(spec),
+			"pipelineRun":            pipelineRunName,
+			"blueprintConfigMap":     blueprintConfigMap,
+			"blueprintSecret":        blueprintSecret,
+			"blueprintHash":          image.Status.BlueprintHash,
+			"blueprintVersion":       image.Status.BlueprintVersion,
+			"imageBuilder":           fmt.Sprintf("%s/%s", imageBuilder.Namespace, imageBuilder.Name),
+			"imageBuilderUID":        string(imageBuilder.UID),
+			"imageBuilderGeneration": strconv.FormatInt(imageBuilder.Generation, 10),
+			"builderDataSource":      fmt.Sprintf("%s/%s", builderDataSourceNamespace, builderDataSource),
+			"stepImages":             fmt.Sprintf("%s,%s", r.stepImages(&image).Resolve(ubiImage, imageBuilder.Spec.Architecture), r.stepImages(&image).Resolve(utilsImage, imageBuilder.Spec.Architecture)),
+			"architecture":           string(imageBuilder.Spec.Architecture),
+		},
+	}
+	return record, nil
+}
+
+// embeddedContainerDigests resolves the embedded containers of an image with
+// the registry credentials of their pull Secrets
+func (r *ImageBuilderImageReconciler) embeddedContainerDigests(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage) []containerDigest {
+	digests := []containerDigest{}
+	for _, container := range image.Spec.EmbeddedContainers {
+		digest := containerDigest{Source: container.Source, Name: container.Name}
+		resolved, err := r.resolveContainer(ctx, image.Namespace, container)
+		if err != nil {
+			digest.Error = err.Error()
+		}
+		digest.Image = resolved
+		digests = append(digests, digest)
+	}
+	return digests
+}
+
+// resolveContainer returns the image@sha256 reference of an embedded container
+func (r *ImageBuilderImageReconciler) resolveContainer(ctx context.Context, namespace string, container osbuildv1alpha1.EmbeddedContainer) (string, error) {
+	reference, err := registry.ParseReference(container.Source)
+	if err != nil {
+		return "", err
+	}
+	var credentials *registry.Credentials
+	if container.PullSecret != nil {
+		secret := corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: container.PullSecret.Name}, &secret); err != nil {
+			return "", err
+		}
+		config := dockerConfig{}
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+			return "", err
+		}
+		credentials = registryCredentials(config, reference.Registry)
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	digest, err := registry.NewClient(pointer.BoolDeref(container.TLSVerify, true), credentials).Resolve(ctx, reference)
+	if err != nil {
+		return "", err
+	}
+	return reference.Name() + "@" + digest, nil
+}
+
+// registryCredentials returns the credentials of a registry in the auths of
+// a pull Secret, keyed by host or by URL, Docker Hub being index.docker.io
+func registryCredentials(config dockerConfig, host string) *registry.Credentials {
+	for key, raw := range config.Auths {
+		if parsed, err := url.Parse(key); err == nil && parsed.Host != "" {
+			key = parsed.Host
+		}
+		if key == "index.docker.io" || key == "registry-1.docker.io" {
+			key = "docker.io"
+		}
+		if key != host {
+			continue
+		}
+		auth := struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}{}
+		if err := json.Unmarshal(raw, &auth); err != nil {
+			return nil
+		}
+		if decoded, err := base64.StdEncoding.DecodeString(auth.Auth); err == nil && auth.Auth != "" {
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		return &registry.Credentials{Username: auth.Username, Password: auth.Password}
+	}
+	return nil
+}
+
+// recordStepImageDigests annotates the build record of the current build of
+// an image with the image@sha256 references its pods ran, as reported by the
+// kubelet, the pods of the later tasks adding theirs
+func (r *ImageBuilderImageReconciler) recordStepImageDigests(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, pods []corev1.Pod) error {
+	if image.Status.BuildRecord == "" {
+		return nil
+	}
+	record := corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: image.Namespace, Name: image.Status.BuildRecord}, &record); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	digests := map[string]string{}
+	if recorded := record.Annotations[stepImageDigestsAnnotation]; recorded != "" {
+		_ = json.Unmarshal([]byte(recorded), &digests)
+	}
+	previous := make(map[string]string, len(digests))
+	for stepImage, digest := range digests {
+		previous[stepImage] = digest
+	}
+	for _, pod := range pods {
+		for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			imageID := strings.TrimPrefix(status.ImageID, "docker-pullable://")
+			if strings.Contains(imageID, "@sha256:") {
+				digests[status.Image] = imageID
+			}
+		}
+	}
+	if reflect.DeepEqual(digests, previous) {
+		return nil
+	}
+	data, err := json.Marshal(digests)
+	if err != nil {
+		return err
+	}
+	patch := client.MergeFrom(record.DeepCopy())
+	record.Annotations = mergeMaps(record.Annotations, map[string]string{stepImageDigestsAnnotation: string(data)})
+	return r.Patch(ctx, &record, patch)
+}
+
+// BuildRecords returns the build records of an image, oldest generation first
+func BuildRecords(ctx context.Context, c client.Client, namespace string, imageName string) ([]corev1.ConfigMap, error) {
+	records := corev1.ConfigMapList{}
+	if err := c.List(ctx, &records, client.InNamespace(namespace), client.MatchingLabels{
+		imageBuilderImageLabel: imageName,
+		buildRecordLabel:       "true",
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(records.Items, func(i, j int) bool {
+		first, _ := strconv.ParseInt(records.Items[i].Data["generation"], 10, 64)
+		second, _ := strconv.ParseInt(records.Items[j].Data["generation"], 10, 64)
+		return first < second
+	})
+	return records.Items, nil
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+)
+
+// blueprintHash returns a stable hash of all the rendered blueprints of an image
+func blueprintHash(blueprints map[string]string) string {
+	names := make([]string, 0, len(blueprints))
+	for name := range blueprints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s\n%s\n", name, blueprints[name])
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil))
+}
+
+// pushBlueprints stores the rendered blueprints of an image in composer
+// before its build starts, in name order
+func pushBlueprints(ctx context.Context, composerClient *composer.Client, blueprints map[string]string) error {
+	for _, name := range blueprintNames(blueprints) {
+		if err := composerClient.PushBlueprint(ctx, blueprints[name]); err != nil {
+			return fmt.Errorf("could not push blueprint %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// blueprintNames returns the sorted names of blueprints
+func blueprintNames(blueprints map[string]string) []string {
+	names := make([]string, 0, len(blueprints))
+	for name := range blueprints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// blueprintRejected tells if composer refused a blueprint, which pushing it
+// again does not fix, rather than being unreachable
+func blueprintRejected(err error) bool {
+	apiError := &composer.APIError{}
+	return errors.As(err, &apiError) && apiError.StatusCode < http.StatusInternalServerError
+}
+
+// validateBlueprint does a light syntax check of a rendered TOML blueprint,
+// catching the mistakes broken templates usually produce before the blueprint
+// reaches osbuild-composer. A table defined twice, e.g. by a template and a
+// profile appended to it, is rejected as TOML does not merge them.
+func validateBlueprint(blueprint string) error {
+	hasName := false
+	// tables are the lines defining the tables, arrayTables the names of
+	// the arrays of tables
+	tables := map[string]int{}
+	arrayTables := map[string]bool{}
+	arrayDepth := 0
+	multiline := false
+	for counter, line := range strings.Split(blueprint, "\n") {
+		lineNumber := counter + 1
+		if multiline {
+			if strings.Contains(line, `"""`) {
+				multiline = false
+			}
+			continue
+		}
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		if arrayDepth > 0 {
+			arrayDepth += bracketDepth(line)
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || bracketDepth(line) != 0 {
+				return fmt.Errorf("line %d: malformed table header %q", lineNumber, line)
+			}
+			if strings.HasPrefix(line, "[[") {
+				name := tableName(strings.TrimSuffix(strings.TrimPrefix(line, "[["), "]]"))
+				if _, defined := tables[name]; defined {
+					return fmt.Errorf("line %d: [[%s]] is already defined as a table", lineNumber, name)
+				}
+				arrayTables[name] = true
+				// every element of the array defines its own sub-tables
+				for table := range tables {
+					if strings.HasPrefix(table, name+".") {
+						delete(tables, table)
+					}
+				}
+				continue
+			}
+			name := tableName(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+			if previous, defined := tables[name]; defined {
+				return fmt.Errorf("line %d: table [%s] is already defined on line %d", lineNumber, name, previous)
+			}
+			if arrayTables[name] {
+				return fmt.Errorf("line %d: [%s] is already defined as an array of tables", lineNumber, name)
+			}
+			tables[name] = lineNumber
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return fmt.Errorf("line %d: expected key = value, got %q", lineNumber, line)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if key == "" {
+			return fmt.Errorf("line %d: missing key", lineNumber)
+		}
+		if value == "" {
+			return fmt.Errorf("line %d: missing value for %q", lineNumber, key)
+		}
+		if strings.HasPrefix(value, `"""`) {
+			multiline = strings.Count(value, `"""`) == 1
+			continue
+		}
+		if unescapedQuotes(value)%2 != 0 {
+			return fmt.Errorf("line %d: unbalanced quotes in value of %q", lineNumber, key)
+		}
+		if key == "name" && arrayDepth == 0 && !hasName {
+			hasName = true
+			if value == `""` {
+				return fmt.Errorf("line %d: blueprint name is empty", lineNumber)
+			}
+		}
+		arrayDepth += bracketDepth(value)
+		if arrayDepth < 0 {
+			return fmt.Errorf("line %d: unexpected ] in value of %q", lineNumber, key)
+		}
+	}
+	if multiline {
+		return fmt.Errorf("unterminated multi-line string")
+	}
+	if arrayDepth != 0 {
+		return fmt.Errorf("unterminated array")
+	}
+	if !hasName {
+		return fmt.Errorf("blueprint has no name")
+	}
+	return nil
+}
+
+// tableName removes the spaces around the dotted keys of a table header
+func tableName(header string) string {
+	keys := strings.Split(header, ".")
+	for i := range keys {
+		keys[i] = strings.TrimSpace(keys[i])
+	}
+	return strings.Join(keys, ".")
+}
+
+// stripComment removes a trailing # comment that is not inside a string
+func stripComment(line string) string {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			inString = !inString
+		case '#':
+			if !inString {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// bracketDepth returns the number of opened minus closed brackets outside strings
+func bracketDepth(value string) int {
+	depth := 0
+	inString := false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			inString = !inString
+		case '[':
+			if !inString {
+				depth++
+			}
+		case ']':
+			if !inString {
+				depth--
+			}
+		}
+	}
+	return depth
+}
+
+func unescapedQuotes(value string) int {
+	count := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			count++
+		}
+	}
+	return count
+}
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+type diffEdit struct {
+	op   byte
+	line string
+	from int
+	to   int
+}
+
+// blueprintDiff returns a unified diff of every blueprint that changed between
+// two renderings, empty if they are identical
+func blueprintDiff(previous, current map[string]string) string {
+	names := []string{}
+	for name := range previous {
+		names = append(names, name)
+	}
+	for name := range current {
+		if _, ok := previous[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var diff strings.Builder
+	for _, name := range names {
+		if previous[name] == current[name] {
+			continue
+		}
+		diff.WriteString(unifiedDiff("a/"+name, "b/"+name, previous[name], current[name]))
+	}
+	return diff.String()
+}
+
+// unifiedDiff compares two texts line by line and formats the result like diff -u
+func unifiedDiff(fromName, toName, from, to string) string {
+	a := splitLines(from)
+	b := splitLines(to)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	edits := []diffEdit{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, diffEdit{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, diffEdit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, diffEdit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", fromName, toName)
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := k + 1
+		for l := k; l < len(edits) && l-end < 2*diffContext; l++ {
+			if edits[l].op != ' ' {
+				end = l + 1
+			}
+		}
+		end += diffContext
+		if end > len(edits) {
+			end = len(edits)
+		}
+		writeHunk(&diff, edits[start:end])
+		k = end
+	}
+	return diff.String()
+}
+
+func writeHunk(diff *strings.Builder, edits []diffEdit) {
+	fromCount, toCount := 0, 0
+	for _, edit := range edits {
+		if edit.op != '+' {
+			fromCount++
+		}
+		if edit.op != '-' {
+			toCount++
+		}
+	}
+	fromStart, toStart := edits[0].from+1, edits[0].to+1
+	if fromCount == 0 {
+		fromStart--
+	}
+	if toCount == 0 {
+		toStart--
+	}
+	fmt.Fprintf(diff, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+	for _, edit := range edits {
+		fmt.Fprintf(diff, "%c%s\n", edit.op, edit.line)
+	}
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
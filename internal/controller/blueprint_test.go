@@ -0,0 +1,65 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	"github.com/kwozyman/osbuild-operator/pkg/composertest"
+)
+
+var _ = Describe("Blueprint push", func() {
+	ctx := context.Background()
+	var composerServer *composertest.Server
+
+	BeforeEach(func() {
+		composerServer = composertest.NewServer()
+		DeferCleanup(composerServer.Close)
+	})
+
+	It("pushes the blueprints of an image to composer", func() {
+		blueprints := map[string]string{
+			"edge":           "name = \"edge\"\nversion = \"0.0.1\"\n",
+			"edge-installer": "name = \"edge-installer\"\nversion = \"0.0.1\"\n",
+		}
+		Expect(pushBlueprints(ctx, composer.NewClient(composerServer.APIEndpoint()), blueprints)).To(Succeed())
+		for name, blueprint := range blueprints {
+			pushed, ok := composerServer.Blueprint(name)
+			Expect(ok).To(BeTrue())
+			Expect(pushed).To(Equal(blueprint))
+		}
+	})
+
+	It("reports the blueprints composer rejects", func() {
+		err := pushBlueprints(ctx, composer.NewClient(composerServer.APIEndpoint()), map[string]string{"broken": "version = \"0.0.1\"\n"})
+		Expect(err).To(MatchError(ContainSubstring("could not push blueprint broken")))
+		Expect(blueprintRejected(err)).To(BeTrue())
+	})
+
+	It("retries the blueprints of an unreachable composer", func() {
+		composerServer.Close()
+		err := pushBlueprints(ctx, composer.NewClient(composerServer.APIEndpoint()), map[string]string{"edge": "name = \"edge\"\n"})
+		Expect(err).To(HaveOccurred())
+		Expect(blueprintRejected(err)).To(BeFalse())
+		Expect(blueprintRejected(fmt.Errorf("other error"))).To(BeFalse())
+	})
+})
@@ -0,0 +1,102 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// blueprintObject stores blueprints in a ConfigMap, or in a Secret when they
+// embed values read from Secrets so those are never readable from ConfigMaps
+func blueprintObject(objectMeta metav1.ObjectMeta, blueprints map[string]string, sensitive bool, immutable bool) client.Object {
+	var immutableField *bool
+	if immutable {
+		immutableField = pointer.Bool(true)
+	}
+	if sensitive {
+		data := map[string][]byte{}
+		for name, blueprint := range blueprints {
+			data[name] = []byte(blueprint)
+		}
+		return &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Secret",
+				APIVersion: "v1",
+			},
+			ObjectMeta: objectMeta,
+			Immutable:  immutableField,
+			Type:       corev1.SecretTypeOpaque,
+			Data:       data,
+		}
+	}
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: objectMeta,
+		Immutable:  immutableField,
+		Data:       blueprints,
+	}
+}
+
+// deleteBlueprintObject deletes the named blueprints of an image stored in a
+// ConfigMap, or Secret when secret is set, once they moved to the other kind
+func deleteBlueprintObject(ctx context.Context, c client.Client, namespace string, name string, imageName string, secret bool) error {
+	var object client.Object = &corev1.ConfigMap{}
+	if secret {
+		object = &corev1.Secret{}
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, object); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	// never delete an object that was not generated for this image
+	if object.GetLabels()[imageBuilderImageLabel] != imageName {
+		return nil
+	}
+	if err := c.Delete(ctx, object); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// readBlueprints returns the blueprints stored in the named ConfigMap, or
+// Secret when secret is set
+func readBlueprints(ctx context.Context, c client.Client, namespace string, name string, secret bool) (map[string]string, error) {
+	if !secret {
+		configMap := corev1.ConfigMap{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &configMap); err != nil {
+			return nil, err
+		}
+		return configMap.Data, nil
+	}
+	secretObject := corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &secretObject); err != nil {
+		return nil, err
+	}
+	blueprints := map[string]string{}
+	for name, blueprint := range secretObject.Data {
+		blueprints[name] = string(blueprint)
+	}
+	return blueprints, nil
+}
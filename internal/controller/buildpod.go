@@ -0,0 +1,160 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// stepImageDefaults are the default references of the helper images
+var stepImageDefaults = map[osbuildv1alpha1.StepImage]string{
+	osbuildv1alpha1.StepImageUBI:         ubiImage,
+	osbuildv1alpha1.StepImageComposerCLI: utilsImage,
+	osbuildv1alpha1.StepImageOras:        orasImage,
+	osbuildv1alpha1.StepImageAWSCLI:      awsCLIImage,
+	osbuildv1alpha1.StepImageOSTree:      ostreeImage,
+	osbuildv1alpha1.StepImageNginx:       nginxImage,
+	osbuildv1alpha1.StepImageLorax:       loraxImage,
+	osbuildv1alpha1.StepImagePython:      pythonImage,
+}
+
+// relatedImageEnv is the environment variable replacing the reference of a
+// helper image, following the RELATED_IMAGE_ convention used to mirror the
+// images of operators, e.g. RELATED_IMAGE_COMPOSER_CLI
+func relatedImageEnv(name osbuildv1alpha1.StepImage) string {
+	return "RELATED_IMAGE_" + strings.ToUpper(strings.ReplaceAll(string(name), "-", "_"))
+}
+
+// LoadBuildPodDefaults reads the default settings of the build pods from a
+// JSON file, when path is set, then takes the helper images the file does not
+// set from their RELATED_IMAGE_ environment variables
+func LoadBuildPodDefaults(path string) (*osbuildv1alpha1.BuildPodSettings, error) {
+	settings := osbuildv1alpha1.BuildPodSettings{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for name := range settings.StepImages {
+			if _, ok := stepImageDefaults[name]; !ok {
+				return nil, fmt.Errorf("%s: unknown step image %q", path, name)
+			}
+		}
+	}
+	for _, name := range osbuildv1alpha1.StepImages {
+		if reference := os.Getenv(relatedImageEnv(name)); reference != "" && settings.StepImages[name] == "" {
+			if settings.StepImages == nil {
+				settings.StepImages = map[osbuildv1alpha1.StepImage]string{}
+			}
+			settings.StepImages[name] = reference
+		}
+	}
+	return &settings, nil
+}
+
+// buildPod merges the build pod settings of an image with the defaults of
+// the operator
+func (r *ImageBuilderImageReconciler) buildPod(image *osbuildv1alpha1.ImageBuilderImage) osbuildv1alpha1.BuildPodSettings {
+	merged := osbuildv1alpha1.BuildPodSettings{
+		StepImages: map[osbuildv1alpha1.StepImage]string{},
+	}
+	for _, settings := range []*osbuildv1alpha1.BuildPodSettings{r.BuildPod, image.Spec.BuildPod} {
+		if settings == nil {
+			continue
+		}
+		for name, reference := range settings.StepImages {
+			merged.StepImages[name] = reference
+		}
+		for _, secret := range settings.ImagePullSecrets {
+			found := false
+			for _, other := range merged.ImagePullSecrets {
+				found = found || other.Name == secret.Name
+			}
+			if !found {
+				merged.ImagePullSecrets = append(merged.ImagePullSecrets, secret)
+			}
+		}
+		if settings.Resources != nil {
+			merged.Resources = settings.Resources
+		}
+		merged.NodeSelector = mergeMaps(merged.NodeSelector, settings.NodeSelector)
+		merged.Tolerations = append(merged.Tolerations, settings.Tolerations...)
+	}
+	return merged
+}
+
+// stepImages resolves the helper images of an image, replaced by its build
+// pod settings
+func (r *ImageBuilderImageReconciler) stepImages(image *osbuildv1alpha1.ImageBuilderImage) *ImageResolver {
+	overrides := map[string]string{}
+	for name, reference := range r.buildPod(image).StepImages {
+		overrides[stepImageDefaults[name]] = reference
+	}
+	return r.Images.WithOverrides(overrides)
+}
+
+// buildPodTemplate schedules the build pods of an image on the nodes of the
+// architecture of its builder and of its node selector
+func buildPodTemplate(settings osbuildv1alpha1.BuildPodSettings, arch osbuildv1alpha1.Architecture, affinity *corev1.Affinity) *pod.PodTemplate {
+	nodeSelector := mergeMaps(settings.NodeSelector, architectureSelector(arch))
+	if len(nodeSelector) == 0 {
+		nodeSelector = nil
+	}
+	return &pod.PodTemplate{
+		Affinity:         affinity,
+		NodeSelector:     nodeSelector,
+		Tolerations:      settings.Tolerations,
+		ImagePullSecrets: settings.ImagePullSecrets,
+	}
+}
+
+// setStepResources sets the requests and limits of the steps of a generated
+// task that do not set their own
+func setStepResources(spec *tektonv1.TaskSpec, resources *corev1.ResourceRequirements) {
+	if resources == nil {
+		return
+	}
+	for i := range spec.Steps {
+		step := &spec.Steps[i]
+		if len(step.ComputeResources.Requests) == 0 && len(step.ComputeResources.Limits) == 0 {
+			step.ComputeResources = *resources.DeepCopy()
+		}
+	}
+}
+
+// setPipelineResources sets the requests and limits of the tasks embedded in
+// a generated pipeline, referenced tasks being set with setStepResources
+func setPipelineResources(pipeline *tektonv1.Pipeline, resources *corev1.ResourceRequirements) {
+	for _, tasks := range [][]tektonv1.PipelineTask{pipeline.Spec.Tasks, pipeline.Spec.Finally} {
+		for i := range tasks {
+			if tasks[i].TaskSpec != nil {
+				setStepResources(&tasks[i].TaskSpec.TaskSpec, resources)
+			}
+		}
+	}
+}
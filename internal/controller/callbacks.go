@@ -0,0 +1,388 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Headers of the callback requests
+const (
+	callbackEventHeader     = "X-Osbuild-Event"
+	callbackSignatureHeader = "X-Osbuild-Signature-256"
+	callbackDeliveryHeader  = "X-Osbuild-Delivery"
+)
+
+// callbackSigningKey is the key of the signing Secret holding the HMAC key
+const callbackSigningKey = "key"
+
+// callbackRetryInterval is how often failed deliveries are retried
+const callbackRetryInterval = 30 * time.Second
+
+// sinkClient sends the events to the sinks configured on the operator, the
+// callbacks of the images using the client of their DestinationFilter
+var sinkClient = &http.Client{Timeout: deliveryTimeout}
+
+// cloudEventTypePrefix prefixes the type of the CloudEvents, e.g.
+// io.rh-ecosystem-edge.osbuild.build.succeeded
+const cloudEventTypePrefix = "io.rh-ecosystem-edge.osbuild.build."
+
+// buildEvents are the transitions of a build in the order they happen
+var buildEvents = []osbuildv1alpha1.BuildEvent{
+	osbuildv1alpha1.BuildEventQueued,
+	osbuildv1alpha1.BuildEventStarted,
+	osbuildv1alpha1.BuildEventSucceeded,
+	osbuildv1alpha1.BuildEventFailed,
+}
+
+// callbackPayload is the JSON body POSTed to the callbacks, and the data of
+// the CloudEvents
+type callbackPayload struct {
+	Event        osbuildv1alpha1.BuildEvent      `json:"event"`
+	Namespace    string                          `json:"namespace"`
+	Name         string                          `json:"name"`
+	UID          string                          `json:"uid"`
+	Generation   int64                           `json:"generation"`
+	PipelineRun  string                          `json:"pipelineRun"`
+	Reason       string                          `json:"reason,omitempty"`
+	Message      string                          `json:"message,omitempty"`
+	Time         metav1.Time                     `json:"time"`
+	ComposeType  osbuildv1alpha1.ComposeType     `json:"composeType,omitempty"`
+	Duration     string                          `json:"duration,omitempty"`
+	ArtifactsURL string                          `json:"artifactsURL,omitempty"`
+	Artifacts    []osbuildv1alpha1.BuildArtifact `json:"artifacts,omitempty"`
+}
+
+// buildEvent is the state of the build run by a PipelineRun
+func buildEvent(pipelineRun *tektonv1.PipelineRun) osbuildv1alpha1.BuildEvent {
+	switch {
+	case pipelineRun.IsPending():
+		return osbuildv1alpha1.BuildEventQueued
+	case !pipelineRun.IsDone():
+		return osbuildv1alpha1.BuildEventStarted
+	case pipelineRun.Status.GetCondition(apis.ConditionSucceeded).IsTrue():
+		return osbuildv1alpha1.BuildEventSucceeded
+	}
+	return osbuildv1alpha1.BuildEventFailed
+}
+
+// pendingEvents are the wanted events of the build not delivered yet,
+// transitions happening between two reconciles being delivered in order. All
+// events are wanted when wanted is empty.
+func pendingEvents(wanted []osbuildv1alpha1.BuildEvent, delivered osbuildv1alpha1.CallbackStatus, generation int64, current osbuildv1alpha1.BuildEvent) []osbuildv1alpha1.BuildEvent {
+	if delivered.Generation == generation && delivered.Event == current {
+		return nil
+	}
+	filter := map[osbuildv1alpha1.BuildEvent]bool{}
+	for _, event := range wanted {
+		filter[event] = true
+	}
+	last := -1
+	if delivered.Generation == generation {
+		for i, event := range buildEvents {
+			if event == delivered.Event {
+				last = i
+			}
+		}
+	}
+	events := []osbuildv1alpha1.BuildEvent{}
+	for _, event := range buildEvents[last+1:] {
+		// a build either succeeds or fails
+		terminal := event == osbuildv1alpha1.BuildEventSucceeded || event == osbuildv1alpha1.BuildEventFailed
+		if terminal && event != current {
+			continue
+		}
+		if len(filter) == 0 || filter[event] {
+			events = append(events, event)
+		}
+		if event == current {
+			break
+		}
+	}
+	return events
+}
+
+// deliveryID identifies the delivery of an event of a build, the same for
+// every attempt for the receiver to drop duplicates
+func deliveryID(payload callbackPayload) string {
+	return fmt.Sprintf("%s-%d-%s", payload.UID, payload.Generation, strings.ToLower(string(payload.Event)))
+}
+
+// deliverEvents delivers events in order, recording the last one delivered
+// in status. deliver tells if the delivery of an event is done, it stops at
+// the first one still being sent or failed.
+func deliverEvents(status *osbuildv1alpha1.CallbackStatus, events []osbuildv1alpha1.BuildEvent, generation int64, deliver func(osbuildv1alpha1.BuildEvent) (bool, error)) error {
+	for _, event := range events {
+		done, err := deliver(event)
+		if err != nil {
+			status.Message = fmt.Sprintf("Could not deliver %s event: %s", event, err)
+			return err
+		}
+		if !done {
+			return nil
+		}
+		now := metav1.Now()
+		status.Event = event
+		status.Generation = generation
+		status.LastDeliveryTime = &now
+		status.Message = ""
+	}
+	return nil
+}
+
+// notifyBuildEvents queues the transitions of the current build for their
+// delivery to the callbacks of the image and to the sinks, it tells if a
+// delivery failed and must be retried. The image is reconciled again once a
+// queued delivery is done.
+func (r *ImageBuilderImageReconciler) notifyBuildEvents(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, pipelineRun *tektonv1.PipelineRun) bool {
+	generation, err := strconv.ParseInt(pipelineRun.Labels[imageBuilderImageGenerationLabel], 10, 64)
+	if err != nil {
+		generation = image.Generation
+	}
+	current := buildEvent(pipelineRun)
+	retry := false
+	failed := func(name string, status osbuildv1alpha1.CallbackStatus) {
+		r.Recorder.Event(image, corev1.EventTypeWarning, osbuildv1alpha1.EventCallbackFailed,
+			eventMessage(fmt.Sprintf("%s: %s", name, status.Message)))
+		retry = true
+	}
+
+	namespace := image.Namespace
+	delivered := map[string]osbuildv1alpha1.CallbackStatus{}
+	for _, status := range image.Status.Callbacks {
+		delivered[status.Name] = status
+	}
+	statuses := []osbuildv1alpha1.CallbackStatus{}
+	for _, callback := range image.Spec.Callbacks {
+		callback := callback
+		status := delivered[callback.Name]
+		status.Name = callback.Name
+		events := pendingEvents(callback.Events, status, generation, current)
+		if err := deliverEvents(&status, events, generation, func(event osbuildv1alpha1.BuildEvent) (bool, error) {
+			payload := buildPayload(image, pipelineRun, generation, event)
+			return r.Deliveries.Send("callback/"+callback.Name+"/"+deliveryID(payload), client.ObjectKeyFromObject(image), func(ctx context.Context) error {
+				return r.deliverCallback(ctx, namespace, callback, payload)
+			})
+		}); err != nil {
+			failed("Callback "+callback.Name, status)
+		}
+		statuses = append(statuses, status)
+	}
+	image.Status.Callbacks = nil
+	if len(statuses) > 0 {
+		image.Status.Callbacks = statuses
+	}
+
+	// the operator-wide sinks receive every event
+	notifySink := func(name string, delivered **osbuildv1alpha1.CallbackStatus, deliver func(context.Context, callbackPayload) error) {
+		if name == "" {
+			*delivered = nil
+			return
+		}
+		status := osbuildv1alpha1.CallbackStatus{Name: name}
+		if *delivered != nil && (*delivered).Name == name {
+			status = **delivered
+		}
+		events := pendingEvents(nil, status, generation, current)
+		if err := deliverEvents(&status, events, generation, func(event osbuildv1alpha1.BuildEvent) (bool, error) {
+			payload := buildPayload(image, pipelineRun, generation, event)
+			return r.Deliveries.Send("sink/"+name+"/"+deliveryID(payload), client.ObjectKeyFromObject(image), func(ctx context.Context) error {
+				return deliver(ctx, payload)
+			})
+		}); err != nil {
+			failed(name, status)
+		}
+		*delivered = &status
+	}
+	notifySink(r.CloudEventsSink, &image.Status.CloudEvents, r.deliverCloudEvent)
+	kafkaName := ""
+	if r.Kafka != nil {
+		kafkaName = r.Kafka.String()
+	}
+	notifySink(kafkaName, &image.Status.Kafka, func(ctx context.Context, payload callbackPayload) error {
+		return r.Kafka.Publish(ctx, payload)
+	})
+	return retry
+}
+
+// buildPayload describes a build event with the Ready condition and the
+// artifacts of the image
+func buildPayload(image *osbuildv1alpha1.ImageBuilderImage, pipelineRun *tektonv1.PipelineRun, generation int64, event osbuildv1alpha1.BuildEvent) callbackPayload {
+	payload := callbackPayload{
+		Event:       event,
+		Namespace:   image.Namespace,
+		Name:        image.Name,
+		UID:         string(image.UID),
+		Generation:  generation,
+		PipelineRun: pipelineRun.Name,
+		Time:        metav1.Now(),
+	}
+	if ready := meta.FindStatusCondition(image.Status.Conditions, osbuildv1alpha1.ConditionReady); ready != nil {
+		payload.Reason = ready.Reason
+		payload.Message = ready.Message
+	}
+	for _, entry := range image.Status.History {
+		if entry.Build == pipelineRun.Name {
+			payload.ComposeType = entry.ComposeType
+			break
+		}
+	}
+	if start, end := pipelineRun.Status.StartTime, pipelineRun.Status.CompletionTime; start != nil && end != nil {
+		payload.Duration = end.Sub(start.Time).Round(time.Second).String()
+	}
+	if event == osbuildv1alpha1.BuildEventSucceeded {
+		payload.ArtifactsURL = image.Status.ArtifactsURL
+		payload.Artifacts = image.Status.Artifacts
+	}
+	return payload
+}
+
+// slackMessage summarizes a build event in the body of a Slack message
+func slackMessage(payload callbackPayload) ([]byte, error) {
+	text := fmt.Sprintf("Build %s of ImageBuilderImage %s/%s, generation %d", strings.ToLower(string(payload.Event)),
+		payload.Namespace, payload.Name, payload.Generation)
+	if payload.ComposeType != "" {
+		text += fmt.Sprintf(", %s", payload.ComposeType)
+	}
+	if payload.Duration != "" {
+		text += fmt.Sprintf(", in %s", payload.Duration)
+	}
+	switch {
+	case payload.Event == osbuildv1alpha1.BuildEventFailed:
+		text += fmt.Sprintf("\n%s: %s", payload.Reason, payload.Message)
+	case payload.ArtifactsURL != "":
+		text += fmt.Sprintf("\nArtifacts: %s", payload.ArtifactsURL)
+	}
+	return json.Marshal(map[string]string{"text": text})
+}
+
+// deliverCallback POSTs an event to a callback, only connecting to the
+// addresses permitted by the CallbackFilter
+func (r *ImageBuilderImageReconciler) deliverCallback(ctx context.Context, namespace string, callback osbuildv1alpha1.BuildCallback, payload callbackPayload) error {
+	var body []byte
+	var err error
+	if callback.Format == osbuildv1alpha1.CallbackFormatSlack {
+		body, err = slackMessage(payload)
+	} else {
+		body, err = json.Marshal(payload)
+	}
+	if err != nil {
+		return err
+	}
+	url := callback.URL
+	if ref := callback.URLSecret; ref != nil {
+		secret := corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, &secret); err != nil {
+			return err
+		}
+		value, ok := secret.Data[ref.Key]
+		if !ok {
+			return fmt.Errorf("secret %s has no %s key", secret.Name, ref.Key)
+		}
+		url = strings.TrimSpace(string(value))
+		// the URL of the Secret is not validated by the webhook
+		if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+			return fmt.Errorf("the %s key of secret %s is not an http:// or https:// URL", ref.Key, secret.Name)
+		}
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if callback.HeadersSecret != "" {
+		secret := corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: callback.HeadersSecret}, &secret); err != nil {
+			return err
+		}
+		for name, value := range secret.Data {
+			request.Header.Set(name, string(value))
+		}
+	}
+	if callback.SigningSecret != "" {
+		secret := corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: callback.SigningSecret}, &secret); err != nil {
+			return err
+		}
+		key, ok := secret.Data[callbackSigningKey]
+		if !ok {
+			return fmt.Errorf("secret %s has no %s key", secret.Name, callbackSigningKey)
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(body)
+		request.Header.Set(callbackSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(callbackEventHeader, string(payload.Event))
+	request.Header.Set(callbackDeliveryHeader, deliveryID(payload))
+	return post(r.CallbackFilter.Client(), request)
+}
+
+// deliverCloudEvent sends an event to the CloudEvents sink in the structured
+// content mode of the HTTP binding. The ID of the event is the same for every
+// delivery of the transition, for the sink to drop duplicates.
+func (r *ImageBuilderImageReconciler) deliverCloudEvent(ctx context.Context, payload callbackPayload) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              deliveryID(payload),
+		"source":          fmt.Sprintf("/apis/%s/namespaces/%s/imagebuilderimages/%s", osbuildv1alpha1.GroupVersion, payload.Namespace, payload.Name),
+		"type":            cloudEventTypePrefix + strings.ToLower(string(payload.Event)),
+		"subject":         payload.PipelineRun,
+		"time":            payload.Time.UTC().Format(time.RFC3339),
+		"datacontenttype": "application/json",
+		"data":            payload,
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.CloudEventsSink, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/cloudevents+json")
+	return post(sinkClient, request)
+}
+
+// post sends a request, failing on non 2xx statuses
+func post(httpClient *http.Client, request *http.Request) error {
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s returned %d", request.URL, response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// pythonImage converts the TOML blueprints to JSON with tomllib, for the
+// compose requests of the Cloud API
+const pythonImage = "registry.access.redhat.com/ubi9/python-311:1-25"
+
+// cloudComposeRequestStepName is the step writing the compose request of the
+// Cloud API
+const cloudComposeRequestStepName = "compose-request"
+
+// cloudComposeRequestScript completes the compose request set by the
+// operator with the JSON form of the blueprint and its distribution. The
+// installer is built from the edge commit served by the sidecar of its task
+// on the pod IP.
+const cloudComposeRequestScript = `#!/usr/bin/env python3
+import json
+import os
+import tomllib
+
+directory = "/workspace/shared-volume/$(params.blueprintName)"
+request = json.loads(os.environ["request"])
+with open(os.path.join(directory, "blueprints", os.environ["blueprint"]), "rb") as blueprint_file:
+    blueprint = tomllib.load(blueprint_file)
+if not blueprint.get("distro"):
+    blueprint.pop("distro", None)
+request["distribution"] = blueprint.get("distro") or os.environ["distribution"]
+request["blueprint"] = blueprint
+if os.environ.get("POD_IP"):
+    ref = ""
+    try:
+        with open(os.path.join(directory, "commit.json")) as commit_file:
+            ref = json.load(commit_file).get("ref", "")
+    except (OSError, ValueError):
+        pass
+    request["image_request"]["ostree"] = {"ref": ref or "rhel/9/x86_64/edge", "url": "http://%s:8000/repo" % os.environ["POD_IP"]}
+with open(os.path.join(directory, os.environ["request_file"]), "w") as request_file:
+    json.dump(request, request_file)
+print("Requesting a %s %s compose of blueprint %s" % (request["distribution"], request["image_request"]["image_type"], blueprint.get("name", "")))
+`
+
+// cloudStartComposeCommand posts the compose request, removed once sent as
+// the blueprint may hold credentials
+const cloudStartComposeCommand = `/usr/bin/curl --silent -H "Content-Type: application/json" --data-binary "@${dir}/${request_file}" "$(params.apiEndpoint)/compose" --output "${dir}/${compose_file}"; status=$?; rm -f "${dir}/${request_file}"; exit ${status}`
+
+// cloudWaitScript follows a compose of the Cloud API until it is done, like
+// waitScriptTemplate. The ID of the compose is added to the compose file as
+// build_id, where the weldr API returns it, for the steps downloading and
+// describing the artifacts.
+const cloudWaitScript = `#!/bin/bash
+file="/workspace/shared-volume/$(params.blueprintName)/${compose_file}"
+compose_id=$(jq -r 'select(.kind == "ComposeId") | .id' "${file}")
+if [ -z "${compose_id}" ]; then
+  echo "Compose was not started: $(jq -c '.reason // .' "${file}")"
+  exit 1
+fi
+jq --arg id "${compose_id}" '. + {build_id: $id}' "${file}" > "${file}.tmp" && mv -f "${file}.tmp" "${file}"
+unknown=0
+while true; do
+  response=$(/usr/bin/curl --silent "${api}/composes/${compose_id}")
+  status=$(echo "${response}" | jq -r '.image_status.status // "unknown"' 2>/dev/null)
+  case "${status}" in
+  pending|building|uploading|registering)
+    unknown=0
+    ;;
+  success)
+    echo "${response}"
+    exit 0
+    ;;
+  failure)
+    echo "Compose ${compose_id} failed: ${response}"
+    echo "${response}" | jq -c '.image_status.error // {}' | grep -qi depsolve && exit 2
+    exit 1
+    ;;
+  *)
+    unknown=$((unknown + 1))
+    echo "Compose ${compose_id} is in state ${status:-unknown}"
+    if [ "${unknown}" -ge "${status_checks:-10}" ]; then
+      echo "Compose ${compose_id} is unknown to composer"
+      exit 1
+    fi
+    ;;
+  esac
+  delay=${poll_interval:-30}
+  for ((i = 0; i < unknown && delay < 300; i++)); do
+    delay=$((delay * 2))
+  done
+  sleep $((delay < 300 ? delay : 300))
+done
+`
+
+// cloudImageTypes are the image types of the Cloud API named differently
+// from the compose types of the weldr API
+var cloudImageTypes = map[osbuildv1alpha1.ComposeType]string{
+	osbuildv1alpha1.ComposeQcow2: "guest-image",
+	osbuildv1alpha1.ComposeAMI:   "aws",
+	osbuildv1alpha1.ComposeVHD:   "azure",
+	osbuildv1alpha1.ComposeGCE:   "gcp",
+	osbuildv1alpha1.ComposeVMDK:  "vsphere",
+}
+
+// cloudImageType is the image type of the Cloud API building a compose type
+func cloudImageType(composeType osbuildv1alpha1.ComposeType) string {
+	if imageType, ok := cloudImageTypes[composeType]; ok {
+		return imageType
+	}
+	return string(composeType)
+}
+
+// builderAPIFlavor is the composer API a builder is used with, weldr by
+// default
+func builderAPIFlavor(builder *osbuildv1alpha1.ImageBuilder) osbuildv1alpha1.APIFlavor {
+	if builder.Spec.APIFlavor == "" {
+		return osbuildv1alpha1.APIFlavorWeldr
+	}
+	return builder.Spec.APIFlavor
+}
+
+// cloudArchitecture is the architecture of the images of a builder, named
+// like composer, x86_64 while the architecture of the builder is not known
+func cloudArchitecture(builder *osbuildv1alpha1.ImageBuilder) string {
+	switch builderArchitecture(builder) {
+	case osbuildv1alpha1.ArchitectureARM64:
+		return string(osbuildv1alpha1.ImageArchitectureAArch64)
+	case osbuildv1alpha1.ArchitectureS390X:
+		return string(osbuildv1alpha1.ImageArchitectureS390X)
+	}
+	return string(osbuildv1alpha1.ImageArchitectureX86_64)
+}
+
+// cloudRepository is the repository of the Cloud API of a source
+func cloudRepository(source *osbuildv1alpha1.ImageBuilderSource) composer.CloudRepository {
+	repository := composer.CloudRepository{
+		CheckGPG:  source.Spec.CheckGPG,
+		GPGKey:    strings.Join(source.Spec.GPGKeys, "\n"),
+		IgnoreSSL: !pointer.BoolDeref(source.Spec.CheckSSL, true),
+	}
+	switch source.Spec.Type {
+	case osbuildv1alpha1.SourceMirrorlist:
+		repository.Mirrorlist = source.Spec.URL
+	case osbuildv1alpha1.SourceMetalink:
+		repository.Metalink = source.Spec.URL
+	default:
+		repository.BaseURL = source.Spec.URL
+	}
+	return repository
+}
+
+// cloudRepositories are the repositories of the sources of an image, sent
+// with its compose requests. The sources that do not exist yet are left out,
+// the build waiting for them.
+func (r *ImageBuilderImageReconciler) cloudRepositories(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage) ([]composer.CloudRepository, error) {
+	repositories := []composer.CloudRepository{}
+	for _, name := range image.Spec.Repositories {
+		source := osbuildv1alpha1.ImageBuilderSource{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: image.Namespace, Name: name}, &source); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		repositories = append(repositories, cloudRepository(&source))
+	}
+	return repositories, nil
+}
+
+// setCloudCompose replaces the steps of a generated task composing with the
+// weldr API by the ones of the Cloud API: compose-request writes the
+// request of the blueprint, start-compose posts it and wait-for-finish
+// follows the compose. The compose-json step of the installer is replaced by
+// compose-request, which reads the pod IP. The image is kept in composer
+// and uploaded to uploads.
+func (r *imageReconcile) setCloudCompose(task *tektonv1.Task, builder *osbuildv1alpha1.ImageBuilder, blueprint string, imageType string, ostree *composer.OSTreeOptions, composeFile string, uploads []composer.CloudUploadTarget) {
+	request, _ := json.Marshal(composer.CloudComposeRequest{
+		Distribution: builder.Spec.Distribution,
+		ImageRequest: composer.CloudImageRequest{
+			Architecture:  cloudArchitecture(builder),
+			ImageType:     imageType,
+			Repositories:  r.composeRepositories,
+			OSTree:        ostree,
+			UploadTargets: append(composer.LocalUpload(), uploads...),
+		},
+	})
+	requestFile := strings.TrimSuffix(composeFile, ".json") + "-request.json"
+	env := []corev1.EnvVar{
+		{Name: "dir", Value: "/workspace/shared-volume/$(params.blueprintName)"},
+		{Name: "request_file", Value: requestFile},
+		{Name: "compose_file", Value: composeFile},
+	}
+	steps := []tektonv1.Step{}
+	for _, step := range task.Spec.Steps {
+		switch step.Name {
+		case "compose-json":
+			continue
+		case "start-compose":
+			requestEnv := append([]corev1.EnvVar{
+				{Name: "request", Value: string(request)},
+				{Name: "blueprint", Value: blueprint},
+				{Name: "distribution", Value: builder.Spec.Distribution},
+			}, env...)
+			// the sidecar serves the commit the installer is built from
+			if len(task.Spec.Sidecars) > 0 {
+				requestEnv = append(requestEnv, corev1.EnvVar{
+					Name: "POD_IP",
+					ValueFrom: &corev1.EnvVarSource{
+						FieldRef: &corev1.ObjectFieldSelector{
+							FieldPath: "status.podIP",
+						},
+					},
+				})
+			}
+			steps = append(steps, tektonv1.Step{
+				Name:   cloudComposeRequestStepName,
+				Image:  pythonImage,
+				Script: cloudComposeRequestScript,
+				Env:    requestEnv,
+			})
+			step.Command = []string{"/bin/bash", "-c", cloudStartComposeCommand}
+			// the variables of the generated step, e.g. the composer
+			// endpoint and its credentials, are kept
+			step.Env = append(step.Env, env...)
+		case "wait-for-finish":
+			step.Script = cloudWaitScript
+		}
+		steps = append(steps, step)
+	}
+	task.Spec.Steps = steps
+}
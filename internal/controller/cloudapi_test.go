@@ -0,0 +1,173 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	"github.com/kwozyman/osbuild-operator/pkg/composertest"
+)
+
+var _ = Describe("Cloud API", func() {
+	ctx := context.Background()
+	var composerServer *composertest.Server
+	var composerClient *composer.Client
+
+	BeforeEach(func() {
+		composerServer = composertest.NewServer()
+		composerClient = composer.NewClient(composerServer.URL + composer.CloudAPIPath)
+	})
+
+	AfterEach(func() {
+		composerServer.Close()
+	})
+
+	It("follows a compose uploaded to a cloud", func() {
+		Expect(composerClient.Cloud).To(BeTrue())
+		options, err := json.Marshal(composer.CloudUploadOptions{Region: "us-east-1", ImageName: "edge"})
+		Expect(err).NotTo(HaveOccurred())
+		id, err := composerClient.CloudCompose(ctx, composer.CloudComposeRequest{
+			Distribution: "rhel-92",
+			ImageRequest: composer.CloudImageRequest{
+				Architecture:  string(osbuildv1alpha1.ImageArchitectureX86_64),
+				ImageType:     "ami",
+				UploadTargets: append(composer.LocalUpload(), composer.CloudUploadTarget{Type: composer.ProviderAWS, UploadOptions: options}),
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, err := composerClient.ComposeStatus(ctx, id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.QueueStatus).To(Equal(composer.StatusWaiting))
+
+		composerServer.Advance()
+		composerServer.Advance()
+		status, err = composerClient.ComposeStatus(ctx, id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.QueueStatus).To(Equal(composer.StatusFinished))
+
+		uploads, err := composerClient.Uploads(ctx, id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uploads).To(HaveLen(1))
+		Expect(uploads[0].Provider).To(Equal(composer.ProviderAWS))
+		Expect(uploads[0].Status).To(Equal(composer.StatusFinished))
+		Expect(uploads[0].Region).To(Equal("us-east-1"))
+		Expect(uploads[0].ImageID).To(HavePrefix("ami-"))
+	})
+
+	It("leaves out the weldr requests the Cloud API has no equivalent of", func() {
+		Expect(composerClient.PushBlueprint(ctx, "name = \"edge\"\n")).To(Succeed())
+		_, ok := composerServer.Blueprint("edge")
+		Expect(ok).To(BeFalse())
+
+		blueprints, err := composerClient.Blueprints(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(blueprints).To(BeEmpty())
+
+		Expect(composerClient.Cancel(ctx, "id")).NotTo(Succeed())
+	})
+
+	Context("when the tasks of a build compose with the Cloud API", func() {
+		var reconcile *imageReconcile
+		var builder *osbuildv1alpha1.ImageBuilder
+		var task *tektonv1.Task
+
+		stepNames := func(task *tektonv1.Task) []string {
+			names := []string{}
+			for _, step := range task.Spec.Steps {
+				names = append(names, step.Name)
+			}
+			return names
+		}
+
+		BeforeEach(func() {
+			reconcile = &imageReconcile{ImageBuilderImageReconciler: &ImageBuilderImageReconciler{}}
+			builder = &osbuildv1alpha1.ImageBuilder{
+				Spec: osbuildv1alpha1.ImageBuilderSpec{
+					APIFlavor:    osbuildv1alpha1.APIFlavorCloud,
+					Distribution: "rhel-92",
+					Architecture: osbuildv1alpha1.ArchitectureARM64,
+				},
+			}
+			task = &tektonv1.Task{
+				Spec: tektonv1.TaskSpec{
+					Steps: []tektonv1.Step{
+						{Name: "compose-json", Image: utilsImage},
+						{
+							Name:  "start-compose",
+							Image: utilsImage,
+							Env:   []corev1.EnvVar{{Name: "composer_token_file", Value: "/var/run/composer/token"}},
+						},
+						{Name: "wait-for-finish", Image: utilsImage},
+					},
+				},
+			}
+		})
+
+		It("posts the compose request written by the compose-request step", func() {
+			reconcile.setCloudCompose(task, builder, "edge", "edge-commit", nil, "compose.json", nil)
+
+			Expect(stepNames(task)).To(Equal([]string{cloudComposeRequestStepName, "start-compose", "wait-for-finish"}))
+			request := task.Spec.Steps[0]
+			Expect(request.Image).To(Equal(pythonImage))
+			Expect(request.Image).NotTo(HaveSuffix(":latest"))
+			Expect(request.Env).To(ContainElement(corev1.EnvVar{Name: "blueprint", Value: "edge"}))
+			Expect(request.Env).To(ContainElement(corev1.EnvVar{Name: "request_file", Value: "compose-request.json"}))
+			for _, variable := range request.Env {
+				if variable.Name != "request" {
+					continue
+				}
+				composeRequest := composer.CloudComposeRequest{}
+				Expect(json.NewDecoder(strings.NewReader(variable.Value)).Decode(&composeRequest)).To(Succeed())
+				Expect(composeRequest.Distribution).To(Equal("rhel-92"))
+				Expect(composeRequest.ImageRequest.Architecture).To(Equal("aarch64"))
+				Expect(composeRequest.ImageRequest.ImageType).To(Equal("edge-commit"))
+				Expect(composeRequest.ImageRequest.UploadTargets).To(HaveLen(1))
+				Expect(composeRequest.ImageRequest.UploadTargets[0].Type).To(Equal(composer.LocalUploadTarget))
+			}
+
+			start := task.Spec.Steps[1]
+			Expect(start.Command).To(Equal([]string{"/bin/bash", "-c", cloudStartComposeCommand}))
+			// the variables of the generated step are kept
+			Expect(start.Env).To(ContainElement(corev1.EnvVar{Name: "composer_token_file", Value: "/var/run/composer/token"}))
+			Expect(start.Env).To(ContainElement(corev1.EnvVar{Name: "compose_file", Value: "compose.json"}))
+			Expect(task.Spec.Steps[2].Script).To(Equal(cloudWaitScript))
+		})
+
+		It("reads the pod IP when a sidecar serves the commit of the installer", func() {
+			task.Spec.Sidecars = []tektonv1.Sidecar{{Name: "serve-commit", Image: utilsImage}}
+
+			reconcile.setCloudCompose(task, builder, "edge-installer", "edge-simplified-installer", nil, "compose.json", nil)
+
+			names := []string{}
+			for _, variable := range task.Spec.Steps[0].Env {
+				names = append(names, variable.Name)
+			}
+			Expect(names).To(ContainElement("POD_IP"))
+		})
+	})
+})
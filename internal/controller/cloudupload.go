@@ -0,0 +1,193 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// weldrUploadComposeCommand starts a compose of the weldr API uploading its
+// image, the credentials of the cloud being added to the settings of the
+// upload from the environment so they are never stored in the Task nor on
+// the shared volume
+const weldrUploadComposeCommand = `set -o pipefail
+jq -n --argjson request "${request}" \
+  --arg accessKeyID "${AWS_ACCESS_KEY_ID:-}" --arg secretAccessKey "${AWS_SECRET_ACCESS_KEY:-}" --arg sessionToken "${AWS_SESSION_TOKEN:-}" \
+  --arg storageAccessKey "${AZURE_STORAGE_ACCESS_KEY:-}" \
+  --arg credentials "$(printf '%s' "${GOOGLE_APPLICATION_CREDENTIALS:-}" | base64 -w0)" \
+  '$request | .upload.settings += ({$accessKeyID, $secretAccessKey, $sessionToken, $storageAccessKey, $credentials} | with_entries(select(.value != "")))' |
+  /usr/bin/curl --silent -H "Content-Type: application/json" --data-binary @- "$(params.apiEndpoint)/compose" --output "/workspace/shared-volume/$(params.blueprintName)/compose.json"`
+
+// uploadImageName is the name of the image uploaded by spec.upload,
+// <image>-<generation> by default
+func uploadImageName(image *osbuildv1alpha1.ImageBuilderImage) string {
+	if image.Spec.Upload.ImageName != "" {
+		return image.Spec.Upload.ImageName
+	}
+	return fmt.Sprintf("%s-%d", image.Name, image.Generation)
+}
+
+// weldrUpload is the upload of the compose requests of the weldr API,
+// without the credentials of the cloud
+func weldrUpload(image *osbuildv1alpha1.ImageBuilderImage) *composer.Upload {
+	upload := image.Spec.Upload
+	imageName := uploadImageName(image)
+	switch {
+	case upload.AWS != nil:
+		return &composer.Upload{
+			ImageName: imageName,
+			Provider:  composer.ProviderAWS,
+			Settings:  map[string]string{"region": upload.AWS.Region, "bucket": upload.AWS.Bucket},
+		}
+	case upload.Azure != nil:
+		return &composer.Upload{
+			ImageName: imageName,
+			Provider:  composer.ProviderAzure,
+			Settings:  map[string]string{"storageAccount": upload.Azure.StorageAccount, "container": upload.Azure.Container},
+		}
+	}
+	return &composer.Upload{
+		ImageName: imageName,
+		Provider:  composer.ProviderGCP,
+		Settings:  map[string]string{"region": upload.GCP.Region, "bucket": upload.GCP.Bucket, "object": imageName + ".tar.gz"},
+	}
+}
+
+// cloudUploadTargets are the upload targets of the compose requests of the
+// Cloud API uploading to the cloud of spec.upload, none without it
+func cloudUploadTargets(image *osbuildv1alpha1.ImageBuilderImage) []composer.CloudUploadTarget {
+	upload := image.Spec.Upload
+	if upload == nil {
+		return nil
+	}
+	imageName := uploadImageName(image)
+	target := composer.CloudUploadTarget{}
+	options := composer.CloudUploadOptions{}
+	switch {
+	case upload.AWS != nil:
+		target.Type = composer.ProviderAWS
+		options = composer.CloudUploadOptions{
+			Region:            upload.AWS.Region,
+			ShareWithAccounts: upload.AWS.ShareWithAccounts,
+			SnapshotName:      imageName,
+		}
+	case upload.Azure != nil:
+		target.Type = composer.ProviderAzure
+		options = composer.CloudUploadOptions{
+			TenantID:       upload.Azure.TenantID,
+			SubscriptionID: upload.Azure.SubscriptionID,
+			ResourceGroup:  upload.Azure.ResourceGroup,
+			Location:       upload.Azure.Location,
+			ImageName:      imageName,
+		}
+	default:
+		target.Type = composer.ProviderGCP
+		options = composer.CloudUploadOptions{
+			Region:            upload.GCP.Region,
+			Bucket:            upload.GCP.Bucket,
+			ShareWithAccounts: upload.GCP.ShareWithAccounts,
+			ImageName:         imageName,
+		}
+	}
+	target.UploadOptions, _ = json.Marshal(options)
+	return []composer.CloudUploadTarget{target}
+}
+
+// setWeldrUpload makes the start-compose step of the task composing the
+// image upload it to the cloud of spec.upload with the weldr API
+func (r *imageReconcile) setWeldrUpload(task *tektonv1.Task, image *osbuildv1alpha1.ImageBuilderImage) {
+	request, _ := json.Marshal(composer.ComposeRequest{
+		BlueprintName: "$(params.blueprintName)",
+		ComposeType:   string(r.composeType),
+		OSTree:        r.ostree,
+		Upload:        weldrUpload(image),
+	})
+	for i := range task.Spec.Steps {
+		step := &task.Spec.Steps[i]
+		if step.Name != "start-compose" {
+			continue
+		}
+		step.Image = utilsImage
+		step.Command = []string{"/bin/bash", "-c", weldrUploadComposeCommand}
+		step.Env = append(step.Env, corev1.EnvVar{Name: "request", Value: string(request)})
+		if secret := image.Spec.Upload.CredentialsSecret; secret != "" {
+			step.EnvFrom = []corev1.EnvFromSource{
+				{
+					SecretRef: &corev1.SecretEnvSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+					},
+				},
+			}
+		}
+	}
+}
+
+// setCloudImage records the upload of the image of the last successful
+// build to the cloud of spec.upload, returning true while composer did not
+// finish it. The compose is the one of the image artifact, or the finished
+// compose of the compose type.
+func (r *ImageBuilderImageReconciler) setCloudImage(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, composerClient *composer.Client) (bool, error) {
+	if image.Spec.Upload == nil {
+		image.Status.CloudImage = nil
+		return false, nil
+	}
+	if !meta.IsStatusConditionTrue(image.Status.Conditions, osbuildv1alpha1.ConditionReady) {
+		return false, nil
+	}
+	id := ""
+	for _, compose := range image.Status.Composes {
+		if compose.ComposeType == string(imageComposeType(image)) && compose.QueueStatus == composeFinished {
+			id = compose.ID
+		}
+	}
+	for _, artifact := range image.Status.Artifacts {
+		if artifact.Type == osbuildv1alpha1.ArtifactImage && artifact.ComposeID != "" {
+			id = artifact.ComposeID
+		}
+	}
+	if id == "" {
+		return false, nil
+	}
+	if current := image.Status.CloudImage; current != nil && current.ComposeID == id &&
+		(current.Status == composer.StatusFinished || current.Status == composer.StatusFailed) {
+		return false, nil
+	}
+	uploads, err := composerClient.Uploads(ctx, id)
+	if err != nil || len(uploads) == 0 {
+		return err != nil, err
+	}
+	upload := uploads[0]
+	image.Status.CloudImage = &osbuildv1alpha1.CloudImage{
+		Provider:   upload.Provider,
+		ComposeID:  id,
+		Generation: image.Status.ArtifactsGeneration,
+		Status:     upload.Status,
+		ImageName:  upload.ImageName,
+		ImageID:    upload.ImageID,
+		Region:     upload.Region,
+		ProjectID:  upload.ProjectID,
+	}
+	return upload.Status != composer.StatusFinished && upload.Status != composer.StatusFailed, nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+)
+
+const clusterImageBuilderLabel = "osbuild-operator-cluster-builder"
+
+// ClusterImageBuilderReconciler reconciles a ClusterImageBuilder object by
+// running a namespaced ImageBuilder in spec.namespace
+type ClusterImageBuilderReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=clusterimagebuilders,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=clusterimagebuilders/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=clusterimagebuilders/finalizers,verbs=update
+
+// Reconcile creates or updates the ImageBuilder backing a ClusterImageBuilder
+func (r *ClusterImageBuilderReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var clusterImageBuilder osbuildv1alpha1.ClusterImageBuilder
+	if err := r.Get(ctx, req.NamespacedName, &clusterImageBuilder); err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Resource not found, must have been deleted")
+			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "ImageBuilder", osbuildv1alpha1.GroupVersion.String(), "", clusterImageBuilderLabel, req.Name); err != nil {
+				logger.Error(err, "Could not delete ImageBuilder")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "Unable to fetch ClusterImageBuilder")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	imageBuilder := osbuildv1alpha1.ImageBuilder{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ImageBuilder",
+			APIVersion: osbuildv1alpha1.GroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clusterImageBuilder.Name,
+			Namespace: clusterImageBuilder.Spec.Namespace,
+			Labels: map[string]string{
+				clusterImageBuilderLabel: clusterImageBuilder.Name,
+			},
+		},
+		Spec: clusterImageBuilder.Spec.ImageBuilderSpec,
+	}
+	existing := osbuildv1alpha1.ImageBuilder{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&imageBuilder), &existing); err == nil {
+		if existing.Labels[clusterImageBuilderLabel] != clusterImageBuilder.Name {
+			err := fmt.Errorf("ImageBuilder %s/%s already exists and does not belong to this ClusterImageBuilder", existing.Namespace, existing.Name)
+			logger.Error(err, "Could not create ImageBuilder")
+			return ctrl.Result{}, nil
+		}
+		imageBuilder.ResourceVersion = existing.ResourceVersion
+	} else if !errors.IsNotFound(err) {
+		logger.Error(err, "Could not get ImageBuilder")
+		return ctrl.Result{}, err
+	}
+	if err := CreateOrUpdateObject(ctx, r.Client, &imageBuilder); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	clusterImageBuilder.Status.ImageBuilder = fmt.Sprintf("%s/%s", imageBuilder.Namespace, imageBuilder.Name)
+	if err := r.Status().Update(ctx, &clusterImageBuilder); err != nil {
+		logger.Error(err, "Could not update ClusterImageBuilder status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// clusterBuilderOf maps an ImageBuilder to the ClusterImageBuilder it backs
+func clusterBuilderOf(ctx context.Context, object client.Object) []reconcile.Request {
+	name, ok := object.GetLabels()[clusterImageBuilderLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{
+		{
+			NamespacedName: client.ObjectKey{
+				Name: name,
+			},
+		},
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterImageBuilderReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&osbuildv1alpha1.ClusterImageBuilder{}).
+		Watches(&osbuildv1alpha1.ImageBuilder{}, handler.EnqueueRequestsFromMapFunc(clusterBuilderOf)).
+		Complete(r)
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// composerAPIMountPath is where the build steps find the credentials of the
+// composer API, curl reading the .curlrc of $CURL_HOME
+const composerAPIMountPath = "/composer-api"
+const composerAPIVolume = "composer-api"
+
+// builderClient is the client of the composer API of a builder, uid and hash
+// telling the builder and the credentials it was built with
+type builderClient struct {
+	uid    types.UID
+	hash   string
+	client *composer.Client
+}
+
+// builderClients keeps the clients of the builders whose composer API needs
+// credentials, keyed by the namespace and name of the builder, so their pool
+// of connections outlives a reconcile. Two builders never share a client, even
+// when they point at the same URL.
+var builderClients sync.Map
+
+// builderAPIURL is the composer API of a builder, served by its Service
+// unless spec.api.url is set, the weldr or the Cloud API after its flavor
+func builderAPIURL(builder *osbuildv1alpha1.ImageBuilder) string {
+	path := composer.WeldrAPIPath
+	if builderAPIFlavor(builder) == osbuildv1alpha1.APIFlavorCloud {
+		path = composer.CloudAPIPath
+	}
+	if builder.Spec.API != nil && builder.Spec.API.URL != "" {
+		return strings.TrimSuffix(builder.Spec.API.URL, "/") + path
+	}
+	port := builder.Spec.ServicePort
+	if port == 0 {
+		port = defaultImageBuilderPort
+	}
+	return fmt.Sprintf("http://%s.%s:%v%s", builder.Name, builder.Namespace, port, path)
+}
+
+// builderComposerClient returns a client of the composer API of a builder,
+// with the credentials of its spec.api
+func builderComposerClient(ctx context.Context, c client.Client, builder *osbuildv1alpha1.ImageBuilder) (*composer.Client, error) {
+	apiUrl := builderAPIURL(builder)
+	credentials, err := composerCredentials(ctx, c, builder)
+	if err != nil {
+		return nil, err
+	}
+	key := client.ObjectKeyFromObject(builder)
+	if credentials == nil {
+		builderClients.Delete(key)
+		return composer.NewClient(apiUrl), nil
+	}
+	hash := credentialsHash(apiUrl, credentials)
+	if existing, ok := builderClients.Load(key); ok && existing.(builderClient).uid == builder.UID && existing.(builderClient).hash == hash {
+		composerClient := *existing.(builderClient).client
+		return &composerClient, nil
+	}
+	composerClient, err := composer.NewClientWithCredentials(apiUrl, *credentials)
+	if err != nil {
+		return nil, fmt.Errorf("spec.api of ImageBuilder %s/%s: %w", builder.Namespace, builder.Name, err)
+	}
+	builderClients.Store(key, builderClient{uid: builder.UID, hash: hash, client: composerClient})
+	copied := *composerClient
+	return &copied, nil
+}
+
+// forgetBuilderClient drops the client of a deleted builder
+func forgetBuilderClient(builder types.NamespacedName) {
+	builderClients.Delete(builder)
+}
+
+// composerCredentials reads the CA bundle, client certificate and token of
+// the composer API of a builder, nil when it has none
+func composerCredentials(ctx context.Context, c client.Client, builder *osbuildv1alpha1.ImageBuilder) (*composer.Credentials, error) {
+	api := builder.Spec.API
+	if api == nil || (api.CABundle == nil && api.ClientCertSecret == "" && api.BearerToken == nil) {
+		return nil, nil
+	}
+	credentials := composer.Credentials{}
+	if api.CABundle != nil {
+		configMap := corev1.ConfigMap{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: builder.Namespace, Name: api.CABundle.Name}, &configMap); err != nil {
+			return nil, fmt.Errorf("spec.api.caBundle: %w", err)
+		}
+		ca, ok := configMap.Data[api.CABundle.Key]
+		if !ok {
+			return nil, fmt.Errorf("spec.api.caBundle: ConfigMap %s has no key %s", api.CABundle.Name, api.CABundle.Key)
+		}
+		credentials.CA = []byte(ca)
+	}
+	if api.ClientCertSecret != "" {
+		secret := corev1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: builder.Namespace, Name: api.ClientCertSecret}, &secret); err != nil {
+			return nil, fmt.Errorf("spec.api.clientCertSecret: %w", err)
+		}
+		credentials.Certificate = secret.Data[corev1.TLSCertKey]
+		credentials.Key = secret.Data[corev1.TLSPrivateKeyKey]
+		if len(credentials.Certificate) == 0 || len(credentials.Key) == 0 {
+			return nil, fmt.Errorf("spec.api.clientCertSecret: Secret %s needs the %s and %s keys", api.ClientCertSecret, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+		}
+	}
+	if api.BearerToken != nil {
+		secret := corev1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: builder.Namespace, Name: api.BearerToken.Name}, &secret); err != nil {
+			return nil, fmt.Errorf("spec.api.bearerToken: %w", err)
+		}
+		token, ok := secret.Data[api.BearerToken.Key]
+		if !ok {
+			return nil, fmt.Errorf("spec.api.bearerToken: Secret %s has no key %s", api.BearerToken.Name, api.BearerToken.Key)
+		}
+		credentials.Token = strings.TrimSpace(string(token))
+		// the token ends up in a header, and in the .curlrc of the build steps
+		if strings.IndexFunc(credentials.Token, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("spec.api.bearerToken: the token of Secret %s holds control characters", api.BearerToken.Name)
+		}
+	}
+	return &credentials, nil
+}
+
+// credentialsHash tells apart two sets of credentials of an endpoint
+func credentialsHash(apiUrl string, credentials *composer.Credentials) string {
+	hash := sha256.New()
+	for _, value := range [][]byte{[]byte(apiUrl), credentials.CA, credentials.Certificate, credentials.Key, []byte(credentials.Token)} {
+		hash.Write(value)
+		hash.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// curlrcEscaper quotes a value of a .curlrc string
+var curlrcEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// composerAPISecret holds the credentials of the composer API for the build
+// steps of the namespace of objectMeta, with the .curlrc passing them to curl
+func composerAPISecret(objectMeta metav1.ObjectMeta, credentials *composer.Credentials) *corev1.Secret {
+	data := map[string][]byte{}
+	var curlrc bytes.Buffer
+	if len(credentials.CA) > 0 {
+		data["ca.crt"] = credentials.CA
+		fmt.Fprintf(&curlrc, "cacert = \"%s/ca.crt\"\n", composerAPIMountPath)
+	}
+	if len(credentials.Certificate) > 0 {
+		data[corev1.TLSCertKey] = credentials.Certificate
+		data[corev1.TLSPrivateKeyKey] = credentials.Key
+		fmt.Fprintf(&curlrc, "cert = \"%s/%s\"\nkey = \"%s/%s\"\n", composerAPIMountPath, corev1.TLSCertKey, composerAPIMountPath, corev1.TLSPrivateKeyKey)
+	}
+	if credentials.Token != "" {
+		fmt.Fprintf(&curlrc, "header = \"Authorization: Bearer %s\"\n", curlrcEscaper.Replace(credentials.Token))
+	}
+	data[".curlrc"] = curlrc.Bytes()
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: objectMeta,
+		Type:       corev1.SecretTypeOpaque,
+		Data:       data,
+	}
+}
+
+// usesComposerAPI tells if a step talks to composer
+func usesComposerAPI(step *tektonv1.Step) bool {
+	values := append([]string{step.Script}, step.Command...)
+	values = append(values, step.Args...)
+	for _, env := range step.Env {
+		values = append(values, env.Value)
+	}
+	return strings.Contains(strings.Join(values, "\n"), "$(params.apiEndpoint)")
+}
+
+// setComposerAPI mounts the credentials of the composer API stored in the
+// Secret secretName in the steps of a generated task talking to composer
+func setComposerAPI(spec *tektonv1.TaskSpec, secretName string) {
+	mounted := false
+	for i := range spec.Steps {
+		step := &spec.Steps[i]
+		if !usesComposerAPI(step) {
+			continue
+		}
+		mounted = true
+		step.VolumeMounts = append(step.VolumeMounts, corev1.VolumeMount{
+			Name:      composerAPIVolume,
+			MountPath: composerAPIMountPath,
+			ReadOnly:  true,
+		})
+		step.Env = append(step.Env, corev1.EnvVar{
+			Name:  "CURL_HOME",
+			Value: composerAPIMountPath,
+		})
+	}
+	if mounted {
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: composerAPIVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secretName,
+				},
+			},
+		})
+	}
+}
+
+// setPipelineComposerAPI mounts the credentials of the composer API in the
+// tasks embedded in a generated pipeline, referenced tasks being set with
+// setComposerAPI
+func setPipelineComposerAPI(pipeline *tektonv1.Pipeline, secretName string) {
+	for _, tasks := range [][]tektonv1.PipelineTask{pipeline.Spec.Tasks, pipeline.Spec.Finally} {
+		for i := range tasks {
+			if tasks[i].TaskSpec != nil {
+				setComposerAPI(&tasks[i].TaskSpec.TaskSpec, secretName)
+			}
+		}
+	}
+}
+
+// withComposerAPI mounts the credentials of the composer API of the image
+// being reconciled in generated tasks
+func (r *imageReconcile) withComposerAPI(tasks []tektonv1.Task) []tektonv1.Task {
+	if r.composerAPISecret == "" {
+		return tasks
+	}
+	for i := range tasks {
+		setComposerAPI(&tasks[i].Spec, r.composerAPISecret)
+	}
+	return tasks
+}
+
+// credentialsShared tells if the credentials of the composer API of a builder
+// may be copied to the namespace of an image: its own namespace, or one the
+// builder explicitly allows with spec.allowedNamespaces. Builders open to
+// every namespace keep their credentials to themselves.
+func credentialsShared(image *osbuildv1alpha1.ImageBuilderImage, builder *osbuildv1alpha1.ImageBuilder) bool {
+	api := builder.Spec.API
+	if api == nil || (api.ClientCertSecret == "" && api.BearerToken == nil) {
+		return true
+	}
+	return builder.Namespace == image.Namespace || builder.Spec.AllowedNamespaces != nil
+}
+
+// reconcileComposerAPISecret copies the credentials of the composer API of a
+// builder to the namespace of the image being reconciled, where its build
+// steps mount them, or deletes the copy when the builder needs none. A dry run
+// only names the copy.
+func (r *imageReconcile) reconcileComposerAPISecret(ctx context.Context, builder *osbuildv1alpha1.ImageBuilder, objectMeta metav1.ObjectMeta, dryRun bool) error {
+	r.composerAPISecret = ""
+	credentials, err := composerCredentials(ctx, r.Client, builder)
+	if err != nil {
+		return err
+	}
+	switch {
+	case credentials == nil && dryRun:
+		return nil
+	case credentials == nil:
+		return deleteGeneratedObject(ctx, r.Client, client.ObjectKey{Namespace: objectMeta.Namespace, Name: objectMeta.Name}, &corev1.Secret{}, objectMeta.Labels[imageBuilderImageLabel])
+	case dryRun:
+		r.composerAPISecret = objectMeta.Name
+		return nil
+	}
+	if err := CreateOrUpdateObject(ctx, r.Client, composerAPISecret(objectMeta, credentials)); err != nil {
+		return err
+	}
+	r.composerAPISecret = objectMeta.Name
+	return nil
+}
@@ -0,0 +1,353 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const defaultComposerImage = "ghcr.io/osbuild/osbuild-composer"
+const defaultWorkerImage = "ghcr.io/osbuild/osbuild-worker"
+const composerProxyImage = "docker.io/alpine/socat"
+
+// defaultComposerStorageSize matches the root disk of the builder VM
+var defaultComposerStorageSize = resource.MustParse("30Gi")
+
+// composerConfigHashAnnotation rolls the composer pod when its configuration
+// changes, as it is mounted with subPath
+const composerConfigHashAnnotation = "osbuild.rh-ecosystem-edge.io/config-hash"
+
+// builderRuntime returns what runs the composer of a builder
+func builderRuntime(builder *osbuildv1alpha1.ImageBuilder) osbuildv1alpha1.ComposerRuntime {
+	if builder.Spec.Runtime == "" {
+		return osbuildv1alpha1.RuntimeVirtualMachine
+	}
+	return builder.Spec.Runtime
+}
+
+// composerDeploymentName is the name of the Deployment, configuration Secret
+// and state volume of a composer running as a Deployment
+func composerDeploymentName(builderName string) string {
+	return fmt.Sprintf("%s-composer", builderName)
+}
+
+// deployComposer applies the configuration Secret, state volume and
+// Deployment of a builder running composer in containers, and reports its
+// workers. The builder is only queried once it returns true, Reconcile
+// returning the result and error otherwise.
+func (r *builderReconcile) deployComposer(ctx context.Context, builder *osbuildv1alpha1.ImageBuilder, labels map[string]string) (bool, ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	spec := builder.Spec.Composer
+	if spec == nil {
+		spec = &osbuildv1alpha1.ComposerDeployment{}
+	}
+	objectMeta := metav1.ObjectMeta{
+		Name:      composerDeploymentName(builder.Name),
+		Namespace: builder.Namespace,
+		Labels:    labels,
+		OwnerReferences: []metav1.OwnerReference{
+			*metav1.NewControllerRef(builder, osbuildv1alpha1.GroupVersion.WithKind("ImageBuilder")),
+		},
+	}
+
+	config := composerConfig(objectMeta, spec)
+	if err := ApplyObject(ctx, r.Client, &config, true); err != nil {
+		logger.Error(err, "Could not apply composer configuration")
+		return false, ctrl.Result{}, err
+	}
+	volume := composerStateClaim(objectMeta, spec)
+	if err := r.Create(ctx, &volume); err != nil {
+		if errors.IsAlreadyExists(err) {
+			logger.Info("Composer state volume already exists, skipping creation")
+		} else {
+			logger.Error(err, "Could not create composer state volume")
+			return false, ctrl.Result{}, err
+		}
+	}
+	deployment := r.composerDeployment(objectMeta, spec, &config, builder.Spec.Architecture)
+	if err := ApplyObject(ctx, r.Client, &deployment, true); err != nil {
+		logger.Error(err, "Could not apply composer deployment")
+		return false, ctrl.Result{}, err
+	}
+
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(builder.Namespace), client.MatchingLabels(labels)); err != nil {
+		logger.Error(err, "Could not list composer pods")
+		return false, ctrl.Result{}, err
+	}
+	builder.Status.ReadyWorkers = 0
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if strings.HasPrefix(status.Name, "worker-") && status.Ready {
+				builder.Status.ReadyWorkers++
+			}
+		}
+		if pod.Spec.NodeName != "" {
+			node := corev1.Node{}
+			if err := r.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, &node); err != nil {
+				logger.Error(err, "Could not get the node of the composer pod")
+				return false, ctrl.Result{}, err
+			}
+			builder.Status.Architecture = osbuildv1alpha1.Architecture(node.Labels[corev1.LabelArchStable])
+		}
+	}
+
+	if deployment.Status.AvailableReplicas == 0 || deployment.Status.ObservedGeneration < deployment.Generation {
+		message := fmt.Sprintf("Waiting for Deployment %s to be available", deployment.Name)
+		logger.Info(message)
+		setBuilderCondition(builder, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonComposerDeploying, message)
+		if err := r.Status().Update(ctx, builder); err != nil {
+			logger.Error(err, "Could not update ImageBuilder status")
+			return false, ctrl.Result{}, err
+		}
+		// the Deployment is owned, its availability triggers a reconcile
+		return false, ctrl.Result{RequeueAfter: inventoryInterval}, nil
+	}
+	return true, ctrl.Result{}, nil
+}
+
+// deleteRuntimeObjects deletes the objects of the runtime a builder does not
+// use, the kinds that are not installed having none
+func (r *ImageBuilderReconciler) deleteRuntimeObjects(ctx context.Context, objects ...client.Object) error {
+	for _, object := range objects {
+		if err := r.Get(ctx, client.ObjectKeyFromObject(object), object); err != nil {
+			if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			return err
+		}
+		if err := r.Delete(ctx, object, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// composerConfig holds the configuration files of composer and its workers
+func composerConfig(objectMeta metav1.ObjectMeta, spec *osbuildv1alpha1.ComposerDeployment) corev1.Secret {
+	return corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: objectMeta,
+		Type:       corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"osbuild-composer.toml": []byte(spec.Config),
+			"osbuild-worker.toml":   []byte(workerConfig(spec.WorkerConfig)),
+		},
+	}
+}
+
+// composerStateClaim is the volume keeping the blueprints and composes of
+// composer across restarts
+func composerStateClaim(objectMeta metav1.ObjectMeta, spec *osbuildv1alpha1.ComposerDeployment) corev1.PersistentVolumeClaim {
+	size := defaultComposerStorageSize
+	if spec.StorageSize != nil {
+		size = *spec.StorageSize
+	}
+	return corev1.PersistentVolumeClaim{
+		ObjectMeta: objectMeta,
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: spec.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+}
+
+// composerDeployment runs composer, its workers and a proxy exposing the
+// weldr API socket on the service port in a single pod. The workers take
+// their jobs from the local socket of composer, so they need no credentials.
+func (r *builderReconcile) composerDeployment(objectMeta metav1.ObjectMeta, spec *osbuildv1alpha1.ComposerDeployment, config *corev1.Secret, arch osbuildv1alpha1.Architecture) appsv1.Deployment {
+	image := spec.Image
+	if image == "" {
+		image = defaultComposerImage
+	}
+	workerImage := spec.WorkerImage
+	if workerImage == "" {
+		workerImage = defaultWorkerImage
+	}
+	workers := int32(1)
+	if spec.Workers != nil {
+		workers = *spec.Workers
+	}
+	hash := sha256.New()
+	for _, key := range []string{"osbuild-composer.toml", "osbuild-worker.toml"} {
+		hash.Write(config.Data[key])
+	}
+
+	containers := []corev1.Container{
+		{
+			Name:      "composer",
+			Image:     image,
+			Args:      []string{"--weldr-api", "--local-worker-api"},
+			Resources: spec.Resources,
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "config", MountPath: "/etc/osbuild-composer/osbuild-composer.toml", SubPath: "osbuild-composer.toml"},
+				{Name: "state", MountPath: "/var/lib/osbuild-composer"},
+				{Name: "jobs", MountPath: "/run/osbuild-composer"},
+				{Name: "weldr", MountPath: "/run/weldr"},
+			},
+		},
+		{
+			Name:  "proxy",
+			Image: composerProxyImage,
+			Args: []string{
+				"-d", "-d",
+				fmt.Sprintf("TCP-LISTEN:%d,fork", r.servicePort),
+				"UNIX-CONNECT:/run/weldr/api.socket",
+			},
+			Ports: []corev1.ContainerPort{
+				{
+					Name:          "weldr",
+					ContainerPort: r.servicePort,
+					Protocol:      corev1.ProtocolTCP,
+				},
+			},
+			ReadinessProbe: &corev1.Probe{
+				ProbeHandler: corev1.ProbeHandler{
+					HTTPGet: &corev1.HTTPGetAction{
+						Path: "/api/status",
+						Port: intstr.FromInt(int(r.servicePort)),
+					},
+				},
+				PeriodSeconds: 10,
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "weldr", MountPath: "/run/weldr"},
+			},
+		},
+	}
+	for i := int32(0); i < workers; i++ {
+		containers = append(containers, corev1.Container{
+			Name:      fmt.Sprintf("worker-%d", i),
+			Image:     workerImage,
+			Args:      []string{"-unix", "/run/osbuild-composer/job.socket"},
+			Resources: spec.WorkerResources,
+			// osbuild sets up loop devices and mounts to build the images
+			SecurityContext: &corev1.SecurityContext{
+				Privileged: pointer.Bool(true),
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "config", MountPath: "/etc/osbuild-worker/osbuild-worker.toml", SubPath: "osbuild-worker.toml"},
+				{Name: "jobs", MountPath: "/run/osbuild-composer"},
+				{Name: "cache", MountPath: "/var/cache/osbuild-worker"},
+				{Name: "containers-auth", MountPath: containersAuthDir, ReadOnly: true},
+			},
+		})
+	}
+
+	return appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Deployment",
+			APIVersion: "apps/v1",
+		},
+		ObjectMeta: objectMeta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: pointer.Int32(1),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: objectMeta.Labels,
+			},
+			// the state volume is mounted by a single pod
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RecreateDeploymentStrategyType,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: objectMeta.Labels,
+					Annotations: map[string]string{
+						composerConfigHashAnnotation: fmt.Sprintf("%x", hash.Sum(nil)),
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers:   containers,
+					NodeSelector: architectureSelector(arch),
+					Volumes: []corev1.Volume{
+						{
+							Name: "config",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: config.Name,
+								},
+							},
+						},
+						{
+							Name: "state",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: objectMeta.Name,
+								},
+							},
+						},
+						{
+							Name: "jobs",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+						{
+							Name: "weldr",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+						{
+							Name: "cache",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+						{
+							// written by the images embedding containers
+							Name: "containers-auth",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: containersAuthSecretName(objectMeta.Labels[imageBuilderLabel]),
+									Optional:   pointer.Bool(true),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
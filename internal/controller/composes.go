@@ -0,0 +1,372 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// composeRequeueInterval is how often composer is asked about the composes
+// of a running build, their progress not changing any watched resource
+const composeRequeueInterval = 30 * time.Second
+
+// Queue statuses of weldr
+const (
+	composeWaiting  = "WAITING"
+	composeFinished = "FINISHED"
+	composeFailed   = "FAILED"
+)
+
+// composesDone tells if every compose of a build reached a final state
+func composesDone(composes []osbuildv1alpha1.ComposeStatus) bool {
+	for _, compose := range composes {
+		if compose.QueueStatus != composeFinished && compose.QueueStatus != composeFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// composeLogLines is how many lines of the output of a failed compose its
+// event reports
+const composeLogLines = 5
+
+// storedComposeLogSize is how many kilobytes of the output of a failed
+// compose are kept in its ConfigMap, below the size limit of ConfigMaps
+const storedComposeLogSize = 512
+
+// composeLogKey is the key of the log in the ConfigMap of a failed compose
+const composeLogKey = "compose.log"
+
+// The record-compose step follows the start of every compose of a build,
+// writing the compose ID in the composeID result, and in the termination
+// message of the container when the build runs as a Job
+const (
+	recordComposeStepName = "record-compose"
+	composeIDResult       = "composeID"
+)
+
+// recordComposeScript reads the ID of the compose started by the previous
+// step, the build_id of the weldr API or the id of the Cloud API, a compose
+// that was not started being reported by wait-for-finish
+const recordComposeScript = `#!/bin/bash
+id=$(jq -r '.build_id // .id // empty' "/workspace/shared-volume/$(params.blueprintName)/${compose_file}" 2>/dev/null)
+printf '%s' "${id}" > "$(results.composeID.path)"
+printf '%s' "${id}" > /dev/termination-log 2>/dev/null || true
+`
+
+// addRecordComposeStep records the ID of the compose started by the
+// start-compose step of a task in its results
+func addRecordComposeStep(task *tektonv1.Task, composeFile string) {
+	steps := []tektonv1.Step{}
+	for _, step := range task.Spec.Steps {
+		steps = append(steps, step)
+		if step.Name != "start-compose" {
+			continue
+		}
+		steps = append(steps, tektonv1.Step{
+			Name:   recordComposeStepName,
+			Image:  utilsImage,
+			Script: recordComposeScript,
+			Env: []corev1.EnvVar{
+				{Name: "compose_file", Value: composeFile},
+			},
+		})
+	}
+	task.Spec.Steps = steps
+	task.Spec.Results = append(task.Spec.Results, tektonv1.TaskResult{
+		Name:        composeIDResult,
+		Type:        tektonv1.ResultsTypeString,
+		Description: "ID of the compose started by the task",
+	})
+}
+
+// isRecordComposeStep tells if a step, or the container of a Job running it,
+// is a record-compose step
+func isRecordComposeStep(name string) bool {
+	return name == recordComposeStepName || strings.HasPrefix(name, recordComposeStepName+"-")
+}
+
+// buildComposeIDs returns the IDs of the composes started by a build, the
+// named PipelineRun or Job, read from its record-compose steps as soon as
+// they are done, including the ones of the retried tasks
+func buildComposeIDs(ctx context.Context, c client.Client, namespace string, build string, job bool) ([]string, error) {
+	ids := []string{}
+	seen := map[string]bool{}
+	add := func(id string) {
+		id = strings.TrimSpace(id)
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if job {
+		pods := corev1.PodList{}
+		if err := c.List(ctx, &pods, client.InNamespace(namespace), client.MatchingLabels{"job-name": build}); err != nil {
+			return nil, err
+		}
+		for _, pod := range pods.Items {
+			for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+				if isRecordComposeStep(status.Name) && status.State.Terminated != nil {
+					add(status.State.Terminated.Message)
+				}
+			}
+		}
+		return ids, nil
+	}
+	taskRuns := tektonv1.TaskRunList{}
+	if err := c.List(ctx, &taskRuns, client.InNamespace(namespace), client.MatchingLabels{"tekton.dev/pipelineRun": build}); err != nil {
+		return nil, err
+	}
+	for _, taskRun := range taskRuns.Items {
+		attempts := append([]tektonv1.TaskRunStatus{taskRun.Status}, taskRun.Status.RetriesStatus...)
+		for _, attempt := range attempts {
+			for _, step := range attempt.Steps {
+				if !isRecordComposeStep(step.Name) || step.Terminated == nil {
+					continue
+				}
+				// the termination message holds the results of the step
+				results := []struct {
+					Key   string `json:"key"`
+					Value string `json:"value"`
+				}{}
+				if err := json.Unmarshal([]byte(step.Terminated.Message), &results); err != nil {
+					continue
+				}
+				for _, result := range results {
+					if result.Key == composeIDResult {
+						add(result.Value)
+					}
+				}
+			}
+		}
+	}
+	return ids, nil
+}
+
+// composeLogConfigMapName is the name of the ConfigMap holding the log of a
+// failed compose of an image
+func composeLogConfigMapName(imageName string, id string) string {
+	return fmt.Sprintf("%s-log-%s", imageName, id)
+}
+
+// setComposeStatus records the composes started in composer by the
+// PipelineRun, identified by the IDs its record-compose steps reported, the
+// latest one per blueprint, with an event when one starts, finishes or
+// fails. It returns true while they should be followed.
+func (r *ImageBuilderImageReconciler) setComposeStatus(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, pipelineRun *tektonv1.PipelineRun, composerClient *composer.Client) (bool, error) {
+	if pipelineRun.Status.StartTime == nil {
+		image.Status.Composes = nil
+		return false, nil
+	}
+	if pipelineRun.IsDone() && composesDone(image.Status.Composes) {
+		return false, nil
+	}
+
+	ids, err := buildComposeIDs(ctx, r.Client, pipelineRun.Namespace, pipelineRun.Name, false)
+	if err != nil {
+		return true, err
+	}
+	started := map[string]bool{}
+	for _, id := range ids {
+		started[id] = true
+	}
+	queue, err := composerClient.Queue(ctx)
+	if err != nil {
+		return true, err
+	}
+	finished, err := composerClient.Finished(ctx)
+	if err != nil {
+		return true, err
+	}
+	failed, err := composerClient.Failed(ctx)
+	if err != nil {
+		return true, err
+	}
+	latest := map[string]composer.ComposeInfo{}
+	for _, composes := range [][]composer.ComposeInfo{queue.New, queue.Run, finished, failed} {
+		for _, compose := range composes {
+			if !started[compose.ID] {
+				continue
+			}
+			if previous, ok := latest[compose.Blueprint]; !ok || compose.Created().After(previous.Created()) {
+				latest[compose.Blueprint] = compose
+			}
+		}
+	}
+	composes := []osbuildv1alpha1.ComposeStatus{}
+	for _, compose := range latest {
+		composes = append(composes, osbuildv1alpha1.ComposeStatus{
+			Blueprint:   compose.Blueprint,
+			ID:          compose.ID,
+			ComposeType: compose.ComposeType,
+			QueueStatus: compose.QueueStatus,
+			Created:     metav1.NewTime(compose.Created()),
+		})
+	}
+	// the commit is composed before the installer
+	sort.Slice(composes, func(i, j int) bool {
+		return composes[i].Created.Before(&composes[j].Created)
+	})
+	r.composeEvents(ctx, image, composes, composerClient)
+	image.Status.Composes = composes
+	return !pipelineRun.IsDone() || !composesDone(composes), nil
+}
+
+// composeEvents reports the composes that started, finished or failed since
+// the status of the image was recorded
+func (r *ImageBuilderImageReconciler) composeEvents(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, composes []osbuildv1alpha1.ComposeStatus, composerClient *composer.Client) {
+	logger := log.FromContext(ctx)
+	previous := map[string]osbuildv1alpha1.ComposeStatus{}
+	for _, compose := range image.Status.Composes {
+		previous[compose.ID] = compose
+	}
+	for i := range composes {
+		compose := &composes[i]
+		recorded, known := previous[compose.ID]
+		if !known {
+			r.Recorder.Event(image, corev1.EventTypeNormal, osbuildv1alpha1.EventComposeStarted,
+				eventMessage(fmt.Sprintf("Compose %s of blueprint %s started, building %s", compose.ID, compose.Blueprint, compose.ComposeType)))
+		}
+		compose.LogConfigMap = recorded.LogConfigMap
+		if recorded.QueueStatus == compose.QueueStatus {
+			continue
+		}
+		switch compose.QueueStatus {
+		case composeFinished:
+			r.Recorder.Event(image, corev1.EventTypeNormal, osbuildv1alpha1.EventComposeFinished,
+				eventMessage(fmt.Sprintf("Compose %s of blueprint %s finished, its %s image is at %s/compose/image/%s",
+					compose.ID, compose.Blueprint, compose.ComposeType, composerClient.Endpoint, compose.ID)))
+		case composeFailed:
+			output, err := composerClient.Log(ctx, compose.ID, storedComposeLogSize)
+			if err != nil {
+				logger.Error(err, "Could not get compose log")
+				output = "the output of osbuild could not be read"
+			} else if err := r.storeComposeLog(ctx, image, compose, output); err != nil {
+				logger.Error(err, "Could not store compose log")
+			}
+			message := fmt.Sprintf("Compose %s of blueprint %s failed: %s", compose.ID, compose.Blueprint, lastLines(output, composeLogLines))
+			if compose.LogConfigMap != "" {
+				message = fmt.Sprintf("%s\nSee ConfigMap %s for the full log", message, compose.LogConfigMap)
+			}
+			r.Recorder.Event(image, corev1.EventTypeWarning, osbuildv1alpha1.ReasonComposeFailed, eventMessage(message))
+		}
+	}
+}
+
+// storeComposeLog keeps the log of a failed compose in a ConfigMap owned by
+// the image, which is deleted with the compose
+func (r *ImageBuilderImageReconciler) storeComposeLog(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, compose *osbuildv1alpha1.ComposeStatus, output string) error {
+	configMap := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      composeLogConfigMapName(image.Name, compose.ID),
+			Namespace: image.Namespace,
+			Labels:    r.resourceLabels(image),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(image, osbuildv1alpha1.GroupVersion.WithKind("ImageBuilderImage")),
+			},
+		},
+		Data: map[string]string{
+			composeLogKey: output,
+		},
+	}
+	if err := r.Create(ctx, &configMap); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	compose.LogConfigMap = configMap.Name
+	return nil
+}
+
+// setComposeLogMessage points the failure conditions of an image to the logs
+// of its failed composes
+func setComposeLogMessage(image *osbuildv1alpha1.ImageBuilderImage) {
+	failed := meta.FindStatusCondition(image.Status.Conditions, osbuildv1alpha1.ConditionFailed)
+	if failed == nil || failed.Status != metav1.ConditionTrue {
+		return
+	}
+	logs := []string{}
+	for _, compose := range image.Status.Composes {
+		if compose.QueueStatus == composeFailed && compose.LogConfigMap != "" {
+			logs = append(logs, fmt.Sprintf("compose %s of blueprint %s in ConfigMap %s", compose.ID, compose.Blueprint, compose.LogConfigMap))
+		}
+	}
+	if len(logs) == 0 {
+		return
+	}
+	message := "The log of " + strings.Join(logs, ", the log of ")
+	if failed.Message != "" {
+		message = fmt.Sprintf("%s; the log of %s", failed.Message, strings.Join(logs, ", the log of "))
+	}
+	setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, failed.Reason, message)
+	setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, failed.Reason, message)
+}
+
+// lastLines returns the last count non-empty lines of output
+func lastLines(output string, count int) string {
+	lines := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// buildPhase summarizes the conditions of an image and the state of the
+// composes of its current build
+func buildPhase(image *osbuildv1alpha1.ImageBuilderImage) osbuildv1alpha1.BuildPhase {
+	if meta.IsStatusConditionTrue(image.Status.Conditions, osbuildv1alpha1.ConditionFailed) {
+		return osbuildv1alpha1.PhaseFailed
+	}
+	ready := meta.FindStatusCondition(image.Status.Conditions, osbuildv1alpha1.ConditionReady)
+	if ready == nil {
+		return osbuildv1alpha1.PhasePending
+	}
+	if ready.Status == metav1.ConditionTrue {
+		return osbuildv1alpha1.PhaseSucceeded
+	}
+	if ready.Reason == osbuildv1alpha1.ReasonBuildQueued {
+		return osbuildv1alpha1.PhaseQueued
+	}
+	if ready.Reason != osbuildv1alpha1.ReasonBuildRunning {
+		return osbuildv1alpha1.PhasePending
+	}
+	for _, compose := range image.Status.Composes {
+		if compose.QueueStatus == composeWaiting {
+			return osbuildv1alpha1.PhaseQueued
+		}
+	}
+	return osbuildv1alpha1.PhaseRunning
+}
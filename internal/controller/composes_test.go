@@ -0,0 +1,120 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	"github.com/kwozyman/osbuild-operator/pkg/composertest"
+)
+
+var _ = Describe("Build composes", func() {
+	ctx := context.Background()
+	var composerServer *composertest.Server
+	var reconciler *ImageBuilderImageReconciler
+	var recorder *record.FakeRecorder
+	var image *osbuildv1alpha1.ImageBuilderImage
+	var pipelineRun *tektonv1.PipelineRun
+
+	startCompose := func(blueprint string) string {
+		Expect(pushBlueprint(composerServer, blueprint)).To(Succeed())
+		id, err := startWeldrCompose(composerServer, blueprint)
+		Expect(err).NotTo(HaveOccurred())
+		return id
+	}
+
+	BeforeEach(func() {
+		composerServer = composertest.NewServer()
+		DeferCleanup(composerServer.Close)
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &ImageBuilderImageReconciler{
+			Client:   fake.NewClientBuilder().WithScheme(scheme.Scheme).Build(),
+			Recorder: recorder,
+		}
+		image = &osbuildv1alpha1.ImageBuilderImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "builds"},
+		}
+		pipelineRun = &tektonv1.PipelineRun{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge-build-1", Namespace: "builds"},
+		}
+		started := metav1.NewTime(time.Now().Add(-time.Minute))
+		pipelineRun.Status.StartTime = &started
+	})
+
+	It("records the latest compose the build started of every blueprint", func() {
+		first := startCompose("edge")
+		latest := startCompose("edge")
+		startCompose("other")
+		// the compose of another image using the same blueprint name
+		startCompose("edge")
+		taskRun := tektonv1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "edge-build-1-compose"}}
+		taskRun.Status.Steps = []tektonv1.StepState{recordComposeState("record-compose", latest)}
+		taskRun.Status.RetriesStatus = []tektonv1.TaskRunStatus{{}}
+		taskRun.Status.RetriesStatus[0].Steps = []tektonv1.StepState{recordComposeState("record-compose", first)}
+		reconciler.Client = taskRunClient(image.Namespace, pipelineRun.Name, taskRun)
+
+		follow, err := reconciler.setComposeStatus(ctx, image, pipelineRun, composer.NewClient(composerServer.APIEndpoint()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(follow).To(BeTrue())
+		Expect(image.Status.Composes).To(HaveLen(1))
+		Expect(image.Status.Composes[0].ID).To(Equal(latest))
+		Expect(image.Status.Composes[0].QueueStatus).To(Equal(composeWaiting))
+		Expect(recorder.Events).To(Receive(ContainSubstring("Compose %s of blueprint edge started", latest)))
+
+		Expect(composerServer.SetStatus(latest, composertest.StatusFailed)).To(Succeed())
+		_, err = reconciler.setComposeStatus(ctx, image, pipelineRun, composer.NewClient(composerServer.APIEndpoint()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(ContainSubstring("Compose %s of blueprint edge failed", latest)))
+		Expect(image.Status.Composes[0].LogConfigMap).To(Equal(composeLogConfigMapName("edge", latest)))
+	})
+
+	It("records no compose before the build started", func() {
+		startCompose("edge")
+		pipelineRun.Status.StartTime = nil
+
+		follow, err := reconciler.setComposeStatus(ctx, image, pipelineRun, composer.NewClient(composerServer.APIEndpoint()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(follow).To(BeFalse())
+		Expect(image.Status.Composes).To(BeEmpty())
+	})
+
+	It("reports a build waiting for composer as queued", func() {
+		Expect(buildPhase(image)).To(Equal(osbuildv1alpha1.PhasePending))
+		meta.SetStatusCondition(&image.Status.Conditions, metav1.Condition{
+			Type:   osbuildv1alpha1.ConditionReady,
+			Status: metav1.ConditionFalse,
+			Reason: osbuildv1alpha1.ReasonBuildRunning,
+		})
+		image.Status.Composes = []osbuildv1alpha1.ComposeStatus{{QueueStatus: composeWaiting}}
+		Expect(buildPhase(image)).To(Equal(osbuildv1alpha1.PhaseQueued))
+		image.Status.Composes[0].QueueStatus = "RUNNING"
+		Expect(buildPhase(image)).To(Equal(osbuildv1alpha1.PhaseRunning))
+	})
+})
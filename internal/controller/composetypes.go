@@ -0,0 +1,83 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// composeImage is the file a compose is downloaded to on the shared volume
+type composeImage struct {
+	Name      string
+	MediaType string
+}
+
+// composeImages are the files of the compose types other than edge-commit,
+// whose commit is extracted to be served as an ostree repository
+var composeImages = map[osbuildv1alpha1.ComposeType]composeImage{
+	osbuildv1alpha1.ComposeEdgeContainer:  {Name: "container.tar", MediaType: "application/x-tar"},
+	osbuildv1alpha1.ComposeQcow2:          {Name: "disk.qcow2", MediaType: "application/x-qemu-disk"},
+	osbuildv1alpha1.ComposeAMI:            {Name: "image.raw", MediaType: "application/octet-stream"},
+	osbuildv1alpha1.ComposeVHD:            {Name: "disk.vhd", MediaType: "application/x-vhd"},
+	osbuildv1alpha1.ComposeGCE:            {Name: "image.tar.gz", MediaType: "application/gzip"},
+	osbuildv1alpha1.ComposeVMDK:           {Name: "disk.vmdk", MediaType: "application/x-vmdk"},
+	osbuildv1alpha1.ComposeOpenStack:      {Name: "disk.qcow2", MediaType: "application/x-qemu-disk"},
+	osbuildv1alpha1.ComposeImageInstaller: {Name: "image-installer.iso", MediaType: "application/x-iso9660-image"},
+}
+
+// imageComposeType is the compose type of an image, edge-commit by default
+func imageComposeType(image *osbuildv1alpha1.ImageBuilderImage) osbuildv1alpha1.ComposeType {
+	if image.Spec.ComposeType == "" {
+		return osbuildv1alpha1.ComposeEdgeCommit
+	}
+	return image.Spec.ComposeType
+}
+
+// composeTypeSupported tells if composer on the builder builds a compose
+// type, assuming it does until the builder reported its compose types
+func composeTypeSupported(builder *osbuildv1alpha1.ImageBuilder, composeType osbuildv1alpha1.ComposeType) bool {
+	if len(builder.Status.ComposeTypes) == 0 {
+		return true
+	}
+	for _, supported := range builder.Status.ComposeTypes {
+		if supported == string(composeType) {
+			return true
+		}
+	}
+	return false
+}
+
+// composeImageEnv describes the file of a compose type to the steps
+// describing and uploading the artifacts, empty for edge-commit
+func composeImageEnv(composeType osbuildv1alpha1.ComposeType) []corev1.EnvVar {
+	image := composeImages[composeType]
+	return []corev1.EnvVar{
+		{
+			Name:  "compose_type",
+			Value: string(composeType),
+		},
+		{
+			Name:  "image_file",
+			Value: image.Name,
+		},
+		{
+			Name:  "image_media_type",
+			Value: image.MediaType,
+		},
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// fieldManager is the field manager of the objects applied by the operator
+const fieldManager = "osbuild-operator"
+
+// ApplyObject server-side applies an object owned by the operator. Fields
+// changed by other managers make the apply fail with a conflict, unless force
+// is set to take their ownership back.
+func ApplyObject(ctx context.Context, c client.Client, object client.Object, force bool) error {
+	logger := log.FromContext(ctx)
+	gvk, err := apiutil.GVKForObject(object, c.Scheme())
+	if err != nil {
+		return err
+	}
+	object.GetObjectKind().SetGroupVersionKind(gvk)
+	object.SetResourceVersion("")
+	object.SetManagedFields(nil)
+	options := []client.PatchOption{client.FieldOwner(fieldManager)}
+	if force {
+		options = append(options, client.ForceOwnership)
+	}
+	if err := c.Patch(ctx, object, client.Apply, options...); err != nil {
+		if !errors.IsConflict(err) {
+			logger.Error(err, fmt.Sprintf("Could not apply object %s/%s", gvk.Kind, object.GetName()))
+		}
+		return err
+	}
+	logger.Info(fmt.Sprintf("Object %s/%s applied", gvk.Kind, object.GetName()))
+	return nil
+}
+
+// fieldConflicts lists the fields of a failed apply owned by other managers,
+// with the manager owning each of them. It returns an empty string for any
+// other error.
+func fieldConflicts(err error) string {
+	status, ok := err.(errors.APIStatus)
+	if !ok || !errors.IsConflict(err) || status.Status().Details == nil {
+		return ""
+	}
+	conflicts := []string{}
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			conflicts = append(conflicts, cause.Message)
+		}
+	}
+	return strings.Join(conflicts, "; ")
+}
+
+// resourceConflict reports the fields of a generated object owned by another
+// field manager. The build does not go on until the change is reverted or
+// spec.forceOwnership is set.
+func (r *ImageBuilderImageReconciler) resourceConflict(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, object client.Object, conflicts string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	message := fmt.Sprintf("%s %s was modified outside of the operator, set spec.forceOwnership to override: %s",
+		object.GetObjectKind().GroupVersionKind().Kind, object.GetName(), conflicts)
+	logger.Error(nil, message)
+	r.Recorder.Event(image, corev1.EventTypeWarning, osbuildv1alpha1.ReasonFieldManagerConflict, eventMessage(message))
+	setImageCondition(image, osbuildv1alpha1.ConditionResourceConflict, metav1.ConditionTrue, osbuildv1alpha1.ReasonFieldManagerConflict, message)
+	setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonResourceConflict, message)
+	setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonResourceConflict, message)
+	return ctrl.Result{}, updateImageStatus(ctx, r.Client, image)
+}
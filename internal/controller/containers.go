@@ -0,0 +1,150 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// containersAuthKey is the key of the registry credentials in the containers
+// auth Secret of a builder
+const containersAuthKey = "auth.json"
+
+// containersAuthDir is where the workers of a builder find its containers
+// auth Secret
+const containersAuthDir = "/etc/osbuild-worker/containers"
+
+// containersAuthSecretName is the name of the Secret holding the registry
+// credentials the workers of a builder pull the embedded containers with
+func containersAuthSecretName(builderName string) string {
+	return fmt.Sprintf("%s-containers-auth", builderName)
+}
+
+// workerConfig is the osbuild-worker.toml of the workers, pointing them to the
+// containers auth Secret unless the configuration already has a [containers]
+// table
+func workerConfig(config string) string {
+	for _, line := range strings.Split(config, "\n") {
+		if strings.TrimSpace(line) == "[containers]" {
+			return config
+		}
+	}
+	if config != "" && !strings.HasSuffix(config, "\n") {
+		config += "\n"
+	}
+	return config + fmt.Sprintf("\n[containers]\nauth_file_path = %s\n", tomlString(containersAuthDir+"/"+containersAuthKey))
+}
+
+// dockerConfig is the content of a kubernetes.io/dockerconfigjson Secret, the
+// entries being kept as is
+type dockerConfig struct {
+	Auths map[string]json.RawMessage `json:"auths"`
+}
+
+// syncContainersAuth adds the registry credentials of the pull Secrets of the
+// embedded containers of an image to the containers auth Secret of its
+// builder, an entry of an image replacing the one of the same registry. It
+// returns why the image must wait when a pull Secret does not exist or holds
+// no credentials.
+func (r *ImageBuilderImageReconciler) syncContainersAuth(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, builder *osbuildv1alpha1.ImageBuilder) (string, error) {
+	logger := log.FromContext(ctx)
+	auths := map[string]json.RawMessage{}
+	for _, container := range image.Spec.EmbeddedContainers {
+		if container.PullSecret == nil {
+			continue
+		}
+		secret := corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: image.Namespace, Name: container.PullSecret.Name}, &secret); err != nil {
+			if errors.IsNotFound(err) {
+				return fmt.Sprintf("Pull Secret %s of embedded container %s does not exist", container.PullSecret.Name, container.Source), nil
+			}
+			return "", err
+		}
+		config := dockerConfig{}
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil || len(config.Auths) == 0 {
+			return fmt.Sprintf("Pull Secret %s of embedded container %s has no registry credentials in its %s key",
+				container.PullSecret.Name, container.Source, corev1.DockerConfigJsonKey), nil
+		}
+		for registry, auth := range config.Auths {
+			auths[registry] = auth
+		}
+	}
+	if len(auths) == 0 {
+		return "", nil
+	}
+
+	key := client.ObjectKey{Namespace: builder.Namespace, Name: containersAuthSecretName(builder.Name)}
+	secret := corev1.Secret{}
+	if err := r.Get(ctx, key, &secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+		secret = corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Labels: map[string]string{
+					imageBuilderLabel: builder.Name,
+				},
+				// deleted with the builder
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(builder, osbuildv1alpha1.GroupVersion.WithKind("ImageBuilder")),
+				},
+			},
+			Type: corev1.SecretTypeOpaque,
+		}
+	}
+	config := dockerConfig{Auths: map[string]json.RawMessage{}}
+	if data := secret.Data[containersAuthKey]; len(data) > 0 {
+		if err := json.Unmarshal(data, &config); err != nil || config.Auths == nil {
+			// rewritten from the credentials of the image
+			config.Auths = map[string]json.RawMessage{}
+		}
+	}
+	for registry, auth := range auths {
+		config.Auths[registry] = auth
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	if bytes.Equal(data, secret.Data[containersAuthKey]) {
+		return "", nil
+	}
+	secret.Data = map[string][]byte{containersAuthKey: data}
+	if secret.ResourceVersion == "" {
+		err = r.Create(ctx, &secret)
+	} else {
+		err = r.Update(ctx, &secret)
+	}
+	if err != nil {
+		return "", err
+	}
+	logger.Info(fmt.Sprintf("Added the registry credentials of the embedded containers to Secret %s/%s", key.Namespace, key.Name))
+	return "", nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+)
+
+// customizationsBlueprint renders the structured customizations of the spec
+// as TOML, appended to the generated commit blueprint once its template was
+// rendered so their values are never interpreted as template actions
+func customizationsBlueprint(spec *osbuildv1alpha1.ImageBuilderImageSpec) string {
+	var blueprint strings.Builder
+	for _, name := range spec.Packages {
+		fmt.Fprintf(&blueprint, "\n[[packages]]\nname = %s\n", tomlString(name))
+	}
+	for _, user := range spec.Users {
+		fmt.Fprintf(&blueprint, "\n[[customizations.user]]\nname = %s\n", tomlString(user.Name))
+		if user.Key != "" {
+			fmt.Fprintf(&blueprint, "key = %s\n", tomlString(user.Key))
+		}
+		if len(user.Groups) > 0 {
+			fmt.Fprintf(&blueprint, "groups = %s\n", tomlStrings(user.Groups))
+		}
+	}
+	for _, filesystem := range spec.Filesystem {
+		fmt.Fprintf(&blueprint, "\n[[customizations.filesystem]]\nmountpoint = %s\nminsize = %d\n",
+			tomlString(filesystem.Mountpoint), filesystem.MinSize.Value())
+	}
+	if spec.Kernel != nil {
+		fmt.Fprintf(&blueprint, "\n[customizations.kernel]\nappend = %s\n", tomlString(spec.Kernel.Append))
+	}
+	if spec.Services != nil && len(spec.Services.Enabled) > 0 {
+		fmt.Fprintf(&blueprint, "\n[customizations.services]\nenabled = %s\n", tomlStrings(spec.Services.Enabled))
+	}
+	if spec.Firewall != nil && len(spec.Firewall.Ports) > 0 {
+		fmt.Fprintf(&blueprint, "\n[customizations.firewall]\nports = %s\n", tomlStrings(spec.Firewall.Ports))
+	}
+	for _, container := range spec.EmbeddedContainers {
+		fmt.Fprintf(&blueprint, "\n[[containers]]\nsource = %s\n", tomlString(container.Source))
+		if container.Name != "" {
+			fmt.Fprintf(&blueprint, "name = %s\n", tomlString(container.Name))
+		}
+		if container.TLSVerify != nil {
+			fmt.Fprintf(&blueprint, "tls-verify = %t\n", *container.TLSVerify)
+		}
+	}
+	return blueprint.String()
+}
+
+// tomlString quotes a value as a TOML basic string
+func tomlString(value string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for _, r := range value {
+		switch {
+		case r == '"' || r == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteRune(r)
+		case r == '\n':
+			quoted.WriteString(`\n`)
+		case r == '\t':
+			quoted.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&quoted, `\u%04X`, r)
+		default:
+			quoted.WriteRune(r)
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// tomlStrings formats values as a TOML array of strings
+func tomlStrings(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, tomlString(value))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// DefaultDeliveryWorkers is how many build events are delivered at once by
+// default
+const DefaultDeliveryWorkers = 4
+
+// deliveryTimeout bounds a delivery, a slow endpoint only holding a worker of
+// the deliveries
+const deliveryTimeout = 10 * time.Second
+
+// deliveryExpiry is how long the outcome of a delivery is kept for the image
+// to read it, e.g. when the image was deleted in the meantime
+const deliveryExpiry = 10 * time.Minute
+
+// delivery is a build event being delivered to a callback or a sink
+type delivery struct {
+	image    types.NamespacedName
+	send     func(context.Context) error
+	done     bool
+	err      error
+	finished time.Time
+}
+
+// Deliveries sends the build events to the callbacks and sinks outside of the
+// reconciles, so a slow or unreachable endpoint does not hold a worker of the
+// controller. Every delivery is sent once by one of the workers, the image
+// being reconciled again with its outcome through the source of Source.
+type Deliveries struct {
+	// Workers is how many deliveries are sent at once
+	Workers int
+
+	lock       sync.Mutex
+	deliveries map[string]*delivery
+	queue      workqueue.Interface
+	events     chan event.GenericEvent
+}
+
+// NewDeliveries returns the deliveries of the build events, sent by workers
+// once started by the manager
+func NewDeliveries(workers int) *Deliveries {
+	if workers < 1 {
+		workers = DefaultDeliveryWorkers
+	}
+	return &Deliveries{
+		Workers:    workers,
+		deliveries: map[string]*delivery{},
+		queue:      workqueue.New(),
+		events:     make(chan event.GenericEvent, 1024),
+	}
+}
+
+// Source triggers a reconcile of the image of every finished delivery
+func (d *Deliveries) Source() source.Source {
+	return &source.Channel{Source: d.events}
+}
+
+// Send queues a delivery identified by id, the same for every attempt of
+// the same event, and returns its outcome once it is done. It returns false
+// while the delivery is queued or being sent. A failed delivery is sent again
+// by the next call.
+func (d *Deliveries) Send(id string, image types.NamespacedName, send func(context.Context) error) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for key, other := range d.deliveries {
+		if other.done && time.Since(other.finished) > deliveryExpiry {
+			delete(d.deliveries, key)
+		}
+	}
+	if existing, ok := d.deliveries[id]; ok {
+		if !existing.done {
+			return false, nil
+		}
+		delete(d.deliveries, id)
+		return true, existing.err
+	}
+	d.deliveries[id] = &delivery{image: image, send: send}
+	d.queue.Add(id)
+	return false, nil
+}
+
+// Start sends the deliveries until ctx is done
+func (d *Deliveries) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("deliveries")
+	for i := 0; i < d.Workers; i++ {
+		go func() {
+			for d.deliverNext(ctx) {
+			}
+		}()
+	}
+	logger.Info("Delivering build events")
+	<-ctx.Done()
+	d.queue.ShutDown()
+	return nil
+}
+
+// NeedLeaderElection makes sure a single replica delivers the events
+func (d *Deliveries) NeedLeaderElection() bool {
+	return true
+}
+
+// deliverNext sends the next delivery of the queue, it returns false once
+// the queue is shut down
+func (d *Deliveries) deliverNext(ctx context.Context) bool {
+	item, shutdown := d.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer d.queue.Done(item)
+	id := item.(string)
+	d.lock.Lock()
+	current, ok := d.deliveries[id]
+	d.lock.Unlock()
+	if !ok {
+		return true
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	err := current.send(sendCtx)
+	cancel()
+
+	d.lock.Lock()
+	current.done = true
+	current.err = err
+	current.finished = time.Now()
+	d.lock.Unlock()
+	select {
+	case d.events <- event.GenericEvent{Object: &osbuildv1alpha1.ImageBuilderImage{
+		ObjectMeta: metav1.ObjectMeta{Namespace: current.image.Namespace, Name: current.image.Name},
+	}}:
+	case <-ctx.Done():
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultCallbackDeniedCIDRs are the networks the callbacks may not reach by
+// default: loopback, link-local, where the cloud metadata endpoints are, and
+// the default pod and service networks of OpenShift and Kubernetes
+var DefaultCallbackDeniedCIDRs = []string{
+	"127.0.0.0/8",
+	"::1/128",
+	"169.254.0.0/16",
+	"fe80::/10",
+	"10.128.0.0/14",
+	"172.30.0.0/16",
+	"10.96.0.0/12",
+	"10.244.0.0/16",
+}
+
+// DestinationFilter restricts the addresses the callbacks of the images
+// connect to. An address in Allowed is always reached; when Allowed is set,
+// no other address is, otherwise every address out of Denied is.
+type DestinationFilter struct {
+	Allowed []*net.IPNet
+	Denied  []*net.IPNet
+
+	once   sync.Once
+	client *http.Client
+}
+
+// NewDestinationFilter returns the filter of comma separated lists of
+// allowed and denied CIDRs
+func NewDestinationFilter(allowed, denied string) (*DestinationFilter, error) {
+	var err error
+	filter := &DestinationFilter{}
+	if filter.Allowed, err = ParseCIDRs(allowed); err != nil {
+		return nil, err
+	}
+	if filter.Denied, err = ParseCIDRs(denied); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// ParseCIDRs parses a comma separated list of CIDRs
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Permits tells if an address may be reached
+func (f *DestinationFilter) Permits(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if f == nil {
+		return true
+	}
+	for _, network := range f.Allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	if len(f.Allowed) > 0 {
+		return false
+	}
+	for _, network := range f.Denied {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return !ip.IsUnspecified()
+}
+
+// Client returns an HTTP client only connecting to the permitted addresses.
+// The addresses are checked once resolved, when connecting, so that a name
+// resolving to a denied address or a redirect to one are refused too. The
+// proxy of the environment is not used, it would hide the destination.
+func (f *DestinationFilter) Client() *http.Client {
+	f.once.Do(f.newClient)
+	return f.client
+}
+
+// newClient builds the client of the filter
+func (f *DestinationFilter) newClient() {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !f.Permits(net.ParseIP(host)) {
+				return fmt.Errorf("callbacks may not reach %s", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+	f.client = &http.Client{Transport: transport, Timeout: deliveryTimeout}
+}
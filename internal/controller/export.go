@@ -0,0 +1,250 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// ExportFormat identifies the version of the export bundle format
+const ExportFormat = "osbuild.rh-ecosystem-edge.io/export/v1"
+
+// The scheduled exports are stored in a ConfigMap per image, named after
+// exportConfigMapPrefix, under exportBundleKey
+const (
+	exportConfigMapPrefix = "osbuild-export-"
+	exportBundleKey       = "bundle.json"
+	exportLabel           = "osbuild-operator-export"
+)
+
+// maxExportSize is the largest bundle stored in a ConfigMap, below the 1MiB
+// limit of the objects
+const maxExportSize = 1000 * 1024
+
+// ExportBundle holds everything needed to recreate the images of a namespace
+// and trace their builds, for backups and migrations
+type ExportBundle struct {
+	Format     string          `json:"format"`
+	ExportedAt metav1.Time     `json:"exportedAt"`
+	Namespace  string          `json:"namespace"`
+	Images     []ExportedImage `json:"images"`
+}
+
+// ExportedImage is an ImageBuilderImage with the blueprints and metadata of
+// its builds
+type ExportedImage struct {
+	Name string                                `json:"name"`
+	Spec osbuildv1alpha1.ImageBuilderImageSpec `json:"spec"`
+	// Blueprints are the rendered blueprints of the last build, by name
+	Blueprints          map[string]string               `json:"blueprints,omitempty"`
+	BlueprintHash       string                          `json:"blueprintHash,omitempty"`
+	ArtifactsGeneration int64                           `json:"artifactsGeneration,omitempty"`
+	Artifacts           []osbuildv1alpha1.BuildArtifact `json:"artifacts,omitempty"`
+	Uploads             []osbuildv1alpha1.UploadStatus  `json:"uploads,omitempty"`
+	// Builds are the data of the build records, oldest generation first
+	Builds []map[string]string `json:"builds,omitempty"`
+}
+
+// Export bundles the images of a namespace with their blueprints and the
+// metadata of their builds and artifacts
+func Export(ctx context.Context, c client.Client, namespace string) (*ExportBundle, error) {
+	images := osbuildv1alpha1.ImageBuilderImageList{}
+	if err := c.List(ctx, &images, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	bundle := ExportBundle{
+		Format:     ExportFormat,
+		ExportedAt: metav1.Now(),
+		Namespace:  namespace,
+		Images:     []ExportedImage{},
+	}
+	for i := range images.Items {
+		exported, err := exportImage(ctx, c, &images.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		bundle.Images = append(bundle.Images, exported)
+	}
+	return &bundle, nil
+}
+
+// exportImage describes an image with its blueprints and the metadata of its
+// builds and artifacts
+func exportImage(ctx context.Context, c client.Client, image *osbuildv1alpha1.ImageBuilderImage) (ExportedImage, error) {
+	exported := ExportedImage{
+		Name:                image.Name,
+		Spec:                image.Spec,
+		BlueprintHash:       image.Status.BlueprintHash,
+		ArtifactsGeneration: image.Status.ArtifactsGeneration,
+		Artifacts:           image.Status.Artifacts,
+		Uploads:             image.Status.Uploads,
+	}
+	// blueprints stored in a Secret embed credentials and are not exported
+	if image.Status.BlueprintConfigMap != "" {
+		blueprints := corev1.ConfigMap{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: image.Namespace, Name: image.Status.BlueprintConfigMap}, &blueprints)
+		if err != nil && !errors.IsNotFound(err) {
+			return exported, err
+		}
+		exported.Blueprints = blueprints.Data
+	}
+	records, err := BuildRecords(ctx, c, image.Namespace, image.Name)
+	if err != nil {
+		return exported, err
+	}
+	for _, record := range records {
+		exported.Builds = append(exported.Builds, record.Data)
+	}
+	return exported, nil
+}
+
+// exportConfigMapName is the name of the ConfigMap holding the scheduled
+// export of an image, the ConfigMap names being longer than the image ones
+func exportConfigMapName(imageName string) string {
+	name := exportConfigMapPrefix + imageName
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		sum := sha256.Sum256([]byte(imageName))
+		suffix := "-" + hex.EncodeToString(sum[:])[:10]
+		name = strings.TrimRight(name[:validation.DNS1123SubdomainMaxLength-len(suffix)], "-.") + suffix
+	}
+	return name
+}
+
+// Exporter periodically stores the export bundle of every image in a
+// ConfigMap of its namespace, a bundle per image keeping each ConfigMap
+// under the size limit of the objects
+type Exporter struct {
+	Client   client.Client
+	Interval time.Duration
+}
+
+var _ manager.LeaderElectionRunnable = &Exporter{}
+
+// NeedLeaderElection makes sure a single replica writes the bundles
+func (e *Exporter) NeedLeaderElection() bool {
+	return true
+}
+
+// Start exports every Interval until the context is cancelled
+func (e *Exporter) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("exporter")
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+	for {
+		if err := e.ExportAll(ctx); err != nil {
+			logger.Error(err, "Could not export images")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExportAll writes the bundles of all the images, deleting the ones of the
+// images that were removed, a failing image not holding back the others
+func (e *Exporter) ExportAll(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("exporter")
+	images := osbuildv1alpha1.ImageBuilderImageList{}
+	if err := e.Client.List(ctx, &images); err != nil {
+		return err
+	}
+	exports := corev1.ConfigMapList{}
+	if err := e.Client.List(ctx, &exports, client.MatchingLabels{exportLabel: "true"}); err != nil {
+		return err
+	}
+	exported := map[client.ObjectKey]bool{}
+	for i := range images.Items {
+		image := &images.Items[i]
+		key := client.ObjectKey{Namespace: image.Namespace, Name: exportConfigMapName(image.Name)}
+		// a failed export keeps the previous one
+		exported[key] = true
+		if err := e.export(ctx, image, key); err != nil {
+			logger.Error(err, "Could not export image", "namespace", image.Namespace, "name", image.Name)
+		}
+	}
+	for i := range exports.Items {
+		configMap := &exports.Items[i]
+		if exported[client.ObjectKeyFromObject(configMap)] {
+			continue
+		}
+		if err := e.Client.Delete(ctx, configMap); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "Could not delete export", "namespace", configMap.Namespace, "name", configMap.Name)
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) export(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, key client.ObjectKey) error {
+	exported, err := exportImage(ctx, e.Client, image)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(ExportBundle{
+		Format:     ExportFormat,
+		ExportedAt: metav1.Now(),
+		Namespace:  image.Namespace,
+		Images:     []ExportedImage{exported},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if len(data) > maxExportSize {
+		return fmt.Errorf("the export of the image is %d bytes, larger than a ConfigMap, download it from the API", len(data))
+	}
+	configMap := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels: map[string]string{
+				exportLabel: "true",
+			},
+		},
+		Data: map[string]string{
+			exportBundleKey: string(data),
+		},
+	}
+	existing := corev1.ConfigMap{}
+	if err := e.Client.Get(ctx, key, &existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return e.Client.Create(ctx, &configMap)
+	}
+	if existing.Labels[exportLabel] != "true" {
+		return fmt.Errorf("ConfigMap %s/%s already exists and was not created by the exporter, skipping the image", key.Namespace, key.Name)
+	}
+	existing.Labels = mergeMaps(existing.Labels, configMap.Labels)
+	existing.Data = configMap.Data
+	return e.Client.Update(ctx, &existing)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+)
+
+var _ = Describe("Scheduled exports", func() {
+	ctx := context.Background()
+	var exporter *Exporter
+	var image *osbuildv1alpha1.ImageBuilderImage
+	var key client.ObjectKey
+
+	BeforeEach(func() {
+		exporter = &Exporter{Client: k8sClient}
+		image = &osbuildv1alpha1.ImageBuilderImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: createNamespace(ctx)},
+			Spec: osbuildv1alpha1.ImageBuilderImageSpec{
+				ComposeType: osbuildv1alpha1.ComposeQcow2,
+			},
+		}
+		Expect(k8sClient.Create(ctx, image)).To(Succeed())
+		key = client.ObjectKey{Namespace: image.Namespace, Name: exportConfigMapName(image.Name)}
+	})
+
+	It("updates its own export", func() {
+		Expect(exporter.export(ctx, image, key)).To(Succeed())
+		Expect(exporter.export(ctx, image, key)).To(Succeed())
+
+		configMap := corev1.ConfigMap{}
+		Expect(k8sClient.Get(ctx, key, &configMap)).To(Succeed())
+		Expect(configMap.Labels).To(HaveKeyWithValue(exportLabel, "true"))
+		Expect(configMap.Data).To(HaveKey(exportBundleKey))
+	})
+
+	It("leaves alone a ConfigMap of the same name it did not create", func() {
+		existing := corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			Data:       map[string]string{"settings": "kept"},
+		}
+		Expect(k8sClient.Create(ctx, &existing)).To(Succeed())
+
+		Expect(exporter.export(ctx, image, key)).To(MatchError(ContainSubstring("was not created by the exporter")))
+
+		configMap := corev1.ConfigMap{}
+		Expect(k8sClient.Get(ctx, key, &configMap)).To(Succeed())
+		Expect(configMap.Labels).NotTo(HaveKey(exportLabel))
+		Expect(configMap.Data).To(Equal(map[string]string{"settings": "kept"}))
+	})
+})
@@ -0,0 +1,105 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resolveFDO sets the URL of the FDO manufacturing server the installer
+// blueprint of a spec renders from spec.fdo, discovering its Service when it
+// is selected. It returns why the image must wait when no Service, or more
+// than one, is selected.
+func (r *ImageBuilderImageReconciler) resolveFDO(ctx context.Context, namespace string, spec *osbuildv1alpha1.ImageBuilderImageSpec) (string, error) {
+	fdo := spec.FDO
+	if fdo == nil {
+		return "", nil
+	}
+	if fdo.ManufacturingServerSelector == nil {
+		spec.FdoManufacturingServerUrl = fdo.ManufacturingServerURL
+		return "", nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(fdo.ManufacturingServerSelector)
+	if err != nil {
+		return "", err
+	}
+	services := corev1.ServiceList{}
+	if err := r.List(ctx, &services, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return "", err
+	}
+	switch len(services.Items) {
+	case 0:
+		return fmt.Sprintf("No Service matches spec.fdo.manufacturingServerSelector %s", selector), nil
+	case 1:
+	default:
+		names := []string{}
+		for _, service := range services.Items {
+			names = append(names, service.Name)
+		}
+		sort.Strings(names)
+		return fmt.Sprintf("%d Services match spec.fdo.manufacturingServerSelector %s, narrow it: %s", len(names), selector, strings.Join(names, ", ")), nil
+	}
+	serverURL, ok := fdoServerURL(&services.Items[0])
+	if !ok {
+		return fmt.Sprintf("Service %s of the FDO manufacturing server has no port", services.Items[0].Name), nil
+	}
+	spec.FdoManufacturingServerUrl = serverURL
+	return "", nil
+}
+
+// fdoServerURL is the URL of the manufacturing server behind a Service, on
+// its port named https or http, or its first port, https being used for the
+// https and 443 ports. The devices reach it through the address of its load
+// balancer, the in-cluster name only being reachable from the cluster.
+func fdoServerURL(service *corev1.Service) (string, bool) {
+	if len(service.Spec.Ports) == 0 {
+		return "", false
+	}
+	port := service.Spec.Ports[0]
+	for _, candidate := range service.Spec.Ports {
+		if candidate.Name == "https" || candidate.Name == "http" {
+			port = candidate
+			break
+		}
+	}
+	scheme := "http"
+	if port.Name == "https" || port.Port == 443 {
+		scheme = "https"
+	}
+	host := fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace)
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.Hostname != "" {
+			host = ingress.Hostname
+			break
+		}
+		if ingress.IP != "" {
+			host = ingress.IP
+			break
+		}
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(int(port.Port)))), true
+}
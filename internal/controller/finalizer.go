@@ -0,0 +1,171 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// imageFinalizer holds the deletion of an ImageBuilderImage until its composes
+// are deleted from composer and its generated resources are gone
+const imageFinalizer = "osbuild.rh-ecosystem-edge.io/cleanup"
+
+// finalizerTimeout bounds how long a deleted image waits for composer to
+// answer before its composes are left behind
+const finalizerTimeout = 10 * time.Minute
+
+// finalizerRetryInterval is how often the composes of a deleted image are
+// deleted again when composer did not answer
+const finalizerRetryInterval = 30 * time.Second
+
+// deleteGeneratedResources deletes the resources labeled with the name of an
+// image, including the ones created before they were owned by it
+func (r *ImageBuilderImageReconciler) deleteGeneratedResources(ctx context.Context, namespace string, name string) error {
+	for _, kind := range []struct{ kind, apiVersion string }{
+		{"PipelineRun", "tekton.dev/v1"},
+		{"Job", "batch/v1"},
+		{"Pipeline", "tekton.dev/v1"},
+		{"Task", "tekton.dev/v1"},
+		{"ConfigMap", "v1"},
+		{"Secret", "v1"},
+		{"Route", "route.openshift.io/v1"},
+		{"Service", "v1"},
+		{"Deployment", "apps/v1"},
+	} {
+		if err := DeleteAllObjectsWithLabel(ctx, r.Client, kind.kind, kind.apiVersion, namespace, imageBuilderImageLabel, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// imageComposes returns the IDs of the composes of the current build and of
+// the artifacts of the last successful one
+func imageComposes(image *osbuildv1alpha1.ImageBuilderImage) []string {
+	ids := []string{}
+	seen := map[string]bool{}
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, compose := range image.Status.Composes {
+		add(compose.ID)
+	}
+	for _, artifact := range image.Status.Artifacts {
+		add(artifact.ComposeID)
+	}
+	return ids
+}
+
+// builderComposer returns the client of the composer API of the builder
+// recorded by the build record of the current build, nil when it is gone
+func (r *ImageBuilderImageReconciler) builderComposer(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage) (*composer.Client, error) {
+	if image.Status.BuildRecord == "" {
+		return nil, nil
+	}
+	record := corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: image.Namespace, Name: image.Status.BuildRecord}, &record); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	namespace, name, found := strings.Cut(record.Data["imageBuilder"], "/")
+	if !found {
+		return nil, nil
+	}
+	builder := osbuildv1alpha1.ImageBuilder{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &builder); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return builderComposerClient(ctx, r.Client, &builder)
+}
+
+// deleteComposes cancels the composes of an image still in progress and
+// deletes them, with their artifacts, from composer
+func deleteComposes(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, composerClient *composer.Client, ids []string) error {
+	for _, compose := range image.Status.Composes {
+		if compose.QueueStatus == composeFinished || compose.QueueStatus == composeFailed {
+			continue
+		}
+		if err := composerClient.Cancel(ctx, compose.ID); err != nil {
+			if apiError, ok := err.(*composer.APIError); !ok || apiError.StatusCode >= 500 {
+				return err
+			}
+		}
+	}
+	// composes deleted by hand are reported as errors, so they are deleted
+	// one by one and only unreachable composers are retried
+	for _, id := range ids {
+		if err := composerClient.Delete(ctx, id); err != nil {
+			if _, ok := err.(*composer.APIError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// finalize cleans up after a deleted image: its composes are deleted from
+// composer, retrying for finalizerTimeout when it does not answer, and its
+// generated resources are deleted before the finalizer is removed
+func (r *ImageBuilderImageReconciler) finalize(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if ids := imageComposes(image); len(ids) > 0 {
+		composerClient, err := r.builderComposer(ctx, image)
+		if err != nil {
+			logger.Error(err, "Could not get the builder of the deleted image")
+			return ctrl.Result{}, err
+		}
+		if composerClient == nil {
+			logger.Info(fmt.Sprintf("The builder of the image is gone, composes %s are left behind", strings.Join(ids, ", ")))
+		} else if err := deleteComposes(ctx, image, composerClient, ids); err != nil {
+			if time.Since(image.DeletionTimestamp.Time) < finalizerTimeout {
+				logger.Info(fmt.Sprintf("Could not delete composes %s, retrying: %s", strings.Join(ids, ", "), err))
+				return ctrl.Result{RequeueAfter: finalizerRetryInterval}, nil
+			}
+			r.Recorder.Event(image, corev1.EventTypeWarning, osbuildv1alpha1.EventCleanupFailed,
+				eventMessage(fmt.Sprintf("Could not delete composes %s from composer: %s", strings.Join(ids, ", "), err)))
+		} else {
+			logger.Info(fmt.Sprintf("Deleted composes %s", strings.Join(ids, ", ")))
+		}
+	}
+
+	if err := r.deleteGeneratedResources(ctx, image.Namespace, image.Name); err != nil {
+		logger.Error(err, "Could not delete generated resources")
+		return ctrl.Result{}, err
+	}
+	if controllerutil.RemoveFinalizer(image, imageFinalizer) {
+		if err := r.Update(ctx, image); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "Could not remove finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var orphanedResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "osbuild_operator_orphaned_resources",
+	Help: "Number of generated resources whose ImageBuilderImage no longer exists, found by the last sweep",
+}, []string{"kind"})
+
+var orphanedResourcesDeletedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "osbuild_operator_orphaned_resources_deleted_total",
+	Help: "Number of generated resources deleted because their ImageBuilderImage no longer exists",
+}, []string{"kind"})
+
+func init() {
+	metrics.Registry.MustRegister(orphanedResources, orphanedResourcesDeletedTotal)
+}
+
+// OrphanCollector periodically looks for the resources labeled for an
+// ImageBuilderImage that no longer exists, e.g. because it was deleted while
+// the operator was not running, and deletes them. In dry run mode it only
+// reports them in the osbuild_operator_orphaned_resources metric.
+type OrphanCollector struct {
+	// Client deletes the orphans
+	Client client.Client
+	// Reader lists the generated resources, usually the uncached API reader
+	// so sweeping does not cache every ConfigMap of the cluster
+	Reader   client.Reader
+	Interval time.Duration
+	DryRun   bool
+}
+
+var _ manager.LeaderElectionRunnable = &OrphanCollector{}
+
+// NeedLeaderElection makes sure a single replica deletes orphans
+func (c *OrphanCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// Start sweeps every Interval until the context is cancelled
+func (c *OrphanCollector) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("orphan-collector")
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Sweep(ctx); err != nil {
+			logger.Error(err, "Could not sweep orphaned resources")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep runs a single collection
+func (c *OrphanCollector) Sweep(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("orphan-collector")
+	// resources of images created during the sweep are not orphans
+	started := time.Now().Add(-time.Minute)
+	images := osbuildv1alpha1.ImageBuilderImageList{}
+	if err := c.Reader.List(ctx, &images); err != nil {
+		return err
+	}
+	existing := map[client.ObjectKey]bool{}
+	for _, image := range images.Items {
+		existing[client.ObjectKeyFromObject(&image)] = true
+	}
+
+	for kind, list := range map[string]client.ObjectList{
+		"ConfigMap":             &corev1.ConfigMapList{},
+		"PersistentVolumeClaim": &corev1.PersistentVolumeClaimList{},
+		"Secret":                &corev1.SecretList{},
+		"Job":                   &batchv1.JobList{},
+		"Task":                  &tektonv1.TaskList{},
+		"Pipeline":              &tektonv1.PipelineList{},
+	} {
+		if err := c.Reader.List(ctx, list, client.HasLabels{imageBuilderImageLabel}); err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return err
+		}
+		objects, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		orphans := 0
+		for _, item := range objects {
+			object := item.(client.Object)
+			if object.GetCreationTimestamp().Time.After(started) {
+				continue
+			}
+			if existing[client.ObjectKey{Namespace: object.GetNamespace(), Name: object.GetLabels()[imageBuilderImageLabel]}] {
+				continue
+			}
+			orphans++
+			if c.DryRun {
+				logger.Info(fmt.Sprintf("Found orphaned %s %s/%s", kind, object.GetNamespace(), object.GetName()))
+				continue
+			}
+			if err := c.Client.Delete(ctx, object, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+				logger.Error(err, fmt.Sprintf("Could not delete orphaned %s %s/%s", kind, object.GetNamespace(), object.GetName()))
+				continue
+			}
+			logger.Info(fmt.Sprintf("Deleted orphaned %s %s/%s", kind, object.GetNamespace(), object.GetName()))
+			orphanedResourcesDeletedTotal.WithLabelValues(kind).Inc()
+		}
+		orphanedResources.WithLabelValues(kind).Set(float64(orphans))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// composerProbeInterval is the first wait before probing again a composer
+// that did not answer
+const composerProbeInterval = 15 * time.Second
+
+// probeComposer checks that composer answers its /api/status endpoint,
+// recording the version of composer and the distributions it builds
+func (r *ImageBuilderReconciler) probeComposer(ctx context.Context, builder *osbuildv1alpha1.ImageBuilder, composerClient *composer.Client) error {
+	logger := log.FromContext(ctx)
+	if builder.Status.Health == nil {
+		builder.Status.Health = &osbuildv1alpha1.ComposerHealth{}
+	}
+	health := builder.Status.Health
+	now := metav1.Now()
+	health.LastProbeTime = now
+	status, err := composerClient.Status(ctx)
+	reachable := composerReachable(builder)
+	if err != nil {
+		health.ConsecutiveFailures++
+		if reachable {
+			r.Recorder.Event(builder, corev1.EventTypeWarning, osbuildv1alpha1.ReasonComposerUnavailable,
+				eventMessage(fmt.Sprintf("Composer stopped answering: %s", err)))
+		}
+		setBuilderCondition(builder, osbuildv1alpha1.ConditionComposerReachable, metav1.ConditionFalse, osbuildv1alpha1.ReasonComposerUnavailable,
+			fmt.Sprintf("Composer did not answer %d probes in a row: %s", health.ConsecutiveFailures, err))
+		return err
+	}
+	if !reachable {
+		r.Recorder.Event(builder, corev1.EventTypeNormal, osbuildv1alpha1.ReasonComposerResponding,
+			fmt.Sprintf("Composer answers again after %d failed probes", health.ConsecutiveFailures))
+	}
+	health.ConsecutiveFailures = 0
+	health.LastReachableTime = &now
+	health.API = status.API
+	health.Backend = status.Backend
+	builder.Status.ComposerVersion = status.Build
+	setBuilderCondition(builder, osbuildv1alpha1.ConditionComposerReachable, metav1.ConditionTrue, osbuildv1alpha1.ReasonComposerResponding,
+		fmt.Sprintf("Composer %s serves API %s", status.Build, status.API))
+	if distros, err := composerClient.Distros(ctx); err != nil {
+		logger.Error(err, "Could not get composer distributions")
+	} else {
+		builder.Status.Distros = distros
+	}
+	return nil
+}
+
+// composerReachable tells if composer answered the last probe of its
+// builder, assuming it does until the builder was probed
+func composerReachable(builder *osbuildv1alpha1.ImageBuilder) bool {
+	condition := meta.FindStatusCondition(builder.Status.Conditions, osbuildv1alpha1.ConditionComposerReachable)
+	return condition == nil || condition.Status == metav1.ConditionTrue
+}
+
+// composerBackoff is how long to wait before trying again a composer of
+// builder that does not answer, doubling with every failed probe up to the
+// inventory interval
+func composerBackoff(builder *osbuildv1alpha1.ImageBuilder) time.Duration {
+	backoff := composerProbeInterval
+	if builder.Status.Health == nil {
+		return backoff
+	}
+	for i := int32(1); i < builder.Status.Health.ConsecutiveFailures && backoff < inventoryInterval; i++ {
+		backoff *= 2
+	}
+	if backoff > inventoryInterval {
+		return inventoryInterval
+	}
+	return backoff
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// defaultHistoryLimit is how many builds are kept when spec.historyLimit is
+// not set
+const defaultHistoryLimit = 10
+
+// blueprintVersionRe matches the top-level version of a TOML blueprint
+var blueprintVersionRe = regexp.MustCompile(`(?m)^version\s*=\s*"([^"]*)"[ \t]*$`)
+
+// blueprintVersion returns the version set by a blueprint, empty when it sets
+// none
+func blueprintVersion(blueprint string) string {
+	if match := blueprintVersionRe.FindStringSubmatch(blueprint); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// withBlueprintVersion sets the version of a blueprint
+func withBlueprintVersion(blueprint string, version string) string {
+	line := fmt.Sprintf("version = %q", version)
+	if location := blueprintVersionRe.FindStringIndex(blueprint); location != nil {
+		return blueprint[:location[0]] + line + blueprint[location[1]:]
+	}
+	// top-level keys come before the first table
+	return line + "\n" + blueprint
+}
+
+// parseVersion splits a major.minor.patch version, ok being false when it is
+// not one
+func parseVersion(version string) ([3]int, bool) {
+	parsed := [3]int{}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return parsed, false
+		}
+		parsed[i] = number
+	}
+	return parsed, true
+}
+
+// versionLess tells if version a is lower than version b
+func versionLess(a [3]int, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// nextBlueprintVersion is the version the blueprints of a new build are
+// pushed with: the highest version set by the rendered blueprints when it is
+// above the previous one, the previous one with its patch level bumped
+// otherwise
+func nextBlueprintVersion(previous string, blueprints map[string]string) string {
+	rendered, found := [3]int{0, 0, 1}, false
+	for _, blueprint := range blueprints {
+		if version, ok := parseVersion(blueprintVersion(blueprint)); ok && (!found || versionLess(rendered, version)) {
+			rendered, found = version, true
+		}
+	}
+	last, ok := parseVersion(previous)
+	if !ok || versionLess(last, rendered) {
+		return fmt.Sprintf("%d.%d.%d", rendered[0], rendered[1], rendered[2])
+	}
+	return fmt.Sprintf("%d.%d.%d", last[0], last[1], last[2]+1)
+}
+
+// versionedBlueprints returns the blueprints set to version
+func versionedBlueprints(blueprints map[string]string, version string) map[string]string {
+	versioned := make(map[string]string, len(blueprints))
+	for name, blueprint := range blueprints {
+		versioned[name] = withBlueprintVersion(blueprint, version)
+	}
+	return versioned
+}
+
+// addHistoryEntry records a build just created at the top of the history, a
+// build still running being superseded by it
+func addHistoryEntry(image *osbuildv1alpha1.ImageBuilderImage, build string, composeType osbuildv1alpha1.ComposeType) {
+	for i := range image.Status.History {
+		if entry := &image.Status.History[i]; entry.Result == osbuildv1alpha1.BuildRunning {
+			entry.Result = osbuildv1alpha1.BuildSuperseded
+		}
+	}
+	entry := osbuildv1alpha1.BuildHistoryEntry{
+		Build:            build,
+		Generation:       image.Generation,
+		BlueprintVersion: image.Status.BlueprintVersion,
+		ComposeType:      composeType,
+		Result:           osbuildv1alpha1.BuildRunning,
+	}
+	image.Status.History = append([]osbuildv1alpha1.BuildHistoryEntry{entry}, image.Status.History...)
+}
+
+// historyEntry returns the running entry of the history for a build
+func historyEntry(image *osbuildv1alpha1.ImageBuilderImage, build string) *osbuildv1alpha1.BuildHistoryEntry {
+	for i := range image.Status.History {
+		entry := &image.Status.History[i]
+		if entry.Build == build && entry.Result == osbuildv1alpha1.BuildRunning {
+			return entry
+		}
+	}
+	return nil
+}
+
+// setHistoryEntry sets the result of the current build, the named
+// PipelineRun or Job, in the history. Its ImageBuilderCompose reports the
+// rest.
+func setHistoryEntry(image *osbuildv1alpha1.ImageBuilderImage, build string, result osbuildv1alpha1.BuildResult) {
+	if entry := historyEntry(image, build); entry != nil {
+		entry.Result = result
+	}
+}
+
+// pruneHistory drops the builds beyond spec.historyLimit from the history,
+// deleting their PipelineRun or Job, unless it is the current one, and their
+// composes, read from their ImageBuilderCompose, unless they produced the
+// current artifacts. Composes composer could not delete are left behind.
+func (r *ImageBuilderImageReconciler) pruneHistory(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, composerClient *composer.Client, current string) error {
+	logger := log.FromContext(ctx)
+	limit := int(image.Spec.HistoryLimit)
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if len(image.Status.History) <= limit {
+		return nil
+	}
+	dropped := image.Status.History[limit:]
+	image.Status.History = image.Status.History[:limit]
+
+	kept := map[string]bool{}
+	for _, artifact := range image.Status.Artifacts {
+		kept[artifact.ComposeID] = true
+	}
+	for _, entry := range image.Status.History {
+		kept[entry.Build] = true
+	}
+	ids := []string{}
+	for _, entry := range dropped {
+		key := client.ObjectKey{Namespace: image.Namespace, Name: entry.Build}
+		compose := osbuildv1alpha1.ImageBuilderCompose{}
+		if err := r.Get(ctx, key, &compose); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		if compose.Spec.Image != image.Name {
+			compose.Status.ComposeIDs = nil
+		}
+		if !kept[entry.Build] && entry.Build != current {
+			if err := deleteGeneratedObject(ctx, r.Client, key, &batchv1.Job{}, image.Name); err != nil {
+				return err
+			}
+			if r.Tekton {
+				if err := deleteGeneratedObject(ctx, r.Client, key, &tektonv1.PipelineRun{}, image.Name); err != nil {
+					return err
+				}
+			}
+			if err := deleteGeneratedObject(ctx, r.Client, key, &osbuildv1alpha1.ImageBuilderCompose{}, image.Name); err != nil {
+				return err
+			}
+		}
+		for _, id := range compose.Status.ComposeIDs {
+			if !kept[id] {
+				ids = append(ids, id)
+				key := client.ObjectKey{Namespace: image.Namespace, Name: composeLogConfigMapName(image.Name, id)}
+				if err := deleteGeneratedObject(ctx, r.Client, key, &corev1.ConfigMap{}, image.Name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := composerClient.Delete(ctx, ids...); err != nil {
+		logger.Error(err, "Could not delete composes of pruned builds")
+		return nil
+	}
+	logger.Info(fmt.Sprintf("Deleted composes [%s] of builds beyond the history limit", strings.Join(ids, ", ")))
+	return nil
+}
@@ -26,13 +26,20 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	"text/template"
 
 	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/pointer"
@@ -40,24 +47,47 @@ import (
 	"kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 )
 
-const defaultSubscriptionSecretName = "osbuild-subscription-secret"
-const defaultImageBuilderPort int32 = 8080
+const defaultSubscriptionSecretName = osbuildv1alpha1.DefaultSubscriptionSecretName
+const defaultImageBuilderPort = osbuildv1alpha1.DefaultServicePort
 const imageBuilderLabel = "osbuild-operator-builder"
+const builderDataSource = "rhel9"
+const builderDataSourceNamespace = "openshift-virtualization-os-images"
 
 // ImageBuilderReconciler reconciles a ImageBuilder object
 type ImageBuilderReconciler struct {
 	client.Client
-	Scheme      *runtime.Scheme
-	servicePort int32
-	sshKey      string
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// MaxConcurrentReconciles is how many builders are reconciled at once, 1
+	// when not set
+	MaxConcurrentReconciles int
+	// RateLimiter delays the requeues of the builders, the default one of
+	// controller-runtime when not set
+	RateLimiter workqueue.RateLimiter
+}
+
+// builderReconcile holds the state of the reconcile of a builder, every
+// reconcile having its own to run concurrently
+type builderReconcile struct {
+	*ImageBuilderReconciler
+	servicePort  int32
+	sshKey       string
+	architecture osbuildv1alpha1.Architecture
 }
 
 //+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilders,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilders/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilders/finalizers,verbs=update
-//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;delete
-//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;create;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;patch;delete
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;patch;delete
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;patch;delete
 //+kubebuilder:rbac:groups=kubevirt.io,resources=virtualmachines,verbs=get;list;create;delete
+//+kubebuilder:rbac:groups=kubevirt.io,resources=virtualmachineinstances,verbs=get
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;create;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -69,6 +99,11 @@ type ImageBuilderReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.15.0/pkg/reconcile
 func (r *ImageBuilderReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return (&builderReconcile{ImageBuilderReconciler: r}).reconcile(ctx, req)
+}
+
+// reconcile reconciles a builder with the state of its own reconcile
+func (r *builderReconcile) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
 	labels := map[string]string{
@@ -79,15 +114,28 @@ func (r *ImageBuilderReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	if err := r.Get(ctx, req.NamespacedName, &imageBuilder); err != nil {
 		if errors.IsNotFound(err) {
 			logger.Info("Resource not found, must have been deleted")
-			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Service", "v1", imageBuilderLabel, req.Name); err != nil {
+			forgetBuilderClient(req.NamespacedName)
+			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Service", "v1", req.Namespace, imageBuilderLabel, req.Name); err != nil {
 				logger.Error(err, "Could not delete services")
 				return ctrl.Result{}, err
 			}
-			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "VirtualMachine", "kubevirt.io/v1", imageBuilderLabel, req.Name); err != nil {
+			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "VirtualMachine", "kubevirt.io/v1", req.Namespace, imageBuilderLabel, req.Name); err != nil {
 				logger.Error(err, "Could not delete vm")
 				return ctrl.Result{}, err
 			}
-			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Secret", "v1", imageBuilderLabel, req.Name); err != nil {
+			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Deployment", "apps/v1", req.Namespace, imageBuilderLabel, req.Name); err != nil {
+				logger.Error(err, "Could not delete composer deployment")
+				return ctrl.Result{}, err
+			}
+			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Route", "route.openshift.io/v1", req.Namespace, imageBuilderLabel, req.Name); err != nil {
+				logger.Error(err, "Could not delete ostree repository route")
+				return ctrl.Result{}, err
+			}
+			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "PersistentVolumeClaim", "v1", req.Namespace, imageBuilderLabel, req.Name); err != nil {
+				logger.Error(err, "Could not delete composer state and ostree repository volumes")
+				return ctrl.Result{}, err
+			}
+			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Secret", "v1", req.Namespace, imageBuilderLabel, req.Name); err != nil {
 				logger.Error(err, "Could not delete vm")
 				return ctrl.Result{}, err
 			}
@@ -96,6 +144,10 @@ func (r *ImageBuilderReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		logger.Error(err, "Unable to fetch ImageBuilder")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	if err := r.labelArchitecture(ctx, &imageBuilder); err != nil {
+		logger.Error(err, "Could not label ImageBuilder with its architecture")
+		return ctrl.Result{}, err
+	}
 
 	if imageBuilder.Spec.ServicePort == 0 {
 		logger.Info(fmt.Sprintf("spec.servicePort is not set, using default %v", defaultImageBuilderPort))
@@ -104,6 +156,122 @@ func (r *ImageBuilderReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		r.servicePort = imageBuilder.Spec.ServicePort
 	}
 
+	selector := map[string]string{
+		"vm.kubevirt.io/name": imageBuilder.Name,
+	}
+	if builderRuntime(&imageBuilder) == osbuildv1alpha1.RuntimeDeployment {
+		selector = labels
+	}
+	service := r.composerService(metav1.ObjectMeta{
+		Name:      imageBuilder.Name,
+		Namespace: imageBuilder.Namespace,
+		Labels:    labels,
+	}, selector)
+	logger.Info("Applying service object")
+	if err := ApplyObject(ctx, r.Client, &service, true); err != nil {
+		logger.Error(err, "Could not apply Image Builder Service")
+		return ctrl.Result{}, err
+	}
+	composerClient, err := builderComposerClient(ctx, r.Client, &imageBuilder)
+	if err != nil {
+		logger.Error(err, "Could not read composer API credentials")
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileOSTreeRepository(ctx, &imageBuilder, labels); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	imageBuilder.Status.Runtime = builderRuntime(&imageBuilder)
+	if imageBuilder.Status.Runtime == osbuildv1alpha1.RuntimeDeployment {
+		// the virtual machine is replaced by the Deployment
+		if err := r.deleteRuntimeObjects(ctx,
+			&kubevirt.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: imageBuilder.Name, Namespace: imageBuilder.Namespace}},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-cloudconfig", req.Name), Namespace: req.Namespace}},
+		); err != nil {
+			logger.Error(err, "Could not delete Image Builder VM")
+			return ctrl.Result{}, err
+		}
+		if deployed, result, err := r.deployComposer(ctx, &imageBuilder, labels); !deployed {
+			return result, err
+		}
+	} else {
+		name := composerDeploymentName(imageBuilder.Name)
+		if err := r.deleteRuntimeObjects(ctx,
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: imageBuilder.Namespace}},
+			&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: imageBuilder.Namespace}},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: imageBuilder.Namespace}},
+		); err != nil {
+			logger.Error(err, "Could not delete composer deployment")
+			return ctrl.Result{}, err
+		}
+		imageBuilder.Status.ReadyWorkers = 0
+		if running, result, err := r.runVM(ctx, &imageBuilder, labels, composerClient); !running {
+			return result, err
+		}
+	}
+
+	owned, err := r.ownedBlueprints(ctx)
+	if err != nil {
+		logger.Error(err, "Could not list ImageBuilderImages")
+		return ctrl.Result{}, err
+	}
+	// composer only answers once the VM booted or the Deployment rolled out,
+	// keep the last inventory until then
+	err = r.probeComposer(ctx, &imageBuilder, composerClient)
+	var inventory *osbuildv1alpha1.ComposerInventory
+	if err == nil {
+		inventory, err = composerInventory(ctx, composerClient, owned)
+	}
+	if err != nil {
+		logger.Error(err, "Could not get composer inventory")
+		message := fmt.Sprintf("Could not get composer inventory: %s", err)
+		setBuilderCondition(&imageBuilder, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonComposerUnavailable, message)
+		if err := r.Status().Update(ctx, &imageBuilder); err != nil {
+			logger.Error(err, "Could not update ImageBuilder status")
+			return ctrl.Result{}, err
+		}
+		// an upgrade is only over once the new composer answers
+		if builderUpgrading(&imageBuilder) {
+			return ctrl.Result{RequeueAfter: upgradeRequeueInterval}, nil
+		}
+		return ctrl.Result{RequeueAfter: composerBackoff(&imageBuilder)}, nil
+	}
+	// a replaced composer starts empty, push back what the cluster declares
+	restored, restoreErr := r.restoreBlueprints(ctx, &imageBuilder, composerClient)
+	if restoreErr != nil {
+		logger.Error(restoreErr, "Could not restore blueprints")
+	}
+	inventory.Blueprints += int32(len(restored))
+	imageBuilder.Status.Inventory = inventory
+	composeTypes, composeTypesErr := composerClient.ComposeTypes(ctx)
+	if composeTypesErr != nil {
+		logger.Error(composeTypesErr, "Could not get composer compose types")
+	} else {
+		imageBuilder.Status.ComposeTypes = composeTypes
+	}
+	r.composerReady(&imageBuilder)
+	if err := r.Status().Update(ctx, &imageBuilder); err != nil {
+		logger.Error(err, "Could not update ImageBuilder status")
+		return ctrl.Result{}, err
+	}
+	// retried with backoff instead of waiting for the next inventory
+	if restoreErr != nil {
+		return ctrl.Result{}, restoreErr
+	}
+	if composeTypesErr != nil {
+		return ctrl.Result{}, composeTypesErr
+	}
+
+	return ctrl.Result{RequeueAfter: inventoryInterval}, nil
+}
+
+// runVM creates the virtual machine installing composer from RPMs with
+// cloud-init, upgrading it when spec.composerVersion changed. The builder is
+// only queried once it returns true, Reconcile returning the result and error
+// otherwise.
+func (r *builderReconcile) runVM(ctx context.Context, imageBuilder *osbuildv1alpha1.ImageBuilder, labels map[string]string, composerClient *composer.Client) (bool, ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
 	var subscriptionSecretName string //this is where we get the RH sub secret
 	if imageBuilder.Spec.SubscriptionSecretName == "" {
 		logger.Info(fmt.Sprintf("spec.subscriptionSecret is not set, using default %s", defaultSubscriptionSecretName))
@@ -114,26 +282,27 @@ func (r *ImageBuilderReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	subscriptionSecret := &corev1.Secret{}
 
 	err := r.Get(ctx, client.ObjectKey{
-		Namespace: req.NamespacedName.Namespace,
+		Namespace: imageBuilder.Namespace,
 		Name:      subscriptionSecretName,
 	}, subscriptionSecret)
 	if err != nil {
 		logger.Error(err, "Could not get subscriptionSecret")
-		return ctrl.Result{}, err
+		return false, ctrl.Result{}, err
 	}
 
 	r.sshKey = imageBuilder.Spec.SshKey
+	r.architecture = imageBuilder.Spec.Architecture
 	cloudConfigSecret := r.cloudInitData(metav1.ObjectMeta{
-		Name:      fmt.Sprintf("%s-cloudconfig", req.Name),
-		Namespace: req.Namespace,
+		Name:      fmt.Sprintf("%s-cloudconfig", imageBuilder.Name),
+		Namespace: imageBuilder.Namespace,
 		Labels:    labels,
-	}, *subscriptionSecret)
+	}, *subscriptionSecret, imageBuilder.Spec.ComposerVersion)
 	if err := r.Client.Create(ctx, &cloudConfigSecret); err != nil {
 		if errors.IsAlreadyExists(err) {
 			logger.Info("Secret already exists")
 		} else {
 			logger.Error(err, "Could not create secret")
-			return ctrl.Result{}, err
+			return false, ctrl.Result{}, err
 		}
 	}
 
@@ -142,6 +311,9 @@ func (r *ImageBuilderReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		Name:      imageBuilder.Name,
 		Namespace: imageBuilder.Namespace,
 		Labels:    labels,
+		Annotations: map[string]string{
+			composerVersionAnnotation: imageBuilder.Spec.ComposerVersion,
+		},
 	}, cloudConfigSecret)
 	logger.Info("Creating VM object")
 	if err := r.Create(ctx, &vm); err != nil {
@@ -149,29 +321,40 @@ func (r *ImageBuilderReconciler) Reconcile(ctx context.Context, req ctrl.Request
 			logger.Info("Image Builder VM already exists, skipping creation")
 		} else {
 			logger.Error(err, "Could not create Image Builder VM")
-			return ctrl.Result{}, err
+			return false, ctrl.Result{}, err
 		}
 	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&vm), &vm); err != nil {
+		logger.Error(err, "Could not get Image Builder VM")
+		return false, ctrl.Result{}, err
+	}
+	if vm.DeletionTimestamp != nil {
+		logger.Info("Waiting for the previous Image Builder VM to be deleted")
+		return false, ctrl.Result{RequeueAfter: upgradeRequeueInterval}, nil
+	}
 
-	service := r.createVMService(metav1.ObjectMeta{
-		Name:      imageBuilder.Name,
-		Namespace: imageBuilder.Namespace,
-		Labels:    labels,
-	})
-	logger.Info("Creating service object")
-	if err := r.Create(ctx, &service); err != nil {
-		if errors.IsAlreadyExists(err) {
-			logger.Info("Image Builder VM already exists, skipping creation")
-		} else {
-			logger.Error(err, "Could not create Image Builder Service")
-			return ctrl.Result{}, err
-		}
+	if needsUpgrade(imageBuilder, &vm) {
+		result, err := r.upgradeComposer(ctx, imageBuilder, &vm, &cloudConfigSecret, composerClient)
+		return false, result, err
 	}
 
-	return ctrl.Result{}, nil
+	if mismatch, err := r.checkArchitecture(ctx, imageBuilder, &vm); err != nil {
+		logger.Error(err, "Could not check the architecture of the Image Builder VM")
+		return false, ctrl.Result{}, err
+	} else if mismatch != "" {
+		logger.Error(nil, mismatch)
+		setBuilderCondition(imageBuilder, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonArchitectureMismatch, mismatch)
+		if err := r.Status().Update(ctx, imageBuilder); err != nil {
+			logger.Error(err, "Could not update ImageBuilder status")
+			return false, ctrl.Result{}, err
+		}
+		return false, ctrl.Result{RequeueAfter: inventoryInterval}, nil
+	}
+	return true, ctrl.Result{}, nil
 }
 
-func (r *ImageBuilderReconciler) createVMService(objectMeta metav1.ObjectMeta) corev1.Service {
+// composerService exposes the composer API of the pods matching selector
+func (r *builderReconcile) composerService(objectMeta metav1.ObjectMeta, selector map[string]string) corev1.Service {
 	service := corev1.Service{
 		ObjectMeta: objectMeta,
 		Spec: corev1.ServiceSpec{
@@ -181,26 +364,26 @@ func (r *ImageBuilderReconciler) createVMService(objectMeta metav1.ObjectMeta) c
 					Port:     int32(r.servicePort),
 				},
 			},
-			Selector: map[string]string{
-				"vm.kubevirt.io/name": objectMeta.Name,
-			},
+			Selector: selector,
 		},
 	}
 
 	return service
 }
 
-func (r *ImageBuilderReconciler) cloudInitData(objectMeta metav1.ObjectMeta, subSecret corev1.Secret) corev1.Secret {
+func (r *builderReconcile) cloudInitData(objectMeta metav1.ObjectMeta, subSecret corev1.Secret, composerVersion string) corev1.Secret {
 
 	type templateValues struct {
-		Username string
-		Password string
-		SshKey   string
+		Username        string
+		Password        string
+		SshKey          string
+		ComposerVersion string
 	}
 	values := templateValues{
-		Username: string(subSecret.Data["username"]),
-		Password: string(subSecret.Data["password"]),
-		SshKey:   r.sshKey,
+		Username:        string(subSecret.Data["username"]),
+		Password:        string(subSecret.Data["password"]),
+		SshKey:          r.sshKey,
+		ComposerVersion: composerVersion,
 	}
 	const configTemplate = `#cloud-config
 user: cloud-user
@@ -229,7 +412,7 @@ write_files:
       [Install]
       WantedBy=multi-user.target
 runcmd:
-  - [dnf, install, -y, osbuild-composer, composer-cli, socat]
+  - [dnf, install, -y, osbuild-composer{{if ne .ComposerVersion ""}}-{{.ComposerVersion}}{{end}}, composer-cli, socat]
   - [systemctl, daemon-reload]
   - [systemctl, enable, --now, osbuild-composer.socket, osbuild-proxy]
 	`
@@ -250,7 +433,7 @@ runcmd:
 	return secret
 }
 
-func (r *ImageBuilderReconciler) createVM(objectMeta metav1.ObjectMeta, cloudSecret corev1.Secret) kubevirt.VirtualMachine {
+func (r *builderReconcile) createVM(objectMeta metav1.ObjectMeta, cloudSecret corev1.Secret) kubevirt.VirtualMachine {
 	rootVolumeName := fmt.Sprintf("%s-vm-volume", objectMeta.Name)
 
 	dataVolumeTemplateSpec := kubevirt.DataVolumeTemplateSpec{}
@@ -259,8 +442,8 @@ func (r *ImageBuilderReconciler) createVM(objectMeta metav1.ObjectMeta, cloudSec
 	dataVolumeTemplateSpec.Spec.SourceRef = &v1beta1.DataVolumeSourceRef{
 		Kind: "DataSource",
 	}
-	dataVolumeTemplateSpec.Spec.SourceRef.Name = "rhel9"
-	dataVolumeTemplateSpecNamespace := "openshift-virtualization-os-images"
+	dataVolumeTemplateSpec.Spec.SourceRef.Name = builderDataSource
+	dataVolumeTemplateSpecNamespace := builderDataSourceNamespace
 	dataVolumeTemplateSpec.Spec.SourceRef.Namespace = &dataVolumeTemplateSpecNamespace
 
 	dataVolumeTemplateSpec.Spec.Storage = &v1beta1.StorageSpec{
@@ -335,6 +518,8 @@ func (r *ImageBuilderReconciler) createVM(objectMeta metav1.ObjectMeta, cloudSec
 		Rng:                        &kubevirt.Rng{},
 	}
 
+	vmInstanceTemplateSpec.Spec.NodeSelector = architectureSelector(r.architecture)
+
 	vmInstanceTemplateSpec.Spec.Networks = []kubevirt.Network{
 		{
 			Name: "default",
@@ -385,7 +570,24 @@ func (r *ImageBuilderReconciler) createVM(objectMeta metav1.ObjectMeta, cloudSec
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ImageBuilderReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// the blueprints to restore are the ones of the build records of the
+	// builder
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.ConfigMap{}, buildRecordBuilderField, buildRecordBuilder); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&osbuildv1alpha1.ImageBuilder{}).
+		// the inventory refreshes status periodically, do not reconcile again
+		// for it, only for the changes of the spec, labels and annotations
+		For(&osbuildv1alpha1.ImageBuilder{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.LabelChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
+		WithOptions(crcontroller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             r.RateLimiter,
+		}).
+		// the Deployment of composer reports when it becomes available
+		Owns(&appsv1.Deployment{}).
 		Complete(r)
 }
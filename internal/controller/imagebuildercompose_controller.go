@@ -0,0 +1,328 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/apis"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+)
+
+// ImageBuilderComposeReconciler reconciles an ImageBuilderCompose object by
+// reporting the build of its image it stands for
+type ImageBuilderComposeReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Tekton is set when the Tekton CRDs are installed, the PipelineRuns
+	// being watched
+	Tekton bool
+}
+
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuildercomposes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuildercomposes/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=tekton.dev,resources=pipelineruns,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=tekton.dev,resources=taskruns,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+// Reconcile sets the status of the compose from its PipelineRun or Job, which
+// it owns, and from the current build of the image while it is the one
+func (r *ImageBuilderComposeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var compose osbuildv1alpha1.ImageBuilderCompose
+	if err := r.Get(ctx, req.NamespacedName, &compose); err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Resource not found, must have been deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "Unable to fetch ImageBuilderCompose")
+		return ctrl.Result{}, err
+	}
+	image := osbuildv1alpha1.ImageBuilderImage{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: compose.Namespace, Name: compose.Spec.Image}, &image); err != nil {
+		if errors.IsNotFound(err) {
+			// deleted with its image
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "Could not get ImageBuilderImage")
+		return ctrl.Result{}, err
+	}
+
+	status := *compose.Status.DeepCopy()
+	if err := r.buildStatus(ctx, &compose, &status); err != nil {
+		logger.Error(err, "Could not get the build of ImageBuilderCompose")
+		return ctrl.Result{}, err
+	}
+	composeStatus(&status, &image, currentBuild(&image, compose.Spec.BuildKind) == compose.Spec.Build)
+	if reflect.DeepEqual(status, compose.Status) {
+		return ctrl.Result{}, nil
+	}
+	compose.Status = status
+	if err := r.Status().Update(ctx, &compose); err != nil {
+		logger.Error(err, "Could not update ImageBuilderCompose status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// buildStatus sets the start and completion times, the result and the
+// composes of a build from its PipelineRun or Job, adopting it. A build
+// deleted while it ran was superseded, the last status of a build deleted
+// once done is kept.
+func (r *ImageBuilderComposeReconciler) buildStatus(ctx context.Context, compose *osbuildv1alpha1.ImageBuilderCompose, status *osbuildv1alpha1.ImageBuilderComposeStatus) error {
+	job := compose.Spec.BuildKind == osbuildv1alpha1.BuildKindJob
+	if !job && !r.Tekton {
+		return nil
+	}
+	var build client.Object = &tektonv1.PipelineRun{}
+	if job {
+		build = &batchv1.Job{}
+	}
+	key := client.ObjectKey{Namespace: compose.Namespace, Name: compose.Spec.Build}
+	if err := r.Get(ctx, key, build); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if status.Result == "" || status.Result == osbuildv1alpha1.BuildRunning {
+			now := metav1.Now()
+			status.CompletionTime = &now
+			status.Result = osbuildv1alpha1.BuildSuperseded
+		}
+		return nil
+	}
+	// a build of the same name left by another image is not the one of the
+	// compose
+	if build.GetLabels()[imageBuilderImageLabel] != compose.Spec.Image {
+		return nil
+	}
+	if err := r.adoptBuild(ctx, compose, build); err != nil {
+		return err
+	}
+	switch build := build.(type) {
+	case *tektonv1.PipelineRun:
+		status.StartTime = build.Status.StartTime
+		status.CompletionTime = build.Status.CompletionTime
+		status.Result = pipelineRunResult(build)
+	case *batchv1.Job:
+		status.StartTime = build.Status.StartTime
+		status.CompletionTime = jobCompletionTime(build)
+		status.Result = jobResult(build)
+	}
+	if status.StartTime == nil {
+		return nil
+	}
+	ids, err := buildComposeIDs(ctx, r.Client, compose.Namespace, compose.Spec.Build, job)
+	if err != nil {
+		return err
+	}
+	// the pods of a Job may be gone, the composes they reported are kept
+	for _, id := range ids {
+		found := false
+		for _, known := range status.ComposeIDs {
+			found = found || known == id
+		}
+		if !found {
+			status.ComposeIDs = append(status.ComposeIDs, id)
+		}
+	}
+	return nil
+}
+
+// adoptBuild makes a compose an owner of its PipelineRun or Job, next to the
+// image controlling it
+func (r *ImageBuilderComposeReconciler) adoptBuild(ctx context.Context, compose *osbuildv1alpha1.ImageBuilderCompose, build client.Object) error {
+	for _, owner := range build.GetOwnerReferences() {
+		if owner.UID == compose.UID {
+			return nil
+		}
+	}
+	patch := client.MergeFromWithOptions(build.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+	build.SetOwnerReferences(append(build.GetOwnerReferences(), metav1.OwnerReference{
+		APIVersion: osbuildv1alpha1.GroupVersion.String(),
+		Kind:       "ImageBuilderCompose",
+		Name:       compose.Name,
+		UID:        compose.UID,
+	}))
+	return r.Patch(ctx, build, patch)
+}
+
+// pipelineRunResult is the result of a build run by a PipelineRun, a
+// cancelled one having been superseded
+func pipelineRunResult(pipelineRun *tektonv1.PipelineRun) osbuildv1alpha1.BuildResult {
+	switch {
+	case pipelineRun.IsCancelled():
+		return osbuildv1alpha1.BuildSuperseded
+	case !pipelineRun.IsDone():
+		return osbuildv1alpha1.BuildRunning
+	case pipelineRun.Status.GetCondition(apis.ConditionSucceeded).IsTrue():
+		return osbuildv1alpha1.BuildSucceeded
+	}
+	return osbuildv1alpha1.BuildFailed
+}
+
+// jobResult is the result of a build run by a Job
+func jobResult(job *batchv1.Job) osbuildv1alpha1.BuildResult {
+	switch {
+	case jobCondition(job, batchv1.JobComplete) != nil:
+		return osbuildv1alpha1.BuildSucceeded
+	case jobCondition(job, batchv1.JobFailed) != nil:
+		return osbuildv1alpha1.BuildFailed
+	}
+	return osbuildv1alpha1.BuildRunning
+}
+
+// jobCompletionTime is when a Job ended, Kubernetes only setting its
+// completion time when it succeeded
+func jobCompletionTime(job *batchv1.Job) *metav1.Time {
+	if job.Status.CompletionTime != nil {
+		return job.Status.CompletionTime
+	}
+	if failed := jobCondition(job, batchv1.JobFailed); failed != nil {
+		return &failed.LastTransitionTime
+	}
+	return nil
+}
+
+// currentBuild is the name of the current build of an image run by a
+// resource of kind
+func currentBuild(image *osbuildv1alpha1.ImageBuilderImage, kind osbuildv1alpha1.BuildKind) string {
+	if kind == osbuildv1alpha1.BuildKindJob {
+		return image.Status.Job
+	}
+	return image.Status.PipelineRun
+}
+
+// composeStatus sets the logs of a build in the status of its compose, while
+// its artifacts are served, and its composes and failure while it is the
+// current build of the image
+func composeStatus(status *osbuildv1alpha1.ImageBuilderComposeStatus, image *osbuildv1alpha1.ImageBuilderImage, current bool) {
+	ids := map[string]bool{}
+	for _, id := range status.ComposeIDs {
+		ids[id] = true
+	}
+	status.Logs = ""
+	for _, artifact := range image.Status.Artifacts {
+		if artifact.Type == osbuildv1alpha1.ArtifactLogs && ids[artifact.ComposeID] && artifact.Location != "" {
+			status.Logs = artifact.Location
+			break
+		}
+	}
+	status.Composes = nil
+	if !current {
+		return
+	}
+	for _, compose := range image.Status.Composes {
+		if ids[compose.ID] {
+			status.Composes = append(status.Composes, compose)
+		}
+	}
+	status.Message = ""
+	if status.Result == osbuildv1alpha1.BuildFailed {
+		if ready := meta.FindStatusCondition(image.Status.Conditions, osbuildv1alpha1.ConditionReady); ready != nil {
+			status.Message = ready.Message
+		}
+	}
+}
+
+// newImageBuilderCompose returns the compose standing for the build of an
+// image just started, owned by the image
+func newImageBuilderCompose(objectMeta metav1.ObjectMeta, image *osbuildv1alpha1.ImageBuilderImage, kind osbuildv1alpha1.BuildKind, build string, buildRecord string, composeType osbuildv1alpha1.ComposeType) *osbuildv1alpha1.ImageBuilderCompose {
+	objectMeta.Name = build
+	objectMeta.Labels = mergeMaps(objectMeta.Labels, map[string]string{
+		imageBuilderImageGenerationLabel: strconv.FormatInt(image.Generation, 10),
+	})
+	objectMeta.OwnerReferences = []metav1.OwnerReference{
+		*metav1.NewControllerRef(image, osbuildv1alpha1.GroupVersion.WithKind("ImageBuilderImage")),
+	}
+	return &osbuildv1alpha1.ImageBuilderCompose{
+		ObjectMeta: objectMeta,
+		Spec: osbuildv1alpha1.ImageBuilderComposeSpec{
+			Image:            image.Name,
+			Build:            build,
+			BuildKind:        kind,
+			Generation:       image.Generation,
+			BlueprintVersion: image.Status.BlueprintVersion,
+			ComposeType:      composeType,
+			BuildRecord:      buildRecord,
+		},
+	}
+}
+
+// createCompose creates the compose of a build, replacing the one of a
+// previous build of the same name, e.g. a Job named without the generation.
+// Every build pushes the blueprints with a new version.
+func (r *ImageBuilderImageReconciler) createCompose(ctx context.Context, compose *osbuildv1alpha1.ImageBuilderCompose) error {
+	existing := osbuildv1alpha1.ImageBuilderCompose{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(compose), &existing)
+	switch {
+	case errors.IsNotFound(err):
+	case err != nil:
+		return err
+	case existing.Spec.Image == compose.Spec.Image && existing.Spec.BlueprintVersion == compose.Spec.BlueprintVersion:
+		// created by a reconcile that could not update the status
+		return nil
+	default:
+		if err := deleteGeneratedObject(ctx, r.Client, client.ObjectKeyFromObject(compose), &existing, compose.Spec.Image); err != nil {
+			return err
+		}
+	}
+	return r.Create(ctx, compose)
+}
+
+// imageToComposes maps an image to its composes
+func (r *ImageBuilderComposeReconciler) imageToComposes(ctx context.Context, object client.Object) []reconcile.Request {
+	composes := osbuildv1alpha1.ImageBuilderComposeList{}
+	if err := r.List(ctx, &composes, client.InNamespace(object.GetNamespace()), client.MatchingLabels{imageBuilderImageLabel: object.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "Could not list ImageBuilderComposes")
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, compose := range composes.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&compose)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ImageBuilderComposeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// the image controls the builds, the compose is one of their owners
+	toCompose := handler.EnqueueRequestForOwner(mgr.GetScheme(), mgr.GetRESTMapper(), &osbuildv1alpha1.ImageBuilderCompose{})
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(&osbuildv1alpha1.ImageBuilderCompose{}).
+		Watches(&osbuildv1alpha1.ImageBuilderImage{}, handler.EnqueueRequestsFromMapFunc(r.imageToComposes)).
+		Watches(&batchv1.Job{}, toCompose)
+	if r.Tekton {
+		builder = builder.Watches(&tektonv1.PipelineRun{}, toCompose)
+	}
+	return builder.Complete(r)
+}
@@ -22,27 +22,51 @@ import (
 	routev1 "github.com/openshift/api/route/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
 	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 )
 
 const ubiImage = "registry.access.redhat.com/ubi9:latest"
 const utilsImage = "quay.io/cgament/composer-cli"
+const orasImage = "ghcr.io/oras-project/oras:v1.1.0"
+const nginxImage = "docker.io/nginxinc/nginx-unprivileged"
 const imageBuilderImageLabel = "osbuild-operator-image"
+const imageBuilderImageGenerationLabel = "osbuild-operator-generation"
+
+// WebServicePort is the port of the Service exposing the artifacts of an image
+const WebServicePort = 8089
+
+// Artifacts are the paths, relative to the web Service, of the files produced
+// by the image pipeline
+var Artifacts = []string{"installer.iso", "repo/"}
+
 const defaultIsoTarget = "edge-simplified-installer"
 const defaultBlueprintTemplate = `name = "{{ .Name }}"
 version = "0.0.1"
@@ -66,37 +90,161 @@ installation_device = "{{ .InstallationDevice }}"
 
 [customizations.fdo]
 manufacturing_server_url = "{{ .FdoManufacturingServerUrl }}"
+{{- if .DiunPubKeyHash }}
+diun_pub_key_hash = "{{ .DiunPubKeyHash }}"
+{{- else if .DiunPubKeyRootCerts }}
+diun_pub_key_root_certs = """
+{{ .DiunPubKeyRootCerts }}
+"""
+{{- else }}
 diun_pub_key_insecure = "true"
+{{- end }}
 {{ end }}
 `
 
+// installerComposeScript requests the installer compose of the edge commit
+// extracted by the first stage, which composer pulls from the ostree repository
+// served by the sidecar of the task on the pod IP
+const installerComposeScript = `#!/bin/bash
+set -e
+dir="/workspace/shared-volume/$(params.blueprintName)"
+ref=$(jq -r '.ref // empty' "${dir}/commit.json" 2>/dev/null || true)
+jq -n --arg blueprint "$(params.blueprintName)-iso" --arg type "${target}" \
+  --arg ref "${ref:-rhel/9/x86_64/edge}" --arg url "http://${POD_IP}:8000/repo" \
+  '{blueprint_name: $blueprint, compose_type: $type, ostree: {ref: $ref, url: $url}}' | tee "${dir}/ostree-compose.json"
+`
+
+// waitScriptTemplate follows a compose with the status endpoint until it is
+// done, backing off while the status can not be read. It exits with
+// depsolveFailedExitCode when composer could not depsolve the blueprint, and 1
+// for any other failure.
 const waitScriptTemplate = `#!/bin/bash
-compose_id=$(jq '.build_id' -r /workspace/shared-volume/$(params.blueprintName)/${compose_file})
-while /usr/bin/curl "${api}/compose/queue" --silent | jq -r '.run[].id' | grep ${compose_id} || usr/bin/curl "${api}/compose/queue" --silent | jq -r '.new[].id' | grep ${compose_id}; do sleep 30; done
-/usr/bin/curl "${api}/compose/failed" --silent | jq -r '.failed[].id' | grep "${compose_id}" && echo "Compose ${compose_id} failed!" && exit 1
-/usr/bin/curl "${api}/compose/finished" --silent | jq -r --arg id "${composer_id}" '.finished[] | select (.id==$id)'
+file="/workspace/shared-volume/$(params.blueprintName)/${compose_file}"
+compose_id=$(jq -r '.build_id // empty' "${file}")
+if [ -z "${compose_id}" ]; then
+  echo "Compose was not started: $(jq -c '.errors // .' "${file}")"
+  jq -e '.errors[]? | select(.id == "DepsolveError")' "${file}" > /dev/null && exit 2
+  exit 1
+fi
+unknown=0
+while true; do
+  status=$(/usr/bin/curl --silent "${api}/compose/status/${compose_id}" | jq -r '.uuids[0].queue_status // "UNKNOWN"')
+  case "${status}" in
+  WAITING|RUNNING)
+    unknown=0
+    ;;
+  FINISHED)
+    /usr/bin/curl --silent "${api}/compose/info/${compose_id}"
+    exit 0
+    ;;
+  FAILED)
+    info=$(/usr/bin/curl --silent "${api}/compose/info/${compose_id}")
+    echo "Compose ${compose_id} failed: ${info}"
+    # composes failing to depsolve have no packages
+    [ "$(echo "${info}" | jq '.deps.packages // [] | length')" = "0" ] && exit 2
+    exit 1
+    ;;
+  *)
+    unknown=$((unknown + 1))
+    echo "Compose ${compose_id} is in state ${status}"
+    if [ "${unknown}" -ge "${status_checks:-10}" ]; then
+      echo "Compose ${compose_id} is unknown to composer"
+      exit 1
+    fi
+    ;;
+  esac
+  delay=${poll_interval:-30}
+  for ((i = 0; i < unknown && delay < 300; i++)); do
+    delay=$((delay * 2))
+  done
+  sleep $((delay < 300 ? delay : 300))
+done
 `
 
+// depsolveFailedExitCode is the exit code of the wait step when composer
+// could not depsolve the blueprint
+const depsolveFailedExitCode = 2
+
 // ImageBuilderImageReconciler reconciles a ImageBuilderImage object
 type ImageBuilderImageReconciler struct {
 	client.Client
-	Scheme             *runtime.Scheme
-	PipelineWorkspaces []tektonv1.WorkspaceDeclaration
-	PipelineParams     tektonv1.ParamSpecs
-	IsoTarget          string
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// PropagateLabels and PropagateAnnotations select the ImageBuilderImage
+	// metadata copied to the generated resources
+	PropagateLabels      []string
+	PropagateAnnotations []string
+	// NameTemplate is the Go template used to name the generated resources
+	NameTemplate string
+	// MultiTenant restricts images to the builders of their own namespace and
+	// of SharedBuilderNamespace
+	MultiTenant            bool
+	SharedBuilderNamespace string
+	// Deliveries sends the build events outside of the reconciles, started
+	// with the controller when not set
+	Deliveries *Deliveries
+	// CallbackFilter restricts the addresses the callbacks connect to, the
+	// DefaultCallbackDeniedCIDRs being denied when not set
+	CallbackFilter *DestinationFilter
+	// CloudEventsSink is the URI the build events are sent to as CloudEvents
+	CloudEventsSink string
+	// Kafka publishes the build events to a Kafka topic
+	Kafka *KafkaSink
+	// Images resolves the helper images for the architecture of the builder
+	Images *ImageResolver
+	// BuildPod are the default settings of the build pods, merged with the
+	// ones of each image
+	BuildPod *osbuildv1alpha1.BuildPodSettings
+	// Tekton is set when the Tekton CRDs are installed, builds run as Jobs
+	// otherwise
+	Tekton bool
+	// MaxConcurrentReconciles is how many images are reconciled at once, 1
+	// when not set
+	MaxConcurrentReconciles int
+	// RateLimiter delays the requeues of the images, the default one of
+	// controller-runtime when not set
+	RateLimiter workqueue.RateLimiter
+}
+
+// imageReconcile holds the state of the reconcile of an image, every
+// reconcile having its own to run concurrently
+type imageReconcile struct {
+	*ImageBuilderImageReconciler
+	pipelineWorkspaces []tektonv1.WorkspaceDeclaration
+	pipelineParams     tektonv1.ParamSpecs
+	isoTarget          string
+	// composeType is the compose type of the image
+	composeType osbuildv1alpha1.ComposeType
+	// ostree selects the ostree commit of the compose of the image
+	ostree *composer.OSTreeOptions
+	// apiFlavor is the composer API of the builder of the image
+	apiFlavor osbuildv1alpha1.APIFlavor
+	// composeRepositories are sent with the compose requests of the Cloud API
+	composeRepositories []composer.CloudRepository
+	// composerAPISecret holds the credentials of the composer API mounted in
+	// the build steps, empty when it needs none
+	composerAPISecret string
 }
 
 //+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilderimages,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilderimages/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilderimages/finalizers,verbs=update
-//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;delete
-//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;delete;update
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=clusterimagebuilders,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;delete;update;patch
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;create;delete;watch
-//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;create;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilderpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;create;update;patch;delete
 //+kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;create;delete
-//+kubebuilder:rbac:groups=tekton.dev,resources=tasks,verbs=get;list;create;delete
-//+kubebuilder:rbac:groups=tekton.dev,resources=pipelines,verbs=get;list;create;delete
-//+kubebuilder:rbac:groups=tekton.dev,resources=pipelineruns,verbs=get;list;create;delete
+//+kubebuilder:rbac:groups=tekton.dev,resources=tasks,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=tekton.dev,resources=pipelines,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=tekton.dev,resources=pipelineruns,verbs=get;list;watch;create;patch;delete
+//+kubebuilder:rbac:groups=tekton.dev,resources=taskruns,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -108,90 +256,254 @@ type ImageBuilderImageReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.15.0/pkg/reconcile
 func (r *ImageBuilderImageReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	logger := log.FromContext(ctx)
+	return (&imageReconcile{ImageBuilderImageReconciler: r}).reconcile(ctx, req)
+}
 
-	labels := map[string]string{
-		imageBuilderImageLabel: req.Name,
-	}
+// reconcile reconciles an image with the state of its own reconcile
+func (r *imageReconcile) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
 
 	// get new ImageBuilderImage object
 	var imageBuilderImage osbuildv1alpha1.ImageBuilderImage
 	if err := r.Get(ctx, req.NamespacedName, &imageBuilderImage); err != nil {
 		if errors.IsNotFound(err) {
 			logger.Info("Resource not found, must have been deleted")
-			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "PipelineRun", "tekton.dev/v1", imageBuilderImageLabel, req.Name); err != nil {
-				return ctrl.Result{}, err
-			}
-			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Pipeline", "tekton.dev/v1", imageBuilderImageLabel, req.Name); err != nil {
-				return ctrl.Result{}, err
-			}
-			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Task", "tekton.dev/v1", imageBuilderImageLabel, req.Name); err != nil {
-				return ctrl.Result{}, err
-			}
-			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "ConfigMap", "v1", imageBuilderImageLabel, req.Name); err != nil {
-				return ctrl.Result{}, err
-			}
-			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Route", "route.openshift.io/v1", imageBuilderImageLabel, req.Name); err != nil {
-				return ctrl.Result{}, err
-			}
-			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Service", "v1", imageBuilderImageLabel, req.Name); err != nil {
-				return ctrl.Result{}, err
-			}
-			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "Deployment", "apps/v1", imageBuilderImageLabel, req.Name); err != nil {
-				return ctrl.Result{}, err
-			}
-			return ctrl.Result{}, nil
+			return ctrl.Result{}, r.deleteGeneratedResources(ctx, req.Namespace, req.Name)
 		}
 		logger.Error(err, "Unable to fetch ImageBuilderImage")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	if !imageBuilderImage.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(&imageBuilderImage, imageFinalizer) {
+			return r.finalize(ctx, &imageBuilderImage)
+		}
+		return ctrl.Result{}, nil
+	}
+	if controllerutil.AddFinalizer(&imageBuilderImage, imageFinalizer) {
+		if err := r.Update(ctx, &imageBuilderImage); err != nil {
+			logger.Error(err, "Could not add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// metadata of the generated resources
+	labels := r.resourceLabels(&imageBuilderImage)
+	annotations := r.resourceAnnotations(&imageBuilderImage)
+	// the generated resources are garbage collected with the image
+	owners := []metav1.OwnerReference{
+		*metav1.NewControllerRef(&imageBuilderImage, osbuildv1alpha1.GroupVersion.WithKind("ImageBuilderImage")),
+	}
+	names, err := GenerateNames(r.NameTemplate, req.Name, req.Namespace, imageBuilderImage.Generation)
+	if err != nil {
+		logger.Error(err, "Could not generate resource names")
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonInvalidResourceName, err.Error())
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonInvalidResourceName, err.Error())
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
+	}
+	if err := names.checkNameCollisions(ctx, r.Client, req.Namespace, req.Name); err != nil {
+		if _, ok := err.(*nameCollisionError); !ok {
+			logger.Error(err, "Could not check resource names")
+			return ctrl.Result{}, err
+		}
+		logger.Error(err, "Generated resource name is already taken")
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonNameCollision, err.Error())
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonNameCollision, err.Error())
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
+	}
+
+	if errs := imageBuilderImage.Spec.Validate(field.NewPath("spec")); len(errs) > 0 {
+		message := errs.ToAggregate().Error()
+		logger.Error(nil, fmt.Sprintf("Invalid ImageBuilderImage spec: %s", message))
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonSpecInvalid, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonSpecInvalid, message)
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
+	}
+	if len(imageBuilderImage.Spec.Architectures) > 0 {
+		return r.reconcileArchitectures(ctx, &imageBuilderImage)
+	}
+	if len(imageBuilderImage.Status.Architectures) > 0 {
+		if err := r.deleteArchitectureImages(ctx, &imageBuilderImage); err != nil {
+			logger.Error(err, "Could not delete the images of the architectures")
+			return ctrl.Result{}, err
+		}
+	}
+	r.scheduleBuild(ctx, &imageBuilderImage, time.Now())
+
 	// installer compose type
 	if imageBuilderImage.Spec.IsoTarget == "" {
 		logger.Info("No installer target specified, using default")
 		imageBuilderImage.Spec.IsoTarget = defaultIsoTarget
-		r.IsoTarget = defaultIsoTarget
+		r.isoTarget = defaultIsoTarget
 	} else {
-		r.IsoTarget = defaultIsoTarget
+		r.isoTarget = imageBuilderImage.Spec.IsoTarget
+	}
+	r.composeType = imageComposeType(&imageBuilderImage)
+	ostree, message, err := r.resolveOSTree(ctx, &imageBuilderImage)
+	if err != nil {
+		logger.Error(err, "Could not resolve the parent commit")
+		return ctrl.Result{}, err
 	}
+	if message != "" {
+		logger.Info(message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForParent, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForParent, "")
+		if err := updateImageStatus(ctx, r.Client, &imageBuilderImage); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: builderRequeueInterval}, nil
+	}
+	r.ostree = ostree
 
 	// to what ImageBuilder are we tying this?
 	var imageBuilder osbuildv1alpha1.ImageBuilder
-	if imageBuilderImage.Spec.ImageBuilder == "" {
-		logger.Info("ImageBuilder instance is not specified in ImageBuilderImage, trying to find default")
+	if imageBuilderImage.Spec.ClusterImageBuilder != "" {
+		var clusterImageBuilder osbuildv1alpha1.ClusterImageBuilder
+		if err := r.Get(ctx, client.ObjectKey{
+			Name: imageBuilderImage.Spec.ClusterImageBuilder,
+		}, &clusterImageBuilder); err != nil {
+			if errors.IsNotFound(err) {
+				return r.builderSelectionFailed(ctx, &imageBuilderImage, osbuildv1alpha1.ReasonBuilderNotFound,
+					fmt.Sprintf("ClusterImageBuilder %s does not exist", imageBuilderImage.Spec.ClusterImageBuilder))
+			}
+			logger.Error(err, "Could not get ClusterImageBuilder")
+			return ctrl.Result{}, err
+		}
+		if err := r.Get(ctx, client.ObjectKey{
+			Namespace: clusterImageBuilder.Spec.Namespace,
+			Name:      clusterImageBuilder.Name,
+		}, &imageBuilder); err != nil {
+			if errors.IsNotFound(err) {
+				return r.builderSelectionFailed(ctx, &imageBuilderImage, osbuildv1alpha1.ReasonBuilderNotFound,
+					fmt.Sprintf("ImageBuilder %s/%s of ClusterImageBuilder %s does not exist yet", clusterImageBuilder.Spec.Namespace, clusterImageBuilder.Name, clusterImageBuilder.Name))
+			}
+			logger.Error(err, "Could not get ImageBuilder of ClusterImageBuilder")
+			return ctrl.Result{}, err
+		}
+		logger.Info(fmt.Sprintf("Using %s ClusterImageBuilder", clusterImageBuilder.Name))
+	} else if builderName, builderNamespace := imageBuilderImage.Spec.BuilderReference(); builderName == "" {
+		selector, err := builderSelector(&imageBuilderImage)
+		if err != nil {
+			logger.Error(err, "Could not parse ImageBuilder selector")
+			return ctrl.Result{}, err
+		}
+		if selector != nil {
+			logger.Info("ImageBuilder instance is not specified in ImageBuilderImage, selecting it by its labels")
+		} else {
+			logger.Info("ImageBuilder instance is not specified in ImageBuilderImage, trying to find default")
+		}
 		u := &osbuildv1alpha1.ImageBuilderList{}
 		u.SetGroupVersionKind(schema.GroupVersionKind{
 			Group:   "osbuild.rh-ecosystem-edge.io",
 			Kind:    "ImageBuilder",
 			Version: "v1alpha1",
 		})
-		if err := r.List(ctx, u); err != nil {
+		listOptions := []client.ListOption{}
+		if r.MultiTenant {
+			// tenants only look for builders in their own namespace
+			listOptions = append(listOptions, client.InNamespace(req.Namespace))
+		}
+		if err := r.List(ctx, u, listOptions...); err != nil {
 			logger.Error(err, "Could not get ImageBuilder list")
 			return ctrl.Result{}, err
 		}
-		if len(u.Items) != 1 {
-			logger.Error(nil, "No suitable ImageBuilder found or too many")
-			return ctrl.Result{}, nil
+		candidates := u.Items
+		defaults := selectBuilders(u.Items, req.Namespace, r.SharedBuilderNamespace, selector)
+		if len(defaults) == 0 && r.MultiTenant && r.SharedBuilderNamespace != "" {
+			logger.Info(fmt.Sprintf("No ImageBuilder selected in namespace %s, trying shared namespace %s", req.Namespace, r.SharedBuilderNamespace))
+			if err := r.List(ctx, u, client.InNamespace(r.SharedBuilderNamespace)); err != nil {
+				logger.Error(err, "Could not get shared ImageBuilder list")
+				return ctrl.Result{}, err
+			}
+			candidates = append(candidates, u.Items...)
+			defaults = selectBuilders(u.Items, r.SharedBuilderNamespace, r.SharedBuilderNamespace, selector)
+		}
+		if len(defaults) == 0 && selector != nil {
+			message := fmt.Sprintf("No ImageBuilder matches spec.imageBuilderSelector %s, the candidates are: %s", selector, builderNames(candidates))
+			logger.Error(nil, message)
+			return r.builderSelectionFailed(ctx, &imageBuilderImage, osbuildv1alpha1.ReasonNoMatchingBuilder, message)
+		}
+		if len(defaults) > 1 && selector != nil {
+			message := fmt.Sprintf("%d ImageBuilders match spec.imageBuilderSelector %s, narrow it or mark one of them as default: %s", len(defaults), selector, builderNames(defaults))
+			logger.Error(nil, message)
+			return r.builderSelectionFailed(ctx, &imageBuilderImage, osbuildv1alpha1.ReasonAmbiguousBuilder, message)
 		}
-		imageBuilder = u.Items[0]
+		if len(defaults) == 0 {
+			message := fmt.Sprintf("No ImageBuilder is marked as default, set spec.imageBuilder or spec.default on one of the candidates: %s", builderNames(candidates))
+			logger.Error(nil, message)
+			return r.builderSelectionFailed(ctx, &imageBuilderImage, osbuildv1alpha1.ReasonNoDefaultBuilder, message)
+		}
+		if len(defaults) > 1 {
+			message := fmt.Sprintf("%d ImageBuilders are marked as default, set spec.imageBuilder to one of: %s", len(defaults), builderNames(defaults))
+			logger.Error(nil, message)
+			return r.builderSelectionFailed(ctx, &imageBuilderImage, osbuildv1alpha1.ReasonAmbiguousBuilder, message)
+		}
+		imageBuilder = defaults[0]
 		logger.Info(fmt.Sprintf("Using %s ImageBuilder", imageBuilder.Name))
 	} else {
+		if builderNamespace == "" {
+			builderNamespace = req.Namespace
+		}
 		if err := r.Get(ctx, client.ObjectKey{
-			Namespace: req.Namespace,
-			Name:      req.Name,
+			Namespace: builderNamespace,
+			Name:      builderName,
 		}, &imageBuilder); err != nil {
+			if errors.IsNotFound(err) {
+				return r.builderSelectionFailed(ctx, &imageBuilderImage, osbuildv1alpha1.ReasonBuilderNotFound,
+					fmt.Sprintf("ImageBuilder %s/%s does not exist", builderNamespace, builderName))
+			}
 			logger.Error(err, "Could not get ImageBuilder")
 			return ctrl.Result{}, err
 		}
 	}
+	setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionBuilderSelectionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuilderSelected,
+		fmt.Sprintf("Using ImageBuilder %s/%s", imageBuilder.Namespace, imageBuilder.Name))
+	allowed, err := r.builderAllowed(ctx, &imageBuilderImage, &imageBuilder)
+	if err != nil {
+		logger.Error(err, "Could not check access to ImageBuilder")
+		return ctrl.Result{}, err
+	}
+	if !allowed {
+		message := fmt.Sprintf("ImageBuilder %s/%s can not be used from namespace %s", imageBuilder.Namespace, imageBuilder.Name, req.Namespace)
+		logger.Error(nil, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuilderNotAllowed, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonBuilderNotAllowed, message)
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
+	}
+	if !credentialsShared(&imageBuilderImage, &imageBuilder) {
+		message := fmt.Sprintf("The credentials of the composer API of ImageBuilder %s/%s are only shared with the namespaces of its spec.allowedNamespaces", imageBuilder.Namespace, imageBuilder.Name)
+		logger.Error(nil, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuilderNotAllowed, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonBuilderNotAllowed, message)
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
+	}
+	if !composeTypeSupported(&imageBuilder, r.composeType) {
+		message := fmt.Sprintf("ImageBuilder %s/%s does not build %s images, it supports: %s", imageBuilder.Namespace, imageBuilder.Name,
+			r.composeType, strings.Join(imageBuilder.Status.ComposeTypes, ", "))
+		logger.Error(nil, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonComposeTypeUnsupported, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonComposeTypeUnsupported, message)
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
+	}
 
 	// the ImageBuilder Service we are communicating through
 	imageService := corev1.Service{}
 	if err := r.Get(ctx, client.ObjectKey{
 		Namespace: imageBuilder.Namespace,
 		Name:      imageBuilder.Name,
-	}, &imageService); err != nil {
-		logger.Error(err, "Could not get image service")
+	}, &imageService); err != nil || len(imageService.Spec.Ports) == 0 {
+		if err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "Could not get image service")
+			return ctrl.Result{}, err
+		}
+		message := fmt.Sprintf("Waiting for the service of ImageBuilder %s/%s", imageBuilder.Namespace, imageBuilder.Name)
+		logger.Info(message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForBuilder, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForBuilder, "")
+		if err := updateImageStatus(ctx, r.Client, &imageBuilderImage); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: builderRequeueInterval}, nil
 	}
 
 	// fill defaults to this spec, do not modify the main object
@@ -199,6 +511,58 @@ func (r *ImageBuilderImageReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	if imageSpec.Name == "" {
 		imageSpec.Name = imageBuilderImage.Name
 	}
+	// credentials are only read at render time and never stored in the spec
+	field, err := r.resolveSecrets(ctx, req.Namespace, &imageSpec)
+	if err != nil {
+		logger.Error(err, fmt.Sprintf("Could not read %s", field))
+		return ctrl.Result{}, err
+	}
+	if field != "" {
+		return r.waitForTemplate(ctx, &imageBuilderImage, field)
+	}
+	message, err = r.resolveFDO(ctx, req.Namespace, &imageSpec)
+	if err != nil {
+		logger.Error(err, "Could not discover the FDO manufacturing server")
+		return ctrl.Result{}, err
+	}
+	if message != "" {
+		logger.Info(message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForFDOServer, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForFDOServer, "")
+		if err := updateImageStatus(ctx, r.Client, &imageBuilderImage); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: builderRequeueInterval}, nil
+	}
+	imageBuilderImage.Status.FDOManufacturingServerURL = ""
+	if imageSpec.FDO != nil {
+		imageBuilderImage.Status.FDOManufacturingServerURL = imageSpec.FdoManufacturingServerUrl
+	}
+	pullSecrets := false
+	for _, container := range imageSpec.EmbeddedContainers {
+		pullSecrets = pullSecrets || container.PullSecret != nil
+	}
+	if pullSecrets && builderRuntime(&imageBuilder) != osbuildv1alpha1.RuntimeDeployment {
+		message := fmt.Sprintf("ImageBuilder %s/%s runs composer in a virtual machine, its workers can not be given the pull Secrets of the embedded containers",
+			imageBuilder.Namespace, imageBuilder.Name)
+		logger.Error(nil, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonRegistryAuthUnsupported, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonRegistryAuthUnsupported, message)
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
+	}
+	message, err = r.syncContainersAuth(ctx, &imageBuilderImage, &imageBuilder)
+	if err != nil {
+		logger.Error(err, "Could not add the registry credentials of the embedded containers")
+		return ctrl.Result{}, err
+	}
+	if message != "" {
+		logger.Info(message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForPullSecret, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForPullSecret, "")
+		// the pull Secrets are watched
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
+	}
+	sensitive := sensitiveBlueprints(&imageSpec)
 
 	// templates used for blueprints
 	var blueprintTemplate string
@@ -208,6 +572,23 @@ func (r *ImageBuilderImageReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	} else {
 		blueprintTemplate = imageBuilderImage.Spec.BlueprintTemplate
 	}
+	if ref := imageBuilderImage.Spec.BlueprintTemplateRef; ref != nil {
+		content, found, err := r.templateSource(ctx, req.Namespace, ref)
+		if err != nil {
+			logger.Error(err, "Could not read spec.blueprintTemplateRef")
+			return ctrl.Result{}, err
+		}
+		if !found {
+			return r.waitForTemplate(ctx, &imageBuilderImage, "spec.blueprintTemplateRef")
+		}
+		if content != "" {
+			blueprintTemplate = content
+		}
+	}
+	if imageBuilderImage.Spec.Profile != "" {
+		logger.Info(fmt.Sprintf("Adding %s profile to the blueprint", imageBuilderImage.Spec.Profile))
+		blueprintTemplate += profileBlueprints[imageBuilderImage.Spec.Profile]
+	}
 	var blueprintIsoTemplate string
 	if imageBuilderImage.Spec.BlueprintIsoTemplate == "" {
 		logger.Info("No defined spec.blueprintIsoTemplate, using default")
@@ -215,28 +596,112 @@ func (r *ImageBuilderImageReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	} else {
 		blueprintIsoTemplate = imageBuilderImage.Spec.BlueprintIsoTemplate
 	}
+	if ref := imageBuilderImage.Spec.BlueprintIsoTemplateRef; ref != nil {
+		content, found, err := r.templateSource(ctx, req.Namespace, ref)
+		if err != nil {
+			logger.Error(err, "Could not read spec.blueprintIsoTemplateRef")
+			return ctrl.Result{}, err
+		}
+		if !found {
+			return r.waitForTemplate(ctx, &imageBuilderImage, "spec.blueprintIsoTemplateRef")
+		}
+		if content != "" {
+			blueprintIsoTemplate = content
+		}
+	}
 
-	// store blueprints in configmaps
-	blueprintConfigMap := corev1.ConfigMap{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "ConfigMap",
-			APIVersion: "v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-blueprint", imageSpec.Name),
-			Namespace: imageBuilderImage.Namespace,
-			Labels:    labels,
-		},
-		Data: map[string]string{
-			imageSpec.Name:                        renderTemplateFromSpec(blueprintTemplate, imageSpec),
-			fmt.Sprintf("%s-iso", imageSpec.Name): renderTemplateFromSpec(blueprintIsoTemplate, imageSpec),
-		},
+	templates := map[string]string{
+		imageSpec.Name:                        blueprintTemplate,
+		fmt.Sprintf("%s-iso", imageSpec.Name): blueprintIsoTemplate,
 	}
+	blueprints := map[string]string{}
+	for name, templ := range templates {
+		blueprint, err := renderTemplateFromSpec(templ, imageSpec)
+		if err == nil && name == imageSpec.Name && imageBuilderImage.Spec.BlueprintTemplate == "" && imageBuilderImage.Spec.BlueprintTemplateRef == nil {
+			blueprint += customizationsBlueprint(&imageSpec)
+		}
+		if err == nil {
+			err = validateBlueprint(blueprint)
+		}
+		if err != nil {
+			logger.Error(err, fmt.Sprintf("Blueprint %s is not valid", name))
+			message := fmt.Sprintf("blueprint %s: %s", name, err)
+			r.Recorder.Event(&imageBuilderImage, corev1.EventTypeWarning, osbuildv1alpha1.ReasonBlueprintInvalid, eventMessage(message))
+			setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBlueprintInvalid, message)
+			setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonBlueprintInvalid, message)
+			return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
+		}
+		blueprints[name] = blueprint
+	}
+	imageBuilderImage.Status.BlueprintHash = blueprintHash(blueprints)
 
-	if err := CreateOrUpdateObject(ctx, r.Client, &blueprintConfigMap); err != nil {
-		return ctrl.Result{}, err
+	// immutable copy of the blueprints of this generation, builds use it so
+	// it is always possible to tell what was sent to composer
+	generationBlueprints := blueprintObject(metav1.ObjectMeta{
+		Name:      names.BlueprintSnapshot,
+		Namespace: imageBuilderImage.Namespace,
+		Labels: mergeMaps(labels, map[string]string{
+			imageBuilderImageGenerationLabel: strconv.FormatInt(imageBuilderImage.Generation, 10),
+		}),
+		Annotations:     annotations,
+		OwnerReferences: owners,
+	}, blueprints, sensitive, true)
+	// a dry run creates nothing, its plan holds the blueprints
+	if !imageBuilderImage.Spec.DryRun {
+		// store blueprints in configmaps, or secrets when they embed credentials
+		blueprintObjectMeta := metav1.ObjectMeta{
+			Name:            names.BlueprintConfigMap,
+			Namespace:       imageBuilderImage.Namespace,
+			Labels:          labels,
+			Annotations:     annotations,
+			OwnerReferences: owners,
+		}
+		if err := CreateOrUpdateObject(ctx, r.Client, blueprintObject(blueprintObjectMeta, blueprints, sensitive, false)); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := deleteBlueprintObject(ctx, r.Client, req.Namespace, names.BlueprintConfigMap, req.Name, !sensitive); err != nil {
+			logger.Error(err, "Could not delete previous blueprints")
+			return ctrl.Result{}, err
+		}
+		if err := r.Create(ctx, generationBlueprints); err != nil {
+			if errors.IsAlreadyExists(err) {
+				logger.Info("Blueprints for this generation already exist, skipping creation")
+			} else {
+				logger.Error(err, "Could not create blueprints for this generation")
+				return ctrl.Result{}, err
+			}
+		}
+		if err := r.deletePlan(ctx, &imageBuilderImage); err != nil {
+			logger.Error(err, "Could not delete the plan of the last dry run")
+			return ctrl.Result{}, err
+		}
 	}
 
+	// compare with the blueprints of the current build so changes can be
+	// reviewed, unless either embeds credentials that must not leak to events
+	if previous := imageBuilderImage.Status.BlueprintConfigMap; previous != "" && previous != generationBlueprints.GetName() && !sensitive {
+		previousBlueprints, err := readBlueprints(ctx, r.Client, imageBuilderImage.Namespace, previous, false)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				logger.Error(err, "Could not get previous blueprint ConfigMap")
+				return ctrl.Result{}, err
+			}
+			logger.Info(fmt.Sprintf("Previous blueprint ConfigMap %s not found, not computing diff", previous))
+		} else {
+			diff := blueprintDiff(previousBlueprints, blueprints)
+			if diff != "" && diff != imageBuilderImage.Status.BlueprintDiff {
+				r.Recorder.Event(&imageBuilderImage, corev1.EventTypeNormal, osbuildv1alpha1.EventBlueprintChanged,
+					eventMessage(fmt.Sprintf("Blueprints changed since %s:\n%s", previous, diff)))
+			}
+			imageBuilderImage.Status.BlueprintDiff = diff
+		}
+	} else {
+		imageBuilderImage.Status.BlueprintDiff = ""
+	}
+
+	// resources a dry run would create next to the build
+	planned := []client.Object{}
+
 	//persistentVolume used for inter-task communication
 	var pvcName string
 	if imageBuilderImage.Spec.SharedVolume == "" {
@@ -245,9 +710,56 @@ func (r *ImageBuilderImageReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	} else {
 		pvcName = imageBuilderImage.Spec.SharedVolume
 	}
+	ephemeral := ephemeralStorage(&imageBuilderImage)
+	if ephemeral {
+		imageBuilderImage.Status.StorageStrategy = osbuildv1alpha1.StorageEphemeral
+	} else {
+		pvc := corev1.PersistentVolumeClaim{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: pvcName}, &pvc); err != nil {
+			if !errors.IsNotFound(err) {
+				logger.Error(err, "Could not get PersistentVolumeClaim")
+				return ctrl.Result{}, err
+			}
+			// a named claim belongs to the user, only the default one is created
+			if imageBuilderImage.Spec.SharedVolume != "" {
+				message := fmt.Sprintf("Waiting for PersistentVolumeClaim %s", pvcName)
+				logger.Info(message)
+				setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForVolume, message)
+				setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForVolume, "")
+				if err := updateImageStatus(ctx, r.Client, &imageBuilderImage); err != nil {
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{RequeueAfter: builderRequeueInterval}, nil
+			}
+			pvc = sharedVolumeClaim(metav1.ObjectMeta{
+				Name:            pvcName,
+				Namespace:       req.Namespace,
+				Labels:          labels,
+				Annotations:     annotations,
+				OwnerReferences: owners,
+			}, &imageBuilderImage)
+			if imageBuilderImage.Spec.DryRun {
+				planned = append(planned, &pvc)
+			} else {
+				logger.Info(fmt.Sprintf("Creating PersistentVolumeClaim %s", pvcName))
+				if err := r.Create(ctx, &pvc); err != nil && !errors.IsAlreadyExists(err) {
+					logger.Error(err, "Could not create PersistentVolumeClaim")
+					return ctrl.Result{}, err
+				}
+			}
+		}
+		imageBuilderImage.Status.StorageStrategy = storageStrategy(&pvc)
+	}
+	podAffinity := storageAffinity(imageBuilderImage.Status.StorageStrategy, req.Name)
+	generated := metav1.ObjectMeta{
+		Namespace:       req.Namespace,
+		Labels:          labels,
+		Annotations:     annotations,
+		OwnerReferences: owners,
+	}
 
 	// common pipeline environment
-	r.PipelineWorkspaces = []tektonv1.WorkspaceDeclaration{
+	r.pipelineWorkspaces = []tektonv1.WorkspaceDeclaration{
 		{
 			Name: "shared-volume",
 		},
@@ -255,7 +767,7 @@ func (r *ImageBuilderImageReconciler) Reconcile(ctx context.Context, req ctrl.Re
 			Name: "blueprints",
 		},
 	}
-	r.PipelineParams = tektonv1.ParamSpecs{
+	r.pipelineParams = tektonv1.ParamSpecs{
 		tektonv1.ParamSpec{
 			Name: "blueprintName",
 		},
@@ -265,117 +777,118 @@ func (r *ImageBuilderImageReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	}
 
 	// generate and create pipeline tasks
-	apiUrl := fmt.Sprintf("http://%s.%s:%v/api/v1",
-		imageService.Name, imageService.Namespace, imageService.Spec.Ports[0].Port)
-
-	prepareTask := r.PrepareSharedVolumeTask(metav1.ObjectMeta{
-		Name:      fmt.Sprintf("%s-prepare-volume", req.Name),
-		Namespace: req.Namespace,
-		Labels:    labels,
-	})
-	if err := r.Create(ctx, &prepareTask); err != nil {
-		if errors.IsAlreadyExists(err) {
-			logger.Info("prepare volume task already exists, skipping creation")
-		} else {
-			logger.Error(err, "Could not create task preparevolume")
-			return ctrl.Result{}, err
-		}
+	composerClient, err := builderComposerClient(ctx, r.Client, &imageBuilder)
+	if err != nil {
+		logger.Error(err, "Could not read composer API credentials")
+		return ctrl.Result{}, err
 	}
-
-	commitTask := r.CommitTask(metav1.ObjectMeta{
-		Name:      fmt.Sprintf("%s-generate-commit", req.Name),
-		Namespace: req.Namespace,
-		Labels:    labels,
-	})
-	if err := r.Create(ctx, &commitTask); err != nil {
-		if errors.IsAlreadyExists(err) {
-			logger.Info("Commit task already exists, skipping creation")
-		} else {
-			logger.Error(err, "Could not create commit task")
+	r.apiFlavor = builderAPIFlavor(&imageBuilder)
+	r.composeRepositories = nil
+	if r.apiFlavor == osbuildv1alpha1.APIFlavorCloud {
+		if r.composeRepositories, err = r.cloudRepositories(ctx, &imageBuilderImage); err != nil {
+			logger.Error(err, "Could not get ImageBuilderSource")
 			return ctrl.Result{}, err
 		}
 	}
-
-	downloadTask := r.DownloadExtractCommitTask(metav1.ObjectMeta{
-		Name:      fmt.Sprintf("%s-download-extract-commit", req.Name),
-		Namespace: req.Namespace,
-		Labels:    labels,
-	})
-	if err := r.Create(ctx, &downloadTask); err != nil {
-		if errors.IsAlreadyExists(err) {
-			logger.Info("Download and extract task already exists, skipping creation")
-		} else {
-			logger.Error(err, "Could not create download task")
-			return ctrl.Result{}, err
-		}
+	if err := r.reconcileComposerAPISecret(ctx, &imageBuilder, metav1.ObjectMeta{
+		Name:            names.ComposerAPI,
+		Namespace:       req.Namespace,
+		Labels:          labels,
+		Annotations:     annotations,
+		OwnerReferences: owners,
+	}, imageBuilderImage.Spec.DryRun); err != nil {
+		logger.Error(err, "Could not reconcile composer API credentials")
+		return ctrl.Result{}, err
 	}
 
-	isoComposeTask := r.IsoComposeTask(metav1.ObjectMeta{
-		Name:      fmt.Sprintf("%s-iso-compose", req.Name),
-		Namespace: req.Namespace,
-		Labels:    labels,
-	})
-	if err := r.Create(ctx, &isoComposeTask); err != nil {
-		if errors.IsAlreadyExists(err) {
-			logger.Info("Iso compose task already exists, skipping creation")
-		} else {
-			logger.Error(err, "Could not create isocompose task")
-			return ctrl.Result{}, err
-		}
+	switch executor := r.imageExecutor(&imageBuilderImage); {
+	case executor == osbuildv1alpha1.ExecutorJob && tektonOnly(&imageBuilderImage.Spec) != "":
+		message := fmt.Sprintf("Tekton is not installed in the cluster, which spec.%s needs", tektonOnly(&imageBuilderImage.Spec))
+		logger.Info(message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonExecutorUnavailable, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonExecutorUnavailable, message)
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
+	case executor == osbuildv1alpha1.ExecutorJob:
+		return r.reconcileBuildJob(ctx, &imageBuilderImage, &imageBuilder, jobBuild{
+			names:          names,
+			generated:      generated,
+			composerClient: composerClient,
+			blueprints:     blueprints,
+			blueprintsName: generationBlueprints.GetName(),
+			sensitive:      sensitive,
+			pvcName:        pvcName,
+			affinity:       podAffinity,
+			ephemeral:      ephemeral,
+			planned:        planned,
+		})
+	case !r.Tekton:
+		message := "Tekton is not installed in the cluster, set spec.executor to job"
+		logger.Info(message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonExecutorUnavailable, message)
+		setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonExecutorUnavailable, message)
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, &imageBuilderImage)
 	}
-	isoDownloadTask := r.DownloadTask(metav1.ObjectMeta{
-		Name:      fmt.Sprintf("%s-iso-download", req.Name),
-		Namespace: req.Namespace,
-		Labels:    labels,
-	}, "compose-iso.json", "installer.iso")
-	if err := r.Create(ctx, &isoDownloadTask); err != nil {
-		if errors.IsAlreadyExists(err) {
-			logger.Info("Iso download task already exists, skipping creation")
-		} else {
-			logger.Error(err, "Could not create isodownload task")
-			return ctrl.Result{}, err
-		}
+	pipelineRef := &tektonv1.PipelineRef{
+		Name: names.Pipeline,
 	}
-	// create commit pipeline and pipelinerun
-	imagePipeline := r.ImagePipeline(metav1.ObjectMeta{
-		Name:      fmt.Sprintf("%s-pipeline", req.Name),
-		Namespace: req.Namespace,
-		Labels:    labels,
-	}, []tektonv1.Task{prepareTask, commitTask, downloadTask, isoComposeTask, isoDownloadTask})
-	if err := r.Create(ctx, &imagePipeline); err != nil {
-		if errors.IsAlreadyExists(err) {
-			logger.Info("Image generation pipeline already exists, skipping creation")
-		} else {
-			logger.Error(err, "Could not create image pipeline")
-			return ctrl.Result{}, err
+	var pipelineTasks []tektonv1.Task
+	var imagePipeline tektonv1.Pipeline
+	if ref := imageBuilderImage.Spec.PipelineRef; ref != nil {
+		// the user pipeline replaces the generated one
+		pipelineRef = userPipelineRef(ref)
+	} else {
+		pipelineTasks = r.generatedTasks(&imageBuilderImage, &imageBuilder, names, generated, ephemeral)
+		// commit pipeline, created with the pipelinerun
+		pipelineMeta := metav1.ObjectMeta{
+			Name:            names.Pipeline,
+			Namespace:       req.Namespace,
+			Labels:          labels,
+			Annotations:     annotations,
+			OwnerReferences: owners,
+		}
+		imagePipeline = r.ImagePipeline(pipelineMeta, pipelineTasks)
+		if ephemeral {
+			imagePipeline = r.EphemeralPipeline(pipelineMeta, pipelineTasks)
+		}
+		setProvenance(&imagePipeline, &imageBuilderImage)
+		if retries := imageBuilderImage.Spec.Retries; retries != nil && !ephemeral {
+			setTaskRetries(&imagePipeline, retries.Download, names.DownloadTask, names.IsoDownloadTask)
 		}
+		addUploads(&imagePipeline, imageBuilderImage.Spec.UploadTargets, imageBuilderImage.Name, imageBuilderImage.Generation, r.composeType, ephemeral)
+		if publishesOSTree(&imageBuilderImage, &imageBuilder, r.composeType) {
+			addOSTreePublish(&imagePipeline, &imageBuilder, ephemeral)
+		}
+		imagePipeline.Spec.Tasks = withHooks(imagePipeline.Spec.Tasks, imageBuilderImage.Spec.Hooks)
+		setPipelineImages(&imagePipeline, r.stepImages(&imageBuilderImage), imageBuilder.Spec.Architecture)
+		setPipelineResources(&imagePipeline, r.buildPod(&imageBuilderImage).Resources)
+		if r.composerAPISecret != "" {
+			setPipelineComposerAPI(&imagePipeline, r.composerAPISecret)
+		}
+	}
+	triggers, err := buildAnnotations(&imageBuilderImage)
+	if err != nil {
+		logger.Error(err, "Could not hash ImageBuilderImage spec")
+		return ctrl.Result{}, err
 	}
+	buildNumber := nextBuildNumber(&imageBuilderImage)
 	imagePipelineRun := tektonv1.PipelineRun{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-pipeline-run", req.Name),
+			Name:      pipelineRunName(names.PipelineRun, buildNumber),
 			Namespace: req.Namespace,
-			Labels:    labels,
+			Labels: mergeMaps(labels, map[string]string{
+				imageBuilderImageGenerationLabel: strconv.FormatInt(imageBuilderImage.Generation, 10),
+				builderUIDLabel:                  string(imageBuilder.UID),
+			}),
+			Annotations: mergeMaps(annotations, triggers, map[string]string{
+				buildRecordAnnotation: names.BuildRecord,
+			}),
+			OwnerReferences: owners,
 		},
 		Spec: tektonv1.PipelineRunSpec{
-			PipelineRef: &tektonv1.PipelineRef{
-				Name: imagePipeline.Name,
-			},
-			Workspaces: []tektonv1.WorkspaceBinding{
-				{
-					Name: "blueprints",
-					ConfigMap: &corev1.ConfigMapVolumeSource{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: blueprintConfigMap.Name,
-						},
-					},
-				},
-				{
-					Name: "shared-volume",
-					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-						ClaimName: pvcName,
-					},
-				},
-			},
+			PipelineRef: pipelineRef,
+			Workspaces: append([]tektonv1.WorkspaceBinding{
+				blueprintsWorkspace(generationBlueprints.GetName(), sensitive),
+			}, buildWorkspaces(&imageBuilderImage, pvcName)...),
 			Params: tektonv1.Params{
 				{
 					Name: "blueprintName",
@@ -388,47 +901,404 @@ func (r *ImageBuilderImageReconciler) Reconcile(ctx context.Context, req ctrl.Re
 					Name: "apiEndpoint",
 					Value: tektonv1.ParamValue{
 						Type:      "string",
-						StringVal: apiUrl,
+						StringVal: composerClient.Endpoint,
+					},
+				},
+				{
+					Name: "generation",
+					Value: tektonv1.ParamValue{
+						Type:      "string",
+						StringVal: strconv.FormatInt(imageBuilderImage.Generation, 10),
 					},
 				},
 			},
+			TaskRunTemplate: tektonv1.PipelineTaskRunTemplate{
+				PodTemplate: buildPodTemplate(r.buildPod(&imageBuilderImage), imageBuilder.Spec.Architecture, podAffinity),
+			},
 			Status: tektonv1.PipelineRunSpecStatus("PipelineRunPending"),
 		},
 	}
+	if timeouts := imageBuilderImage.Spec.ComposeTimeouts; timeouts != nil && timeouts.Total != nil {
+		imagePipelineRun.Spec.Timeouts = &tektonv1.TimeoutFields{
+			Pipeline: timeouts.Total,
+		}
+	}
+	if imageBuilderImage.Spec.DryRun {
+		for i := range pipelineTasks {
+			planned = append(planned, &pipelineTasks[i])
+		}
+		if imageBuilderImage.Spec.PipelineRef == nil {
+			planned = append(planned, &imagePipeline)
+		}
+		planned = append(planned, &imagePipelineRun)
+		return r.reconcilePlan(ctx, &imageBuilderImage, names, generated, blueprints, sensitive, planned)
+	}
 
-	if err := r.Create(ctx, &imagePipelineRun); err != nil {
-		if errors.IsAlreadyExists(err) {
+	// a Job left by the job executor is replaced by the PipelineRun
+	if err := deleteGeneratedObject(ctx, r.Client, client.ObjectKey{Namespace: req.Namespace, Name: names.BuildJob}, &batchv1.Job{}, req.Name); err != nil {
+		logger.Error(err, "Could not delete image build job")
+		return ctrl.Result{}, err
+	}
+	if imageBuilderImage.Spec.PipelineRef != nil {
+		if err := r.deleteGeneratedPipeline(ctx, names, req.Namespace); err != nil {
+			logger.Error(err, "Could not delete generated pipeline")
+			return ctrl.Result{}, err
+		}
+	} else {
+		for i := range pipelineTasks {
+			if err := ApplyObject(ctx, r.Client, &pipelineTasks[i], imageBuilderImage.Spec.ForceOwnership); err != nil {
+				if conflicts := fieldConflicts(err); conflicts != "" {
+					return r.resourceConflict(ctx, &imageBuilderImage, &pipelineTasks[i], conflicts)
+				}
+				return ctrl.Result{}, err
+			}
+		}
+		if err := ApplyObject(ctx, r.Client, &imagePipeline, imageBuilderImage.Spec.ForceOwnership); err != nil {
+			if conflicts := fieldConflicts(err); conflicts != "" {
+				return r.resourceConflict(ctx, &imageBuilderImage, &imagePipeline, conflicts)
+			}
+			return ctrl.Result{}, err
+		}
+		if meta.FindStatusCondition(imageBuilderImage.Status.Conditions, osbuildv1alpha1.ConditionResourceConflict) != nil {
+			setImageCondition(&imageBuilderImage, osbuildv1alpha1.ConditionResourceConflict, metav1.ConditionFalse, osbuildv1alpha1.ReasonNoConflict, "")
+		}
+	}
+
+	// the current build is followed until it is superseded, it is then kept
+	// for the history and a new PipelineRun is created next to it
+	current := false
+	if name := imageBuilderImage.Status.PipelineRun; name != "" {
+		existingPipelineRun := tektonv1.PipelineRun{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: name}, &existingPipelineRun)
+		if err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "Could not get image pipelinerun")
+			return ctrl.Result{}, err
+		}
+		if err == nil && existingPipelineRun.DeletionTimestamp == nil {
+			switch {
+			case !superseded(&imageBuilderImage, &existingPipelineRun),
+				imageBuilderImage.Spec.Suspend && !existingPipelineRun.IsDone():
+				// a suspended image lets its build finish
+				current = true
+				imagePipelineRun.Name = name
+			case imageBuilderImage.Spec.Suspend:
+				return r.suspendBuild(ctx, &imageBuilderImage)
+			default:
+				if err := r.supersedeBuild(ctx, &imageBuilderImage, &existingPipelineRun, composerClient); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+		}
+	}
+
+	// quotas only hold back new builds
+	if !current && imageBuilderImage.Spec.Suspend {
+		return r.suspendBuild(ctx, &imageBuilderImage)
+	}
+	if !current {
+		if admitted, result, err := r.admitBuild(ctx, &imageBuilderImage, &imageBuilder, composerClient, blueprints); !admitted {
+			return result, err
+		}
+		if err := r.Create(ctx, &imagePipelineRun); err != nil {
+			if !errors.IsAlreadyExists(err) {
+				logger.Error(err, "Could not create commit pipelinerun")
+				return ctrl.Result{}, err
+			}
+			// created by a reconcile that could not update the status
 			logger.Info("Image generation pipeline run already exists, skipping creation")
+			imageBuilderImage.Status.BuildNumber = buildNumber
 		} else {
-			logger.Error(err, "Could not create commit pipelinerun")
-			return ctrl.Result{}, err
+			imageBuilderImage.Status.BuildNumber = buildNumber
+			if err := r.recordBuildStart(ctx, &imageBuilderImage, &imageBuilder, names, generated, "PipelineRun", imagePipelineRun.Name, generationBlueprints.GetName(), sensitive); err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.pruneHistory(ctx, &imageBuilderImage, composerClient, imagePipelineRun.Name); err != nil {
+				logger.Error(err, "Could not delete builds beyond the history limit")
+				return ctrl.Result{}, err
+			}
+		}
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&imagePipelineRun), &imagePipelineRun); err != nil {
+		logger.Error(err, "Could not get image pipelinerun")
+		return ctrl.Result{}, err
+	}
+	failureReason, err := buildFailureReason(ctx, r.Client, &imagePipelineRun)
+	if err != nil {
+		logger.Error(err, "Could not get build failure")
+		return ctrl.Result{}, err
+	}
+	previousReady := meta.FindStatusCondition(imageBuilderImage.Status.Conditions, osbuildv1alpha1.ConditionReady).DeepCopy()
+	setBuildConditions(&imageBuilderImage, &imagePipelineRun, failureReason)
+	r.recordBuildCompletion(&imageBuilderImage, "PipelineRun", imagePipelineRun.Name, imagePipelineRun.IsDone(), previousReady)
+	if err := setBuildProgress(ctx, r.Client, &imageBuilderImage, &imagePipelineRun); err != nil {
+		logger.Error(err, "Could not get build progress")
+		return ctrl.Result{}, err
+	}
+	buildPods := corev1.PodList{}
+	if err := r.List(ctx, &buildPods, client.InNamespace(imagePipelineRun.Namespace), client.MatchingLabels{"tekton.dev/pipelineRun": imagePipelineRun.Name}); err != nil {
+		logger.Error(err, "Could not get image pipelinerun pods")
+		return ctrl.Result{}, err
+	}
+	if err := r.recordStepImageDigests(ctx, &imageBuilderImage, buildPods.Items); err != nil {
+		logger.Error(err, "Could not record step image digests in build record")
+		return ctrl.Result{}, err
+	}
+	if err := setUploadStatus(ctx, r.Client, &imageBuilderImage, &imagePipelineRun); err != nil {
+		logger.Error(err, "Could not get upload status")
+		return ctrl.Result{}, err
+	}
+	ostreeURL := ""
+	if publishesOSTree(&imageBuilderImage, &imageBuilder, r.composeType) {
+		ostreeURL = imageBuilder.Status.OSTreeRepositoryURL
+	}
+	if err := setOSTreeStatus(ctx, r.Client, &imageBuilderImage, &imagePipelineRun, ostreeURL); err != nil {
+		logger.Error(err, "Could not get ostree publication")
+		return ctrl.Result{}, err
+	}
+	followComposes, err := r.setComposeStatus(ctx, &imageBuilderImage, &imagePipelineRun, composerClient)
+	if err != nil {
+		// composer not answering does not hold back the build
+		logger.Error(err, "Could not get composes of the build")
+	}
+	followUpload, err := r.setCloudImage(ctx, &imageBuilderImage, composerClient)
+	if err != nil {
+		logger.Error(err, "Could not get the upload of the image")
+	}
+	setComposeLogMessage(&imageBuilderImage)
+	setHistoryEntry(&imageBuilderImage, imagePipelineRun.Name, pipelineRunResult(&imagePipelineRun))
+	result := ctrl.Result{}
+	if followComposes || followUpload {
+		result.RequeueAfter = composeRequeueInterval
+	}
+	// failed deliveries are retried, the build not being reconciled again
+	// once it is done, the image is reconciled again once the queued ones are
+	if r.notifyBuildEvents(ctx, &imageBuilderImage, &imagePipelineRun) {
+		result.RequeueAfter = callbackRetryInterval
+	}
+
+	return r.serveArtifacts(ctx, &imageBuilderImage, names, generated, pvcName, podAffinity, ephemeral, scheduleRequeue(&imageBuilderImage, result))
+}
+
+// admitBuild holds back a new build while its builder upgrades composer, its
+// composer does not answer, a quota of the namespace is exceeded, the builder
+// runs as many builds as it may or one of its sources does not exist, then
+// pushes its sources and blueprints to composer.
+// The build is only created when it returns true, Reconcile returning the
+// result and error otherwise.
+func (r *ImageBuilderImageReconciler) admitBuild(ctx context.Context, imageBuilderImage *osbuildv1alpha1.ImageBuilderImage, imageBuilder *osbuildv1alpha1.ImageBuilder, composerClient *composer.Client, blueprints map[string]string) (bool, ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	if builderUpgrading(imageBuilder) {
+		message := fmt.Sprintf("ImageBuilder %s/%s is upgrading composer, the build starts once it is done", imageBuilder.Namespace, imageBuilder.Name)
+		logger.Info(message)
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForBuilder, message)
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForBuilder, "")
+		if err := updateImageStatus(ctx, r.Client, imageBuilderImage); err != nil {
+			logger.Error(err, "Could not update ImageBuilderImage status")
+			return false, ctrl.Result{}, err
+		}
+		return false, ctrl.Result{RequeueAfter: builderRequeueInterval}, nil
+	}
+	// no build is started against a composer that does not answer
+	if !composerReachable(imageBuilder) {
+		message := fmt.Sprintf("Composer of ImageBuilder %s/%s does not answer, the build starts once it does", imageBuilder.Namespace, imageBuilder.Name)
+		logger.Info(message)
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForBuilder, message)
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForBuilder, "")
+		if err := updateImageStatus(ctx, r.Client, imageBuilderImage); err != nil {
+			logger.Error(err, "Could not update ImageBuilderImage status")
+			return false, ctrl.Result{}, err
+		}
+		return false, ctrl.Result{RequeueAfter: composerBackoff(imageBuilder)}, nil
+	}
+	quota, message, err := r.checkQuota(ctx, imageBuilderImage.Namespace)
+	if err != nil {
+		logger.Error(err, "Could not check namespace quota")
+		return false, ctrl.Result{}, err
+	}
+	if quota != "" {
+		logger.Info(fmt.Sprintf("Quota %s exceeded, holding back build: %s", quota, message))
+		quotaExceededTotal.WithLabelValues(imageBuilderImage.Namespace, quota).Inc()
+		r.Recorder.Event(imageBuilderImage, corev1.EventTypeWarning, osbuildv1alpha1.ReasonQuotaExceeded, message)
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionQuotaExceeded, metav1.ConditionTrue, quota, message)
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonQuotaExceeded, message)
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonQuotaExceeded, "")
+		if err := updateImageStatus(ctx, r.Client, imageBuilderImage); err != nil {
+			logger.Error(err, "Could not update ImageBuilderImage status")
+			return false, ctrl.Result{}, err
+		}
+		return false, ctrl.Result{RequeueAfter: quotaRequeueInterval}, nil
+	}
+	meta.RemoveStatusCondition(&imageBuilderImage.Status.Conditions, osbuildv1alpha1.ConditionQuotaExceeded)
+	queued, message, err := r.queueBuild(ctx, imageBuilderImage, imageBuilder)
+	if err != nil {
+		logger.Error(err, "Could not count the builds of the builder")
+		return false, ctrl.Result{}, err
+	}
+	if queued {
+		logger.Info(fmt.Sprintf("Queueing build: %s", message))
+		if ready := meta.FindStatusCondition(imageBuilderImage.Status.Conditions, osbuildv1alpha1.ConditionReady); ready == nil || ready.Reason != osbuildv1alpha1.ReasonBuildQueued {
+			r.Recorder.Event(imageBuilderImage, corev1.EventTypeNormal, osbuildv1alpha1.ReasonBuildQueued, message)
+		}
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildQueued, message)
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildQueued, "")
+		if err := updateImageStatus(ctx, r.Client, imageBuilderImage); err != nil {
+			logger.Error(err, "Could not update ImageBuilderImage status")
+			return false, ctrl.Result{}, err
 		}
+		return false, ctrl.Result{RequeueAfter: queueRequeueInterval}, nil
 	}
+	// the sources are pushed first, composer depsolving the blueprints
+	// against them. The Cloud API takes them, and the blueprints, with every
+	// compose request.
+	cloud := builderAPIFlavor(imageBuilder) == osbuildv1alpha1.APIFlavorCloud
+	for _, name := range imageBuilderImage.Spec.Repositories {
+		source := osbuildv1alpha1.ImageBuilderSource{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: imageBuilderImage.Namespace, Name: name}, &source); err != nil {
+			if !errors.IsNotFound(err) {
+				logger.Error(err, "Could not get ImageBuilderSource")
+				return false, ctrl.Result{}, err
+			}
+			message := fmt.Sprintf("Waiting for ImageBuilderSource %s", name)
+			logger.Info(message)
+			setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForSource, message)
+			setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForSource, "")
+			if err := updateImageStatus(ctx, r.Client, imageBuilderImage); err != nil {
+				logger.Error(err, "Could not update ImageBuilderImage status")
+				return false, ctrl.Result{}, err
+			}
+			return false, ctrl.Result{RequeueAfter: builderRequeueInterval}, nil
+		}
+		if cloud {
+			continue
+		}
+		if err := pushSource(ctx, composerClient, &source); err != nil {
+			if !blueprintRejected(err) {
+				logger.Error(err, "Could not push source to composer")
+				return false, ctrl.Result{}, err
+			}
+			message := fmt.Sprintf("Composer rejected ImageBuilderSource %s: %s", name, err)
+			logger.Error(err, "Composer rejected the source")
+			setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonSourceRejected, message)
+			setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonSourceRejected, message)
+			return false, ctrl.Result{}, updateImageStatus(ctx, r.Client, imageBuilderImage)
+		}
+	}
+	// the build only composes the blueprints, the operator pushes them with
+	// the version of the build
+	version := nextBlueprintVersion(imageBuilderImage.Status.BlueprintVersion, blueprints)
+	if cloud {
+		imageBuilderImage.Status.BlueprintVersion = version
+		return true, ctrl.Result{}, nil
+	}
+	if err := pushBlueprints(ctx, composerClient, versionedBlueprints(blueprints, version)); err != nil {
+		if !blueprintRejected(err) {
+			logger.Error(err, "Could not push blueprints to composer")
+			return false, ctrl.Result{}, err
+		}
+		logger.Error(err, "Composer rejected the blueprints")
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBlueprintInvalid, err.Error())
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonBlueprintInvalid, err.Error())
+		return false, ctrl.Result{}, updateImageStatus(ctx, r.Client, imageBuilderImage)
+	}
+	imageBuilderImage.Status.BlueprintVersion = version
+	r.Recorder.Event(imageBuilderImage, corev1.EventTypeNormal, osbuildv1alpha1.EventBlueprintPushed,
+		eventMessage(fmt.Sprintf("Pushed blueprints %s version %s to ImageBuilder %s/%s", strings.Join(blueprintNames(blueprints), ", "), version, imageBuilder.Namespace, imageBuilder.Name)))
+	return true, ctrl.Result{}, nil
+}
 
-	// webserver deployment
-	webDeployment := r.WebDeployment(metav1.ObjectMeta{
-		Name:      fmt.Sprintf("%s-web", req.Name),
-		Namespace: req.Namespace,
-		Labels:    labels,
-	}, pvcName, req.Name)
-	webService := r.WebService(metav1.ObjectMeta{
-		Name:      fmt.Sprintf("%s-service", req.Name),
-		Namespace: req.Namespace,
-		Labels:    labels,
-	}, webDeployment.Name)
-	webRoute := r.WebRoute(metav1.ObjectMeta{
-		Name:      fmt.Sprintf("%s-route", req.Name),
-		Namespace: req.Namespace,
-		Labels:    labels,
-	}, webService.Name)
-
-	if err := r.Create(ctx, &webDeployment); err != nil {
+// recordBuildStart creates the build record of a build just created, the
+// named PipelineRun or Job of kind building the blueprints stored in the
+// ConfigMap, or Secret when sensitive, named blueprintsName
+func (r *imageReconcile) recordBuildStart(ctx context.Context, imageBuilderImage *osbuildv1alpha1.ImageBuilderImage, imageBuilder *osbuildv1alpha1.ImageBuilder, names GeneratedNames, generated metav1.ObjectMeta, kind string, buildName string, blueprintsName string, sensitive bool) error {
+	logger := log.FromContext(ctx)
+	blueprintConfigMap, blueprintSecret := blueprintsName, ""
+	if sensitive {
+		blueprintConfigMap, blueprintSecret = "", blueprintsName
+	}
+	recordMeta := *generated.DeepCopy()
+	recordMeta.Name = names.BuildRecord
+	recordMeta.Labels = mergeMaps(generated.Labels, map[string]string{
+		imageBuilderImageGenerationLabel: strconv.FormatInt(imageBuilderImage.Generation, 10),
+	})
+	buildRecord, err := r.BuildRecord(recordMeta, *imageBuilderImage, *imageBuilder, buildName, blueprintConfigMap, blueprintSecret)
+	if err != nil {
+		logger.Error(err, "Could not generate build record")
+		return err
+	}
+	if len(imageBuilderImage.Spec.EmbeddedContainers) > 0 {
+		containers, err := json.Marshal(r.embeddedContainerDigests(ctx, imageBuilderImage))
+		if err != nil {
+			return err
+		}
+		buildRecord.Data["embeddedContainers"] = string(containers)
+	}
+	if err := r.Create(ctx, &buildRecord); err != nil {
 		if errors.IsAlreadyExists(err) {
-			logger.Info("Deployment already exists")
+			logger.Info("Build record already exists, skipping creation")
 		} else {
-			logger.Error(err, "Could not create deplyoment")
+			logger.Error(err, "Could not create build record")
+			return err
+		}
+	}
+	message := fmt.Sprintf("Created %s %s with blueprints %s", kind, buildName, blueprintsName)
+	if imageBuilderImage.Status.BlueprintDiff != "" {
+		message = fmt.Sprintf("%s, changes:\n%s", message, imageBuilderImage.Status.BlueprintDiff)
+	}
+	r.Recorder.Event(imageBuilderImage, corev1.EventTypeNormal, osbuildv1alpha1.EventBuildTriggered, eventMessage(message))
+	addHistoryEntry(imageBuilderImage, buildName, r.composeType)
+	compose := newImageBuilderCompose(generated, imageBuilderImage, osbuildv1alpha1.BuildKind(kind), buildName, names.BuildRecord, r.composeType)
+	if err := r.createCompose(ctx, compose); err != nil {
+		logger.Error(err, "Could not create ImageBuilderCompose")
+		return err
+	}
+	return nil
+}
+
+// serveArtifacts runs the web server serving the artifacts of the image from
+// its volume with spec.exposeArtifact, then updates its status and returns
+// result
+func (r *ImageBuilderImageReconciler) serveArtifacts(ctx context.Context, imageBuilderImage *osbuildv1alpha1.ImageBuilderImage, names GeneratedNames, generated metav1.ObjectMeta, pvcName string, podAffinity *corev1.Affinity, ephemeral bool, result ctrl.Result) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	named := func(name string) metav1.ObjectMeta {
+		objectMeta := generated
+		objectMeta.Name = name
+		return objectMeta
+	}
+	if ephemeral || !imageBuilderImage.Spec.ExposeArtifact {
+		// nothing outlives the build pod of ephemeral builds, there are no
+		// artifacts to serve
+		if err := r.deleteWebServer(ctx, names, imageBuilderImage.Namespace); err != nil {
+			logger.Error(err, "Could not delete web server")
+			return ctrl.Result{}, err
+		}
+		imageBuilderImage.Status.ArtifactsURL = ""
+		imageBuilderImage.Status.ArtifactURL = ""
+		if err := updateImageStatus(ctx, r.Client, imageBuilderImage); err != nil {
+			logger.Error(err, "Could not update ImageBuilderImage status")
 			return ctrl.Result{}, err
 		}
+		return result, nil
+	}
+
+	// webserver deployment
+	webDeployment := r.WebDeployment(named(names.WebDeployment), pvcName, artifactsSubPath(imageBuilderImage.Name, servedGeneration(imageBuilderImage)), podAffinity)
+	// the web server runs next to the build pods, on the same nodes
+	buildPod := r.buildPod(imageBuilderImage)
+	webPodSpec := &webDeployment.Spec.Template.Spec
+	webPodSpec.Containers[0].Image = r.stepImages(imageBuilderImage).Resolve(nginxImage, "")
+	webPodSpec.ImagePullSecrets = buildPod.ImagePullSecrets
+	webPodSpec.NodeSelector = buildPod.NodeSelector
+	webPodSpec.Tolerations = buildPod.Tolerations
+	webService := r.WebService(named(names.WebService), webDeployment.Name)
+	webRoute := r.WebRoute(named(names.WebRoute), webService.Name)
+
+	// the deployment follows the generation whose artifacts are served
+	if err := ApplyObject(ctx, r.Client, &webDeployment, imageBuilderImage.Spec.ForceOwnership); err != nil {
+		if conflicts := fieldConflicts(err); conflicts != "" {
+			return r.resourceConflict(ctx, imageBuilderImage, &webDeployment, conflicts)
+		}
+		logger.Error(err, "Could not apply deployment")
+		return ctrl.Result{}, err
 	}
 	if err := r.Create(ctx, &webService); err != nil {
 		if errors.IsAlreadyExists(err) {
@@ -438,16 +1308,56 @@ func (r *ImageBuilderImageReconciler) Reconcile(ctx context.Context, req ctrl.Re
 			return ctrl.Result{}, err
 		}
 	}
+	// clusters without routes serve the artifacts on the Service only
+	routed := true
 	if err := r.Create(ctx, &webRoute); err != nil {
-		if errors.IsAlreadyExists(err) {
+		switch {
+		case meta.IsNoMatchError(err):
+			routed = false
+		case errors.IsAlreadyExists(err):
 			logger.Info("Route already exists")
-		} else {
+			if err := r.Get(ctx, client.ObjectKeyFromObject(&webRoute), &webRoute); err != nil {
+				logger.Error(err, "Could not get route")
+				return ctrl.Result{}, err
+			}
+		default:
 			logger.Error(err, "Could not create route")
 			return ctrl.Result{}, err
 		}
 	}
+	// the host is assigned by the router when the route does not set one
+	imageBuilderImage.Status.ArtifactsURL = ""
+	switch {
+	case !routed:
+		imageBuilderImage.Status.ArtifactsURL = fmt.Sprintf("http://%s.%s.svc:%d", webService.Name, webService.Namespace, WebServicePort)
+	case webRoute.Spec.Host != "":
+		imageBuilderImage.Status.ArtifactsURL = "http://" + webRoute.Spec.Host
+	}
+	imageBuilderImage.Status.ArtifactURL = ""
+	if artifact := exposedArtifact(imageBuilderImage); artifact != nil && imageBuilderImage.Status.ArtifactsURL != "" {
+		imageBuilderImage.Status.ArtifactURL = imageBuilderImage.Status.ArtifactsURL + artifact.Location
+	}
+
+	if err := updateImageStatus(ctx, r.Client, imageBuilderImage); err != nil {
+		logger.Error(err, "Could not update ImageBuilderImage status")
+		return ctrl.Result{}, err
+	}
+
+	return result, nil
+}
 
-	return ctrl.Result{}, nil
+// exposedArtifact is the artifact of the last successful build published in
+// status.artifactURL: the installer, else the image of the other compose
+// types, else the edge commit
+func exposedArtifact(image *osbuildv1alpha1.ImageBuilderImage) *osbuildv1alpha1.BuildArtifact {
+	for _, artifactType := range []osbuildv1alpha1.ArtifactType{osbuildv1alpha1.ArtifactInstaller, osbuildv1alpha1.ArtifactImage, osbuildv1alpha1.ArtifactCommit} {
+		for i := range image.Status.Artifacts {
+			if artifact := &image.Status.Artifacts[i]; artifact.Type == artifactType && artifact.Location != "" {
+				return artifact
+			}
+		}
+	}
+	return nil
 }
 
 func (r *ImageBuilderImageReconciler) WebRoute(objectMeta metav1.ObjectMeta, serviceName string) routev1.Route {
@@ -472,7 +1382,7 @@ func (r *ImageBuilderImageReconciler) WebService(objectMeta metav1.ObjectMeta, a
 		Spec: corev1.ServiceSpec{
 			Ports: []corev1.ServicePort{
 				{
-					Port:       8089,
+					Port:       WebServicePort,
 					TargetPort: intstr.FromInt(8080),
 					Protocol:   corev1.ProtocolTCP,
 				},
@@ -486,7 +1396,7 @@ func (r *ImageBuilderImageReconciler) WebService(objectMeta metav1.ObjectMeta, a
 	return service
 }
 
-func (r *ImageBuilderImageReconciler) WebDeployment(objectMeta metav1.ObjectMeta, pvcName string, imageName string) appsv1.Deployment {
+func (r *ImageBuilderImageReconciler) WebDeployment(objectMeta metav1.ObjectMeta, pvcName string, subPath string, affinity *corev1.Affinity) appsv1.Deployment {
 	appName := objectMeta.Name
 	var replicas int32 = 1
 	webDeployment := appsv1.Deployment{
@@ -500,15 +1410,16 @@ func (r *ImageBuilderImageReconciler) WebDeployment(objectMeta metav1.ObjectMeta
 			Replicas: &replicas,
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
+					Labels: mergeMaps(objectMeta.Labels, map[string]string{
 						"app": appName,
-					},
+					}),
+					Annotations: objectMeta.Annotations,
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
 						{
 							Name:  "nginx",
-							Image: "docker.io/nginxinc/nginx-unprivileged",
+							Image: nginxImage,
 							Resources: corev1.ResourceRequirements{
 								Limits: corev1.ResourceList{
 									corev1.ResourceCPU:    resource.MustParse("500m"),
@@ -524,11 +1435,12 @@ func (r *ImageBuilderImageReconciler) WebDeployment(objectMeta metav1.ObjectMeta
 								{
 									Name:      "data-pv",
 									MountPath: "/usr/share/nginx/html/",
-									SubPath:   imageName,
+									SubPath:   subPath,
 								},
 							},
 						},
 					},
+					Affinity: affinity,
 					Volumes: []corev1.Volume{
 						{
 							Name: "data-pv",
@@ -546,19 +1458,54 @@ func (r *ImageBuilderImageReconciler) WebDeployment(objectMeta metav1.ObjectMeta
 	return webDeployment
 }
 
-func renderTemplateFromSpec(blueprint string, values osbuildv1alpha1.ImageBuilderImageSpec) string {
+// renderTemplateFromSpec renders a blueprint template with the spec, a
+// template that does not parse or render being reported as an error
+func renderTemplateFromSpec(blueprint string, values osbuildv1alpha1.ImageBuilderImageSpec) (string, error) {
 	var render bytes.Buffer
-	templ, err := template.New("template").Parse(blueprint)
+	templ, err := template.New("template").Option("missingkey=error").Funcs(osbuildv1alpha1.TemplateFuncs(values.Secrets)).Parse(blueprint)
 	if err != nil {
-		panic(err)
+		return "", err
+	}
+	if err := templ.Execute(&render, values); err != nil {
+		return "", err
 	}
-	templ.Execute(&render, values)
-	return render.String()
+	return render.String(), nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ImageBuilderImageReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	if r.Deliveries == nil {
+		r.Deliveries = NewDeliveries(DefaultDeliveryWorkers)
+		if err := mgr.Add(r.Deliveries); err != nil {
+			return err
+		}
+	}
+	if r.CallbackFilter == nil {
+		filter, err := NewDestinationFilter("", strings.Join(DefaultCallbackDeniedCIDRs, ","))
+		if err != nil {
+			return err
+		}
+		r.CallbackFilter = filter
+	}
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&osbuildv1alpha1.ImageBuilderImage{}).
-		Complete(r)
+		WithOptions(crcontroller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             r.RateLimiter,
+		}).
+		Owns(&osbuildv1alpha1.ImageBuilderImage{}).
+		Owns(&batchv1.Job{}).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.templateSourceToImages)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.templateSourceToImages)).
+		WatchesRawSource(r.Deliveries.Source(), &handler.EnqueueRequestForObject{})
+	if r.Tekton {
+		// PipelineRuns created before the image owned them only carry its
+		// label, they are mapped to it like their TaskRuns
+		builder = builder.
+			Watches(&tektonv1.PipelineRun{}, handler.EnqueueRequestsFromMapFunc(pipelineRunToImage)).
+			Watches(&tektonv1.TaskRun{}, handler.EnqueueRequestsFromMapFunc(pipelineRunToImage)).
+			Watches(&tektonv1.Pipeline{}, handler.EnqueueRequestsFromMapFunc(pipelineRunToImage)).
+			Watches(&tektonv1.Task{}, handler.EnqueueRequestsFromMapFunc(pipelineRunToImage))
+	}
+	return builder.Complete(r)
 }
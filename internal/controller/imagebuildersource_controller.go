@@ -0,0 +1,236 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+)
+
+// sourceFinalizer holds the deletion of an ImageBuilderSource until it is
+// deleted from the composers it was pushed to
+const sourceFinalizer = "osbuild.rh-ecosystem-edge.io/source-cleanup"
+
+// ImageBuilderSourceReconciler reconciles an ImageBuilderSource object by
+// pushing it to the composer of every ImageBuilder of its namespace
+type ImageBuilderSourceReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuildersources,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuildersources/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuildersources/finalizers,verbs=update
+
+// Reconcile pushes the source to the builders of its namespace, pushing it
+// again periodically so that composers deployed again get it back
+func (r *ImageBuilderSourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var source osbuildv1alpha1.ImageBuilderSource
+	if err := r.Get(ctx, req.NamespacedName, &source); err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Resource not found, must have been deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "Unable to fetch ImageBuilderSource")
+		return ctrl.Result{}, err
+	}
+
+	if !source.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(&source, sourceFinalizer) {
+			return r.finalize(ctx, &source)
+		}
+		return ctrl.Result{}, nil
+	}
+	if controllerutil.AddFinalizer(&source, sourceFinalizer) {
+		if err := r.Update(ctx, &source); err != nil {
+			logger.Error(err, "Could not add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	builders := osbuildv1alpha1.ImageBuilderList{}
+	if err := r.List(ctx, &builders, client.InNamespace(req.Namespace)); err != nil {
+		logger.Error(err, "Could not list ImageBuilders")
+		return ctrl.Result{}, err
+	}
+	source.Status.ObservedGeneration = source.Generation
+	pushed := []string{}
+	unreachable := []string{}
+	for i := range builders.Items {
+		builder := &builders.Items[i]
+		if !meta.IsStatusConditionTrue(builder.Status.Conditions, osbuildv1alpha1.ConditionReady) || !composerReachable(builder) {
+			unreachable = append(unreachable, builder.Name)
+			continue
+		}
+		composerClient, err := builderComposerClient(ctx, r.Client, builder)
+		if err != nil {
+			logger.Error(err, "Could not read the credentials of the composer API")
+			unreachable = append(unreachable, builder.Name)
+			continue
+		}
+		if err := pushSource(ctx, composerClient, &source); err != nil {
+			if blueprintRejected(err) {
+				logger.Error(err, "Composer rejected the source")
+				setSourceCondition(&source, metav1.ConditionFalse, osbuildv1alpha1.ReasonSourceRejected, err.Error())
+				return ctrl.Result{}, r.Status().Update(ctx, &source)
+			}
+			logger.Info(fmt.Sprintf("Could not push source to ImageBuilder %s: %s", builder.Name, err))
+			unreachable = append(unreachable, builder.Name)
+			continue
+		}
+		pushed = append(pushed, builder.Name)
+	}
+	// builders the source could not be pushed to keep it when they had it
+	for _, name := range source.Status.Builders {
+		for _, builder := range unreachable {
+			if name == builder {
+				pushed = append(pushed, name)
+			}
+		}
+	}
+	sort.Strings(pushed)
+	source.Status.Builders = pushed
+	switch {
+	case len(builders.Items) == 0:
+		setSourceCondition(&source, metav1.ConditionFalse, osbuildv1alpha1.ReasonNoBuilders,
+			fmt.Sprintf("Namespace %s has no ImageBuilder, the source is pushed by the builds using it", req.Namespace))
+	case len(unreachable) > 0:
+		setSourceCondition(&source, metav1.ConditionFalse, osbuildv1alpha1.ReasonComposerUnavailable,
+			fmt.Sprintf("Composer of ImageBuilders %s does not answer", strings.Join(unreachable, ", ")))
+	default:
+		setSourceCondition(&source, metav1.ConditionTrue, osbuildv1alpha1.ReasonSourcePushed,
+			fmt.Sprintf("Source %s pushed to ImageBuilders %s", sourceID(&source), strings.Join(pushed, ", ")))
+	}
+	if err := r.Status().Update(ctx, &source); err != nil {
+		logger.Error(err, "Could not update ImageBuilderSource status")
+		return ctrl.Result{}, err
+	}
+	if len(unreachable) > 0 {
+		return ctrl.Result{RequeueAfter: builderRequeueInterval}, nil
+	}
+	return ctrl.Result{RequeueAfter: inventoryInterval}, nil
+}
+
+// finalize deletes the source from the composers it was pushed to, leaving
+// it behind on the ones that do not answer
+func (r *ImageBuilderSourceReconciler) finalize(ctx context.Context, source *osbuildv1alpha1.ImageBuilderSource) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	for _, name := range source.Status.Builders {
+		builder := osbuildv1alpha1.ImageBuilder{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: source.Namespace, Name: name}, &builder); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			logger.Error(err, "Could not get ImageBuilder")
+			return ctrl.Result{}, err
+		}
+		composerClient, err := builderComposerClient(ctx, r.Client, &builder)
+		if err != nil {
+			logger.Info(fmt.Sprintf("Could not read the credentials of the composer API of ImageBuilder %s, leaving source %s behind: %s", name, sourceID(source), err))
+			continue
+		}
+		if err := composerClient.DeleteSource(ctx, sourceID(source)); err != nil {
+			logger.Info(fmt.Sprintf("Could not delete source %s from ImageBuilder %s, leaving it behind: %s", sourceID(source), name, err))
+		}
+	}
+	if controllerutil.RemoveFinalizer(source, sourceFinalizer) {
+		if err := r.Update(ctx, source); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "Could not remove finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// setSourceCondition sets the Ready condition of a source
+func setSourceCondition(source *osbuildv1alpha1.ImageBuilderSource, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&source.Status.Conditions, metav1.Condition{
+		Type:               osbuildv1alpha1.ConditionReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: source.Generation,
+	})
+}
+
+// sourceID is the id of a source in composer
+func sourceID(source *osbuildv1alpha1.ImageBuilderSource) string {
+	if source.Spec.ID != "" {
+		return source.Spec.ID
+	}
+	return source.Name
+}
+
+// pushSource stores a source in composer
+func pushSource(ctx context.Context, composerClient *composer.Client, source *osbuildv1alpha1.ImageBuilderSource) error {
+	sourceType := source.Spec.Type
+	if sourceType == "" {
+		sourceType = osbuildv1alpha1.SourceBaseURL
+	}
+	return composerClient.PushSource(ctx, composer.Source{
+		ID:       sourceID(source),
+		Name:     source.Name,
+		Type:     string(sourceType),
+		URL:      source.Spec.URL,
+		CheckGPG: source.Spec.CheckGPG,
+		CheckSSL: pointer.BoolDeref(source.Spec.CheckSSL, true),
+		GPGKeys:  source.Spec.GPGKeys,
+		Distros:  source.Spec.Distros,
+	})
+}
+
+// buildersToSources maps an ImageBuilder to the sources of its namespace, so
+// that builders get them as soon as composer answers
+func (r *ImageBuilderSourceReconciler) buildersToSources(ctx context.Context, object client.Object) []reconcile.Request {
+	sources := osbuildv1alpha1.ImageBuilderSourceList{}
+	if err := r.List(ctx, &sources, client.InNamespace(object.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Could not list ImageBuilderSources")
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, source := range sources.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&source)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ImageBuilderSourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&osbuildv1alpha1.ImageBuilderSource{}).
+		Watches(&osbuildv1alpha1.ImageBuilder{}, handler.EnqueueRequestsFromMapFunc(r.buildersToSources)).
+		Complete(r)
+}
@@ -0,0 +1,455 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/apis"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+)
+
+const imagePromotionLabel = "osbuild-operator-promotion"
+
+// promotionRequeueInterval is how often promotions waiting for their image or
+// upload are checked
+const promotionRequeueInterval = time.Minute
+
+// ImagePromotionReconciler reconciles an ImagePromotion object by copying the
+// pushed artifact, or creating the ostree ref of the published commit, with a
+// TaskRun. A promotion runs once, it is deleted and
+// created again to run it again.
+type ImagePromotionReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagepromotions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagepromotions/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagepromotions/finalizers,verbs=update
+//+kubebuilder:rbac:groups=tekton.dev,resources=taskruns,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=osbuild.rh-ecosystem-edge.io,resources=imagebuilders,verbs=get;list;watch
+
+// Reconcile resolves the promoted digest and runs the TaskRun promoting it
+func (r *ImagePromotionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var promotion osbuildv1alpha1.ImagePromotion
+	if err := r.Get(ctx, req.NamespacedName, &promotion); err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Resource not found, must have been deleted")
+			if err := DeleteAllObjectsWithLabel(ctx, r.Client, "TaskRun", "tekton.dev/v1", req.Namespace, imagePromotionLabel, req.Name); err != nil {
+				logger.Error(err, "Could not delete TaskRun")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "Unable to fetch ImagePromotion")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if promotion.Status.State == osbuildv1alpha1.PromotionSucceeded || promotion.Status.State == osbuildv1alpha1.PromotionFailed {
+		return ctrl.Result{}, nil
+	}
+
+	if message := promotionSpecError(&promotion.Spec); message != "" {
+		promotion.Status.State = osbuildv1alpha1.PromotionFailed
+		promotion.Status.Message = message
+		if err := r.Status().Update(ctx, &promotion); err != nil {
+			logger.Error(err, "Could not update ImagePromotion status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	image := osbuildv1alpha1.ImageBuilderImage{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: promotion.Spec.Image}, &image); err != nil {
+		if errors.IsNotFound(err) {
+			return r.promotionPending(ctx, &promotion, fmt.Sprintf("ImageBuilderImage %s not found", promotion.Spec.Image))
+		}
+		logger.Error(err, "Could not get ImageBuilderImage")
+		return ctrl.Result{}, err
+	}
+	var steps []tektonv1.Step
+	var volumes []corev1.Volume
+	var message string
+	switch {
+	case promotion.Spec.OSTreeRef != "":
+		var err error
+		steps, volumes, message, err = r.ostreePromotion(ctx, &promotion, &image)
+		if err != nil {
+			logger.Error(err, "Could not list ImageBuilders")
+			return ctrl.Result{}, err
+		}
+	case promotion.Spec.S3 != nil:
+		steps, message = s3Promotion(&promotion, &image)
+	default:
+		steps, volumes, message = registryPromotion(&promotion, &image)
+	}
+	if message != "" {
+		return r.promotionPending(ctx, &promotion, message)
+	}
+
+	taskRun := r.PromotionTaskRun(&promotion, steps, volumes)
+	if err := r.Create(ctx, &taskRun); err != nil {
+		if errors.IsAlreadyExists(err) {
+			logger.Info("TaskRun already exists")
+		} else {
+			logger.Error(err, "Could not create TaskRun")
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&taskRun), &taskRun); err != nil {
+		logger.Error(err, "Could not get TaskRun")
+		return ctrl.Result{}, err
+	}
+	promotion.Status.TaskRun = taskRun.Name
+	promotion.Status.Message = ""
+	succeeded := taskRun.Status.GetCondition(apis.ConditionSucceeded)
+	switch {
+	case !taskRun.IsDone():
+		promotion.Status.State = osbuildv1alpha1.PromotionRunning
+	case succeeded.IsTrue():
+		promotion.Status.State = osbuildv1alpha1.PromotionSucceeded
+		logger.Info(fmt.Sprintf("Promoted %s to %s", promotion.Status.Source, promotion.Status.Reference))
+	default:
+		promotion.Status.State = osbuildv1alpha1.PromotionFailed
+		promotion.Status.Message = succeeded.Message
+	}
+	if err := r.Status().Update(ctx, &promotion); err != nil {
+		logger.Error(err, "Could not update ImagePromotion status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// promotionPending reports why a promotion can not run yet
+func (r *ImagePromotionReconciler) promotionPending(ctx context.Context, promotion *osbuildv1alpha1.ImagePromotion, message string) (ctrl.Result, error) {
+	log.FromContext(ctx).Info(message)
+	promotion.Status.State = osbuildv1alpha1.PromotionPending
+	promotion.Status.Message = message
+	if err := r.Status().Update(ctx, promotion); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: promotionRequeueInterval}, nil
+}
+
+// promotionSpecError tells why a promotion can never run
+func promotionSpecError(spec *osbuildv1alpha1.ImagePromotionSpec) string {
+	destinations := 0
+	if spec.To != nil {
+		destinations++
+	}
+	if spec.S3 != nil {
+		destinations++
+	}
+	if spec.OSTreeRef != "" {
+		destinations++
+	}
+	switch {
+	case destinations != 1:
+		return "Exactly one of to, s3 and ostreeRef must be set"
+	case spec.OSTreeRef == "" && spec.From == "":
+		return "from must be set to promote to a registry or bucket"
+	}
+	return ""
+}
+
+// promotionSource returns the upload target from of the image
+func promotionSource(image *osbuildv1alpha1.ImageBuilderImage, from string) *osbuildv1alpha1.UploadTarget {
+	for i := range image.Spec.UploadTargets {
+		if image.Spec.UploadTargets[i].Name == from {
+			return &image.Spec.UploadTargets[i]
+		}
+	}
+	return nil
+}
+
+// registryPromotion resolves the digest of the artifact of a registry upload
+// target and returns the step copying it with oras, which keeps its digest.
+// The message tells why it can not be copied yet.
+func registryPromotion(promotion *osbuildv1alpha1.ImagePromotion, image *osbuildv1alpha1.ImageBuilderImage) ([]tektonv1.Step, []corev1.Volume, string) {
+	target := promotionSource(image, promotion.Spec.From)
+	if target == nil || target.Registry == nil {
+		return nil, nil, fmt.Sprintf("ImageBuilderImage %s has no registry upload target %s", image.Name, promotion.Spec.From)
+	}
+
+	// the digest is resolved once, later builds of the image don't change
+	// what is promoted
+	if promotion.Status.Digest == "" {
+		promotion.Status.Digest = promotion.Spec.Digest
+		for _, upload := range image.Status.Uploads {
+			if promotion.Status.Digest == "" && upload.Name == target.Name && upload.State == osbuildv1alpha1.UploadSucceeded {
+				promotion.Status.Digest = upload.Digest
+			}
+		}
+		if promotion.Status.Digest == "" {
+			return nil, nil, fmt.Sprintf("Waiting for the upload of ImageBuilderImage %s to %s", image.Name, target.Name)
+		}
+	}
+	promotion.Status.Source = target.Registry.Repository + "@" + promotion.Status.Digest
+	tag := promotion.Spec.To.Tag
+	if tag == "" {
+		tag = target.Registry.Tag
+	}
+	if tag == "" {
+		tag = strings.TrimPrefix(promotion.Status.Digest, "sha256:")[:12]
+	}
+	promotion.Status.Reference = promotion.Spec.To.Repository + ":" + tag
+
+	flags := []string{}
+	volumes := []corev1.Volume{}
+	mounts := []corev1.VolumeMount{}
+	registries := []struct {
+		direction string
+		registry  osbuildv1alpha1.RegistryUploadTarget
+	}{
+		{"from", *target.Registry},
+		{"to", *promotion.Spec.To},
+	}
+	for _, registry := range registries {
+		if registry.registry.Insecure {
+			flags = append(flags, "--"+registry.direction+"-plain-http")
+		}
+		if registry.registry.CredentialsSecret == "" {
+			continue
+		}
+		flags = append(flags, "--"+registry.direction+"-registry-config", "/registry-auth/"+registry.direction+"/config.json")
+		volumes = append(volumes, corev1.Volume{
+			Name: "registry-auth-" + registry.direction,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: registry.registry.CredentialsSecret,
+					Items: []corev1.KeyToPath{
+						{
+							Key:  corev1.DockerConfigJsonKey,
+							Path: "config.json",
+						},
+					},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      "registry-auth-" + registry.direction,
+			MountPath: "/registry-auth/" + registry.direction,
+			ReadOnly:  true,
+		})
+	}
+	return []tektonv1.Step{
+		{
+			Name:  "copy",
+			Image: orasImage,
+			Command: append(append([]string{"oras", "copy"}, flags...),
+				promotion.Status.Source, promotion.Status.Reference),
+			VolumeMounts: mounts,
+		},
+	}, volumes, ""
+}
+
+// s3Promotion resolves the location of the artifacts of an s3 upload target
+// and returns the steps downloading them with the credentials of the target
+// and uploading them with the ones of the destination bucket. The message
+// tells why they can not be copied yet.
+func s3Promotion(promotion *osbuildv1alpha1.ImagePromotion, image *osbuildv1alpha1.ImageBuilderImage) ([]tektonv1.Step, string) {
+	target := promotionSource(image, promotion.Spec.From)
+	if target == nil || target.S3 == nil {
+		return nil, fmt.Sprintf("ImageBuilderImage %s has no s3 upload target %s", image.Name, promotion.Spec.From)
+	}
+
+	// the location holds the generation of the build, it is resolved once
+	// so later builds of the image don't change what is promoted
+	if promotion.Status.Source == "" {
+		for _, upload := range image.Status.Uploads {
+			if promotion.Status.Source == "" && upload.Name == target.Name && upload.State == osbuildv1alpha1.UploadSucceeded {
+				promotion.Status.Source = upload.URL
+			}
+		}
+		if promotion.Status.Source == "" {
+			return nil, fmt.Sprintf("Waiting for the upload of ImageBuilderImage %s to %s", image.Name, target.Name)
+		}
+	}
+	prefix := promotion.Spec.S3.Prefix
+	if prefix == "" {
+		_, prefix, _ = strings.Cut(strings.TrimPrefix(promotion.Status.Source, "s3://"), "/")
+	}
+	promotion.Status.Reference = "s3://" + promotion.Spec.S3.Bucket + "/" + strings.Trim(prefix, "/") + "/"
+
+	return []tektonv1.Step{
+		s3PromotionStep("download", target.S3, promotion.Status.Source, promotionArtifactsPath),
+		s3PromotionStep("upload", promotion.Spec.S3, promotionArtifactsPath, promotion.Status.Reference),
+	}, ""
+}
+
+// promotionArtifactsPath is where the artifacts copied between buckets are
+// kept by the steps of the TaskRun
+const promotionArtifactsPath = "/workspace/artifacts/"
+
+// s3PromotionStep copies the artifacts from source to destination with the
+// AWS CLI, authenticated to bucket
+func s3PromotionStep(name string, bucket *osbuildv1alpha1.S3UploadTarget, source string, destination string) tektonv1.Step {
+	command := []string{"aws", "s3", "cp", "--only-show-errors", "--recursive"}
+	if bucket.Endpoint != "" {
+		command = append(command, "--endpoint-url", bucket.Endpoint)
+	}
+	step := tektonv1.Step{
+		Name:    name,
+		Image:   awsCLIImage,
+		Command: append(command, source, destination),
+	}
+	if bucket.Region != "" {
+		step.Env = []corev1.EnvVar{{Name: "AWS_DEFAULT_REGION", Value: bucket.Region}}
+	}
+	if bucket.CredentialsSecret != "" {
+		step.EnvFrom = []corev1.EnvFromSource{
+			{
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: bucket.CredentialsSecret},
+				},
+			},
+		}
+	}
+	return step
+}
+
+// ostreePromotionScript points the promoted ref at the edge commit in the
+// repository of the builder and publishes it in the summary
+const ostreePromotionScript = `#!/bin/sh
+set -e
+ostree refs --repo=/ostree/repo --force --create="${ref}" "${commit}"
+ostree summary --update --repo=/ostree/repo
+`
+
+// ostreePromotion resolves the edge commit the image published to the ostree
+// repository of its builder and returns the step pointing the promoted ref
+// at it. The message tells why the ref can not be created yet.
+func (r *ImagePromotionReconciler) ostreePromotion(ctx context.Context, promotion *osbuildv1alpha1.ImagePromotion, image *osbuildv1alpha1.ImageBuilderImage) ([]tektonv1.Step, []corev1.Volume, string, error) {
+	if image.Status.OSTree == nil || image.Status.OSTree.Commit == "" {
+		return nil, nil, fmt.Sprintf("Waiting for ImageBuilderImage %s to publish a commit to the ostree repository of its builder", image.Name), nil
+	}
+	// the commit is resolved once, later builds of the image don't change
+	// what is promoted
+	if promotion.Status.Digest == "" {
+		promotion.Status.Digest = image.Status.OSTree.Commit
+		promotion.Status.Source = image.Status.OSTree.Ref
+	}
+	promotion.Status.Reference = promotion.Spec.OSTreeRef
+
+	// the repository is only published by the builders of the namespace of
+	// the image
+	builders := osbuildv1alpha1.ImageBuilderList{}
+	if err := r.List(ctx, &builders, client.InNamespace(image.Namespace)); err != nil {
+		return nil, nil, "", err
+	}
+	claim := ""
+	for _, builder := range builders.Items {
+		if builder.Status.OSTreeRepositoryURL == image.Status.OSTree.URL {
+			claim = ostreeRepositoryName(builder.Name)
+		}
+	}
+	if claim == "" {
+		return nil, nil, fmt.Sprintf("No ImageBuilder of the namespace serves the ostree repository %s", image.Status.OSTree.URL), nil
+	}
+	return []tektonv1.Step{
+		{
+			Name:   "create-ref",
+			Image:  ostreeImage,
+			Script: ostreePromotionScript,
+			Env: []corev1.EnvVar{
+				{
+					Name:  "ref",
+					Value: promotion.Status.Reference,
+				},
+				{
+					Name:  "commit",
+					Value: promotion.Status.Digest,
+				},
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      ostreePublishName,
+					MountPath: "/ostree",
+				},
+			},
+		},
+	}, []corev1.Volume{
+		{
+			Name: ostreePublishName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: claim,
+				},
+			},
+		},
+	}, "", nil
+}
+
+// PromotionTaskRun runs the steps promoting the artifact
+func (r *ImagePromotionReconciler) PromotionTaskRun(promotion *osbuildv1alpha1.ImagePromotion, steps []tektonv1.Step, volumes []corev1.Volume) tektonv1.TaskRun {
+	return tektonv1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      promotion.Name + "-promote",
+			Namespace: promotion.Namespace,
+			Labels: map[string]string{
+				imagePromotionLabel:    promotion.Name,
+				imageBuilderImageLabel: promotion.Spec.Image,
+			},
+		},
+		Spec: tektonv1.TaskRunSpec{
+			TaskSpec: &tektonv1.TaskSpec{
+				Steps:   steps,
+				Volumes: volumes,
+			},
+		},
+	}
+}
+
+// promotionOf maps a TaskRun to the ImagePromotion that created it
+func promotionOf(ctx context.Context, object client.Object) []reconcile.Request {
+	name, ok := object.GetLabels()[imagePromotionLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{
+		{
+			NamespacedName: client.ObjectKey{
+				Namespace: object.GetNamespace(),
+				Name:      name,
+			},
+		},
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ImagePromotionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&osbuildv1alpha1.ImagePromotion{}).
+		Watches(&tektonv1.TaskRun{}, handler.EnqueueRequestsFromMapFunc(promotionOf)).
+		Complete(r)
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	kubevirt "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ImageResolver picks the helper images run by the build pods for the
+// architecture of their nodes. Images without a reference for an
+// architecture are used as is, which works for multi-arch images.
+type ImageResolver struct {
+	// Images maps the default reference of an image, e.g.
+	// quay.io/cgament/composer-cli, to its reference per architecture,
+	// usually pinned to the digest of the image for that architecture
+	Images map[string]map[osbuildv1alpha1.Architecture]string
+	// Overrides replaces default references before they are resolved for an
+	// architecture
+	Overrides map[string]string
+}
+
+// LoadImageResolver reads the per-architecture references of the helper
+// images from a JSON file, usually mounted from a ConfigMap
+func LoadImageResolver(path string) (*ImageResolver, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	resolver := ImageResolver{}
+	if err := json.Unmarshal(data, &resolver.Images); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	known := map[osbuildv1alpha1.Architecture]bool{}
+	for _, arch := range osbuildv1alpha1.Architectures {
+		known[arch] = true
+	}
+	for image, references := range resolver.Images {
+		for arch, reference := range references {
+			if !known[arch] {
+				return nil, fmt.Errorf("%s: unknown architecture %q for image %s", path, arch, image)
+			}
+			if reference == "" {
+				return nil, fmt.Errorf("%s: empty reference for image %s on %s", path, image, arch)
+			}
+		}
+	}
+	return &resolver, nil
+}
+
+// WithOverrides returns a resolver replacing the default references of
+// overrides, the replacements being resolved for an architecture in turn
+func (r *ImageResolver) WithOverrides(overrides map[string]string) *ImageResolver {
+	resolver := ImageResolver{Overrides: overrides}
+	if r != nil {
+		resolver.Images = r.Images
+	}
+	return &resolver
+}
+
+// Resolve returns the reference of image for arch, a nil resolver or an
+// empty architecture keeping the default reference
+func (r *ImageResolver) Resolve(image string, arch osbuildv1alpha1.Architecture) string {
+	if r == nil {
+		return image
+	}
+	if override, ok := r.Overrides[image]; ok {
+		image = override
+	}
+	if arch == "" {
+		return image
+	}
+	if reference, ok := r.Images[image][arch]; ok {
+		return reference
+	}
+	return image
+}
+
+// setStepImages resolves the images of the steps of a generated task for the
+// architecture of the builder
+func setStepImages(spec *tektonv1.TaskSpec, images *ImageResolver, arch osbuildv1alpha1.Architecture) {
+	for i := range spec.Steps {
+		spec.Steps[i].Image = images.Resolve(spec.Steps[i].Image, arch)
+	}
+}
+
+// setPipelineImages resolves the images of the tasks embedded in a generated
+// pipeline, referenced tasks being resolved with setStepImages
+func setPipelineImages(pipeline *tektonv1.Pipeline, images *ImageResolver, arch osbuildv1alpha1.Architecture) {
+	for _, tasks := range [][]tektonv1.PipelineTask{pipeline.Spec.Tasks, pipeline.Spec.Finally} {
+		for i := range tasks {
+			if tasks[i].TaskSpec != nil {
+				setStepImages(&tasks[i].TaskSpec.TaskSpec, images, arch)
+			}
+		}
+	}
+}
+
+// architectureSelector schedules pods on the nodes of an architecture, any
+// node when it is empty
+func architectureSelector(arch osbuildv1alpha1.Architecture) map[string]string {
+	if arch == "" {
+		return nil
+	}
+	return map[string]string{
+		corev1.LabelArchStable: string(arch),
+	}
+}
+
+// checkArchitecture records the architecture of the node running the VM of
+// a builder, composer and its workers running on it. It returns a message
+// when it is not spec.architecture, nothing being checked before the VM is
+// scheduled.
+func (r *ImageBuilderReconciler) checkArchitecture(ctx context.Context, builder *osbuildv1alpha1.ImageBuilder, vm *kubevirt.VirtualMachine) (string, error) {
+	instance := kubevirt.VirtualMachineInstance{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(vm), &instance); err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if instance.Status.NodeName == "" {
+		return "", nil
+	}
+	node := corev1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: instance.Status.NodeName}, &node); err != nil {
+		return "", err
+	}
+	builder.Status.Architecture = osbuildv1alpha1.Architecture(node.Labels[corev1.LabelArchStable])
+	if builder.Spec.Architecture != "" && builder.Status.Architecture != builder.Spec.Architecture {
+		return fmt.Sprintf("Image Builder VM runs on node %s of architecture %q instead of %q, composer would build images for the wrong architecture",
+			node.Name, builder.Status.Architecture, builder.Spec.Architecture), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// inventoryInterval is how often the composer inventory of a builder is refreshed
+const inventoryInterval = 5 * time.Minute
+
+// maxOrphanedBlueprints limits the orphaned blueprints listed in status
+const maxOrphanedBlueprints = 20
+
+// ownedBlueprints returns the names of the blueprints rendered by the
+// ImageBuilderImages of the cluster
+func (r *ImageBuilderReconciler) ownedBlueprints(ctx context.Context) (map[string]bool, error) {
+	images := osbuildv1alpha1.ImageBuilderImageList{}
+	if err := r.List(ctx, &images); err != nil {
+		return nil, err
+	}
+	owned := map[string]bool{}
+	for _, image := range images.Items {
+		name := image.Spec.Name
+		if name == "" {
+			name = image.Name
+		}
+		owned[name] = true
+		owned[fmt.Sprintf("%s-iso", name)] = true
+	}
+	return owned, nil
+}
+
+// composerInventory queries composer for its blueprints and composes. Blueprints
+// not in owned are reported as orphans.
+func composerInventory(ctx context.Context, composerClient *composer.Client, owned map[string]bool) (*osbuildv1alpha1.ComposerInventory, error) {
+	blueprints, err := composerClient.Blueprints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queue, err := composerClient.Queue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	finished, err := composerClient.Finished(ctx)
+	if err != nil {
+		return nil, err
+	}
+	failed, err := composerClient.Failed(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := osbuildv1alpha1.ComposerInventory{
+		Blueprints:       int32(len(blueprints)),
+		QueuedComposes:   int32(len(queue.New)),
+		RunningComposes:  int32(len(queue.Run)),
+		FinishedComposes: int32(len(finished)),
+		FailedComposes:   int32(len(failed)),
+		LastUpdated:      metav1.Now(),
+	}
+	orphans := []string{}
+	for _, blueprint := range blueprints {
+		if !owned[blueprint] {
+			orphans = append(orphans, blueprint)
+		}
+	}
+	sort.Strings(orphans)
+	if len(orphans) > maxOrphanedBlueprints {
+		orphans = orphans[:maxOrphanedBlueprints]
+	}
+	inventory.OrphanedBlueprints = orphans
+	for _, composes := range [][]composer.ComposeInfo{queue.New, queue.Run, finished, failed} {
+		for _, compose := range composes {
+			if !owned[compose.Blueprint] {
+				inventory.OrphanedComposes++
+			}
+		}
+	}
+	return &inventory, nil
+}
@@ -0,0 +1,657 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// jobMarkersDir is where the containers of a build Job mark that their step
+// is done, so the steps run one after the other
+const jobMarkersDir = "/osbuild-job"
+
+// jobReferenceRe matches the references to results and to other tasks left in
+// a step once BuildJob substituted the declared results
+var jobReferenceRe = regexp.MustCompile(`\$\((results|tasks)\.[^)]*\)`)
+
+// jobBuild holds what the build of an image run by a Job is made of
+type jobBuild struct {
+	names          GeneratedNames
+	generated      metav1.ObjectMeta
+	composerClient *composer.Client
+	// blueprints are stored in the ConfigMap, or Secret when sensitive,
+	// named blueprintsName
+	blueprints     map[string]string
+	blueprintsName string
+	sensitive      bool
+	pvcName        string
+	affinity       *corev1.Affinity
+	ephemeral      bool
+	// planned are the other resources a dry run would create
+	planned []client.Object
+}
+
+// imageExecutor returns what runs the builds of an image, Tekton when it is
+// installed unless the image selects one
+func (r *ImageBuilderImageReconciler) imageExecutor(image *osbuildv1alpha1.ImageBuilderImage) osbuildv1alpha1.Executor {
+	if image.Spec.Executor != "" {
+		return image.Spec.Executor
+	}
+	if r.Tekton {
+		return osbuildv1alpha1.ExecutorTekton
+	}
+	return osbuildv1alpha1.ExecutorJob
+}
+
+// tektonOnly returns the field of an image spec only the tekton executor
+// supports, empty when it has none
+func tektonOnly(spec *osbuildv1alpha1.ImageBuilderImageSpec) string {
+	switch {
+	case spec.PipelineRef != nil:
+		return "pipelineRef"
+	case spec.Hooks != nil:
+		return "hooks"
+	case len(spec.UploadTargets) > 0:
+		return "uploadTargets"
+	}
+	return ""
+}
+
+// reconcileBuildJob builds an image with a Job running the steps of the
+// generated tasks instead of a PipelineRun
+func (r *imageReconcile) reconcileBuildJob(ctx context.Context, imageBuilderImage *osbuildv1alpha1.ImageBuilderImage, imageBuilder *osbuildv1alpha1.ImageBuilder, build jobBuild) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	tasks := r.generatedTasks(imageBuilderImage, imageBuilder, build.names, build.generated, build.ephemeral)
+	if !build.ephemeral {
+		tasks = append(tasks, tektonv1.Task{Spec: cleanupBuildsTask().TaskSpec.TaskSpec})
+	}
+	// the Job would run them with the references left as is
+	if step, reference := jobUnsupportedReference(tasks); step != "" {
+		message := fmt.Sprintf("Step %s references %s, which the job executor does not support", step, reference)
+		logger.Info(message)
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonExecutorUnavailable, message)
+		setImageCondition(imageBuilderImage, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, osbuildv1alpha1.ReasonExecutorUnavailable, message)
+		return ctrl.Result{}, updateImageStatus(ctx, r.Client, imageBuilderImage)
+	}
+	triggers, err := buildAnnotations(imageBuilderImage)
+	if err != nil {
+		logger.Error(err, "Could not hash ImageBuilderImage spec")
+		return ctrl.Result{}, err
+	}
+	generation := strconv.FormatInt(imageBuilderImage.Generation, 10)
+	jobMeta := *build.generated.DeepCopy()
+	jobMeta.Name = build.names.BuildJob
+	jobMeta.Labels = mergeMaps(build.generated.Labels, map[string]string{
+		imageBuilderImageGenerationLabel: generation,
+		builderUIDLabel:                  string(imageBuilder.UID),
+	})
+	jobMeta.Annotations = mergeMaps(build.generated.Annotations, triggers, map[string]string{
+		buildRecordAnnotation: build.names.BuildRecord,
+	})
+	buildJob := BuildJob(jobMeta, tasks,
+		append([]tektonv1.WorkspaceBinding{
+			blueprintsWorkspace(build.blueprintsName, build.sensitive),
+		}, buildWorkspaces(imageBuilderImage, build.pvcName)...),
+		map[string]string{
+			"blueprintName": imageBuilderImage.Name,
+			"apiEndpoint":   build.composerClient.Endpoint,
+			"generation":    generation,
+		},
+		buildPodTemplate(r.buildPod(imageBuilderImage), imageBuilder.Spec.Architecture, build.affinity))
+	if timeouts := imageBuilderImage.Spec.ComposeTimeouts; timeouts != nil && timeouts.Total != nil {
+		buildJob.Spec.ActiveDeadlineSeconds = pointer.Int64(int64(timeouts.Total.Seconds()))
+	}
+	if imageBuilderImage.Spec.DryRun {
+		return r.reconcilePlan(ctx, imageBuilderImage, build.names, build.generated, build.blueprints, build.sensitive, append(build.planned, &buildJob))
+	}
+
+	// a PipelineRun left by the tekton executor is replaced by the Job
+	if name := imageBuilderImage.Status.PipelineRun; r.Tekton && name != "" {
+		pipelineRun := tektonv1.PipelineRun{}
+		if err := deleteGeneratedObject(ctx, r.Client, client.ObjectKey{Namespace: imageBuilderImage.Namespace, Name: name}, &pipelineRun, imageBuilderImage.Name); err != nil {
+			logger.Error(err, "Could not delete image pipelinerun")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// a build of an older generation is replaced, its deletion triggers
+	// the reconcile creating the new one
+	existingJob := batchv1.Job{}
+	err = r.Get(ctx, client.ObjectKeyFromObject(&buildJob), &existingJob)
+	suspended := imageBuilderImage.Spec.Suspend
+	if err == nil && superseded(imageBuilderImage, &existingJob) && suspended && jobFinished(&existingJob) {
+		return r.suspendBuild(ctx, imageBuilderImage)
+	}
+	// a suspended image lets its build finish
+	if err == nil && superseded(imageBuilderImage, &existingJob) && !suspended {
+		if existingJob.DeletionTimestamp != nil {
+			return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+		}
+		if err := r.supersedeJob(ctx, imageBuilderImage, &existingJob, build.composerClient); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// quotas only hold back new builds
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			logger.Error(err, "Could not get image build job")
+			return ctrl.Result{}, err
+		}
+		if suspended {
+			return r.suspendBuild(ctx, imageBuilderImage)
+		}
+		if admitted, result, err := r.admitBuild(ctx, imageBuilderImage, imageBuilder, build.composerClient, build.blueprints); !admitted {
+			return result, err
+		}
+	}
+	if err := r.Create(ctx, &buildJob); err != nil {
+		if errors.IsAlreadyExists(err) {
+			logger.Info("Image build job already exists, skipping creation")
+		} else {
+			logger.Error(err, "Could not create image build job")
+			return ctrl.Result{}, err
+		}
+	} else {
+		if err := r.recordBuildStart(ctx, imageBuilderImage, imageBuilder, build.names, build.generated, "Job", buildJob.Name, build.blueprintsName, build.sensitive); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.pruneHistory(ctx, imageBuilderImage, build.composerClient, buildJob.Name); err != nil {
+			logger.Error(err, "Could not delete builds beyond the history limit")
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&buildJob), &buildJob); err != nil {
+		logger.Error(err, "Could not get image build job")
+		return ctrl.Result{}, err
+	}
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(buildJob.Namespace), client.MatchingLabels{"job-name": buildJob.Name}); err != nil {
+		logger.Error(err, "Could not get image build job pods")
+		return ctrl.Result{}, err
+	}
+	previousReady := meta.FindStatusCondition(imageBuilderImage.Status.Conditions, osbuildv1alpha1.ConditionReady).DeepCopy()
+	setJobConditions(imageBuilderImage, &buildJob, jobFailureReason(&buildJob, pods.Items))
+	r.recordBuildCompletion(imageBuilderImage, "Job", buildJob.Name, jobFinished(&buildJob), previousReady)
+	setJobProgress(imageBuilderImage, &buildJob, pods.Items)
+	if err := r.recordStepImageDigests(ctx, imageBuilderImage, pods.Items); err != nil {
+		logger.Error(err, "Could not record step image digests in build record")
+		return ctrl.Result{}, err
+	}
+	setHistoryEntry(imageBuilderImage, buildJob.Name, jobResult(&buildJob))
+
+	result := ctrl.Result{}
+	// the steps of the pod are not watched
+	if !jobFinished(&buildJob) {
+		result.RequeueAfter = composeRequeueInterval
+	}
+	return r.serveArtifacts(ctx, imageBuilderImage, build.names, build.generated, build.pvcName, build.affinity, build.ephemeral, scheduleRequeue(imageBuilderImage, result))
+}
+
+// supersedeJob replaces a superseded build Job the same way supersedeBuild
+// replaces a PipelineRun
+func (r *ImageBuilderImageReconciler) supersedeJob(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, job *batchv1.Job, composerClient *composer.Client) error {
+	logger := log.FromContext(ctx)
+	if !jobFinished(job) {
+		logger.Info(fmt.Sprintf("Cancelling Job %s superseded by generation %d", job.Name, image.Generation))
+		var cancelled []string
+		if job.Status.StartTime != nil {
+			ids, err := buildComposeIDs(ctx, r.Client, job.Namespace, job.Name, true)
+			if err == nil {
+				cancelled, err = cancelBuildComposes(ctx, ids, composerClient)
+			}
+			if err != nil {
+				// the build is replaced anyway, the composes are left to composer
+				logger.Error(err, "Could not cancel composes of superseded build")
+				r.Recorder.Event(image, corev1.EventTypeWarning, osbuildv1alpha1.EventBuildSuperseded,
+					eventMessage(fmt.Sprintf("Could not cancel the composes of Job %s: %s", job.Name, err)))
+			}
+		}
+		if err := r.recordCancellation(ctx, image, job, cancelled); err != nil {
+			logger.Error(err, "Could not record cancellation in build record")
+			return err
+		}
+		r.Recorder.Event(image, corev1.EventTypeNormal, osbuildv1alpha1.EventBuildSuperseded,
+			eventMessage(fmt.Sprintf("Cancelled Job %s and composes [%s], superseded by generation %d",
+				job.Name, strings.Join(cancelled, ", "), image.Generation)))
+	}
+	if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "Could not delete superseded job")
+		return err
+	}
+	return nil
+}
+
+// deleteGeneratedObject deletes the object generated for an image under key,
+// along with its dependents
+func deleteGeneratedObject(ctx context.Context, c client.Client, key client.ObjectKey, object client.Object, imageName string) error {
+	if err := c.Get(ctx, key, object); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	// never delete an object that was not generated for this image
+	if object.GetLabels()[imageBuilderImageLabel] != imageName {
+		return nil
+	}
+	if err := c.Delete(ctx, object, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// BuildJob runs the steps of tasks one after the other in the pod of a Job,
+// with the params substituted. Steps run as init containers until a task has
+// sidecars, which only run alongside regular containers: from there each step
+// runs as a container waiting for the previous one to be done, and the
+// sidecars until the last one is. The pod is scheduled as podTemplate says.
+func BuildJob(objectMeta metav1.ObjectMeta, tasks []tektonv1.Task, workspaces []tektonv1.WorkspaceBinding, params map[string]string, podTemplate *pod.PodTemplate) batchv1.Job {
+	replacements := []string{}
+	for name, value := range params {
+		replacements = append(replacements, fmt.Sprintf("$(params.%s)", name), value)
+	}
+	replacer := strings.NewReplacer(append(replacements, jobResultPaths(tasks)...)...)
+	volumes, mounts := workspaceVolumes(workspaces)
+	volumes = append(volumes, corev1.Volume{
+		Name: "job-markers",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	mounts = append(mounts, corev1.VolumeMount{
+		Name:      "job-markers",
+		MountPath: jobMarkersDir,
+	})
+
+	podSpec := corev1.PodSpec{
+		RestartPolicy:    corev1.RestartPolicyNever,
+		Affinity:         podTemplate.Affinity,
+		NodeSelector:     podTemplate.NodeSelector,
+		Tolerations:      podTemplate.Tolerations,
+		ImagePullSecrets: podTemplate.ImagePullSecrets,
+		Volumes:          volumes,
+	}
+	stepNames := map[string]bool{}
+	sidecars := []tektonv1.Sidecar{}
+	previous := ""
+	volumeNames := map[string]bool{}
+	for _, volume := range podSpec.Volumes {
+		volumeNames[volume.Name] = true
+	}
+	for counter, task := range tasks {
+		sidecars = append(sidecars, task.Spec.Sidecars...)
+		for _, volume := range task.Spec.Volumes {
+			if !volumeNames[volume.Name] {
+				volumeNames[volume.Name] = true
+				podSpec.Volumes = append(podSpec.Volumes, volume)
+			}
+		}
+		for _, step := range task.Spec.Steps {
+			if stepNames[step.Name] {
+				step.Name = fmt.Sprintf("%s-%d", step.Name, counter)
+			}
+			stepNames[step.Name] = true
+			container := corev1.Container{
+				Name:            step.Name,
+				Image:           step.Image,
+				Command:         step.Command,
+				Args:            step.Args,
+				WorkingDir:      step.WorkingDir,
+				Env:             step.Env,
+				EnvFrom:         step.EnvFrom,
+				Resources:       step.ComputeResources,
+				SecurityContext: step.SecurityContext,
+				VolumeMounts:    append(append([]corev1.VolumeMount{}, mounts...), step.VolumeMounts...),
+			}
+			if step.Script != "" {
+				container.Command, container.Args = scriptCommand(step.Script), nil
+			}
+			substituteParams(&container, replacer)
+			if len(sidecars) == 0 {
+				podSpec.InitContainers = append(podSpec.InitContainers, container)
+				continue
+			}
+			podSpec.Containers = append(podSpec.Containers, sequencedContainer(container, previous))
+			previous = container.Name
+		}
+	}
+	for _, sidecar := range sidecars {
+		container := corev1.Container{
+			Name:            sidecar.Name,
+			Image:           sidecar.Image,
+			Command:         sidecar.Command,
+			Args:            sidecar.Args,
+			WorkingDir:      sidecar.WorkingDir,
+			Env:             sidecar.Env,
+			Ports:           sidecar.Ports,
+			Resources:       sidecar.ComputeResources,
+			SecurityContext: sidecar.SecurityContext,
+			VolumeMounts:    mounts,
+		}
+		if sidecar.Script != "" {
+			container.Command, container.Args = scriptCommand(sidecar.Script), nil
+		}
+		substituteParams(&container, replacer)
+		container.Command = []string{"/bin/sh", "-c", fmt.Sprintf("%s &\nuntil [ -f %[2]s/%[3]s ] || [ -f %[2]s/failed ]; do sleep 2; done\n",
+			shellCommand(container), jobMarkersDir, previous)}
+		container.Args = nil
+		podSpec.Containers = append(podSpec.Containers, container)
+	}
+	// a pod needs a container, the last step is one when there are no sidecars
+	if len(podSpec.Containers) == 0 && len(podSpec.InitContainers) > 0 {
+		last := len(podSpec.InitContainers) - 1
+		podSpec.Containers = podSpec.InitContainers[last:]
+		podSpec.InitContainers = podSpec.InitContainers[:last]
+	}
+
+	return batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: objectMeta,
+		Spec: batchv1.JobSpec{
+			// a failed compose is not retried by starting over
+			BackoffLimit: pointer.Int32(0),
+			// like PipelineRuns, builds wait to be started
+			Suspend: pointer.Bool(true),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: objectMeta.Labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+}
+
+// jobResultPaths are the replacements of the paths of the results declared by
+// tasks, written next to the markers
+func jobResultPaths(tasks []tektonv1.Task) []string {
+	replacements := []string{}
+	for _, task := range tasks {
+		for _, result := range task.Spec.Results {
+			replacements = append(replacements, fmt.Sprintf("$(results.%s.path)", result.Name), fmt.Sprintf("%s/results-%s", jobMarkersDir, result.Name))
+		}
+	}
+	return replacements
+}
+
+// jobUnsupportedReference returns the first step, or sidecar, of tasks
+// referencing a result no task declares or another task, which only Tekton
+// resolves, and the reference, empty when there is none
+func jobUnsupportedReference(tasks []tektonv1.Task) (string, string) {
+	replacer := strings.NewReplacer(jobResultPaths(tasks)...)
+	find := func(fields []string, env []corev1.EnvVar) string {
+		for _, variable := range env {
+			fields = append(fields, variable.Value)
+		}
+		for _, field := range fields {
+			if reference := jobReferenceRe.FindString(replacer.Replace(field)); reference != "" {
+				return reference
+			}
+		}
+		return ""
+	}
+	for _, task := range tasks {
+		for _, step := range task.Spec.Steps {
+			fields := append(append([]string{step.Script, step.WorkingDir}, step.Command...), step.Args...)
+			if reference := find(fields, step.Env); reference != "" {
+				return step.Name, reference
+			}
+		}
+		for _, sidecar := range task.Spec.Sidecars {
+			fields := append(append([]string{sidecar.Script, sidecar.WorkingDir}, sidecar.Command...), sidecar.Args...)
+			if reference := find(fields, sidecar.Env); reference != "" {
+				return sidecar.Name, reference
+			}
+		}
+	}
+	return "", ""
+}
+
+// workspaceVolumes turns workspace bindings into the volumes of a pod and the
+// mounts at the paths Tekton mounts them at, the bindings of the same claim
+// sharing a volume
+func workspaceVolumes(workspaces []tektonv1.WorkspaceBinding) ([]corev1.Volume, []corev1.VolumeMount) {
+	volumes := []corev1.Volume{}
+	mounts := []corev1.VolumeMount{}
+	claims := map[string]string{}
+	for _, workspace := range workspaces {
+		volume := corev1.Volume{
+			Name: workspace.Name,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir:  workspace.EmptyDir,
+				ConfigMap: workspace.ConfigMap,
+				Secret:    workspace.Secret,
+			},
+		}
+		if claim := workspace.PersistentVolumeClaim; claim != nil {
+			if name, ok := claims[claim.ClaimName]; ok {
+				volume.Name = name
+			} else {
+				claims[claim.ClaimName] = volume.Name
+				volume.PersistentVolumeClaim = claim
+				volumes = append(volumes, volume)
+			}
+		} else {
+			volumes = append(volumes, volume)
+		}
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      volume.Name,
+			MountPath: "/workspace/" + workspace.Name,
+			SubPath:   workspace.SubPath,
+		})
+	}
+	return volumes, mounts
+}
+
+// substituteParams replaces the params referenced by a container
+func substituteParams(container *corev1.Container, replacer *strings.Replacer) {
+	for i := range container.Command {
+		container.Command[i] = replacer.Replace(container.Command[i])
+	}
+	for i := range container.Args {
+		container.Args[i] = replacer.Replace(container.Args[i])
+	}
+	env := make([]corev1.EnvVar, 0, len(container.Env))
+	for _, variable := range container.Env {
+		variable.Value = replacer.Replace(variable.Value)
+		env = append(env, variable)
+	}
+	container.Env = env
+	container.WorkingDir = replacer.Replace(container.WorkingDir)
+}
+
+// scriptCommand runs a step script with the interpreter of its shebang, or
+// with a shell stopping on the first error like Tekton does
+func scriptCommand(script string) []string {
+	if strings.HasPrefix(script, "#!") {
+		interpreter := strings.Fields(strings.SplitN(script[2:], "\n", 2)[0])
+		if len(interpreter) > 0 {
+			return append(interpreter, "-c", script)
+		}
+	}
+	return []string{"/bin/sh", "-c", "set -e\n" + script}
+}
+
+// sequencedContainer runs the command of a step container once the step
+// named previous is done, marking the step done or the build failed
+func sequencedContainer(container corev1.Container, previous string) corev1.Container {
+	script := ""
+	if previous != "" {
+		script = fmt.Sprintf("until [ -f %[1]s/%[2]s ]; do [ -f %[1]s/failed ] && exit 0; sleep 1; done\n", jobMarkersDir, previous)
+	}
+	script += fmt.Sprintf("%s\ncode=$?\nif [ \"$code\" -ne 0 ]; then echo %s > %s/failed; exit \"$code\"; fi\ntouch %s/%s\n",
+		shellCommand(container), container.Name, jobMarkersDir, jobMarkersDir, container.Name)
+	container.Command = []string{"/bin/sh", "-c", script}
+	container.Args = nil
+	return container
+}
+
+// shellCommand quotes the command and arguments of a container for a shell
+func shellCommand(container corev1.Container) string {
+	words := []string{}
+	for _, word := range append(append([]string{}, container.Command...), container.Args...) {
+		words = append(words, "'"+strings.ReplaceAll(word, "'", `'\''`)+"'")
+	}
+	return strings.Join(words, " ")
+}
+
+// jobCondition returns the condition of conditionType of a Job when it is true
+func jobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		if job.Status.Conditions[i].Type == conditionType && job.Status.Conditions[i].Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// jobFinished tells if a Job completed or failed
+func jobFinished(job *batchv1.Job) bool {
+	return jobCondition(job, batchv1.JobComplete) != nil || jobCondition(job, batchv1.JobFailed) != nil
+}
+
+// setJobConditions translates the build Job state into the Ready and Failed
+// conditions, failureReason being used when the Job failed
+func setJobConditions(image *osbuildv1alpha1.ImageBuilderImage, job *batchv1.Job, failureReason string) {
+	image.Status.Queue = nil
+	image.Status.PipelineRun = ""
+	image.Status.Job = job.Name
+	image.Status.BuildRecord = job.Annotations[buildRecordAnnotation]
+	for _, volume := range job.Spec.Template.Spec.Volumes {
+		if volume.Name == "blueprints" && volume.ConfigMap != nil {
+			image.Status.BlueprintConfigMap = volume.ConfigMap.Name
+			image.Status.BlueprintSecret = ""
+		}
+		if volume.Name == "blueprints" && volume.Secret != nil {
+			image.Status.BlueprintConfigMap = ""
+			image.Status.BlueprintSecret = volume.Secret.SecretName
+		}
+	}
+	complete := jobCondition(job, batchv1.JobComplete)
+	failed := jobCondition(job, batchv1.JobFailed)
+	switch {
+	case complete != nil:
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionTrue, osbuildv1alpha1.ReasonBuildSucceeded, complete.Message)
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildSucceeded, "")
+		if generation, err := strconv.ParseInt(job.Labels[imageBuilderImageGenerationLabel], 10, 64); err == nil {
+			image.Status.ArtifactsGeneration = generation
+		}
+		// the artifacts are only described by the tekton executor
+		image.Status.Artifacts = nil
+		image.Status.BuilderVersion = ""
+		image.Status.BuildDuration = nil
+		if job.Status.StartTime != nil && job.Status.CompletionTime != nil {
+			image.Status.BuildDuration = &metav1.Duration{
+				Duration: job.Status.CompletionTime.Sub(job.Status.StartTime.Time),
+			}
+		}
+	case failed != nil:
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, failureReason, failed.Message)
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, failureReason, failed.Message)
+	case job.Spec.Suspend != nil && *job.Spec.Suspend:
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonJobSuspended,
+			"Job "+job.Name+" is waiting to be started")
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonJobSuspended, "")
+	default:
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildRunning,
+			"Job "+job.Name+" is running")
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildRunning, "")
+	}
+}
+
+// jobFailureReason tells which part of a failed build Job went wrong from the
+// first failed container of its pods, or that it ran past its deadline
+func jobFailureReason(job *batchv1.Job, pods []corev1.Pod) string {
+	failed := jobCondition(job, batchv1.JobFailed)
+	if failed == nil {
+		return ""
+	}
+	if failed.Reason == "DeadlineExceeded" {
+		return osbuildv1alpha1.ReasonBuildTimedOut
+	}
+	for _, pod := range pods {
+		for _, status := range append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...) {
+			terminated := status.State.Terminated
+			if terminated == nil || terminated.ExitCode == 0 {
+				continue
+			}
+			if reason := stepFailureReason(status.Name, terminated.ExitCode); reason != "" {
+				return reason
+			}
+		}
+	}
+	return osbuildv1alpha1.ReasonBuildFailed
+}
+
+// setJobProgress reports the stage and a rough completion percentage of the
+// build from the containers of the pod of its Job
+func setJobProgress(image *osbuildv1alpha1.ImageBuilderImage, job *batchv1.Job, pods []corev1.Pod) {
+	if jobFinished(job) {
+		image.Status.Stage = ""
+		if jobCondition(job, batchv1.JobComplete) != nil {
+			image.Status.Progress = 100
+		}
+		return
+	}
+	image.Status.Stage = ""
+	image.Status.Progress = 0
+	for _, pod := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		steps := len(pod.Spec.InitContainers) + len(pod.Spec.Containers)
+		done := 0
+		for _, status := range statuses {
+			if status.State.Terminated != nil {
+				done++
+				continue
+			}
+			if status.State.Running != nil {
+				if stage, ok := stepStage(status.Name); ok && image.Status.Stage == "" {
+					image.Status.Stage = stage
+				}
+			}
+		}
+		if steps > 0 {
+			// never report a running build as complete
+			image.Status.Progress = int32(done * 100 / steps)
+			if image.Status.Progress > 99 {
+				image.Status.Progress = 99
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Build Jobs", func() {
+	ctx := context.Background()
+	var tasks []tektonv1.Task
+
+	containerNames := func(containers []corev1.Container) []string {
+		names := []string{}
+		for _, container := range containers {
+			names = append(names, container.Name)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		tasks = []tektonv1.Task{
+			{
+				Spec: tektonv1.TaskSpec{
+					Steps: []tektonv1.Step{
+						{
+							Name:   "push-blueprint",
+							Image:  utilsImage,
+							Script: "composer-cli blueprints push $(params.blueprintName).toml",
+						},
+						{
+							Name:   "start-compose",
+							Image:  utilsImage,
+							Script: "#!/bin/bash\ncomposer-cli compose start $(params.blueprintName) edge-commit",
+						},
+					},
+				},
+			},
+			{
+				Spec: tektonv1.TaskSpec{
+					Sidecars: []tektonv1.Sidecar{
+						{Name: "serve-commit", Image: utilsImage, Command: []string{"python3", "-m", "http.server"}},
+					},
+					Steps: []tektonv1.Step{
+						{
+							Name:   "start-compose",
+							Image:  utilsImage,
+							Script: "composer-cli compose start $BLUEPRINT edge-simplified-installer",
+							Env:    []corev1.EnvVar{{Name: "BLUEPRINT", Value: "$(params.blueprintName)-installer"}},
+						},
+					},
+				},
+			},
+		}
+	})
+
+	It("runs the steps of the tasks one after the other in the pod of a Job", func() {
+		namespace := createNamespace(ctx)
+		job := BuildJob(
+			metav1.ObjectMeta{Name: "edge-build-1", Namespace: namespace, Labels: map[string]string{imageBuilderImageLabel: "edge"}},
+			tasks,
+			[]tektonv1.WorkspaceBinding{{Name: "shared-volume", EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			map[string]string{"blueprintName": "edge"},
+			&pod.PodTemplate{NodeSelector: map[string]string{"kubernetes.io/arch": "amd64"}},
+		)
+
+		Expect(*job.Spec.Suspend).To(BeTrue())
+		Expect(*job.Spec.BackoffLimit).To(BeZero())
+		podSpec := job.Spec.Template.Spec
+		Expect(podSpec.NodeSelector).To(HaveKeyWithValue("kubernetes.io/arch", "amd64"))
+		Expect(job.Spec.Template.Labels).To(HaveKeyWithValue(imageBuilderImageLabel, "edge"))
+		// the steps before the sidecar run as init containers
+		Expect(containerNames(podSpec.InitContainers)).To(Equal([]string{"push-blueprint", "start-compose"}))
+		Expect(containerNames(podSpec.Containers)).To(Equal([]string{"start-compose-1", "serve-commit"}))
+
+		Expect(podSpec.InitContainers[0].Command).To(Equal([]string{"/bin/sh", "-c", "set -e\ncomposer-cli blueprints push edge.toml"}))
+		Expect(podSpec.InitContainers[1].Command).To(Equal([]string{"/bin/bash", "-c", "#!/bin/bash\ncomposer-cli compose start edge edge-commit"}))
+		Expect(podSpec.InitContainers[1].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "shared-volume", MountPath: "/workspace/shared-volume"}))
+		Expect(podSpec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "BLUEPRINT", Value: "edge-installer"}))
+		Expect(podSpec.Containers[0].Command).To(ContainElement(ContainSubstring("touch " + jobMarkersDir + "/start-compose-1")))
+		// the sidecar stops once the last step is done
+		Expect(podSpec.Containers[1].Command).To(ContainElement(ContainSubstring(jobMarkersDir + "/start-compose-1")))
+
+		Expect(k8sClient.Create(ctx, &job)).To(Succeed())
+	})
+
+	It("runs the last step as the container of a pod without sidecars", func() {
+		job := BuildJob(metav1.ObjectMeta{Name: "edge-build-1"}, tasks[:1], nil, map[string]string{"blueprintName": "edge"}, &pod.PodTemplate{})
+		podSpec := job.Spec.Template.Spec
+		Expect(containerNames(podSpec.InitContainers)).To(Equal([]string{"push-blueprint"}))
+		Expect(containerNames(podSpec.Containers)).To(Equal([]string{"start-compose"}))
+	})
+
+	It("substitutes the paths of the declared results", func() {
+		tasks[0].Spec.Results = []tektonv1.TaskResult{{Name: composeIDResult}}
+		tasks[0].Spec.Steps[1].Script += " > $(results.composeID.path)"
+		step, reference := jobUnsupportedReference(tasks)
+		Expect(step).To(BeEmpty())
+		Expect(reference).To(BeEmpty())
+
+		job := BuildJob(metav1.ObjectMeta{Name: "edge-build-1"}, tasks[:1], nil, map[string]string{"blueprintName": "edge"}, &pod.PodTemplate{})
+		Expect(job.Spec.Template.Spec.Containers[0].Command).To(ContainElement(ContainSubstring("> " + jobMarkersDir + "/results-composeID")))
+	})
+
+	It("finds the references only Tekton resolves", func() {
+		tasks[0].Spec.Steps[1].Script += " > $(results.undeclared.path)"
+		step, reference := jobUnsupportedReference(tasks)
+		Expect(step).To(Equal("start-compose"))
+		Expect(reference).To(Equal("$(results.undeclared.path)"))
+
+		tasks[0].Spec.Steps[1].Script = "composer-cli compose start edge edge-commit"
+		tasks[1].Spec.Sidecars[0].Env = []corev1.EnvVar{{Name: "COMMIT", Value: "$(tasks.commit.results.url)"}}
+		step, reference = jobUnsupportedReference(tasks)
+		Expect(step).To(Equal("serve-commit"))
+		Expect(reference).To(Equal("$(tasks.commit.results.url)"))
+	})
+
+	It("mounts the workspaces of the same claim once", func() {
+		claim := &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "edge-data"}
+		volumes, mounts := workspaceVolumes([]tektonv1.WorkspaceBinding{
+			{Name: "shared-volume", PersistentVolumeClaim: claim, SubPath: "builds/1"},
+			{Name: "web", PersistentVolumeClaim: claim},
+		})
+		Expect(volumes).To(HaveLen(1))
+		Expect(mounts).To(Equal([]corev1.VolumeMount{
+			{Name: "shared-volume", MountPath: "/workspace/shared-volume", SubPath: "builds/1"},
+			{Name: "shared-volume", MountPath: "/workspace/web"},
+		}))
+	})
+})
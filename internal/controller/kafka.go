@@ -0,0 +1,170 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Keys of the Secret mounted in the directory given to NewKafkaSink, all of
+// them optional
+const (
+	kafkaCAKey       = "ca.crt"
+	kafkaCertKey     = "tls.crt"
+	kafkaKeyKey      = "tls.key"
+	kafkaUsernameKey = "username"
+	kafkaPasswordKey = "password"
+)
+
+// kafkaContentType is the embedded JSON format of the Kafka REST API v2
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+// KafkaSink publishes records to a Kafka topic through an HTTP bridge
+// speaking the Kafka REST API v2, such as the Strimzi Kafka Bridge or the
+// Confluent REST Proxy. It does not connect to the brokers: their addresses
+// and SASL settings are configured on the bridge.
+type KafkaSink struct {
+	// URL is the root of the bridge, e.g. http://my-bridge-bridge-service:8080
+	URL   string
+	Topic string
+	// Username and Password authenticate with basic authentication
+	Username   string
+	Password   string
+	HTTPClient *http.Client
+}
+
+// kafkaRecord is a record of the Kafka REST API
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value callbackPayload `json:"value"`
+}
+
+// NewKafkaSink returns a sink publishing to topic through the bridge at
+// bridgeURL. secretDir, when set, is the directory of a mounted Secret with
+// the CA and client certificate of the bridge and basic auth credentials.
+func NewKafkaSink(bridgeURL string, topic string, secretDir string) (*KafkaSink, error) {
+	if _, err := url.ParseRequestURI(bridgeURL); err != nil {
+		return nil, fmt.Errorf("invalid Kafka bridge URL: %w", err)
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("a Kafka topic is required")
+	}
+	sink := &KafkaSink{
+		URL:        strings.TrimSuffix(bridgeURL, "/"),
+		Topic:      topic,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	if secretDir == "" {
+		return sink, nil
+	}
+	read := func(key string) ([]byte, error) {
+		data, err := os.ReadFile(filepath.Join(secretDir, key))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return data, err
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	ca, err := read(kafkaCAKey)
+	if err != nil {
+		return nil, err
+	}
+	if ca != nil {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", kafkaCAKey)
+		}
+	}
+	cert, err := read(kafkaCertKey)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		key, err := read(kafkaKeyKey)
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	sink.HTTPClient.Transport = transport
+	username, err := read(kafkaUsernameKey)
+	if err != nil {
+		return nil, err
+	}
+	password, err := read(kafkaPasswordKey)
+	if err != nil {
+		return nil, err
+	}
+	sink.Username = strings.TrimSpace(string(username))
+	sink.Password = strings.TrimSpace(string(password))
+	return sink, nil
+}
+
+// String names the sink in the status of the images
+func (k *KafkaSink) String() string {
+	return k.Topic + "@" + k.URL
+}
+
+// Publish sends a build event as a record keyed by the namespace and name of
+// the image, so the events of an image keep their order in a partition
+func (k *KafkaSink) Publish(ctx context.Context, payload callbackPayload) error {
+	body, err := json.Marshal(map[string][]kafkaRecord{
+		"records": {
+			{
+				Key:   payload.Namespace + "/" + payload.Name,
+				Value: payload,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, k.URL+"/topics/"+url.PathEscape(k.Topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", kafkaContentType)
+	if k.Username != "" {
+		request.SetBasicAuth(k.Username, k.Password)
+	}
+	response, err := k.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s returned %d", request.URL, response.StatusCode)
+	}
+	return nil
+}
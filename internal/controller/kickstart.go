@@ -0,0 +1,87 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// loraxImage provides mkksiso, installed from the CentOS Stream repositories
+// when a replacement image does not have it
+const loraxImage = "quay.io/centos/centos:stream9"
+
+// customizeISOStepName is the step embedding the kickstart into the ISO
+const customizeISOStepName = "customize-iso"
+
+// customizeISOScript embeds the kickstart into the installer ISO with
+// mkksiso, replacing the downloaded ISO so the customized one is described,
+// served and uploaded. The kickstart is written outside of the shared volume,
+// which the web server serves.
+const customizeISOScript = `#!/usr/bin/env bash
+set -euo pipefail
+cd "/workspace/shared-volume/$(params.blueprintName)"
+command -v mkksiso > /dev/null || dnf install -y lorax
+kickstart=$(mktemp --suffix=.ks)
+printf '%s\n' "${KICKSTART}" > "${kickstart}"
+args=(--ks "${kickstart}")
+[ -z "${VOLUME_LABEL:-}" ] || args+=(-V "${VOLUME_LABEL}")
+[ -z "${KERNEL_ARGS:-}" ] || args+=(-c "${KERNEL_ARGS}")
+mkksiso "${args[@]}" "${iso}" "${iso}.customized"
+mv -f "${iso}.customized" "${iso}"
+rm -f "${kickstart}"
+`
+
+// addKickstartStep embeds the kickstart of an image into the ISO downloaded
+// by task, once it is downloaded
+func addKickstartStep(task *tektonv1.Task, image *osbuildv1alpha1.ImageBuilderImage, iso string) {
+	spec := &image.Spec
+	if spec.Kickstart == "" && spec.KickstartRef == nil {
+		return
+	}
+	kickstart := corev1.EnvVar{Name: "KICKSTART", Value: spec.Kickstart}
+	if ref := spec.KickstartRef; ref != nil {
+		// read when the step starts, never stored in the Task
+		kickstart.ValueFrom = &corev1.EnvVarSource{
+			ConfigMapKeyRef: ref.ConfigMapKeyRef,
+			SecretKeyRef:    ref.SecretKeyRef,
+		}
+	}
+	env := []corev1.EnvVar{{Name: "iso", Value: iso}, kickstart}
+	if spec.ISO != nil {
+		env = append(env,
+			corev1.EnvVar{Name: "VOLUME_LABEL", Value: spec.ISO.VolumeLabel},
+			corev1.EnvVar{Name: "KERNEL_ARGS", Value: spec.ISO.KernelArgs},
+		)
+	}
+	step := tektonv1.Step{
+		Name:   customizeISOStepName,
+		Image:  loraxImage,
+		Script: customizeISOScript,
+		Env:    env,
+	}
+	// before the post-compose scripts, which see the customized ISO
+	steps := []tektonv1.Step{}
+	for _, existing := range task.Spec.Steps {
+		steps = append(steps, existing)
+		if existing.Name == "download" {
+			steps = append(steps, step)
+		}
+	}
+	task.Spec.Steps = steps
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+)
+
+// resourceLabels returns the labels of every resource generated for an image:
+// the standard app.kubernetes.io labels, the operator selector label and the
+// user labels selected by PropagateLabels
+func (r *ImageBuilderImageReconciler) resourceLabels(image *osbuildv1alpha1.ImageBuilderImage) map[string]string {
+	labels := propagatedKeys(image.Labels, r.PropagateLabels)
+	labels["app.kubernetes.io/name"] = "imagebuilderimage"
+	labels["app.kubernetes.io/instance"] = image.Name
+	labels["app.kubernetes.io/managed-by"] = "osbuild-operator"
+	labels[imageBuilderImageLabel] = image.Name
+	return labels
+}
+
+// resourceAnnotations returns the user annotations selected by PropagateAnnotations
+func (r *ImageBuilderImageReconciler) resourceAnnotations(image *osbuildv1alpha1.ImageBuilderImage) map[string]string {
+	return propagatedKeys(image.Annotations, r.PropagateAnnotations)
+}
+
+// propagatedKeys copies the entries whose key matches one of the patterns, a
+// pattern ending in * matches any key with that prefix
+func propagatedKeys(values map[string]string, patterns []string) map[string]string {
+	propagated := map[string]string{}
+	for key, value := range values {
+		for _, pattern := range patterns {
+			if key == pattern || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(key, strings.TrimSuffix(pattern, "*"))) {
+				propagated[key] = value
+				break
+			}
+		}
+	}
+	return propagated
+}
+
+// mergeMaps returns a new map holding the entries of all maps, later ones win
+func mergeMaps(maps ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, m := range maps {
+		for key, value := range m {
+			merged[key] = value
+		}
+	}
+	return merged
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	routev1 "github.com/openshift/api/route/v1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultNameTemplate keeps the historical <image>-<resource> names
+const DefaultNameTemplate = "{{ .Image }}-{{ .Resource }}"
+
+// nameValues are the variables available to the naming template
+type nameValues struct {
+	Image      string
+	Namespace  string
+	Generation int64
+	Resource   string
+}
+
+// GeneratedNames holds the names of all the resources generated for an image
+type GeneratedNames struct {
+	BlueprintConfigMap string
+	BlueprintSnapshot  string
+	BuildRecord        string
+	PrepareTask        string
+	CommitTask         string
+	DownloadTask       string
+	IsoComposeTask     string
+	IsoDownloadTask    string
+	Pipeline           string
+	PipelineRun        string
+	BuildJob           string
+	WebDeployment      string
+	WebService         string
+	WebRoute           string
+	ComposerAPI        string
+	Plan               string
+}
+
+// GenerateNames renders the naming template for every generated resource,
+// making sure the results are valid and do not collide with each other
+func GenerateNames(nameTemplate string, name string, namespace string, generation int64) (GeneratedNames, error) {
+	if nameTemplate == "" {
+		nameTemplate = DefaultNameTemplate
+	}
+	templ, err := template.New("name").Option("missingkey=error").Parse(nameTemplate)
+	if err != nil {
+		return GeneratedNames{}, fmt.Errorf("invalid naming template: %w", err)
+	}
+
+	rendered := map[string]string{}
+	render := func(resource string) string {
+		if err != nil {
+			return ""
+		}
+		var buffer bytes.Buffer
+		if err = templ.Execute(&buffer, nameValues{
+			Image:      name,
+			Namespace:  namespace,
+			Generation: generation,
+			Resource:   resource,
+		}); err != nil {
+			err = fmt.Errorf("could not render name of %s: %w", resource, err)
+			return ""
+		}
+		result := buffer.String()
+		if problems := validation.IsDNS1123Label(result); len(problems) > 0 {
+			err = fmt.Errorf("name %q of %s is not valid: %s", result, resource, strings.Join(problems, ", "))
+			return ""
+		}
+		if other, ok := rendered[result]; ok {
+			err = fmt.Errorf("%s and %s would both be named %q", other, resource, result)
+			return ""
+		}
+		rendered[result] = resource
+		return result
+	}
+
+	names := GeneratedNames{
+		BlueprintConfigMap: render("blueprint"),
+		BlueprintSnapshot:  render(fmt.Sprintf("blueprint-%d", generation)),
+		BuildRecord:        render(fmt.Sprintf("build-%d", generation)),
+		PrepareTask:        render("prepare-volume"),
+		CommitTask:         render("generate-commit"),
+		DownloadTask:       render("download-extract-commit"),
+		IsoComposeTask:     render("iso-compose"),
+		IsoDownloadTask:    render("iso-download"),
+		Pipeline:           render("pipeline"),
+		PipelineRun:        render("pipeline-run"),
+		BuildJob:           render("build"),
+		WebDeployment:      render("web"),
+		WebService:         render("service"),
+		WebRoute:           render("route"),
+		ComposerAPI:        render("composer-api"),
+		Plan:               render("plan"),
+	}
+	return names, err
+}
+
+// pipelineRunName is the name of the PipelineRun of the build number of an
+// image, the first one keeping the generated name and the next ones numbered
+func pipelineRunName(name string, number int64) string {
+	if number <= 1 {
+		return name
+	}
+	suffix := fmt.Sprintf("-%d", number)
+	if len(name)+len(suffix) > validation.DNS1123LabelMaxLength {
+		name = strings.TrimRight(name[:validation.DNS1123LabelMaxLength-len(suffix)], "-")
+	}
+	return name + suffix
+}
+
+// checkNameCollisions makes sure none of the generated names is already used by
+// a resource that was not created for this image
+func (n GeneratedNames) checkNameCollisions(ctx context.Context, c client.Client, namespace string, imageName string) error {
+	objects := map[string]client.Object{
+		n.BlueprintConfigMap: &corev1.ConfigMap{},
+		n.BlueprintSnapshot:  &corev1.ConfigMap{},
+		n.BuildRecord:        &corev1.ConfigMap{},
+		n.PrepareTask:        &tektonv1.Task{},
+		n.CommitTask:         &tektonv1.Task{},
+		n.DownloadTask:       &tektonv1.Task{},
+		n.IsoComposeTask:     &tektonv1.Task{},
+		n.IsoDownloadTask:    &tektonv1.Task{},
+		n.Pipeline:           &tektonv1.Pipeline{},
+		n.PipelineRun:        &tektonv1.PipelineRun{},
+		n.BuildJob:           &batchv1.Job{},
+		n.WebDeployment:      &appsv1.Deployment{},
+		n.WebService:         &corev1.Service{},
+		n.WebRoute:           &routev1.Route{},
+		n.ComposerAPI:        &corev1.Secret{},
+		n.Plan:               &corev1.ConfigMap{},
+	}
+	for name, object := range objects {
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, object); err != nil {
+			// Tekton resources can not exist when Tekton is not installed
+			if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			return err
+		}
+		if owner := object.GetLabels()[imageBuilderImageLabel]; owner != imageName {
+			return &nameCollisionError{name: name, owner: owner}
+		}
+	}
+	return nil
+}
+
+type nameCollisionError struct {
+	name  string
+	owner string
+}
+
+func (e *nameCollisionError) Error() string {
+	if e.owner == "" {
+		return fmt.Sprintf("%s already exists and was not created by the operator", e.name)
+	}
+	return fmt.Sprintf("%s already exists and belongs to image %s", e.name, e.owner)
+}
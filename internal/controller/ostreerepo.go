@@ -0,0 +1,419 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	routev1 "github.com/openshift/api/route/v1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ostreeImage provides the ostree command initializing the repository and
+// importing the commits into it
+const ostreeImage = "quay.io/fedora/fedora-coreos:stable"
+
+// ostreeRepositoryPort is the port nginx serves the repository on
+const ostreeRepositoryPort int32 = 8080
+
+// defaultOSTreeStorageSize is the size of the repository volume when the
+// builder does not set it
+var defaultOSTreeStorageSize = resource.MustParse("10Gi")
+
+// ostreeRepositoryName is the name of the Deployment, Service, Route and
+// volume of the ostree repository of a builder
+func ostreeRepositoryName(builderName string) string {
+	return fmt.Sprintf("%s-ostree", builderName)
+}
+
+// reconcileOSTreeRepository serves the ostree repository of a builder and
+// records its URL, or removes the server, keeping the commits in its volume,
+// once spec.ostreeRepository is unset
+func (r *ImageBuilderReconciler) reconcileOSTreeRepository(ctx context.Context, builder *osbuildv1alpha1.ImageBuilder, labels map[string]string) error {
+	logger := log.FromContext(ctx)
+	name := ostreeRepositoryName(builder.Name)
+	spec := builder.Spec.OSTreeRepository
+	if spec == nil {
+		builder.Status.OSTreeRepositoryURL = ""
+		return r.deleteRuntimeObjects(ctx,
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: builder.Namespace}},
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: builder.Namespace}},
+			&routev1.Route{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: builder.Namespace}},
+		)
+	}
+	objectMeta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: builder.Namespace,
+		Labels:    labels,
+		OwnerReferences: []metav1.OwnerReference{
+			*metav1.NewControllerRef(builder, osbuildv1alpha1.GroupVersion.WithKind("ImageBuilder")),
+		},
+	}
+
+	volume := ostreeRepositoryClaim(objectMeta, spec)
+	if err := r.Create(ctx, &volume); err != nil {
+		if errors.IsAlreadyExists(err) {
+			logger.Info("OSTree repository volume already exists, skipping creation")
+		} else {
+			logger.Error(err, "Could not create ostree repository volume")
+			return err
+		}
+	}
+	deployment := ostreeRepositoryDeployment(objectMeta, builder.Spec.Architecture)
+	if err := ApplyObject(ctx, r.Client, &deployment, true); err != nil {
+		logger.Error(err, "Could not apply ostree repository deployment")
+		return err
+	}
+	service := ostreeRepositoryService(objectMeta)
+	if err := ApplyObject(ctx, r.Client, &service, true); err != nil {
+		logger.Error(err, "Could not apply ostree repository service")
+		return err
+	}
+	builder.Status.OSTreeRepositoryURL = fmt.Sprintf("http://%s.%s.svc:%d/repo", service.Name, service.Namespace, ostreeRepositoryPort)
+
+	// devices outside of the cluster pull from the Route, where there is one
+	route := routev1.Route{
+		ObjectMeta: objectMeta,
+		Spec: routev1.RouteSpec{
+			To: routev1.RouteTargetReference{
+				Kind: "Service",
+				Name: service.Name,
+			},
+			Port: &routev1.RoutePort{
+				TargetPort: intstr.FromInt(int(ostreeRepositoryPort)),
+			},
+		},
+	}
+	if err := r.Create(ctx, &route); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		if !errors.IsAlreadyExists(err) {
+			logger.Error(err, "Could not create ostree repository route")
+			return err
+		}
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&route), &route); err != nil {
+		logger.Error(err, "Could not get ostree repository route")
+		return err
+	}
+	if route.Spec.Host != "" {
+		builder.Status.OSTreeRepositoryURL = fmt.Sprintf("http://%s/repo", route.Spec.Host)
+	}
+	return nil
+}
+
+// ostreeRepositoryClaim is the volume holding the repository
+func ostreeRepositoryClaim(objectMeta metav1.ObjectMeta, spec *osbuildv1alpha1.OSTreeRepository) corev1.PersistentVolumeClaim {
+	size := defaultOSTreeStorageSize
+	if spec.StorageSize != nil {
+		size = *spec.StorageSize
+	}
+	accessModes := spec.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+	}
+	return corev1.PersistentVolumeClaim{
+		ObjectMeta: objectMeta,
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      accessModes,
+			StorageClassName: spec.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+}
+
+// ostreeRepositoryDeployment serves the repository with nginx once it is
+// initialized. Its pods are not labeled like the builder, whose Service
+// selects the composer pods by these labels.
+func ostreeRepositoryDeployment(objectMeta metav1.ObjectMeta, architecture osbuildv1alpha1.Architecture) appsv1.Deployment {
+	podLabels := map[string]string{
+		"app": objectMeta.Name,
+	}
+	podSpec := corev1.PodSpec{
+		InitContainers: []corev1.Container{
+			{
+				Name:  "init-repository",
+				Image: ostreeImage,
+				Command: []string{
+					"/bin/sh", "-c",
+					"[ -d /srv/repo/objects ] || ostree init --mode=archive --repo=/srv/repo",
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      "repository",
+						MountPath: "/srv",
+					},
+				},
+			},
+		},
+		Containers: []corev1.Container{
+			{
+				Name:  "nginx",
+				Image: nginxImage,
+				Ports: []corev1.ContainerPort{
+					{
+						ContainerPort: ostreeRepositoryPort,
+					},
+				},
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						HTTPGet: &corev1.HTTPGetAction{
+							Path: "/repo/config",
+							Port: intstr.FromInt(int(ostreeRepositoryPort)),
+						},
+					},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      "repository",
+						MountPath: "/usr/share/nginx/html/",
+						ReadOnly:  true,
+					},
+				},
+			},
+		},
+		Volumes: []corev1.Volume{
+			{
+				Name: "repository",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: objectMeta.Name,
+					},
+				},
+			},
+		},
+		NodeSelector: architectureSelector(architecture),
+	}
+	return appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Deployment",
+			APIVersion: "apps/v1",
+		},
+		ObjectMeta: objectMeta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: pointer.Int32(1),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: podLabels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: podLabels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+}
+
+// ostreeRepositoryService exposes the repository in the cluster
+func ostreeRepositoryService(objectMeta metav1.ObjectMeta) corev1.Service {
+	return corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: objectMeta,
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Port:       ostreeRepositoryPort,
+					TargetPort: intstr.FromInt(int(ostreeRepositoryPort)),
+					Protocol:   corev1.ProtocolTCP,
+				},
+			},
+			Selector: map[string]string{
+				"app": objectMeta.Name,
+			},
+			Type: corev1.ServiceTypeClusterIP,
+		},
+	}
+}
+
+// ostreePublishName is the step, and pipeline task, importing the edge
+// commit of a build into the ostree repository of its builder
+const ostreePublishName = "publish-ostree"
+
+// Results of the step importing the edge commit
+const (
+	ostreeRefResult    = "ostree-ref"
+	ostreeCommitResult = "ostree-commit"
+)
+
+// ostreePublishScript pulls the extracted edge commit into the repository of
+// the builder, initializing it when the server did not yet
+const ostreePublishScript = `#!/bin/sh
+set -e
+cd "/workspace/shared-volume/$(params.blueprintName)"
+repo=/ostree/repo
+[ -d "${repo}/objects" ] || ostree init --mode=archive --repo="${repo}"
+ref=$(jq -r '.ref // empty' commit.json 2>/dev/null || true)
+[ -n "${ref}" ] || ref=$(ostree refs --repo=repo | head -n 1)
+ostree pull-local --repo="${repo}" repo "${ref}"
+ostree summary --update --repo="${repo}"
+printf '%s' "${ref}" | tee $(results.` + ostreeRefResult + `.path)
+ostree rev-parse --repo="${repo}" "${ref}" | tr -d '\n' | tee $(results.` + ostreeCommitResult + `.path)
+`
+
+// publishesOSTree tells if the builds of an image import their edge commit
+// into the repository of the builder, which needs its volume to be in the
+// namespace of the image
+func publishesOSTree(image *osbuildv1alpha1.ImageBuilderImage, builder *osbuildv1alpha1.ImageBuilder, composeType osbuildv1alpha1.ComposeType) bool {
+	return composeType == osbuildv1alpha1.ComposeEdgeCommit && builder.Spec.OSTreeRepository != nil &&
+		builder.Namespace == image.Namespace && image.Spec.PipelineRef == nil
+}
+
+// addOSTreePublish imports the edge commit into the repository of builder
+// once the artifacts are described, from a task of its own or the last step
+// of the single task of an ephemeral build
+func addOSTreePublish(pipeline *tektonv1.Pipeline, builder *osbuildv1alpha1.ImageBuilder, ephemeral bool) {
+	step := tektonv1.Step{
+		Name:   ostreePublishName,
+		Image:  ostreeImage,
+		Script: ostreePublishScript,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      ostreePublishName,
+				MountPath: "/ostree",
+			},
+		},
+	}
+	volume := corev1.Volume{
+		Name: ostreePublishName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: ostreeRepositoryName(builder.Name),
+			},
+		},
+	}
+	results := []tektonv1.TaskResult{{Name: ostreeRefResult}, {Name: ostreeCommitResult}}
+	if ephemeral {
+		taskSpec := &pipeline.Spec.Tasks[0].TaskSpec.TaskSpec
+		taskSpec.Steps = append(taskSpec.Steps, step)
+		taskSpec.Results = append(taskSpec.Results, results...)
+		taskSpec.Volumes = append(taskSpec.Volumes, volume)
+		return
+	}
+	pipeline.Spec.Tasks = append(pipeline.Spec.Tasks, tektonv1.PipelineTask{
+		Name: ostreePublishName,
+		TaskSpec: &tektonv1.EmbeddedTask{
+			TaskSpec: tektonv1.TaskSpec{
+				Workspaces: []tektonv1.WorkspaceDeclaration{
+					{
+						Name: "shared-volume",
+					},
+				},
+				Params: tektonv1.ParamSpecs{
+					{
+						Name: "blueprintName",
+					},
+				},
+				Steps:   []tektonv1.Step{step},
+				Results: results,
+				Volumes: []corev1.Volume{volume},
+			},
+		},
+		Workspaces: []tektonv1.WorkspacePipelineTaskBinding{
+			{
+				Name: "shared-volume",
+			},
+		},
+		Params: tektonv1.Params{
+			{
+				Name:  "blueprintName",
+				Value: *tektonv1.NewStructuredValues("$(params.blueprintName)"),
+			},
+		},
+		RunAfter: []string{artifactsTaskName},
+	})
+}
+
+// setOSTreeStatus records the commit imported by the current build into the
+// repository served at url, keeping the last one until it succeeds
+func setOSTreeStatus(ctx context.Context, c client.Client, image *osbuildv1alpha1.ImageBuilderImage, pipelineRun *tektonv1.PipelineRun, url string) error {
+	if url == "" {
+		image.Status.OSTree = nil
+		return nil
+	}
+	steps, _, results, err := pipelineRunSteps(ctx, c, pipelineRun)
+	if err != nil {
+		return err
+	}
+	step, ok := steps[ostreePublishName]
+	if !ok || step.Terminated == nil || step.Terminated.ExitCode != 0 {
+		return nil
+	}
+	image.Status.OSTree = &osbuildv1alpha1.OSTreeCommit{
+		URL:    url,
+		Ref:    results[ostreeRefResult],
+		Commit: results[ostreeCommitResult],
+	}
+	return nil
+}
+
+// resolveOSTree returns the ostree options of the compose of the commit of
+// an image, reading the repository and ref of spec.ostree.parentImage from
+// the commit it published. The message tells why the parent is not known yet.
+func (r *imageReconcile) resolveOSTree(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage) (*composer.OSTreeOptions, string, error) {
+	spec := image.Spec.OSTree
+	if spec == nil || (r.composeType != osbuildv1alpha1.ComposeEdgeCommit && r.composeType != osbuildv1alpha1.ComposeEdgeContainer) {
+		return nil, "", nil
+	}
+	options := &composer.OSTreeOptions{
+		Ref:    spec.Ref,
+		Parent: spec.ParentRef,
+		URL:    spec.URL,
+	}
+	if spec.ParentImage == "" {
+		return options, "", nil
+	}
+	parent := osbuildv1alpha1.ImageBuilderImage{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: image.Namespace, Name: spec.ParentImage}, &parent); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Sprintf("Waiting for parent ImageBuilderImage %s", spec.ParentImage), nil
+		}
+		return nil, "", err
+	}
+	if parent.Status.OSTree == nil || parent.Status.OSTree.Ref == "" {
+		return nil, fmt.Sprintf("Waiting for parent ImageBuilderImage %s to publish a commit to the ostree repository of its builder", spec.ParentImage), nil
+	}
+	// composer resolves the ref in the repository, the commit being the
+	// last one published on it
+	options.Parent = parent.Status.OSTree.Ref
+	options.URL = parent.Status.OSTree.URL
+	if options.Ref == "" {
+		options.Ref = parent.Status.OSTree.Ref
+	}
+	return options, "", nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// userPipelineRef references the pipeline given by spec.pipelineRef, through
+// the Tekton cluster resolver when it lives in another namespace
+func userPipelineRef(ref *osbuildv1alpha1.ImagePipelineReference) *tektonv1.PipelineRef {
+	if ref.Namespace == "" {
+		return &tektonv1.PipelineRef{
+			Name: ref.Name,
+		}
+	}
+	return &tektonv1.PipelineRef{
+		ResolverRef: tektonv1.ResolverRef{
+			Resolver: "cluster",
+			Params: tektonv1.Params{
+				{
+					Name:  "kind",
+					Value: *tektonv1.NewStructuredValues("pipeline"),
+				},
+				{
+					Name:  "name",
+					Value: *tektonv1.NewStructuredValues(ref.Name),
+				},
+				{
+					Name:  "namespace",
+					Value: *tektonv1.NewStructuredValues(ref.Namespace),
+				},
+			},
+		},
+	}
+}
+
+// deleteGeneratedPipeline removes the Pipeline and Tasks generated before
+// the image used its own pipeline
+func (r *ImageBuilderImageReconciler) deleteGeneratedPipeline(ctx context.Context, names GeneratedNames, namespace string) error {
+	objects := []client.Object{
+		&tektonv1.Pipeline{ObjectMeta: metav1.ObjectMeta{Name: names.Pipeline, Namespace: namespace}},
+	}
+	for _, name := range []string{names.PrepareTask, names.CommitTask, names.DownloadTask, names.IsoComposeTask, names.IsoDownloadTask} {
+		objects = append(objects, &tektonv1.Task{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}})
+	}
+	for _, object := range objects {
+		if err := r.Delete(ctx, object); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
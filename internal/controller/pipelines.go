@@ -1,26 +1,41 @@
 package controller
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
 	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func (r *ImageBuilderImageReconciler) DownloadTask(objectMeta metav1.ObjectMeta, compose_file string, destination string) tektonv1.Task {
+// composeImageURL is the URL of the image of the compose whose ID the shell
+// expression id gives, in the API of the builder of the image being
+// reconciled
+func (r *imageReconcile) composeImageURL(id string) string {
+	if r.apiFlavor == osbuildv1alpha1.APIFlavorCloud {
+		return fmt.Sprintf("$(params.apiEndpoint)/composes/%s/download", id)
+	}
+	return "$(params.apiEndpoint)/compose/image/" + id
+}
+
+func (r *imageReconcile) DownloadTask(objectMeta metav1.ObjectMeta, compose_file string, destination string) tektonv1.Task {
 	task := tektonv1.Task{
 		ObjectMeta: objectMeta,
 		Spec: tektonv1.TaskSpec{
-			Workspaces: r.PipelineWorkspaces,
-			Params:     r.PipelineParams,
+			Workspaces: r.pipelineWorkspaces,
+			Params:     r.pipelineParams,
 			Steps: []tektonv1.Step{
 				{
 					Name:  "download",
 					Image: utilsImage,
 					Command: []string{
 						"/usr/bin/bash", "-c",
-						fmt.Sprintf("/usr/bin/curl $(params.apiEndpoint)/compose/image/$(/usr/bin/jq -r '.build_id' \"/workspace/shared-volume/$(params.blueprintName)/%s\") --output \"/workspace/shared-volume/$(params.blueprintName)/%s\" --verbose", compose_file, destination),
+						fmt.Sprintf("/usr/bin/curl %s --output \"/workspace/shared-volume/$(params.blueprintName)/%s\" --verbose", r.composeImageURL(fmt.Sprintf("$(/usr/bin/jq -r '.build_id' \"/workspace/shared-volume/$(params.blueprintName)/%s\")", compose_file)), destination),
 					},
 				},
 			},
@@ -29,27 +44,29 @@ func (r *ImageBuilderImageReconciler) DownloadTask(objectMeta metav1.ObjectMeta,
 	return task
 }
 
-func (r *ImageBuilderImageReconciler) DownloadExtractCommitTask(objectMeta metav1.ObjectMeta) tektonv1.Task {
+func (r *imageReconcile) DownloadExtractCommitTask(objectMeta metav1.ObjectMeta) tektonv1.Task {
 	task := tektonv1.Task{
 		ObjectMeta: objectMeta,
 		Spec: tektonv1.TaskSpec{
-			Workspaces: r.PipelineWorkspaces,
-			Params:     r.PipelineParams,
+			Workspaces: r.pipelineWorkspaces,
+			Params:     r.pipelineParams,
 			Steps: []tektonv1.Step{
 				{
 					Name:  "download-commit",
 					Image: utilsImage,
 					Command: []string{
 						"/usr/bin/bash", "-c",
-						"/usr/bin/curl $(params.apiEndpoint)/compose/image/$(/usr/bin/jq -r '.build_id' /workspace/shared-volume/$(params.blueprintName)/compose.json) --output /workspace/shared-volume/$(params.blueprintName)/edge-commit.tar --verbose",
+						fmt.Sprintf("/usr/bin/curl %s --output /workspace/shared-volume/$(params.blueprintName)/edge-commit.tar --verbose", r.composeImageURL("$(/usr/bin/jq -r '.build_id' /workspace/shared-volume/$(params.blueprintName)/compose.json)")),
 					},
 				},
 				{
 					Name:  "extract-commit",
 					Image: ubiImage,
+					// the metadata of the commit is kept apart from the
+					// compose.json describing the compose
 					Command: []string{
 						"/usr/bin/bash", "-c",
-						"tar xf /workspace/shared-volume/$(params.blueprintName)/edge-commit.tar -C /workspace/shared-volume/$(params.blueprintName)/",
+						"cd /workspace/shared-volume/$(params.blueprintName)/ && tar xf edge-commit.tar --exclude=compose.json && (tar xOf edge-commit.tar compose.json > commit.json || rm -f commit.json)",
 					},
 				},
 			},
@@ -58,7 +75,7 @@ func (r *ImageBuilderImageReconciler) DownloadExtractCommitTask(objectMeta metav
 	return task
 }
 
-func (r *ImageBuilderImageReconciler) PrepareSharedVolumeTask(objectMeta metav1.ObjectMeta) tektonv1.Task {
+func (r *imageReconcile) PrepareSharedVolumeTask(objectMeta metav1.ObjectMeta) tektonv1.Task {
 	task := tektonv1.Task{
 		ObjectMeta: objectMeta,
 		TypeMeta: metav1.TypeMeta{
@@ -66,8 +83,8 @@ func (r *ImageBuilderImageReconciler) PrepareSharedVolumeTask(objectMeta metav1.
 			APIVersion: "tekton.dev/v1",
 		},
 		Spec: tektonv1.TaskSpec{
-			Workspaces: r.PipelineWorkspaces,
-			Params:     r.PipelineParams,
+			Workspaces: r.pipelineWorkspaces,
+			Params:     r.pipelineParams,
 			Steps: []tektonv1.Step{
 				{
 					Name:  "create-directory",
@@ -77,6 +94,14 @@ func (r *ImageBuilderImageReconciler) PrepareSharedVolumeTask(objectMeta metav1.
 						"mkdir -p \"/workspace/shared-volume/$(params.blueprintName)\" && echo Using blueprint $(params.blueprintName)",
 					},
 				},
+				{
+					Name:  "copy-blueprints",
+					Image: ubiImage,
+					Command: []string{
+						"/bin/bash", "-c",
+						"mkdir -p \"/workspace/shared-volume/$(params.blueprintName)/blueprints\" && cp -Lv /workspace/blueprints/* \"/workspace/shared-volume/$(params.blueprintName)/blueprints/\"",
+					},
+				},
 				{
 					Name:  "remove-compose-file",
 					Image: ubiImage,
@@ -91,27 +116,24 @@ func (r *ImageBuilderImageReconciler) PrepareSharedVolumeTask(objectMeta metav1.
 	return task
 }
 
-func (r *ImageBuilderImageReconciler) CommitTask(objectMeta metav1.ObjectMeta) tektonv1.Task {
+func (r *imageReconcile) CommitTask(objectMeta metav1.ObjectMeta) tektonv1.Task {
+	request, _ := json.Marshal(composer.ComposeRequest{
+		BlueprintName: "$(params.blueprintName)",
+		ComposeType:   string(r.composeType),
+		OSTree:        r.ostree,
+	})
 	task := tektonv1.Task{
 		ObjectMeta: objectMeta,
 		Spec: tektonv1.TaskSpec{
-			Workspaces: r.PipelineWorkspaces,
-			Params:     r.PipelineParams,
+			Workspaces: r.pipelineWorkspaces,
+			Params:     r.pipelineParams,
 			Steps: []tektonv1.Step{
-				{
-					Name:  "push-blueprint",
-					Image: ubiImage,
-					Command: []string{
-						"/usr/bin/curl", "-H", "Content-Type: text/x-toml", "--data-binary", "@/workspace/blueprints/$(params.blueprintName)", "$(params.apiEndpoint)/blueprints/new",
-						"--silent",
-					},
-				},
 				{
 					Name:  "start-compose",
 					Image: ubiImage,
 					Command: []string{
 						"/usr/bin/curl", "-H", "Content-Type: application/json",
-						"--data", "{\"blueprint_name\":\"$(params.blueprintName)\",\"compose_type\":\"edge-commit\"}",
+						"--data", string(request),
 						"$(params.apiEndpoint)/compose",
 						"--output", "/workspace/shared-volume/$(params.blueprintName)/compose.json",
 						"--silent",
@@ -138,31 +160,29 @@ func (r *ImageBuilderImageReconciler) CommitTask(objectMeta metav1.ObjectMeta) t
 	return task
 }
 
-func (r *ImageBuilderImageReconciler) IsoComposeTask(objectMeta metav1.ObjectMeta) tektonv1.Task {
+func (r *imageReconcile) IsoComposeTask(objectMeta metav1.ObjectMeta) tektonv1.Task {
 	task := tektonv1.Task{
 		ObjectMeta: objectMeta,
 		Spec: tektonv1.TaskSpec{
-			Workspaces: r.PipelineWorkspaces,
-			Params:     r.PipelineParams,
+			Workspaces: r.pipelineWorkspaces,
+			Params:     r.pipelineParams,
 			Steps: []tektonv1.Step{
 				{
-					Name:  "push-blueprint",
-					Image: ubiImage,
-					Command: []string{
-						"/usr/bin/curl", "-H", "Content-Type: text/x-toml", "--data-binary", "@/workspace/blueprints/$(params.blueprintName)-iso", "$(params.apiEndpoint)/blueprints/new", "--silent",
-					},
-				},
-				{
-					Name:  "compose-json",
-					Image: ubiImage,
-					Command: []string{
-						"/usr/bin/bash", "-c",
-						`echo "{\"blueprint_name\":\"image-iso\",\"compose_type\":\"${target}\",\"ostree\":{\"ref\":\"rhel/9/x86_64/edge\",\"url\":\"http://$(getent hosts | grep pipeline | awk '{print $1}'):8000/repo\"}}" > /workspace/shared-volume/$(params.blueprintName)/ostree-compose.json`,
-					},
+					Name:   "compose-json",
+					Image:  utilsImage,
+					Script: installerComposeScript,
 					Env: []corev1.EnvVar{
 						{
 							Name:  "target",
-							Value: r.IsoTarget,
+							Value: r.isoTarget,
+						},
+						{
+							Name: "POD_IP",
+							ValueFrom: &corev1.EnvVarSource{
+								FieldRef: &corev1.ObjectFieldSelector{
+									FieldPath: "status.podIP",
+								},
+							},
 						},
 					},
 				},
@@ -170,7 +190,7 @@ func (r *ImageBuilderImageReconciler) IsoComposeTask(objectMeta metav1.ObjectMet
 					Name:  "start-compose",
 					Image: ubiImage,
 					Command: []string{
-						"/usr/bin/curl", "-H", "Content-Type: application/json", "--data-binary", "@workspace/shared-volume/$(params.blueprintName)/ostree-compose.json", "$(params.apiEndpoint)/compose", "--verbose", "--output", "/workspace/shared-volume/$(params.blueprintName)/compose-iso.json",
+						"/usr/bin/curl", "-H", "Content-Type: application/json", "--data-binary", "@/workspace/shared-volume/$(params.blueprintName)/ostree-compose.json", "$(params.apiEndpoint)/compose", "--verbose", "--output", "/workspace/shared-volume/$(params.blueprintName)/compose-iso.json",
 					},
 				},
 				{
@@ -213,7 +233,7 @@ func (r *ImageBuilderImageReconciler) IsoComposeTask(objectMeta metav1.ObjectMet
 	return task
 }
 
-func (r *ImageBuilderImageReconciler) ImagePipeline(objectMeta metav1.ObjectMeta, tasks []tektonv1.Task) tektonv1.Pipeline {
+func (r *imageReconcile) ImagePipeline(objectMeta metav1.ObjectMeta, tasks []tektonv1.Task) tektonv1.Pipeline {
 	pipelinetasks := []tektonv1.PipelineTask{}
 	previousTask := tektonv1.Task{}
 	for counter, task := range tasks {
@@ -255,6 +275,22 @@ func (r *ImageBuilderImageReconciler) ImagePipeline(objectMeta metav1.ObjectMeta
 		}
 		pipelinetasks = append(pipelinetasks, currentTask)
 	}
+	if len(pipelinetasks) > 0 {
+		// the artifacts are described once all the tasks wrote them
+		describeTask := pipelinetasks[len(pipelinetasks)-1]
+		describeTask.Name = artifactsTaskName
+		describeTask.TaskRef = nil
+		describeTask.TaskSpec = &tektonv1.EmbeddedTask{
+			TaskSpec: tektonv1.TaskSpec{
+				Workspaces: r.pipelineWorkspaces,
+				Params:     r.pipelineParams,
+				Steps:      []tektonv1.Step{r.describeArtifactsStep()},
+				Results:    artifactsTaskResults(),
+			},
+		}
+		describeTask.RunAfter = []string{previousTask.Name}
+		pipelinetasks = append(pipelinetasks, describeTask)
+	}
 	pipeline := tektonv1.Pipeline{
 		ObjectMeta: objectMeta,
 		Spec: tektonv1.PipelineSpec{
@@ -265,10 +301,400 @@ func (r *ImageBuilderImageReconciler) ImagePipeline(objectMeta metav1.ObjectMeta
 				{
 					Name: "shared-volume",
 				},
+				{
+					Name: "image-volume",
+				},
 			},
-			Tasks:  pipelinetasks,
-			Params: r.PipelineParams,
+			Tasks:   pipelinetasks,
+			Finally: []tektonv1.PipelineTask{cleanupBuildsTask()},
+			Params:  r.pipelineRunParams(),
+			Results: artifactsPipelineResults(artifactsTaskName),
 		},
 	}
 	return pipeline
 }
+
+// EphemeralPipeline runs the steps of all the tasks in a single task, as an
+// emptyDir workspace is not shared between the pods of different TaskRuns.
+// Steps with the same name are suffixed with the position of their task.
+func (r *imageReconcile) EphemeralPipeline(objectMeta metav1.ObjectMeta, tasks []tektonv1.Task) tektonv1.Pipeline {
+	taskSpec := tektonv1.TaskSpec{
+		Workspaces: r.pipelineWorkspaces,
+		Params:     r.pipelineParams,
+	}
+	stepNames := map[string]bool{}
+	for counter, task := range tasks {
+		for _, step := range task.Spec.Steps {
+			if stepNames[step.Name] {
+				step.Name = fmt.Sprintf("%s-%d", step.Name, counter)
+			}
+			stepNames[step.Name] = true
+			taskSpec.Steps = append(taskSpec.Steps, step)
+		}
+		for _, sidecar := range task.Spec.Sidecars {
+			// the sidecars start with the first step, before the data
+			// they serve exists
+			sidecar.ReadinessProbe = nil
+			taskSpec.Sidecars = append(taskSpec.Sidecars, sidecar)
+		}
+	}
+	taskSpec.Steps = append(taskSpec.Steps, r.describeArtifactsStep())
+	taskSpec.Results = artifactsTaskResults()
+	pipeline := tektonv1.Pipeline{
+		ObjectMeta: objectMeta,
+		Spec: tektonv1.PipelineSpec{
+			Workspaces: []tektonv1.PipelineWorkspaceDeclaration{
+				{
+					Name: "blueprints",
+				},
+				{
+					Name: "shared-volume",
+				},
+			},
+			Tasks: []tektonv1.PipelineTask{
+				{
+					Name: "build",
+					TaskSpec: &tektonv1.EmbeddedTask{
+						TaskSpec: taskSpec,
+					},
+					Workspaces: []tektonv1.WorkspacePipelineTaskBinding{
+						{
+							Name: "blueprints",
+						},
+						{
+							Name: "shared-volume",
+						},
+					},
+					Params: tektonv1.Params{
+						{
+							Name: "blueprintName",
+							Value: tektonv1.ParamValue{
+								Type:      "string",
+								StringVal: "$(params.blueprintName)",
+							},
+						},
+						{
+							Name: "apiEndpoint",
+							Value: tektonv1.ParamValue{
+								Type:      "string",
+								StringVal: "$(params.apiEndpoint)",
+							},
+						},
+					},
+				},
+			},
+			Params:  r.pipelineRunParams(),
+			Results: artifactsPipelineResults("build"),
+		},
+	}
+	return pipeline
+}
+
+// pipelineRunParams are the params of the generated pipelines, the ones of
+// the tasks and the generation being built
+func (r *imageReconcile) pipelineRunParams() tektonv1.ParamSpecs {
+	return append(append(tektonv1.ParamSpecs{}, r.pipelineParams...), tektonv1.ParamSpec{
+		Name: "generation",
+	})
+}
+
+// withHooks splices the hooks of the image into the tasks of a generated
+// pipeline: the first tasks run after the pre-build hooks, and the post-build
+// hooks after the last tasks
+func withHooks(tasks []tektonv1.PipelineTask, hooks *osbuildv1alpha1.BuildHooks) []tektonv1.PipelineTask {
+	if hooks == nil {
+		return tasks
+	}
+	preTasks := []tektonv1.PipelineTask{}
+	preNames := []string{}
+	for _, hook := range hooks.PreBuild {
+		task := hookTask("pre-"+hook.Name, hook)
+		preTasks = append(preTasks, task)
+		preNames = append(preNames, task.Name)
+	}
+	// the last tasks are the ones no other task runs after
+	runBefore := map[string]bool{}
+	for _, task := range tasks {
+		for _, name := range task.RunAfter {
+			runBefore[name] = true
+		}
+	}
+	lastNames := []string{}
+	spliced := preTasks
+	for _, task := range tasks {
+		if !runBefore[task.Name] {
+			lastNames = append(lastNames, task.Name)
+		}
+		if len(task.RunAfter) == 0 && len(preNames) > 0 {
+			task.RunAfter = preNames
+		}
+		spliced = append(spliced, task)
+	}
+	for _, hook := range hooks.PostBuild {
+		task := hookTask("post-"+hook.Name, hook)
+		task.RunAfter = lastNames
+		spliced = append(spliced, task)
+	}
+	return spliced
+}
+
+// hookTask runs the Task of a hook
+func hookTask(name string, hook osbuildv1alpha1.BuildHook) tektonv1.PipelineTask {
+	task := tektonv1.PipelineTask{
+		Name: name,
+		TaskRef: &tektonv1.TaskRef{
+			Name: hook.Task,
+		},
+	}
+	if hook.Resolver != "" {
+		task.TaskRef = &tektonv1.TaskRef{
+			ResolverRef: tektonv1.ResolverRef{
+				Resolver: tektonv1.ResolverName(hook.Resolver),
+			},
+		}
+		for _, param := range hook.ResolverParams {
+			task.TaskRef.Params = append(task.TaskRef.Params, tektonv1.Param{
+				Name:  param.Name,
+				Value: *tektonv1.NewStructuredValues(param.Value),
+			})
+		}
+	}
+	for _, param := range hook.Params {
+		task.Params = append(task.Params, tektonv1.Param{
+			Name:  param.Name,
+			Value: *tektonv1.NewStructuredValues(param.Value),
+		})
+	}
+	for _, workspace := range hook.Workspaces {
+		task.Workspaces = append(task.Workspaces, tektonv1.WorkspacePipelineTaskBinding{
+			Name:      workspace,
+			Workspace: workspace,
+		})
+	}
+	return task
+}
+
+// scriptSteps turns the inline scripts of the image into steps run in the
+// directory of the build
+func scriptSteps(prefix string, scripts []osbuildv1alpha1.ScriptStep) []tektonv1.Step {
+	steps := []tektonv1.Step{}
+	for _, script := range scripts {
+		steps = append(steps, tektonv1.Step{
+			Name:       prefix + "-" + script.Name,
+			Image:      script.Image,
+			Script:     script.Script,
+			WorkingDir: "/workspace/shared-volume/$(params.blueprintName)",
+			Env: []corev1.EnvVar{
+				{
+					Name:  "BLUEPRINTS_DIR",
+					Value: "/workspace/shared-volume/$(params.blueprintName)/blueprints",
+				},
+				{
+					Name:  "BLUEPRINT_NAME",
+					Value: "$(params.blueprintName)",
+				},
+			},
+		})
+	}
+	return steps
+}
+
+// pushEditedBlueprintsCommand pushes the blueprints of the build directory
+// again, once edited by the pre-compose scripts
+const pushEditedBlueprintsCommand = `for blueprint in "${BLUEPRINTS_DIR}"/*; do /usr/bin/curl --silent --fail -H "Content-Type: text/x-toml" --data-binary "@${blueprint}" "$(params.apiEndpoint)/blueprints/new" || exit 1; done`
+
+// pushBlueprintStep pushes the blueprints edited by the pre-compose scripts
+// to composer, which bumps their version, before the compose starts. The
+// operator pushed them unchanged before the build.
+func pushBlueprintStep() tektonv1.Step {
+	return tektonv1.Step{
+		Name:    "push-blueprint",
+		Image:   ubiImage,
+		Command: []string{"/bin/bash", "-c", pushEditedBlueprintsCommand},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "BLUEPRINTS_DIR",
+				Value: "/workspace/shared-volume/$(params.blueprintName)/blueprints",
+			},
+		},
+	}
+}
+
+// setStepTimeouts bounds the steps of a generated task with the timeout of
+// their stage, and sets the interval at which they poll composer
+func setStepTimeouts(task *tektonv1.Task, timeouts *osbuildv1alpha1.ComposeTimeouts) {
+	if timeouts == nil {
+		return
+	}
+	for i := range task.Spec.Steps {
+		step := &task.Spec.Steps[i]
+		stage, _ := stepStage(step.Name)
+		switch stage {
+		case osbuildv1alpha1.StageDepsolving:
+			if step.Name == "start-compose" {
+				step.Timeout = timeouts.Depsolve
+			}
+		case osbuildv1alpha1.StageBuilding:
+			step.Timeout = timeouts.Build
+			if timeouts.PollInterval != nil {
+				step.Env = append(step.Env, corev1.EnvVar{
+					Name:  "poll_interval",
+					Value: strconv.FormatInt(int64(timeouts.PollInterval.Seconds()), 10),
+				})
+			}
+		case osbuildv1alpha1.StageUploading:
+			step.Timeout = timeouts.Upload
+		}
+	}
+}
+
+// curlRetryFlags are the curl flags retrying a request retries times
+func curlRetryFlags(retries int32, options *osbuildv1alpha1.NetworkRetries) []string {
+	flags := []string{"--retry", strconv.Itoa(int(retries)), "--retry-connrefused"}
+	if options.RetryOn == osbuildv1alpha1.RetryOnAllErrors {
+		flags = append(flags, "--retry-all-errors")
+	}
+	if options.Delay != nil {
+		flags = append(flags, "--retry-delay", strconv.FormatInt(int64(options.Delay.Seconds()), 10))
+	}
+	return flags
+}
+
+// setStepRetries retries the requests of the steps of a generated task
+// pushing the blueprints edited by the pre-compose scripts and starting
+// composes, and in a single task build the ones downloading the
+// artifacts, which are otherwise retried by Tekton. It also sets how many
+// status checks of the steps waiting for composes may fail.
+func setStepRetries(task *tektonv1.Task, retries *osbuildv1alpha1.NetworkRetries, ephemeral bool) {
+	if retries == nil {
+		return
+	}
+	for i := range task.Spec.Steps {
+		step := &task.Spec.Steps[i]
+		count := int32(0)
+		switch step.Name {
+		case "push-blueprint":
+			count = retries.BlueprintPush
+		case "start-compose":
+			count = retries.ComposeStart
+		case "download", "download-commit":
+			if ephemeral {
+				count = retries.Download
+			}
+		}
+		if step.Name == "wait-for-finish" && retries.StatusChecks > 0 {
+			step.Env = append(step.Env, corev1.EnvVar{
+				Name:  "status_checks",
+				Value: strconv.Itoa(int(retries.StatusChecks)),
+			})
+		}
+		if count == 0 || len(step.Command) == 0 {
+			continue
+		}
+		flags := curlRetryFlags(count, retries)
+		if step.Command[0] == "/usr/bin/curl" {
+			step.Command = append(step.Command, flags...)
+			continue
+		}
+		// shell commands running curl
+		last := len(step.Command) - 1
+		step.Command[last] = strings.ReplaceAll(step.Command[last], "/usr/bin/curl ", "/usr/bin/curl "+strings.Join(flags, " ")+" ")
+	}
+}
+
+// setTaskRetries retries the named tasks of a generated pipeline
+func setTaskRetries(pipeline *tektonv1.Pipeline, retries int32, names ...string) {
+	for i := range pipeline.Spec.Tasks {
+		for _, name := range names {
+			if pipeline.Spec.Tasks[i].Name == name {
+				pipeline.Spec.Tasks[i].Retries = int(retries)
+			}
+		}
+	}
+}
+
+// generatedTasks returns the tasks building an image, in order, named after
+// names and with the metadata of generated, once their steps were adjusted
+// to the timeouts, retries, scripts and build pod settings of the image, to
+// the architecture of its builder and to the flavor and credentials of its
+// composer API
+func (r *imageReconcile) generatedTasks(image *osbuildv1alpha1.ImageBuilderImage, builder *osbuildv1alpha1.ImageBuilder, names GeneratedNames, generated metav1.ObjectMeta, ephemeral bool) []tektonv1.Task {
+	named := func(name string) metav1.ObjectMeta {
+		objectMeta := generated
+		objectMeta.Name = name
+		return objectMeta
+	}
+	images := r.stepImages(image)
+	resources := r.buildPod(image).Resources
+	prepareTask := r.PrepareSharedVolumeTask(named(names.PrepareTask))
+	setStepImages(&prepareTask.Spec, images, builder.Spec.Architecture)
+	setStepResources(&prepareTask.Spec, resources)
+
+	blueprint := image.Spec.Name
+	if blueprint == "" {
+		blueprint = image.Name
+	}
+	cloud := r.apiFlavor == osbuildv1alpha1.APIFlavorCloud
+	commitTask := r.CommitTask(named(names.CommitTask))
+	switch {
+	case cloud:
+		r.setCloudCompose(&commitTask, builder, blueprint, cloudImageType(r.composeType), r.ostree, "compose.json", cloudUploadTargets(image))
+	case image.Spec.Upload != nil:
+		r.setWeldrUpload(&commitTask, image)
+	}
+	if scripts := image.Spec.Scripts; scripts != nil && len(scripts.PreCompose) > 0 {
+		preCompose := scriptSteps("pre-compose", scripts.PreCompose)
+		// the Cloud API reads the edited blueprints with every compose request
+		if !cloud {
+			preCompose = append(preCompose, pushBlueprintStep())
+		}
+		commitTask.Spec.Steps = append(preCompose, commitTask.Spec.Steps...)
+	}
+	addRecordComposeStep(&commitTask, "compose.json")
+	setStepTimeouts(&commitTask, image.Spec.ComposeTimeouts)
+	setStepRetries(&commitTask, image.Spec.Retries, ephemeral)
+	setStepImages(&commitTask.Spec, images, builder.Spec.Architecture)
+	setStepResources(&commitTask.Spec, resources)
+
+	// only an edge-commit is extracted, the other images are served as is
+	downloadTask := r.DownloadExtractCommitTask(named(names.DownloadTask))
+	if composeImage, ok := composeImages[r.composeType]; ok {
+		downloadTask = r.DownloadTask(named(names.DownloadTask), "compose.json", composeImage.Name)
+		if scripts := image.Spec.Scripts; scripts != nil {
+			downloadTask.Spec.Steps = append(downloadTask.Spec.Steps, scriptSteps("post-compose", scripts.PostCompose)...)
+		}
+		if r.composeType == osbuildv1alpha1.ComposeImageInstaller {
+			addKickstartStep(&downloadTask, image, composeImage.Name)
+		}
+	}
+	setStepTimeouts(&downloadTask, image.Spec.ComposeTimeouts)
+	setStepRetries(&downloadTask, image.Spec.Retries, ephemeral)
+	setStepImages(&downloadTask.Spec, images, builder.Spec.Architecture)
+	setStepResources(&downloadTask.Spec, resources)
+
+	tasks := []tektonv1.Task{prepareTask, commitTask, downloadTask}
+	// the installer is built from the edge commit
+	if r.composeType != osbuildv1alpha1.ComposeEdgeCommit {
+		return r.withComposerAPI(tasks)
+	}
+	isoComposeTask := r.IsoComposeTask(named(names.IsoComposeTask))
+	if cloud {
+		r.setCloudCompose(&isoComposeTask, builder, blueprint+"-iso", r.isoTarget, nil, "compose-iso.json", nil)
+	}
+	addRecordComposeStep(&isoComposeTask, "compose-iso.json")
+	setStepTimeouts(&isoComposeTask, image.Spec.ComposeTimeouts)
+	setStepRetries(&isoComposeTask, image.Spec.Retries, ephemeral)
+	setStepImages(&isoComposeTask.Spec, images, builder.Spec.Architecture)
+	setStepResources(&isoComposeTask.Spec, resources)
+
+	isoDownloadTask := r.DownloadTask(named(names.IsoDownloadTask), "compose-iso.json", "installer.iso")
+	if scripts := image.Spec.Scripts; scripts != nil {
+		isoDownloadTask.Spec.Steps = append(isoDownloadTask.Spec.Steps, scriptSteps("post-compose", scripts.PostCompose)...)
+	}
+	addKickstartStep(&isoDownloadTask, image, "installer.iso")
+	setStepTimeouts(&isoDownloadTask, image.Spec.ComposeTimeouts)
+	setStepRetries(&isoDownloadTask, image.Spec.Retries, ephemeral)
+	setStepImages(&isoDownloadTask.Spec, images, builder.Spec.Architecture)
+	setStepResources(&isoDownloadTask.Spec, resources)
+	return r.withComposerAPI(append(tasks, isoComposeTask, isoDownloadTask))
+}
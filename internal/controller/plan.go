@@ -0,0 +1,112 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// planKey is the key of the manifest of a resource in the plan of a dry run
+func planKey(kind string, name string) string {
+	return fmt.Sprintf("%s-%s.json", strings.ToLower(kind), name)
+}
+
+// reconcilePlan publishes what a dry run of an image would create in its
+// plan ConfigMap, or Secret when the blueprints embed the values of Secrets:
+// the blueprints as <name>.toml and the manifest of every resource as
+// <kind>-<name>.json. Nothing else is created.
+func (r *ImageBuilderImageReconciler) reconcilePlan(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, names GeneratedNames, generated metav1.ObjectMeta, blueprints map[string]string, sensitive bool, resources []client.Object) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	data := map[string]string{}
+	for name, blueprint := range blueprints {
+		data[name+".toml"] = blueprint
+	}
+	planned := []string{}
+	for _, object := range resources {
+		gvk, err := apiutil.GVKForObject(object, r.Scheme)
+		if err != nil {
+			logger.Error(err, fmt.Sprintf("Could not render %s", object.GetName()))
+			return ctrl.Result{}, err
+		}
+		object.GetObjectKind().SetGroupVersionKind(gvk)
+		manifest, err := json.MarshalIndent(object, "", "  ")
+		if err != nil {
+			logger.Error(err, fmt.Sprintf("Could not render %s %s", gvk.Kind, object.GetName()))
+			return ctrl.Result{}, err
+		}
+		data[planKey(gvk.Kind, object.GetName())] = string(manifest)
+		planned = append(planned, fmt.Sprintf("%s/%s", gvk.Kind, object.GetName()))
+	}
+
+	planMeta := *generated.DeepCopy()
+	planMeta.Name = names.Plan
+	if err := CreateOrUpdateObject(ctx, r.Client, blueprintObject(planMeta, data, sensitive, false)); err != nil {
+		logger.Error(err, "Could not store the plan of the dry run")
+		return ctrl.Result{}, err
+	}
+	if err := deleteBlueprintObject(ctx, r.Client, image.Namespace, names.Plan, image.Name, !sensitive); err != nil {
+		logger.Error(err, "Could not delete the previous plan of the dry run")
+		return ctrl.Result{}, err
+	}
+
+	// the render time only moves when the plan does, not to update the
+	// status on every reconcile
+	hash := blueprintHash(data)
+	if plan := image.Status.Plan; plan == nil || plan.Hash != hash || plan.ConfigMap != names.Plan || plan.Secret != sensitive {
+		image.Status.Plan = &osbuildv1alpha1.BuildPlan{
+			ConfigMap:  names.Plan,
+			Secret:     sensitive,
+			Resources:  planned,
+			Hash:       hash,
+			RenderTime: metav1.Now(),
+		}
+	}
+	kind := "ConfigMap"
+	if sensitive {
+		kind = "Secret"
+	}
+	message := fmt.Sprintf("Dry run rendered %d blueprints and %d resources to %s %s, no build was started", len(blueprints), len(planned), kind, names.Plan)
+	logger.Info(message)
+	setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonDryRun, message)
+	setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonDryRun, "")
+	return ctrl.Result{}, updateImageStatus(ctx, r.Client, image)
+}
+
+// deletePlan deletes the plan of the last dry run of an image once
+// spec.dryRun is unset
+func (r *ImageBuilderImageReconciler) deletePlan(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage) error {
+	plan := image.Status.Plan
+	if plan == nil {
+		return nil
+	}
+	if err := deleteBlueprintObject(ctx, r.Client, image.Namespace, plan.ConfigMap, image.Name, plan.Secret); err != nil {
+		return err
+	}
+	image.Status.Plan = nil
+	return nil
+}
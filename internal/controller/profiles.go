@@ -0,0 +1,104 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+)
+
+// profileBlueprints are the built-in blueprint fragments selected with
+// spec.profile. They are appended to the commit blueprint template, default
+// or user supplied, and rendered with it, so they must only add packages and
+// tables a user template is not expected to define.
+var profileBlueprints = map[string]string{
+	osbuildv1alpha1.ProfileMinimal: `
+[[packages]]
+name = "podman"
+
+[[packages]]
+name = "greenboot"
+
+[[packages]]
+name = "greenboot-default-health-checks"
+
+[customizations.services]
+enabled = ["podman-auto-update.timer"]
+`,
+	osbuildv1alpha1.ProfileKiosk: `
+[[packages]]
+name = "gdm"
+
+[[packages]]
+name = "gnome-kiosk-script-session"
+
+[[packages]]
+name = "firefox"
+
+[[packages]]
+name = "greenboot-default-health-checks"
+
+[customizations.services]
+enabled = ["gdm"]
+
+[[customizations.files]]
+path = "/etc/gdm/custom.conf"
+data = """
+[daemon]
+AutomaticLoginEnable=True
+AutomaticLogin={{ .UserName }}
+"""
+`,
+	osbuildv1alpha1.ProfileGateway: `
+[[packages]]
+name = "NetworkManager-wifi"
+
+[[packages]]
+name = "NetworkManager-wwan"
+
+[[packages]]
+name = "ModemManager"
+
+[[packages]]
+name = "firewalld"
+
+[[packages]]
+name = "greenboot-default-health-checks"
+
+[customizations.services]
+enabled = ["NetworkManager", "ModemManager", "firewalld"]
+
+[[customizations.files]]
+path = "/etc/sysctl.d/90-gateway.conf"
+data = """
+net.ipv4.ip_forward = 1
+net.ipv6.conf.all.forwarding = 1
+"""
+
+[[customizations.files]]
+path = "/etc/NetworkManager/conf.d/90-gateway.conf"
+data = """
+[main]
+dns=dnsmasq
+
+[connectivity]
+enabled=false
+
+[device]
+wifi.scan-rand-mac-address=no
+"""
+`,
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// builderUIDLabel tells the ImageBuilder a PipelineRun or Job builds with
+const builderUIDLabel = "osbuild-operator-builder-uid"
+
+// queueRequeueInterval is how often builds waiting for their builder check
+// if it has a free slot
+const queueRequeueInterval = 30 * time.Second
+
+// runningBuilds counts the builds of all namespaces using a builder that are
+// not finished
+func (r *ImageBuilderImageReconciler) runningBuilds(ctx context.Context, builder *osbuildv1alpha1.ImageBuilder) (int, error) {
+	selector := client.MatchingLabels{builderUIDLabel: string(builder.UID)}
+	running := 0
+	if r.Tekton {
+		pipelineRuns := tektonv1.PipelineRunList{}
+		if err := r.List(ctx, &pipelineRuns, selector); err != nil {
+			return 0, err
+		}
+		for _, pipelineRun := range pipelineRuns.Items {
+			if !pipelineRun.IsDone() {
+				running++
+			}
+		}
+	}
+	jobs := batchv1.JobList{}
+	if err := r.List(ctx, &jobs, selector); err != nil {
+		return 0, err
+	}
+	for _, job := range jobs.Items {
+		if !jobFinished(&job) {
+			running++
+		}
+	}
+	return running, nil
+}
+
+// queueBuild tells if a new build of an image must wait for its builder,
+// running as many builds as spec.maxConcurrentBuilds allows, and returns why.
+// Waiting builds start in the order they were queued.
+func (r *ImageBuilderImageReconciler) queueBuild(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, builder *osbuildv1alpha1.ImageBuilder) (bool, string, error) {
+	limit := builder.Spec.MaxConcurrentBuilds
+	if limit == nil {
+		image.Status.Queue = nil
+		return false, "", nil
+	}
+	key := fmt.Sprintf("%s/%s", builder.Namespace, builder.Name)
+	if image.Status.Queue == nil || image.Status.Queue.Builder != key {
+		image.Status.Queue = &osbuildv1alpha1.BuildQueueStatus{
+			Builder: key,
+			Since:   metav1.Now(),
+		}
+	}
+	running, err := r.runningBuilds(ctx, builder)
+	if err != nil {
+		return false, "", err
+	}
+	images := osbuildv1alpha1.ImageBuilderImageList{}
+	if err := r.List(ctx, &images); err != nil {
+		return false, "", err
+	}
+	ahead := 0
+	for _, other := range images.Items {
+		// images that stopped waiting may still carry their place
+		ready := meta.FindStatusCondition(other.Status.Conditions, osbuildv1alpha1.ConditionReady)
+		if other.UID == image.UID || other.Status.Queue == nil || other.Status.Queue.Builder != key ||
+			ready == nil || ready.Reason != osbuildv1alpha1.ReasonBuildQueued {
+			continue
+		}
+		since, queued := other.Status.Queue.Since, image.Status.Queue.Since
+		if since.Before(&queued) || (since.Equal(&queued) && other.Namespace+"/"+other.Name < image.Namespace+"/"+image.Name) {
+			ahead++
+		}
+	}
+	if ahead < int(*limit)-running {
+		image.Status.Queue = nil
+		return false, "", nil
+	}
+	image.Status.Queue.Position = int32(ahead + 1)
+	return true, fmt.Sprintf("ImageBuilder %s runs %d builds, the limit is %d, the build is number %d in its queue",
+		key, running, *limit, image.Status.Queue.Position), nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// quotaRequeueInterval is how often builds held back by a quota are retried
+const quotaRequeueInterval = 5 * time.Minute
+
+var quotaExceededTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "osbuild_operator_quota_exceeded_total",
+	Help: "Number of times a build was held back because a namespace quota was exceeded",
+}, []string{"namespace", "quota"})
+
+func init() {
+	metrics.Registry.MustRegister(quotaExceededTotal)
+}
+
+// namespaceQuota is the most restrictive quota of all the policies of a namespace
+type namespaceQuota struct {
+	maxBuildsPerDay     *int32
+	maxConcurrentBuilds *int32
+	maxArtifactStorage  *resource.Quantity
+}
+
+// checkQuota tells if a new build may be started in the namespace of the image,
+// returning the name of the exceeded quota and a message when it may not
+func (r *ImageBuilderImageReconciler) checkQuota(ctx context.Context, namespace string) (string, string, error) {
+	policies := osbuildv1alpha1.ImageBuilderPolicyList{}
+	if err := r.List(ctx, &policies, client.InNamespace(namespace)); err != nil {
+		return "", "", err
+	}
+	quota := namespaceQuota{}
+	for _, policy := range policies.Items {
+		if limit := policy.Spec.MaxBuildsPerDay; limit != nil && (quota.maxBuildsPerDay == nil || *limit < *quota.maxBuildsPerDay) {
+			quota.maxBuildsPerDay = limit
+		}
+		if limit := policy.Spec.MaxConcurrentBuilds; limit != nil && (quota.maxConcurrentBuilds == nil || *limit < *quota.maxConcurrentBuilds) {
+			quota.maxConcurrentBuilds = limit
+		}
+		if limit := policy.Spec.MaxArtifactStorage; limit != nil && (quota.maxArtifactStorage == nil || limit.Cmp(*quota.maxArtifactStorage) < 0) {
+			quota.maxArtifactStorage = limit
+		}
+	}
+
+	if quota.maxConcurrentBuilds != nil {
+		running := 0
+		if r.Tekton {
+			pipelineRuns := tektonv1.PipelineRunList{}
+			if err := r.List(ctx, &pipelineRuns, client.InNamespace(namespace), client.HasLabels{imageBuilderImageLabel}); err != nil {
+				return "", "", err
+			}
+			for _, pipelineRun := range pipelineRuns.Items {
+				if pipelineRunRunning(&pipelineRun) {
+					running++
+				}
+			}
+		}
+		jobs := batchv1.JobList{}
+		if err := r.List(ctx, &jobs, client.InNamespace(namespace), client.HasLabels{imageBuilderImageLabel}); err != nil {
+			return "", "", err
+		}
+		for _, job := range jobs.Items {
+			if jobRunning(&job) {
+				running++
+			}
+		}
+		if running >= int(*quota.maxConcurrentBuilds) {
+			return "MaxConcurrentBuilds", fmt.Sprintf("%d builds are running in namespace %s, the limit is %d", running, namespace, *quota.maxConcurrentBuilds), nil
+		}
+	}
+
+	if quota.maxBuildsPerDay != nil {
+		records := corev1.ConfigMapList{}
+		if err := r.List(ctx, &records, client.InNamespace(namespace), client.MatchingLabels{buildRecordLabel: "true"}); err != nil {
+			return "", "", err
+		}
+		since := time.Now().Add(-24 * time.Hour)
+		builds := 0
+		for _, record := range records.Items {
+			if record.CreationTimestamp.Time.After(since) {
+				builds++
+			}
+		}
+		if builds >= int(*quota.maxBuildsPerDay) {
+			return "MaxBuildsPerDay", fmt.Sprintf("%d builds were started in namespace %s in the last 24 hours, the limit is %d", builds, namespace, *quota.maxBuildsPerDay), nil
+		}
+	}
+
+	if quota.maxArtifactStorage != nil {
+		images := osbuildv1alpha1.ImageBuilderImageList{}
+		if err := r.List(ctx, &images, client.InNamespace(namespace)); err != nil {
+			return "", "", err
+		}
+		volumes := map[string]bool{}
+		for _, image := range images.Items {
+			volumes[SharedVolumeName(&image)] = true
+		}
+		used := resource.Quantity{}
+		for name := range volumes {
+			volume := corev1.PersistentVolumeClaim{}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &volume); err != nil {
+				if client.IgnoreNotFound(err) == nil {
+					continue
+				}
+				return "", "", err
+			}
+			used.Add(volume.Spec.Resources.Requests[corev1.ResourceStorage])
+		}
+		if used.Cmp(*quota.maxArtifactStorage) > 0 {
+			return "MaxArtifactStorage", fmt.Sprintf("artifact volumes of namespace %s request %s, the limit is %s", namespace, used.String(), quota.maxArtifactStorage.String()), nil
+		}
+	}
+	return "", "", nil
+}
+
+// pipelineRunRunning tells if a PipelineRun started and is not done, pending
+// ones not counting against maxConcurrentBuilds
+func pipelineRunRunning(pipelineRun *tektonv1.PipelineRun) bool {
+	succeeded := pipelineRun.Status.GetCondition(apis.ConditionSucceeded)
+	return succeeded != nil && succeeded.IsUnknown() && succeeded.Reason == tektonv1.PipelineRunReasonRunning.String()
+}
+
+// jobRunning tells if a Job started and did not finish, suspended ones not
+// counting against maxConcurrentBuilds
+func jobRunning(job *batchv1.Job) bool {
+	return job.Status.StartTime != nil && !jobFinished(job)
+}
+
+// SharedVolumeName returns the name of the PersistentVolumeClaim of an image
+func SharedVolumeName(image *osbuildv1alpha1.ImageBuilderImage) string {
+	if image.Spec.SharedVolume == "" {
+		return fmt.Sprintf("%s-data", image.Name)
+	}
+	return image.Spec.SharedVolume
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Defaults of the rate limiter of the ImageBuilder and ImageBuilderImage
+// controllers. A composer that does not answer fails every reconcile of its
+// images, the failed reconciles being retried from one second up to the
+// five minutes of the inventory interval rather than within milliseconds.
+const (
+	DefaultRequeueBaseDelay = time.Second
+	DefaultRequeueMaxDelay  = 5 * time.Minute
+	DefaultRequeueQPS       = 10
+	DefaultRequeueBurst     = 100
+)
+
+// NewRateLimiter returns the rate limiter of the requeues of a controller:
+// the failed reconciles of an object are retried after baseDelay, doubling
+// up to maxDelay, and the requeues of all objects are limited to qps, with
+// bursts of burst
+func NewRateLimiter(baseDelay time.Duration, maxDelay time.Duration, qps float64, burst int) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+	)
+}
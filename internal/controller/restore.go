@@ -0,0 +1,141 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// maxRestoredBlueprints limits the restored blueprints listed in status
+const maxRestoredBlueprints = 20
+
+// buildRecordBuilderField indexes the build records by the namespace/name of
+// the ImageBuilder they were built with
+const buildRecordBuilderField = "buildRecord.imageBuilder"
+
+// buildRecordBuilder is the value of buildRecordBuilderField for a ConfigMap,
+// none when it is not a build record
+func buildRecordBuilder(object client.Object) []string {
+	record, ok := object.(*corev1.ConfigMap)
+	if !ok || record.Labels[buildRecordLabel] != "true" || record.Data["imageBuilder"] == "" {
+		return nil
+	}
+	return []string{record.Data["imageBuilder"]}
+}
+
+// builderBlueprints returns the blueprints of the current builds of the
+// images built by a builder, as recorded by their build records, found by
+// buildRecordBuilderField
+func (r *ImageBuilderReconciler) builderBlueprints(ctx context.Context, builder *osbuildv1alpha1.ImageBuilder) (map[string]string, error) {
+	records := corev1.ConfigMapList{}
+	if err := r.List(ctx, &records, client.MatchingFields{buildRecordBuilderField: fmt.Sprintf("%s/%s", builder.Namespace, builder.Name)}); err != nil {
+		return nil, err
+	}
+	blueprints := map[string]string{}
+	for _, record := range records.Items {
+		image := osbuildv1alpha1.ImageBuilderImage{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: record.Namespace, Name: record.Data["image"]}, &image); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		// only the record of the current build of the image
+		if image.DeletionTimestamp != nil || image.Status.BuildRecord != record.Name || (image.Status.BlueprintConfigMap == "" && image.Status.BlueprintSecret == "") {
+			continue
+		}
+		name, secret := image.Status.BlueprintConfigMap, false
+		if image.Status.BlueprintSecret != "" {
+			name, secret = image.Status.BlueprintSecret, true
+		}
+		stored, err := readBlueprints(ctx, r.Client, image.Namespace, name, secret)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if image.Status.BlueprintVersion != "" {
+			stored = versionedBlueprints(stored, image.Status.BlueprintVersion)
+		}
+		for name, blueprint := range stored {
+			blueprints[name] = blueprint
+		}
+	}
+	return blueprints, nil
+}
+
+// restoreBlueprints pushes again the blueprints declared in the cluster that
+// composer does not know about, so a composer replaced with an empty state
+// builds the images again without manual steps
+func (r *ImageBuilderReconciler) restoreBlueprints(ctx context.Context, builder *osbuildv1alpha1.ImageBuilder, composerClient *composer.Client) ([]string, error) {
+	logger := log.FromContext(ctx)
+
+	// the Cloud API stores no blueprints
+	if composerClient.Cloud {
+		return nil, nil
+	}
+	stored, err := composerClient.Blueprints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, name := range stored {
+		known[name] = true
+	}
+	blueprints, err := r.builderBlueprints(ctx, builder)
+	if err != nil {
+		return nil, err
+	}
+	restored := []string{}
+	for name, blueprint := range blueprints {
+		if known[name] {
+			continue
+		}
+		if err := composerClient.PushBlueprint(ctx, blueprint); err != nil {
+			return restored, fmt.Errorf("could not push blueprint %s: %w", name, err)
+		}
+		restored = append(restored, name)
+	}
+	if len(restored) == 0 {
+		return restored, nil
+	}
+	sort.Strings(restored)
+	message := fmt.Sprintf("Restored %d blueprints missing from composer: %s", len(restored), strings.Join(restored, ", "))
+	logger.Info(message)
+	r.Recorder.Event(builder, corev1.EventTypeNormal, osbuildv1alpha1.EventBlueprintsRestored, eventMessage(message))
+	builder.Status.LastRestore = &osbuildv1alpha1.BlueprintRestore{
+		Time:       metav1.Now(),
+		Blueprints: restored,
+		Count:      int32(len(restored)),
+	}
+	if len(restored) > maxRestoredBlueprints {
+		builder.Status.LastRestore.Blueprints = restored[:maxRestoredBlueprints]
+	}
+	return restored, nil
+}
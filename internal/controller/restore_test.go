@@ -0,0 +1,139 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	"github.com/kwozyman/osbuild-operator/pkg/composertest"
+)
+
+var _ = Describe("Blueprint restore", func() {
+	ctx := context.Background()
+	var composerServer *composertest.Server
+	var reconciler *ImageBuilderReconciler
+	var builder *osbuildv1alpha1.ImageBuilder
+
+	buildRecord := func(name string, builder string, image string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "builds",
+				Labels:    map[string]string{buildRecordLabel: "true"},
+			},
+			Data: map[string]string{
+				"imageBuilder": builder,
+				"image":        image,
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		composerServer = composertest.NewServer()
+		DeferCleanup(composerServer.Close)
+		builder = &osbuildv1alpha1.ImageBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: "builds"},
+		}
+		objects := []client.Object{
+			builder,
+			&osbuildv1alpha1.ImageBuilderImage{
+				ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "builds"},
+				Status: osbuildv1alpha1.ImageBuilderImageStatus{
+					BuildRecord:        "edge-record-2",
+					BlueprintConfigMap: "edge-blueprints",
+				},
+			},
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "edge-blueprints", Namespace: "builds"},
+				Data:       map[string]string{"edge": "name = \"edge\"\nversion = \"0.0.2\"\n"},
+			},
+			buildRecord("edge-record-2", "builds/builder", "edge"),
+			// the record of a previous build of the image
+			buildRecord("edge-record-1", "builds/builder", "edge"),
+			// an image built by another builder
+			&osbuildv1alpha1.ImageBuilderImage{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "builds"},
+				Status: osbuildv1alpha1.ImageBuilderImageStatus{
+					BuildRecord:        "other-record-1",
+					BlueprintConfigMap: "other-blueprints",
+				},
+			},
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-blueprints", Namespace: "builds"},
+				Data:       map[string]string{"other": "name = \"other\"\nversion = \"0.0.1\"\n"},
+			},
+			buildRecord("other-record-1", "builds/other-builder", "other"),
+		}
+		reconciler = &ImageBuilderReconciler{
+			Client: fake.NewClientBuilder().
+				WithScheme(scheme.Scheme).
+				WithObjects(objects...).
+				WithIndex(&corev1.ConfigMap{}, buildRecordBuilderField, buildRecordBuilder).
+				Build(),
+			Scheme:   scheme.Scheme,
+			Recorder: record.NewFakeRecorder(10),
+		}
+	})
+
+	It("restores the blueprints of the current builds of the builder", func() {
+		restored, err := reconciler.restoreBlueprints(ctx, builder, composer.NewClient(composerServer.APIEndpoint()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored).To(Equal([]string{"edge"}))
+
+		blueprint, ok := composerServer.Blueprint("edge")
+		Expect(ok).To(BeTrue())
+		Expect(blueprint).To(ContainSubstring(`version = "0.0.2"`))
+		_, ok = composerServer.Blueprint("other")
+		Expect(ok).To(BeFalse())
+
+		Expect(builder.Status.LastRestore).NotTo(BeNil())
+		Expect(builder.Status.LastRestore.Count).To(BeEquivalentTo(1))
+		Expect(builder.Status.LastRestore.Blueprints).To(Equal([]string{"edge"}))
+	})
+
+	It("leaves the blueprints composer still has", func() {
+		Expect(pushBlueprint(composerServer, "edge")).To(Succeed())
+
+		restored, err := reconciler.restoreBlueprints(ctx, builder, composer.NewClient(composerServer.APIEndpoint()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored).To(BeEmpty())
+
+		blueprint, _ := composerServer.Blueprint("edge")
+		Expect(blueprint).NotTo(ContainSubstring("version"))
+		Expect(builder.Status.LastRestore).To(BeNil())
+	})
+
+	It("restores nothing with the Cloud API, which stores no blueprints", func() {
+		restored, err := reconciler.restoreBlueprints(ctx, builder, composer.NewClient(composerServer.URL+composer.CloudAPIPath))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored).To(BeEmpty())
+		_, ok := composerServer.Blueprint("edge")
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,115 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// scheduledBuildAnnotation records on a build the time spec.schedule was due
+// when it was created
+const scheduledBuildAnnotation = "osbuild.rh-ecosystem-edge.io/scheduled-build"
+
+// scheduledBuild is status.lastScheduledBuildTime as recorded on a build
+func scheduledBuild(image *osbuildv1alpha1.ImageBuilderImage) string {
+	if image.Status.LastScheduledBuildTime == nil {
+		return ""
+	}
+	return image.Status.LastScheduledBuildTime.UTC().Format(time.RFC3339)
+}
+
+// scheduleBuild starts a new build of an image when spec.schedule is due, once
+// the running build is done, and sets the next time it is due. Times missed
+// while the operator was not running only start one build, the ones due while
+// the image is suspended none.
+func (r *ImageBuilderImageReconciler) scheduleBuild(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, now time.Time) {
+	logger := log.FromContext(ctx)
+	if image.Spec.Schedule == "" {
+		image.Status.NextBuildTime = nil
+		return
+	}
+	schedule, err := osbuildv1alpha1.ParseSchedule(image.Spec.Schedule)
+	if err != nil {
+		// rejected by the validation of the spec
+		return
+	}
+	from := image.CreationTimestamp.Time
+	if last := image.Status.LastScheduledBuildTime; last != nil {
+		from = last.Time
+	}
+	if skipped := image.Status.LastSkippedBuildTime; skipped != nil && skipped.After(from) {
+		from = skipped.Time
+	}
+	due := time.Time{}
+	for next := schedule.Next(from); !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+		due = next
+	}
+	switch {
+	case due.IsZero():
+	case image.Spec.Suspend:
+		// not built once resumed either
+		image.Status.LastSkippedBuildTime = &metav1.Time{Time: due}
+		logger.Info(fmt.Sprintf("Skipping the build scheduled at %s, spec.suspend is set", due.Format(time.RFC3339)))
+	default:
+		if len(image.Status.History) > 0 && image.Status.History[0].Result == osbuildv1alpha1.BuildRunning {
+			// the end of the build reconciles the image again
+			image.Status.NextBuildTime = &metav1.Time{Time: due}
+			return
+		}
+		image.Status.LastScheduledBuildTime = &metav1.Time{Time: due}
+		message := fmt.Sprintf("Building the image as scheduled at %s by %q", due.Format(time.RFC3339), image.Spec.Schedule)
+		logger.Info(message)
+		r.Recorder.Event(image, corev1.EventTypeNormal, osbuildv1alpha1.EventBuildScheduled, message)
+	}
+	image.Status.NextBuildTime = nil
+	if next := schedule.Next(now); !next.IsZero() {
+		image.Status.NextBuildTime = &metav1.Time{Time: next}
+	}
+}
+
+// scheduleRequeue requeues an image when spec.schedule is next due, unless
+// result requeues it earlier
+func scheduleRequeue(image *osbuildv1alpha1.ImageBuilderImage, result ctrl.Result) ctrl.Result {
+	if image.Status.NextBuildTime == nil {
+		return result
+	}
+	wait := time.Until(image.Status.NextBuildTime.Time)
+	if wait > 0 && (result.RequeueAfter == 0 || wait < result.RequeueAfter) {
+		result.RequeueAfter = wait
+	}
+	return result
+}
+
+// suspendBuild reports that no new build of an image is created while
+// spec.suspend is set, the image being reconciled again when it is cleared
+func (r *ImageBuilderImageReconciler) suspendBuild(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage) (ctrl.Result, error) {
+	message := fmt.Sprintf("spec.suspend is set, generation %d is not built until it is cleared", image.Generation)
+	log.FromContext(ctx).Info(message)
+	image.Status.Queue = nil
+	setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildSuspended, message)
+	setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildSuspended, "")
+	// the skipped times of spec.schedule are still recorded
+	return scheduleRequeue(image, ctrl.Result{}), updateImageStatus(ctx, r.Client, image)
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const maxEventMessageLength = 1024
+
+// stepStages maps the steps of the generated tasks to the build stage they implement
+var stepStages = map[string]osbuildv1alpha1.BuildStage{
+	"create-directory":          osbuildv1alpha1.StageRenderingBlueprint,
+	"copy-blueprints":           osbuildv1alpha1.StageRenderingBlueprint,
+	"remove-compose-file":       osbuildv1alpha1.StageRenderingBlueprint,
+	"push-blueprint":            osbuildv1alpha1.StagePushingBlueprint,
+	"compose-json":              osbuildv1alpha1.StageDepsolving,
+	cloudComposeRequestStepName: osbuildv1alpha1.StageDepsolving,
+	"start-compose":             osbuildv1alpha1.StageDepsolving,
+	recordComposeStepName:       osbuildv1alpha1.StageDepsolving,
+	"wait-for-finish":           osbuildv1alpha1.StageBuilding,
+	"download":                  osbuildv1alpha1.StageUploading,
+	"download-commit":           osbuildv1alpha1.StageUploading,
+	"extract-commit":            osbuildv1alpha1.StageUploading,
+	artifactsTaskName:           osbuildv1alpha1.StageUploading,
+	ostreePublishName:           osbuildv1alpha1.StageUploading,
+	customizeISOStepName:        osbuildv1alpha1.StageUploading,
+}
+
+// stepStage returns the stage of a step, which may be suffixed with the
+// position of its task when the tasks run as a single one
+func stepStage(name string) (osbuildv1alpha1.BuildStage, bool) {
+	if stage, ok := stepStages[name]; ok {
+		return stage, true
+	}
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			stage, ok := stepStages[name[:i]]
+			return stage, ok
+		}
+	}
+	return "", false
+}
+
+func setImageCondition(image *osbuildv1alpha1.ImageBuilderImage, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&image.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: image.Generation,
+	})
+}
+
+// setBuildConditions translates the PipelineRun state into the Ready and Failed
+// conditions, failureReason being used when the PipelineRun failed
+func setBuildConditions(image *osbuildv1alpha1.ImageBuilderImage, pipelineRun *tektonv1.PipelineRun, failureReason string) {
+	image.Status.Queue = nil
+	image.Status.PipelineRun = pipelineRun.Name
+	image.Status.Job = ""
+	image.Status.BuildRecord = pipelineRun.Annotations[buildRecordAnnotation]
+	served := false
+	for _, workspace := range pipelineRun.Spec.Workspaces {
+		if workspace.Name == "blueprints" && workspace.ConfigMap != nil {
+			image.Status.BlueprintConfigMap = workspace.ConfigMap.Name
+			image.Status.BlueprintSecret = ""
+		}
+		if workspace.Name == "blueprints" && workspace.Secret != nil {
+			image.Status.BlueprintConfigMap = ""
+			image.Status.BlueprintSecret = workspace.Secret.SecretName
+		}
+		if workspace.Name == "shared-volume" && workspace.PersistentVolumeClaim != nil {
+			served = true
+		}
+	}
+	succeeded := pipelineRun.Status.GetCondition(apis.ConditionSucceeded)
+	switch {
+	case pipelineRun.IsPending():
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonPipelineRunPending,
+			"PipelineRun "+pipelineRun.Name+" is waiting to be started")
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonPipelineRunPending, "")
+	case !pipelineRun.IsDone():
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildRunning,
+			"PipelineRun "+pipelineRun.Name+" is running")
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildRunning, "")
+	case succeeded.IsTrue():
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionTrue, osbuildv1alpha1.ReasonBuildSucceeded, succeeded.Message)
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuildSucceeded, "")
+		if generation, err := strconv.ParseInt(pipelineRun.Labels[imageBuilderImageGenerationLabel], 10, 64); err == nil {
+			image.Status.ArtifactsGeneration = generation
+		}
+		setBuildArtifacts(image, pipelineRun, served)
+	default:
+		reason := failureReason
+		if pipelineRun.IsCancelled() {
+			reason = osbuildv1alpha1.ReasonBuildCancelled
+		}
+		setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, reason, succeeded.Message)
+		setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionTrue, reason, succeeded.Message)
+	}
+}
+
+// recordBuildCompletion emits an event when the build, the named PipelineRun
+// or Job of kind, completed since the last reconcile, previous being the
+// Ready condition before its conditions were set
+func (r *ImageBuilderImageReconciler) recordBuildCompletion(image *osbuildv1alpha1.ImageBuilderImage, kind string, name string, done bool, previous *metav1.Condition) {
+	current := meta.FindStatusCondition(image.Status.Conditions, osbuildv1alpha1.ConditionReady)
+	if !done || current == nil {
+		return
+	}
+	if previous != nil && previous.Status == current.Status && previous.Reason == current.Reason {
+		return
+	}
+	if current.Status == metav1.ConditionTrue {
+		message := fmt.Sprintf("%s %s succeeded with %d artifacts", kind, name, len(image.Status.Artifacts))
+		if image.Status.BuildDuration != nil {
+			message = fmt.Sprintf("%s in %s", message, image.Status.BuildDuration.Duration.Round(time.Second))
+		}
+		r.Recorder.Event(image, corev1.EventTypeNormal, osbuildv1alpha1.EventBuildSucceeded, eventMessage(message))
+		return
+	}
+	r.Recorder.Event(image, corev1.EventTypeWarning, osbuildv1alpha1.EventBuildFailed,
+		eventMessage(fmt.Sprintf("%s %s failed (%s): %s", kind, name, current.Reason, current.Message)))
+}
+
+// setBuildProgress reports the stage and a rough completion percentage of the
+// build by looking at the steps of the TaskRuns started by the PipelineRun
+func setBuildProgress(ctx context.Context, c client.Client, image *osbuildv1alpha1.ImageBuilderImage, pipelineRun *tektonv1.PipelineRun) error {
+	if pipelineRun.IsDone() {
+		image.Status.Stage = ""
+		if pipelineRun.Status.GetCondition(apis.ConditionSucceeded).IsTrue() {
+			image.Status.Progress = 100
+		}
+		return nil
+	}
+	if pipelineRun.IsPending() || pipelineRun.Status.PipelineSpec == nil {
+		image.Status.Stage = ""
+		image.Status.Progress = 0
+		return nil
+	}
+
+	totalTasks := len(pipelineRun.Status.PipelineSpec.Tasks) + len(pipelineRun.Status.PipelineSpec.Finally)
+	completedTasks := 0.0
+	stage := image.Status.Stage
+	for _, child := range pipelineRun.Status.ChildReferences {
+		if child.Kind != "TaskRun" {
+			continue
+		}
+		taskRun := tektonv1.TaskRun{}
+		if err := c.Get(ctx, client.ObjectKey{
+			Namespace: pipelineRun.Namespace,
+			Name:      child.Name,
+		}, &taskRun); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if taskRun.IsDone() {
+			completedTasks++
+			continue
+		}
+		steps := taskRun.Status.Steps
+		for _, step := range steps {
+			if step.Terminated != nil {
+				completedTasks += 1 / float64(len(steps))
+			}
+			if step.Running != nil {
+				if s, ok := stepStage(step.Name); ok {
+					stage = s
+				}
+			}
+		}
+	}
+	image.Status.Stage = stage
+	if totalTasks > 0 {
+		// never report a running build as complete
+		image.Status.Progress = int32(completedTasks * 100 / float64(totalTasks))
+		if image.Status.Progress > 99 {
+			image.Status.Progress = 99
+		}
+	}
+	return nil
+}
+
+// stepTimeoutReason is the reason of the termination of the steps Tekton
+// stopped after their timeout
+const stepTimeoutReason = "TimeoutExceeded"
+
+// buildFailureReason tells which part of a failed build went wrong from the
+// first failed step of its TaskRuns, or that it timed out
+func buildFailureReason(ctx context.Context, c client.Client, pipelineRun *tektonv1.PipelineRun) (string, error) {
+	if !pipelineRun.IsDone() || pipelineRun.Status.GetCondition(apis.ConditionSucceeded).IsTrue() {
+		return "", nil
+	}
+	if pipelineRun.Status.GetCondition(apis.ConditionSucceeded).Reason == tektonv1.PipelineRunReasonTimedOut.String() {
+		return osbuildv1alpha1.ReasonBuildTimedOut, nil
+	}
+	for _, child := range pipelineRun.Status.ChildReferences {
+		if child.Kind != "TaskRun" {
+			continue
+		}
+		taskRun := tektonv1.TaskRun{}
+		if err := c.Get(ctx, client.ObjectKey{
+			Namespace: pipelineRun.Namespace,
+			Name:      child.Name,
+		}, &taskRun); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return "", err
+		}
+		if taskRun.Status.GetCondition(apis.ConditionSucceeded).GetReason() == tektonv1.TaskRunReasonTimedOut.String() {
+			return osbuildv1alpha1.ReasonBuildTimedOut, nil
+		}
+		for _, step := range taskRun.Status.Steps {
+			if step.Terminated == nil || step.Terminated.ExitCode == 0 {
+				continue
+			}
+			if step.Terminated.Reason == stepTimeoutReason {
+				return osbuildv1alpha1.ReasonBuildTimedOut, nil
+			}
+			if reason := stepFailureReason(step.Name, step.Terminated.ExitCode); reason != "" {
+				return reason, nil
+			}
+		}
+	}
+	return osbuildv1alpha1.ReasonBuildFailed, nil
+}
+
+// stepFailureReason tells which part of a build went wrong from a step that
+// failed with exitCode, empty for the steps outside of the compose
+func stepFailureReason(name string, exitCode int32) string {
+	stage, _ := stepStage(name)
+	switch stage {
+	case osbuildv1alpha1.StageBuilding:
+		if exitCode == depsolveFailedExitCode {
+			return osbuildv1alpha1.ReasonDepsolveFailed
+		}
+		return osbuildv1alpha1.ReasonComposeFailed
+	case osbuildv1alpha1.StageDepsolving:
+		return osbuildv1alpha1.ReasonComposeFailed
+	case osbuildv1alpha1.StageUploading:
+		return osbuildv1alpha1.ReasonUploadFailed
+	}
+	return ""
+}
+
+// eventMessage truncates a message to the size accepted for events
+func eventMessage(message string) string {
+	if len(message) > maxEventMessageLength {
+		return message[:maxEventMessageLength-3] + "..."
+	}
+	return message
+}
+
+// updateImageStatus persists the status subresource of the ImageBuilderImage
+func updateImageStatus(ctx context.Context, c client.Client, image *osbuildv1alpha1.ImageBuilderImage) error {
+	image.Status.ObservedGeneration = image.Generation
+	image.Status.Phase = buildPhase(image)
+	return c.Status().Update(ctx, image)
+}
+
+// pipelineRunToImage maps a PipelineRun, or a TaskRun, which inherits the
+// labels of its PipelineRun, to the ImageBuilderImage that created it, owner
+// or not of the run. It is used the same way for the generated Pipeline and
+// Tasks.
+func pipelineRunToImage(ctx context.Context, object client.Object) []reconcile.Request {
+	name, ok := object.GetLabels()[imageBuilderImageLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{
+		{
+			NamespacedName: types.NamespacedName{
+				Namespace: object.GetNamespace(),
+				Name:      name,
+			},
+		},
+	}
+}
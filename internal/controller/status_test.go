@@ -0,0 +1,59 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+)
+
+var _ = Describe("Build progress", func() {
+	ctx := context.Background()
+
+	It("reports the stage of the steps of a single task build", func() {
+		pipelineRun := &tektonv1.PipelineRun{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge-1", Namespace: "default"},
+		}
+		pipelineRun.Status.PipelineSpec = &tektonv1.PipelineSpec{
+			Tasks: []tektonv1.PipelineTask{{Name: "build"}},
+		}
+		pipelineRun.Status.ChildReferences = []tektonv1.ChildStatusReference{{
+			TypeMeta:         runtime.TypeMeta{Kind: "TaskRun"},
+			Name:             "edge-1-build",
+			PipelineTaskName: "build",
+		}}
+		taskRun := tektonv1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "edge-1-build"}}
+		taskRun.Status.Steps = []tektonv1.StepState{
+			{Name: "push-blueprint-1", ContainerState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}},
+			{Name: "wait-for-finish-2", ContainerState: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		}
+		image := &osbuildv1alpha1.ImageBuilderImage{}
+
+		Expect(setBuildProgress(ctx, taskRunClient("default", pipelineRun.Name, taskRun), image, pipelineRun)).To(Succeed())
+		Expect(image.Status.Stage).To(Equal(osbuildv1alpha1.StageBuilding))
+		Expect(image.Status.Progress).To(Equal(int32(50)))
+	})
+})
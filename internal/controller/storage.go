@@ -0,0 +1,250 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	routev1 "github.com/openshift/api/route/v1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// buildSubPath is the directory of the shared volume holding the data of one
+// generation of an image, so builds sharing a PVC don't overwrite each other
+func buildSubPath(imageName string, generation int64) string {
+	return fmt.Sprintf("%s/%d", imageName, generation)
+}
+
+// artifactsSubPath is the directory of the shared volume with the artifacts
+// of one generation of an image, as written by the pipeline tasks
+func artifactsSubPath(imageName string, generation int64) string {
+	return path.Join(buildSubPath(imageName, generation), imageName)
+}
+
+// cleanupBuildsTask removes the directories of the other generations of the
+// image once a build succeeded
+func cleanupBuildsTask() tektonv1.PipelineTask {
+	return tektonv1.PipelineTask{
+		Name: "cleanup-builds",
+		TaskSpec: &tektonv1.EmbeddedTask{
+			TaskSpec: tektonv1.TaskSpec{
+				Params: tektonv1.ParamSpecs{
+					{
+						Name: "generation",
+					},
+				},
+				Workspaces: []tektonv1.WorkspaceDeclaration{
+					{
+						Name: "image-volume",
+					},
+				},
+				Steps: []tektonv1.Step{
+					{
+						Name:    "remove-old-builds",
+						Image:   ubiImage,
+						Command: []string{"/bin/sh", "-c"},
+						Args: []string{
+							"find /workspace/image-volume -mindepth 1 -maxdepth 1 -type d ! -name \"$(params.generation)\" -print -exec rm -rf {} +",
+						},
+					},
+				},
+			},
+		},
+		Workspaces: []tektonv1.WorkspacePipelineTaskBinding{
+			{
+				Name: "image-volume",
+			},
+		},
+		Params: tektonv1.Params{
+			{
+				Name: "generation",
+				Value: tektonv1.ParamValue{
+					Type:      "string",
+					StringVal: "$(params.generation)",
+				},
+			},
+		},
+		When: tektonv1.WhenExpressions{
+			{
+				Input:    "$(tasks.status)",
+				Operator: selection.In,
+				Values:   []string{"Succeeded", "Completed"},
+			},
+		},
+	}
+}
+
+// servedGeneration is the generation whose artifacts are served, the current
+// one until a build succeeded
+func servedGeneration(image *osbuildv1alpha1.ImageBuilderImage) int64 {
+	if image.Status.ArtifactsGeneration != 0 {
+		return image.Status.ArtifactsGeneration
+	}
+	return image.Generation
+}
+
+// storageStrategy shares ReadWriteMany volumes between nodes and pins the pods
+// using other volumes to a single node. The access modes of a bound claim are
+// the ones of its volume, the requested ones are used until then.
+func storageStrategy(pvc *corev1.PersistentVolumeClaim) osbuildv1alpha1.StorageStrategy {
+	accessModes := pvc.Status.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = pvc.Spec.AccessModes
+	}
+	for _, mode := range accessModes {
+		if mode == corev1.ReadWriteMany {
+			return osbuildv1alpha1.StorageShared
+		}
+	}
+	return osbuildv1alpha1.StorageNodePinned
+}
+
+// storageAffinity schedules the pods of an image on the node of the first one
+// when its volume can only be mounted from one node. A pod matching its own
+// affinity is scheduled anywhere when no other pod matches.
+func storageAffinity(strategy osbuildv1alpha1.StorageStrategy, imageName string) *corev1.Affinity {
+	if strategy != osbuildv1alpha1.StorageNodePinned {
+		return nil
+	}
+	return &corev1.Affinity{
+		PodAffinity: &corev1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+				{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							imageBuilderImageLabel: imageName,
+						},
+					},
+					TopologyKey: corev1.LabelHostname,
+				},
+			},
+		},
+	}
+}
+
+// defaultSharedVolumeSize is the size of the shared volume created when the
+// image does not request one
+var defaultSharedVolumeSize = resource.MustParse("20Gi")
+
+// sharedVolumeClaim is the default shared volume of an image, created when the
+// image does not name an existing claim
+func sharedVolumeClaim(objectMeta metav1.ObjectMeta, image *osbuildv1alpha1.ImageBuilderImage) corev1.PersistentVolumeClaim {
+	size := defaultSharedVolumeSize
+	if image.Spec.SharedVolumeSize != nil {
+		size = *image.Spec.SharedVolumeSize
+	}
+	accessModes := image.Spec.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	return corev1.PersistentVolumeClaim{
+		ObjectMeta: objectMeta,
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      accessModes,
+			StorageClassName: image.Spec.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+}
+
+// ephemeralStorage tells if the image is built in an emptyDir volume
+func ephemeralStorage(image *osbuildv1alpha1.ImageBuilderImage) bool {
+	return image.Spec.Storage != nil && image.Spec.Storage.Type == osbuildv1alpha1.StorageEmptyDir
+}
+
+// blueprintsWorkspace binds the blueprints of a build, stored in a Secret
+// when sensitive
+func blueprintsWorkspace(name string, sensitive bool) tektonv1.WorkspaceBinding {
+	if sensitive {
+		return tektonv1.WorkspaceBinding{
+			Name: "blueprints",
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: name,
+			},
+		}
+	}
+	return tektonv1.WorkspaceBinding{
+		Name: "blueprints",
+		ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{
+				Name: name,
+			},
+		},
+	}
+}
+
+// buildWorkspaces binds the volumes the build works in
+func buildWorkspaces(image *osbuildv1alpha1.ImageBuilderImage, pvcName string) []tektonv1.WorkspaceBinding {
+	if ephemeralStorage(image) {
+		return []tektonv1.WorkspaceBinding{
+			{
+				Name: "shared-volume",
+				EmptyDir: &corev1.EmptyDirVolumeSource{
+					SizeLimit: image.Spec.Storage.SizeLimit,
+				},
+			},
+		}
+	}
+	return []tektonv1.WorkspaceBinding{
+		{
+			Name: "shared-volume",
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: pvcName,
+			},
+			SubPath: buildSubPath(image.Name, image.Generation),
+		},
+		{
+			Name: "image-volume",
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: pvcName,
+			},
+			SubPath: image.Name,
+		},
+	}
+}
+
+// deleteWebServer removes the deployment, service and route, if any, serving the
+// artifacts of an image that does not expose them or is built in an emptyDir
+// volume, which has none
+func (r *ImageBuilderImageReconciler) deleteWebServer(ctx context.Context, names GeneratedNames, namespace string) error {
+	objects := []client.Object{
+		&routev1.Route{ObjectMeta: metav1.ObjectMeta{Name: names.WebRoute, Namespace: namespace}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: names.WebService, Namespace: namespace}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: names.WebDeployment, Namespace: namespace}},
+	}
+	for _, object := range objects {
+		if err := r.Delete(ctx, object); err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const supersededByAnnotation = "osbuild.rh-ecosystem-edge.io/superseded-by-generation"
+const cancelledAtAnnotation = "osbuild.rh-ecosystem-edge.io/cancelled-at"
+const cancelledComposesAnnotation = "osbuild.rh-ecosystem-edge.io/cancelled-composes"
+
+// The PipelineRun of a build records the hashes of the blueprints and of the
+// spec it was created from, and the rebuild annotation of the image at the time
+const blueprintHashAnnotation = "osbuild.rh-ecosystem-edge.io/blueprint-hash"
+const specHashAnnotation = "osbuild.rh-ecosystem-edge.io/spec-hash"
+
+// RebuildAnnotation triggers a new build of an image when its value changes,
+// e.g. to a timestamp, without changing its blueprints
+const RebuildAnnotation = "osbuild.rh-ecosystem-edge.io/rebuild"
+const buildGenerationAnnotation = "osbuild.rh-ecosystem-edge.io/build-generation"
+
+// specHash returns a stable hash of the spec of an image
+func specHash(image *osbuildv1alpha1.ImageBuilderImage) (string, error) {
+	spec, err := json.Marshal(image.Spec)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(spec)), nil
+}
+
+// buildAnnotations are the annotations recording on a PipelineRun what
+// triggered it
+func buildAnnotations(image *osbuildv1alpha1.ImageBuilderImage) (map[string]string, error) {
+	hash, err := specHash(image)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		blueprintHashAnnotation:   image.Status.BlueprintHash,
+		specHashAnnotation:        hash,
+		RebuildAnnotation:         image.Annotations[RebuildAnnotation],
+		buildGenerationAnnotation: buildGeneration(image),
+		scheduledBuildAnnotation:  scheduledBuild(image),
+	}, nil
+}
+
+// buildGeneration is spec.buildGeneration as recorded on a build, empty when
+// it is not set so builds created before it existed are not replaced
+func buildGeneration(image *osbuildv1alpha1.ImageBuilderImage) string {
+	if image.Spec.BuildGeneration == 0 {
+		return ""
+	}
+	return strconv.FormatInt(image.Spec.BuildGeneration, 10)
+}
+
+// nextBuildNumber is the number of the next PipelineRun of an image, the run
+// of an image built before the runs were counted being the first one
+func nextBuildNumber(image *osbuildv1alpha1.ImageBuilderImage) int64 {
+	number := image.Status.BuildNumber
+	if number == 0 && image.Status.PipelineRun != "" {
+		number = 1
+	}
+	return number + 1
+}
+
+// superseded tells if a PipelineRun must be replaced by a new build: its
+// blueprints are not the rendered ones anymore or a rebuild was requested,
+// with the rebuild annotation, spec.buildGeneration or spec.schedule.
+// Other changes of the spec apply to the next build. Runs created before they
+// recorded their blueprint hash are replaced when they build an older
+// generation, and runs created before they were labeled with their generation
+// never are.
+func superseded(image *osbuildv1alpha1.ImageBuilderImage, build metav1.Object) bool {
+	if hash, ok := build.GetAnnotations()[blueprintHashAnnotation]; ok {
+		return hash != image.Status.BlueprintHash ||
+			build.GetAnnotations()[RebuildAnnotation] != image.Annotations[RebuildAnnotation] ||
+			build.GetAnnotations()[buildGenerationAnnotation] != buildGeneration(image) ||
+			build.GetAnnotations()[scheduledBuildAnnotation] != scheduledBuild(image)
+	}
+	generation, ok := build.GetLabels()[imageBuilderImageGenerationLabel]
+	return ok && generation != strconv.FormatInt(image.Generation, 10)
+}
+
+// supersedeBuild replaces a superseded PipelineRun. A build
+// still in progress is cancelled along with the composes it queued, which are
+// then deleted from composer, and the cancellation is recorded on its build
+// record. The PipelineRun is kept for the history, the next build getting a
+// new one, and deleted once beyond spec.historyLimit.
+func (r *ImageBuilderImageReconciler) supersedeBuild(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, pipelineRun *tektonv1.PipelineRun, composerClient *composer.Client) error {
+	logger := log.FromContext(ctx)
+	if !pipelineRun.IsDone() {
+		logger.Info(fmt.Sprintf("Cancelling PipelineRun %s superseded by generation %d", pipelineRun.Name, image.Generation))
+		cancelled, err := r.cancelComposes(ctx, pipelineRun, composerClient)
+		if err != nil {
+			// the build is replaced anyway, the composes are left to composer
+			logger.Error(err, "Could not cancel composes of superseded build")
+			r.Recorder.Event(image, corev1.EventTypeWarning, osbuildv1alpha1.EventBuildSuperseded,
+				eventMessage(fmt.Sprintf("Could not cancel the composes of PipelineRun %s: %s", pipelineRun.Name, err)))
+		}
+		if err := r.recordCancellation(ctx, image, pipelineRun, cancelled); err != nil {
+			logger.Error(err, "Could not record cancellation in build record")
+			return err
+		}
+		r.Recorder.Event(image, corev1.EventTypeNormal, osbuildv1alpha1.EventBuildSuperseded,
+			eventMessage(fmt.Sprintf("Cancelled PipelineRun %s and composes [%s], superseded by generation %d",
+				pipelineRun.Name, strings.Join(cancelled, ", "), image.Generation)))
+	}
+	if pipelineRun.IsDone() || pipelineRun.IsCancelled() {
+		return nil
+	}
+	patch := client.MergeFrom(pipelineRun.DeepCopy())
+	pipelineRun.Spec.Status = tektonv1.PipelineRunSpecStatusCancelled
+	if err := r.Patch(ctx, pipelineRun, patch); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "Could not cancel superseded pipelinerun")
+		return err
+	}
+	return nil
+}
+
+// cancelComposes cancels and deletes the composes still queued that were
+// started by a PipelineRun, returning their IDs
+func (r *ImageBuilderImageReconciler) cancelComposes(ctx context.Context, pipelineRun *tektonv1.PipelineRun, composerClient *composer.Client) ([]string, error) {
+	if pipelineRun.Status.StartTime == nil {
+		return nil, nil
+	}
+	ids, err := buildComposeIDs(ctx, r.Client, pipelineRun.Namespace, pipelineRun.Name, false)
+	if err != nil {
+		return nil, err
+	}
+	return cancelBuildComposes(ctx, ids, composerClient)
+}
+
+// cancelBuildComposes cancels and deletes the composes of ids still queued,
+// returning their IDs
+func cancelBuildComposes(ctx context.Context, ids []string, composerClient *composer.Client) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	started := map[string]bool{}
+	for _, id := range ids {
+		started[id] = true
+	}
+	queue, err := composerClient.Queue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cancelled := []string{}
+	for _, compose := range append(queue.New, queue.Run...) {
+		if !started[compose.ID] {
+			continue
+		}
+		if err := composerClient.Cancel(ctx, compose.ID); err != nil {
+			return cancelled, err
+		}
+		if err := composerClient.Delete(ctx, compose.ID); err != nil {
+			return cancelled, err
+		}
+		cancelled = append(cancelled, compose.ID)
+	}
+	sort.Strings(cancelled)
+	return cancelled, nil
+}
+
+// recordCancellation annotates the build record of a cancelled build, its data
+// being immutable
+func (r *ImageBuilderImageReconciler) recordCancellation(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, build metav1.Object, composes []string) error {
+	name, ok := build.GetAnnotations()[buildRecordAnnotation]
+	if !ok {
+		return nil
+	}
+	record := corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: build.GetNamespace(), Name: name}, &record); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	patch := client.MergeFrom(record.DeepCopy())
+	record.Annotations = mergeMaps(record.Annotations, map[string]string{
+		supersededByAnnotation:      strconv.FormatInt(image.Generation, 10),
+		cancelledAtAnnotation:       time.Now().UTC().Format(time.RFC3339),
+		cancelledComposesAnnotation: strings.Join(composes, ","),
+	})
+	return r.Patch(ctx, &record, patch)
+}
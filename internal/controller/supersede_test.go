@@ -0,0 +1,232 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	"github.com/kwozyman/osbuild-operator/pkg/composertest"
+)
+
+var _ = Describe("Superseded builds", func() {
+	ctx := context.Background()
+	var composerServer *composertest.Server
+	var reconciler *ImageBuilderImageReconciler
+	var namespace string
+	var pipelineRun *tektonv1.PipelineRun
+
+	startCompose := func(blueprint string) string {
+		Expect(pushBlueprint(composerServer, blueprint)).To(Succeed())
+		id, err := startWeldrCompose(composerServer, blueprint)
+		Expect(err).NotTo(HaveOccurred())
+		return id
+	}
+
+	BeforeEach(func() {
+		composerServer = composertest.NewServer()
+		DeferCleanup(composerServer.Close)
+		reconciler = &ImageBuilderImageReconciler{Client: k8sClient}
+		namespace = createNamespace(ctx)
+
+		pipelineRun = &tektonv1.PipelineRun{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge-build-1", Namespace: namespace},
+		}
+		started := metav1.NewTime(time.Now().Add(-time.Minute))
+		pipelineRun.Status.StartTime = &started
+	})
+
+	It("cancels and deletes the queued composes it started", func() {
+		waiting := startCompose("edge")
+		finished := startCompose("edge")
+		Expect(composerServer.SetStatus(finished, composertest.StatusFinished)).To(Succeed())
+		// the compose of another image using the same blueprint name
+		other := startCompose("edge")
+		taskRun := tektonv1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "edge-build-1-compose"}}
+		taskRun.Status.Steps = []tektonv1.StepState{
+			recordComposeState("record-compose", waiting),
+			recordComposeState("record-compose-1", finished),
+		}
+		reconciler.Client = taskRunClient(namespace, pipelineRun.Name, taskRun)
+
+		cancelled, err := reconciler.cancelComposes(ctx, pipelineRun, composer.NewClient(composerServer.APIEndpoint()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cancelled).To(Equal([]string{waiting}))
+
+		for _, compose := range composerServer.Composes() {
+			switch compose.ID {
+			case waiting:
+				Expect(compose.Cancelled).To(BeTrue())
+				Expect(compose.Deleted).To(BeTrue())
+			case finished:
+				Expect(compose.Deleted).To(BeFalse())
+				Expect(compose.Status).To(Equal(composertest.StatusFinished))
+			case other:
+				Expect(compose.Cancelled).To(BeFalse())
+				Expect(compose.Status).To(Equal(composertest.StatusWaiting))
+			}
+		}
+	})
+
+	It("cancels nothing before the build started", func() {
+		id := startCompose("edge")
+		taskRun := tektonv1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "edge-build-1-compose"}}
+		taskRun.Status.Steps = []tektonv1.StepState{recordComposeState("record-compose", id)}
+		reconciler.Client = taskRunClient(namespace, pipelineRun.Name, taskRun)
+		pipelineRun.Status.StartTime = nil
+
+		cancelled, err := reconciler.cancelComposes(ctx, pipelineRun, composer.NewClient(composerServer.APIEndpoint()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cancelled).To(BeEmpty())
+	})
+
+	It("reads the compose IDs of the retried tasks and of the Jobs", func() {
+		taskRun := tektonv1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "edge-build-1-compose"}}
+		taskRun.Status.Steps = []tektonv1.StepState{recordComposeState("record-compose", "id-2")}
+		taskRun.Status.RetriesStatus = []tektonv1.TaskRunStatus{{}}
+		taskRun.Status.RetriesStatus[0].Steps = []tektonv1.StepState{recordComposeState("record-compose", "id-1")}
+		reconciler.Client = taskRunClient(namespace, pipelineRun.Name, taskRun)
+		ids, err := buildComposeIDs(ctx, reconciler.Client, namespace, pipelineRun.Name, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ids).To(Equal([]string{"id-2", "id-1"}))
+
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge-build-1-x", Namespace: namespace, Labels: map[string]string{"job-name": "edge-build-1"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "serve-commit", Image: utilsImage}}},
+		}
+		Expect(k8sClient.Create(ctx, &pod)).To(Succeed())
+		pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+			Name:  "record-compose-1",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: "id-3\n"}},
+		}}
+		Expect(k8sClient.Status().Update(ctx, &pod)).To(Succeed())
+		ids, err = buildComposeIDs(ctx, k8sClient, namespace, "edge-build-1", true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ids).To(Equal([]string{"id-3"}))
+	})
+
+	It("cancels nothing with the Cloud API, which has no queue", func() {
+		cloudClient := composer.NewClient(composerServer.URL + composer.CloudAPIPath)
+		id, err := cloudClient.CloudCompose(ctx, composer.CloudComposeRequest{
+			ImageRequest: composer.CloudImageRequest{ImageType: "edge-commit", UploadTargets: composer.LocalUpload()},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		cancelled, err := cancelBuildComposes(ctx, []string{id}, cloudClient)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cancelled).To(BeEmpty())
+		for _, compose := range composerServer.Composes() {
+			Expect(compose.ID).To(Equal(id))
+			Expect(compose.Cancelled).To(BeFalse())
+		}
+	})
+
+	It("records the cancellation on the build record", func() {
+		record := corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge-record-1", Namespace: namespace},
+			Data:       map[string]string{"image": "edge"},
+		}
+		Expect(k8sClient.Create(ctx, &record)).To(Succeed())
+		pipelineRun.Annotations = map[string]string{buildRecordAnnotation: record.Name}
+		image := &osbuildv1alpha1.ImageBuilderImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: namespace, Generation: 3},
+		}
+
+		Expect(reconciler.recordCancellation(ctx, image, pipelineRun, []string{"id-1", "id-2"})).To(Succeed())
+
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&record), &record)).To(Succeed())
+		Expect(record.Annotations).To(HaveKeyWithValue(supersededByAnnotation, "3"))
+		Expect(record.Annotations).To(HaveKeyWithValue(cancelledComposesAnnotation, "id-1,id-2"))
+		Expect(record.Annotations).To(HaveKey(cancelledAtAnnotation))
+		Expect(record.Data).To(Equal(map[string]string{"image": "edge"}))
+	})
+})
+
+// recordComposeState is the state of a record-compose step reporting the ID
+// of a compose in its results
+func recordComposeState(name string, id string) tektonv1.StepState {
+	return tektonv1.StepState{
+		Name: name,
+		ContainerState: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{
+				Message: fmt.Sprintf(`[{"key":%q,"value":%q,"type":1}]`, composeIDResult, id),
+			},
+		},
+	}
+}
+
+// taskRunClient is a fake client holding the TaskRuns of a PipelineRun
+func taskRunClient(namespace string, pipelineRun string, taskRuns ...tektonv1.TaskRun) client.Client {
+	testScheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+	Expect(tektonv1.AddToScheme(testScheme)).To(Succeed())
+	objects := []client.Object{}
+	for i := range taskRuns {
+		taskRuns[i].Namespace = namespace
+		taskRuns[i].Labels = map[string]string{"tekton.dev/pipelineRun": pipelineRun}
+		objects = append(objects, &taskRuns[i])
+	}
+	return fake.NewClientBuilder().WithScheme(testScheme).WithObjects(objects...).Build()
+}
+
+// pushBlueprint pushes an empty blueprint to a fake composer
+func pushBlueprint(composerServer *composertest.Server, name string) error {
+	return postComposer(composerServer, "/blueprints/new", fmt.Sprintf("name = %q\n", name), nil)
+}
+
+// startWeldrCompose queues an edge-commit compose of a blueprint on a fake
+// composer
+func startWeldrCompose(composerServer *composertest.Server, blueprint string) (string, error) {
+	result := struct {
+		BuildID string `json:"build_id"`
+	}{}
+	body := fmt.Sprintf(`{"blueprint_name": %q, "compose_type": "edge-commit"}`, blueprint)
+	err := postComposer(composerServer, "/compose", body, &result)
+	return result.BuildID, err
+}
+
+func postComposer(composerServer *composertest.Server, path string, body string, result interface{}) error {
+	response, err := http.Post(composerServer.APIEndpoint()+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", path, response.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// templateSource reads the blueprint template selected by ref in namespace.
+// found is false when the object or its key does not exist, unless the
+// reference is optional, in which case the empty template selects the default.
+func (r *ImageBuilderImageReconciler) templateSource(ctx context.Context, namespace string, ref *osbuildv1alpha1.TemplateReference) (string, bool, error) {
+	if ref.ConfigMapKeyRef != nil {
+		configMap := corev1.ConfigMap{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.ConfigMapKeyRef.Name}, &configMap); err != nil {
+			if errors.IsNotFound(err) {
+				return "", optional(ref.ConfigMapKeyRef.Optional), nil
+			}
+			return "", false, err
+		}
+		if value, ok := configMap.Data[ref.ConfigMapKeyRef.Key]; ok {
+			return value, true, nil
+		}
+		return "", optional(ref.ConfigMapKeyRef.Optional), nil
+	}
+	return r.secretValue(ctx, namespace, ref.SecretKeyRef)
+}
+
+// secretValue reads the key of a Secret in namespace, found being false when
+// the Secret or its key does not exist and the selector is not optional
+func (r *ImageBuilderImageReconciler) secretValue(ctx context.Context, namespace string, selector *corev1.SecretKeySelector) (string, bool, error) {
+	secret := corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: selector.Name}, &secret); err != nil {
+		if errors.IsNotFound(err) {
+			return "", optional(selector.Optional), nil
+		}
+		return "", false, err
+	}
+	if value, ok := secret.Data[selector.Key]; ok {
+		return string(value), true, nil
+	}
+	return "", optional(selector.Optional), nil
+}
+
+// resolveSecrets reads the ssh key, template values and DIUN public key
+// verification of a spec from their Secrets, and checks its kickstart exists,
+// returning the field of the first one that does not exist yet
+func (r *ImageBuilderImageReconciler) resolveSecrets(ctx context.Context, namespace string, spec *osbuildv1alpha1.ImageBuilderImageSpec) (string, error) {
+	if spec.SshKeySecretRef != nil {
+		value, found, err := r.secretValue(ctx, namespace, spec.SshKeySecretRef)
+		if err != nil || !found {
+			return "spec.sshKeySecretRef", err
+		}
+		spec.SshKey = value
+	}
+	spec.Secrets = map[string]string{}
+	for i := range spec.TemplateSecrets {
+		value, found, err := r.secretValue(ctx, namespace, &spec.TemplateSecrets[i].SecretKeyRef)
+		if err != nil || !found {
+			return fmt.Sprintf("spec.templateSecrets[%d]", i), err
+		}
+		spec.Secrets[spec.TemplateSecrets[i].Name] = value
+	}
+	if spec.FDO != nil && spec.FDO.DIUNPubKey != nil {
+		for _, ref := range []struct {
+			field    string
+			selector *corev1.SecretKeySelector
+			value    *string
+		}{
+			{"spec.fdo.diunPubKey.hash", spec.FDO.DIUNPubKey.Hash, &spec.DiunPubKeyHash},
+			{"spec.fdo.diunPubKey.rootCerts", spec.FDO.DIUNPubKey.RootCerts, &spec.DiunPubKeyRootCerts},
+		} {
+			if ref.selector == nil {
+				continue
+			}
+			value, found, err := r.secretValue(ctx, namespace, ref.selector)
+			if err != nil || !found {
+				return ref.field, err
+			}
+			*ref.value = strings.TrimSpace(value)
+		}
+	}
+	// the build reads it itself
+	if spec.KickstartRef != nil {
+		if _, found, err := r.templateSource(ctx, namespace, spec.KickstartRef); err != nil || !found {
+			return "spec.kickstartRef", err
+		}
+	}
+	return "", nil
+}
+
+// sensitiveBlueprints tells if the blueprints of a spec embed values read
+// from Secrets, so they are stored in Secrets rather than ConfigMaps
+func sensitiveBlueprints(spec *osbuildv1alpha1.ImageBuilderImageSpec) bool {
+	if spec.SshKeySecretRef != nil || len(spec.TemplateSecrets) > 0 {
+		return true
+	}
+	if spec.FDO != nil && spec.FDO.DIUNPubKey != nil && (spec.FDO.DIUNPubKey.Hash != nil || spec.FDO.DIUNPubKey.RootCerts != nil) {
+		return true
+	}
+	for _, ref := range []*osbuildv1alpha1.TemplateReference{spec.BlueprintTemplateRef, spec.BlueprintIsoTemplateRef} {
+		if ref != nil && ref.SecretKeyRef != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForTemplate reports the image waiting for the ConfigMap or Secret of a
+// template reference or value, which reconciles it again once created
+func (r *ImageBuilderImageReconciler) waitForTemplate(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, field string) (ctrl.Result, error) {
+	message := fmt.Sprintf("Waiting for the ConfigMap or Secret of %s", field)
+	log.FromContext(ctx).Info(message)
+	setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForTemplate, message)
+	setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonWaitingForTemplate, "")
+	return ctrl.Result{}, updateImageStatus(ctx, r.Client, image)
+}
+
+func optional(value *bool) bool {
+	return value != nil && *value
+}
+
+// templateReferences tells if one of the template references or values of an
+// image selects the named ConfigMap, or Secret when secret is set
+func templateReferences(image *osbuildv1alpha1.ImageBuilderImage, name string, secret bool) bool {
+	if secret && image.Spec.SshKeySecretRef != nil && image.Spec.SshKeySecretRef.Name == name {
+		return true
+	}
+	for _, templateSecret := range image.Spec.TemplateSecrets {
+		if secret && templateSecret.SecretKeyRef.Name == name {
+			return true
+		}
+	}
+	if fdo := image.Spec.FDO; secret && fdo != nil && fdo.DIUNPubKey != nil {
+		for _, ref := range []*corev1.SecretKeySelector{fdo.DIUNPubKey.Hash, fdo.DIUNPubKey.RootCerts} {
+			if ref != nil && ref.Name == name {
+				return true
+			}
+		}
+	}
+	for _, container := range image.Spec.EmbeddedContainers {
+		if secret && container.PullSecret != nil && container.PullSecret.Name == name {
+			return true
+		}
+	}
+	for _, ref := range []*osbuildv1alpha1.TemplateReference{image.Spec.BlueprintTemplateRef, image.Spec.BlueprintIsoTemplateRef, image.Spec.KickstartRef} {
+		switch {
+		case ref == nil:
+		case secret && ref.SecretKeyRef != nil && ref.SecretKeyRef.Name == name:
+			return true
+		case !secret && ref.ConfigMapKeyRef != nil && ref.ConfigMapKeyRef.Name == name:
+			return true
+		}
+	}
+	return false
+}
+
+// templateSourceToImages maps a ConfigMap or Secret to the images of its
+// namespace reading a blueprint template from it, so they are rendered again
+// when it changes
+func (r *ImageBuilderImageReconciler) templateSourceToImages(ctx context.Context, object client.Object) []reconcile.Request {
+	_, secret := object.(*corev1.Secret)
+	images := osbuildv1alpha1.ImageBuilderImageList{}
+	if err := r.List(ctx, &images, client.InNamespace(object.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Could not list ImageBuilderImages")
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for i := range images.Items {
+		if templateReferences(&images.Items[i], object.GetName(), secret) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: images.Items[i].Namespace,
+					Name:      images.Items[i].Name,
+				},
+			})
+		}
+	}
+	return requests
+}
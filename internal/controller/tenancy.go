@@ -0,0 +1,157 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// builderSelectionRequeueInterval is how often images without a usable
+// ImageBuilder look for one again
+const builderSelectionRequeueInterval = time.Minute
+
+// builderRequeueInterval is how often images and sources wait for their
+// ImageBuilder to serve composer
+const builderRequeueInterval = builderSelectionRequeueInterval
+
+// builderAllowed tells if an image may be built by a builder of another
+// namespace. A builder setting spec.allowedNamespaces only accepts the
+// namespaces it selects. Otherwise every namespace may use it, unless running
+// in multi-tenant mode, where only the builders of the shared builder namespace
+// and the ones backing a ClusterImageBuilder are open to other namespaces.
+func (r *ImageBuilderImageReconciler) builderAllowed(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, builder *osbuildv1alpha1.ImageBuilder) (bool, error) {
+	if builder.Namespace == image.Namespace {
+		return true, nil
+	}
+	if builder.Spec.AllowedNamespaces == nil {
+		if !r.MultiTenant {
+			return true, nil
+		}
+		if _, ok := builder.Labels[clusterImageBuilderLabel]; ok {
+			return true, nil
+		}
+		return r.SharedBuilderNamespace != "" && builder.Namespace == r.SharedBuilderNamespace, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(builder.Spec.AllowedNamespaces)
+	if err != nil {
+		return false, err
+	}
+	namespace := corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: image.Namespace}, &namespace); err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// defaultBuilders returns the builders marked as default, only keeping the one
+// of the namespace of the image when there is one, then the one of the shared
+// builder namespace
+func defaultBuilders(candidates []osbuildv1alpha1.ImageBuilder, namespace string, sharedNamespace string) []osbuildv1alpha1.ImageBuilder {
+	defaults := []osbuildv1alpha1.ImageBuilder{}
+	for _, candidate := range candidates {
+		if candidate.Spec.Default {
+			defaults = append(defaults, candidate)
+		}
+	}
+	for _, preferred := range []string{namespace, sharedNamespace} {
+		for _, candidate := range defaults {
+			if preferred != "" && candidate.Namespace == preferred {
+				return []osbuildv1alpha1.ImageBuilder{candidate}
+			}
+		}
+	}
+	return defaults
+}
+
+// builderSelector returns the selector of spec.imageBuilderSelector, nil
+// when the image does not set it
+func builderSelector(image *osbuildv1alpha1.ImageBuilderImage) (labels.Selector, error) {
+	if image.Spec.ImageBuilderSelector == nil {
+		return nil, nil
+	}
+	return metav1.LabelSelectorAsSelector(image.Spec.ImageBuilderSelector)
+}
+
+// selectBuilders returns the builders matching selector, only keeping the
+// ones of the namespace of the image when there are some, then the ones
+// marked as default when several match. Without a selector, it returns the
+// builders marked as default.
+func selectBuilders(candidates []osbuildv1alpha1.ImageBuilder, namespace string, sharedNamespace string, selector labels.Selector) []osbuildv1alpha1.ImageBuilder {
+	if selector == nil {
+		return defaultBuilders(candidates, namespace, sharedNamespace)
+	}
+	matches := []osbuildv1alpha1.ImageBuilder{}
+	local := []osbuildv1alpha1.ImageBuilder{}
+	for _, candidate := range candidates {
+		if !selector.Matches(labels.Set(candidate.Labels)) {
+			continue
+		}
+		matches = append(matches, candidate)
+		if candidate.Namespace == namespace {
+			local = append(local, candidate)
+		}
+	}
+	if len(local) > 0 {
+		matches = local
+	}
+	if len(matches) > 1 {
+		if defaults := defaultBuilders(matches, namespace, sharedNamespace); len(defaults) > 0 {
+			return defaults
+		}
+	}
+	return matches
+}
+
+// builderNames returns the sorted namespace/name list of builders, used to
+// report the candidates of a failed selection
+func builderNames(builders []osbuildv1alpha1.ImageBuilder) string {
+	if len(builders) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(builders))
+	for _, builder := range builders {
+		names = append(names, fmt.Sprintf("%s/%s", builder.Namespace, builder.Name))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// builderSelectionFailed reports that no single ImageBuilder could be selected
+// for the image and retries later, as builders may be created or marked as
+// default in the meantime
+func (r *ImageBuilderImageReconciler) builderSelectionFailed(ctx context.Context, image *osbuildv1alpha1.ImageBuilderImage, reason, message string) (ctrl.Result, error) {
+	r.Recorder.Event(image, corev1.EventTypeWarning, reason, eventMessage(message))
+	setImageCondition(image, osbuildv1alpha1.ConditionBuilderSelectionFailed, metav1.ConditionTrue, reason, message)
+	setImageCondition(image, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuilderSelectionFailed, message)
+	setImageCondition(image, osbuildv1alpha1.ConditionFailed, metav1.ConditionFalse, osbuildv1alpha1.ReasonBuilderSelectionFailed, "")
+	if err := updateImageStatus(ctx, r.Client, image); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: builderSelectionRequeueInterval}, nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+)
+
+var _ = Describe("Default builder", func() {
+	newBuilder := func(namespace string, name string, isDefault bool) osbuildv1alpha1.ImageBuilder {
+		return osbuildv1alpha1.ImageBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       osbuildv1alpha1.ImageBuilderSpec{Default: isDefault},
+		}
+	}
+	var candidates []osbuildv1alpha1.ImageBuilder
+
+	BeforeEach(func() {
+		candidates = []osbuildv1alpha1.ImageBuilder{
+			newBuilder("shared", "x86", true),
+			newBuilder("shared", "arm", false),
+			newBuilder("team", "arm", false),
+		}
+	})
+
+	It("selects the builder marked as default", func() {
+		defaults := defaultBuilders(candidates, "team", "shared")
+		Expect(defaults).To(HaveLen(1))
+		Expect(defaults[0].Namespace).To(Equal("shared"))
+		Expect(defaults[0].Name).To(Equal("x86"))
+	})
+
+	It("prefers the default builder of the namespace of the image", func() {
+		candidates = append(candidates, newBuilder("team", "local", true))
+		defaults := defaultBuilders(candidates, "team", "shared")
+		Expect(defaults).To(HaveLen(1))
+		Expect(defaults[0].Namespace).To(Equal("team"))
+		Expect(defaults[0].Name).To(Equal("local"))
+		// the other namespaces use the default builder of the shared namespace
+		defaults = defaultBuilders(candidates, "other", "shared")
+		Expect(builderNames(defaults)).To(Equal("shared/x86"))
+	})
+
+	It("reports the default builders of other namespaces as ambiguous without a shared namespace", func() {
+		candidates = append(candidates, newBuilder("team", "local", true))
+		Expect(builderNames(defaultBuilders(candidates, "other", ""))).To(Equal("shared/x86, team/local"))
+	})
+
+	It("selects no builder without a default", func() {
+		candidates[0].Spec.Default = false
+		Expect(defaultBuilders(candidates, "team", "shared")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Builder selection", func() {
+	newBuilder := func(namespace string, name string, isDefault bool, builderLabels map[string]string) osbuildv1alpha1.ImageBuilder {
+		return osbuildv1alpha1.ImageBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: builderLabels},
+			Spec:       osbuildv1alpha1.ImageBuilderSpec{Default: isDefault},
+		}
+	}
+	var candidates []osbuildv1alpha1.ImageBuilder
+
+	BeforeEach(func() {
+		candidates = []osbuildv1alpha1.ImageBuilder{
+			newBuilder("shared", "x86", true, map[string]string{"arch": "amd64"}),
+			newBuilder("shared", "arm", false, map[string]string{"arch": "arm64"}),
+			newBuilder("team", "arm", false, map[string]string{"arch": "arm64"}),
+			newBuilder("team", "arm-large", false, map[string]string{"arch": "arm64", "size": "large"}),
+		}
+	})
+
+	It("selects the default builder without a selector", func() {
+		Expect(builderNames(selectBuilders(candidates, "team", "shared", nil))).To(Equal("shared/x86"))
+	})
+
+	It("prefers the matching builders of the namespace of the image", func() {
+		selector := labels.SelectorFromSet(labels.Set{"arch": "arm64"})
+		Expect(builderNames(selectBuilders(candidates, "team", "shared", selector))).To(Equal("team/arm, team/arm-large"))
+		Expect(builderNames(selectBuilders(candidates, "other", "shared", selector))).To(Equal("shared/arm, team/arm, team/arm-large"))
+	})
+
+	It("prefers the default builder when several match", func() {
+		candidates[3].Spec.Default = true
+		selector := labels.SelectorFromSet(labels.Set{"arch": "arm64"})
+		Expect(builderNames(selectBuilders(candidates, "team", "shared", selector))).To(Equal("team/arm-large"))
+	})
+
+	It("selects no builder when none matches", func() {
+		selector := labels.SelectorFromSet(labels.Set{"arch": "s390x"})
+		Expect(selectBuilders(candidates, "team", "shared", selector)).To(BeEmpty())
+		Expect(builderNames(nil)).To(Equal("none"))
+	})
+})
@@ -0,0 +1,131 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/composer"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirt "kubevirt.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// composerVersionAnnotation records on the VM the spec.composerVersion it
+// was created with
+const composerVersionAnnotation = "osbuild.rh-ecosystem-edge.io/composer-version"
+
+// defaultUpgradeDrainTimeout bounds the wait for in-flight composes
+const defaultUpgradeDrainTimeout = 2 * time.Hour
+
+// upgradeRequeueInterval is how often an upgrading builder is checked
+const upgradeRequeueInterval = 30 * time.Second
+
+func setBuilderCondition(builder *osbuildv1alpha1.ImageBuilder, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&builder.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: builder.Generation,
+	})
+}
+
+// builderUpgrading tells if a builder holds back new builds while composer is
+// upgraded
+func builderUpgrading(builder *osbuildv1alpha1.ImageBuilder) bool {
+	return meta.IsStatusConditionTrue(builder.Status.Conditions, osbuildv1alpha1.ConditionUpgrading)
+}
+
+// needsUpgrade tells if the VM of a builder runs another version of composer
+// than the requested one
+func needsUpgrade(builder *osbuildv1alpha1.ImageBuilder, vm *kubevirt.VirtualMachine) bool {
+	return vm.Annotations[composerVersionAnnotation] != builder.Spec.ComposerVersion
+}
+
+// upgradeComposer drains the builder, waiting for the composes in flight to
+// finish or for spec.upgradeDrainTimeout, then deletes its VM and cloud-init
+// Secret so they are created again with the requested version of composer
+func (r *ImageBuilderReconciler) upgradeComposer(ctx context.Context, builder *osbuildv1alpha1.ImageBuilder, vm *kubevirt.VirtualMachine, cloudConfig *corev1.Secret, composerClient *composer.Client) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if builder.Status.UpgradeStartedAt == nil {
+		now := metav1.Now()
+		builder.Status.UpgradeStartedAt = &now
+	}
+	timeout := defaultUpgradeDrainTimeout
+	if builder.Spec.UpgradeDrainTimeout != nil {
+		timeout = builder.Spec.UpgradeDrainTimeout.Duration
+	}
+	deadline := builder.Status.UpgradeStartedAt.Add(timeout)
+	// a composer that does not answer has nothing in flight worth waiting for
+	queue, err := composerClient.Queue(ctx)
+	if err == nil && len(queue.New)+len(queue.Run) > 0 && time.Now().Before(deadline) {
+		message := fmt.Sprintf("Waiting for %d composes to finish before upgrading composer to %q, until %s",
+			len(queue.New)+len(queue.Run), builder.Spec.ComposerVersion, deadline.UTC().Format(time.RFC3339))
+		logger.Info(message)
+		setBuilderCondition(builder, osbuildv1alpha1.ConditionUpgrading, metav1.ConditionTrue, osbuildv1alpha1.ReasonDraining, message)
+		setBuilderCondition(builder, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonDraining, message)
+		if err := r.Status().Update(ctx, builder); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: upgradeRequeueInterval}, nil
+	}
+
+	message := fmt.Sprintf("Recreating the builder with composer version %q", builder.Spec.ComposerVersion)
+	logger.Info(message)
+	r.Recorder.Event(builder, corev1.EventTypeNormal, osbuildv1alpha1.ReasonRollingComposer, message)
+	setBuilderCondition(builder, osbuildv1alpha1.ConditionUpgrading, metav1.ConditionTrue, osbuildv1alpha1.ReasonRollingComposer, message)
+	setBuilderCondition(builder, osbuildv1alpha1.ConditionReady, metav1.ConditionFalse, osbuildv1alpha1.ReasonRollingComposer, message)
+	// the status is recorded first, so new builds are held back before
+	// composer goes away
+	if err := r.Status().Update(ctx, builder); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.Delete(ctx, cloudConfig); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "Could not delete cloud-init secret")
+		return ctrl.Result{}, err
+	}
+	// the root disk is deleted with the VM, the new one installing composer
+	// from scratch
+	if err := r.Delete(ctx, vm, client.PropagationPolicy(metav1.DeletePropagationForeground)); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "Could not delete Image Builder VM")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: upgradeRequeueInterval}, nil
+}
+
+// composerReady marks ready a builder whose composer answered its probe,
+// ending the upgrade in progress
+func (r *ImageBuilderReconciler) composerReady(builder *osbuildv1alpha1.ImageBuilder) {
+	if builderUpgrading(builder) {
+		r.Recorder.Event(builder, corev1.EventTypeNormal, osbuildv1alpha1.ReasonUpgradeSucceeded,
+			fmt.Sprintf("Composer upgraded to version %s", builder.Status.ComposerVersion))
+		setBuilderCondition(builder, osbuildv1alpha1.ConditionUpgrading, metav1.ConditionFalse, osbuildv1alpha1.ReasonUpgradeSucceeded,
+			fmt.Sprintf("Composer runs version %s", builder.Status.ComposerVersion))
+	}
+	builder.Status.UpgradeStartedAt = nil
+	setBuilderCondition(builder, osbuildv1alpha1.ConditionReady, metav1.ConditionTrue, osbuildv1alpha1.ReasonComposerReady, "")
+}
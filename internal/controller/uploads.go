@@ -0,0 +1,370 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// edgeArtifactType is the artifact type of the OCI artifacts holding the edge
+// commit and installer of an image
+const edgeArtifactType = "application/vnd.osbuild.edge-image"
+
+// awsCLIImage copies the artifacts to S3 buckets
+const awsCLIImage = "docker.io/amazon/aws-cli:2.13.0"
+
+// uploadStepName is the step, and pipeline task, pushing to a target
+func uploadStepName(target osbuildv1alpha1.UploadTarget) string {
+	return "upload-" + target.Name
+}
+
+// uploadDigestResult is the result holding the digest pushed to a target
+func uploadDigestResult(target osbuildv1alpha1.UploadTarget) string {
+	return uploadStepName(target) + "-digest"
+}
+
+// uploadPath is the directory of a bucket or volume the artifacts of a build
+// are copied to, path defaulting to <image>/<generation>
+func uploadPath(path string, name string, generation int64) string {
+	if path == "" {
+		path = name + "/" + strconv.FormatInt(generation, 10)
+	}
+	return strings.Trim(path, "/") + "/"
+}
+
+// uploadReference is the reference the artifacts are pushed to, or the s3://
+// or pvc:// location they are copied to
+func uploadReference(target osbuildv1alpha1.UploadTarget, name string, generation int64) string {
+	switch {
+	case target.S3 != nil:
+		return "s3://" + target.S3.Bucket + "/" + uploadPath(target.S3.Prefix, name, generation)
+	case target.PVC != nil:
+		return "pvc://" + target.PVC.ClaimName + "/" + uploadPath(target.PVC.Path, name, generation)
+	}
+	tag := target.Registry.Tag
+	if tag == "" {
+		tag = strconv.FormatInt(generation, 10)
+	}
+	return target.Registry.Repository + ":" + tag
+}
+
+// copyArtifactsScript runs copy for every artifact of the build, with the
+// name of the artifact file as argument
+func copyArtifactsScript(copy string) string {
+	return `#!/bin/sh
+set -e
+cd "/workspace/shared-volume/$(params.blueprintName)"
+for file in edge-commit.tar installer.iso ${image_file}; do
+  if [ -f "${file}" ]; then
+    ` + copy + `
+  fi
+done
+`
+}
+
+// uploadStep pushes the artifacts of the build to the destination of a target
+func uploadStep(target osbuildv1alpha1.UploadTarget, name string, generation int64, composeType osbuildv1alpha1.ComposeType) tektonv1.Step {
+	switch {
+	case target.S3 != nil:
+		return s3UploadStep(target, name, generation, composeType)
+	case target.PVC != nil:
+		return pvcUploadStep(target, name, generation, composeType)
+	}
+	return registryUploadStep(target, name, generation, composeType)
+}
+
+// s3UploadStep copies the artifacts to a bucket with the AWS CLI
+func s3UploadStep(target osbuildv1alpha1.UploadTarget, name string, generation int64, composeType osbuildv1alpha1.ComposeType) tektonv1.Step {
+	flags := []string{"--only-show-errors"}
+	if target.S3.Endpoint != "" {
+		flags = append(flags, "--endpoint-url", target.S3.Endpoint)
+	}
+	env := []corev1.EnvVar{
+		{
+			Name:  "destination",
+			Value: uploadReference(target, name, generation),
+		},
+	}
+	if target.S3.Region != "" {
+		env = append(env, corev1.EnvVar{Name: "AWS_DEFAULT_REGION", Value: target.S3.Region})
+	}
+	step := tektonv1.Step{
+		Name:   uploadStepName(target),
+		Image:  awsCLIImage,
+		Script: copyArtifactsScript(`aws s3 cp ` + strings.Join(flags, " ") + ` "${file}" "${destination}${file}"`),
+		Env:    append(env, composeImageEnv(composeType)...),
+	}
+	if target.S3.CredentialsSecret != "" {
+		step.EnvFrom = []corev1.EnvFromSource{
+			{
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: target.S3.CredentialsSecret},
+				},
+			},
+		}
+	}
+	return step
+}
+
+// pvcUploadStep copies the artifacts to a directory of a volume
+func pvcUploadStep(target osbuildv1alpha1.UploadTarget, name string, generation int64, composeType osbuildv1alpha1.ComposeType) tektonv1.Step {
+	directory := "/upload/" + target.Name + "/" + uploadPath(target.PVC.Path, name, generation)
+	return tektonv1.Step{
+		Name:  uploadStepName(target),
+		Image: ubiImage,
+		Script: copyArtifactsScript(`mkdir -p "${destination}"
+    cp "${file}" "${destination}${file}"`),
+		Env: append([]corev1.EnvVar{
+			{
+				Name:  "destination",
+				Value: directory,
+			},
+		}, composeImageEnv(composeType)...),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      uploadStepName(target),
+				MountPath: "/upload/" + target.Name,
+			},
+		},
+	}
+}
+
+// registryUploadStep pushes the edge commit and installer, or the image of
+// the other compose types, of the build to a registry as a single OCI
+// artifact, annotated as described by describeArtifactsStep
+func registryUploadStep(target osbuildv1alpha1.UploadTarget, name string, generation int64, composeType osbuildv1alpha1.ComposeType) tektonv1.Step {
+	flags := []string{"--artifact-type", edgeArtifactType, "--annotation-file", "annotations.json", "--export-manifest", "/tmp/manifest.json"}
+	if target.Registry.CredentialsSecret != "" {
+		flags = append(flags, "--registry-config", "/registry-auth/"+target.Name+"/config.json")
+	}
+	if target.Registry.Insecure {
+		flags = append(flags, "--plain-http")
+	}
+	step := tektonv1.Step{
+		Name:  uploadStepName(target),
+		Image: orasImage,
+		Script: `#!/bin/sh
+set -e
+cd "/workspace/shared-volume/$(params.blueprintName)"
+files=""
+[ -f edge-commit.tar ] && files="${files} edge-commit.tar:application/x-tar"
+[ -f installer.iso ] && files="${files} installer.iso:application/x-iso9660-image"
+[ -n "${image_file}" ] && [ -f "${image_file}" ] && files="${files} ${image_file}:${image_media_type}"
+oras push ` + strings.Join(flags, " ") + ` "${reference}" ${files}
+printf 'sha256:%s' "$(sha256sum /tmp/manifest.json | cut -d' ' -f1)" | tee $(results.` + uploadDigestResult(target) + `.path)
+`,
+		Env: append([]corev1.EnvVar{
+			{
+				Name:  "reference",
+				Value: uploadReference(target, name, generation),
+			},
+		}, composeImageEnv(composeType)...),
+	}
+	if target.Registry.CredentialsSecret != "" {
+		step.VolumeMounts = []corev1.VolumeMount{
+			{
+				Name:      uploadStepName(target),
+				MountPath: "/registry-auth/" + target.Name,
+				ReadOnly:  true,
+			},
+		}
+	}
+	return step
+}
+
+// uploadVolumes mounts the registry credentials and the volumes of the
+// targets
+func uploadVolumes(targets []osbuildv1alpha1.UploadTarget) []corev1.Volume {
+	volumes := []corev1.Volume{}
+	for _, target := range targets {
+		if target.PVC != nil {
+			volumes = append(volumes, corev1.Volume{
+				Name: uploadStepName(target),
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: target.PVC.ClaimName,
+					},
+				},
+			})
+		}
+		if target.Registry == nil || target.Registry.CredentialsSecret == "" {
+			continue
+		}
+		volumes = append(volumes, corev1.Volume{
+			Name: uploadStepName(target),
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: target.Registry.CredentialsSecret,
+					Items: []corev1.KeyToPath{
+						{
+							Key:  corev1.DockerConfigJsonKey,
+							Path: "config.json",
+						},
+					},
+				},
+			},
+		})
+	}
+	return volumes
+}
+
+// addUploads pushes the artifacts to every upload target once they are
+// described. The generated pipeline pushes to the targets in parallel tasks,
+// so a failing registry does not hold back the others; the single task of an
+// ephemeral build pushes from its last steps.
+func addUploads(pipeline *tektonv1.Pipeline, targets []osbuildv1alpha1.UploadTarget, name string, generation int64, composeType osbuildv1alpha1.ComposeType, ephemeral bool) {
+	if len(targets) == 0 {
+		return
+	}
+	if ephemeral {
+		taskSpec := &pipeline.Spec.Tasks[0].TaskSpec.TaskSpec
+		for _, target := range targets {
+			taskSpec.Steps = append(taskSpec.Steps, uploadStep(target, name, generation, composeType))
+			taskSpec.Results = append(taskSpec.Results, uploadResults(target)...)
+		}
+		taskSpec.Volumes = append(taskSpec.Volumes, uploadVolumes(targets)...)
+		return
+	}
+	for _, target := range targets {
+		pipeline.Spec.Tasks = append(pipeline.Spec.Tasks, tektonv1.PipelineTask{
+			Name: uploadStepName(target),
+			TaskSpec: &tektonv1.EmbeddedTask{
+				TaskSpec: tektonv1.TaskSpec{
+					Workspaces: []tektonv1.WorkspaceDeclaration{
+						{
+							Name: "shared-volume",
+						},
+					},
+					Params: tektonv1.ParamSpecs{
+						{
+							Name: "blueprintName",
+						},
+					},
+					Steps:   []tektonv1.Step{uploadStep(target, name, generation, composeType)},
+					Results: uploadResults(target),
+					Volumes: uploadVolumes([]osbuildv1alpha1.UploadTarget{target}),
+				},
+			},
+			Workspaces: []tektonv1.WorkspacePipelineTaskBinding{
+				{
+					Name: "shared-volume",
+				},
+			},
+			Params: tektonv1.Params{
+				{
+					Name:  "blueprintName",
+					Value: *tektonv1.NewStructuredValues("$(params.blueprintName)"),
+				},
+			},
+			RunAfter: []string{artifactsTaskName},
+		})
+	}
+}
+
+// uploadResults are the results of the step pushing to a target, only
+// registries reporting a digest
+func uploadResults(target osbuildv1alpha1.UploadTarget) []tektonv1.TaskResult {
+	if target.Registry == nil {
+		return nil
+	}
+	return []tektonv1.TaskResult{{Name: uploadDigestResult(target)}}
+}
+
+// setUploadStatus reports the uploads of the current build from the steps
+// pushing to the targets
+func setUploadStatus(ctx context.Context, c client.Client, image *osbuildv1alpha1.ImageBuilderImage, pipelineRun *tektonv1.PipelineRun) error {
+	if len(image.Spec.UploadTargets) == 0 {
+		image.Status.Uploads = nil
+		return nil
+	}
+	steps, taskRuns, results, err := pipelineRunSteps(ctx, c, pipelineRun)
+	if err != nil {
+		return err
+	}
+	generation, err := strconv.ParseInt(pipelineRun.Labels[imageBuilderImageGenerationLabel], 10, 64)
+	if err != nil {
+		generation = image.Generation
+	}
+	uploads := []osbuildv1alpha1.UploadStatus{}
+	for _, target := range image.Spec.UploadTargets {
+		if target.Registry == nil && target.S3 == nil && target.PVC == nil {
+			continue
+		}
+		upload := osbuildv1alpha1.UploadStatus{
+			Name:      target.Name,
+			State:     osbuildv1alpha1.UploadPending,
+			Reference: uploadReference(target, image.Name, generation),
+		}
+		if step, ok := steps[uploadStepName(target)]; ok && step.Terminated != nil {
+			if step.Terminated.ExitCode == 0 {
+				upload.State = osbuildv1alpha1.UploadSucceeded
+				upload.URL = upload.Reference
+				if target.Registry != nil {
+					upload.Digest = results[uploadDigestResult(target)]
+					upload.URL = target.Registry.Repository + "@" + upload.Digest
+				}
+			} else {
+				upload.State = osbuildv1alpha1.UploadFailed
+				upload.Message = fmt.Sprintf("push to %s exited with %d, see the logs of step %s of TaskRun %s",
+					upload.Reference, step.Terminated.ExitCode, step.Name, taskRuns[step.Name])
+			}
+		}
+		uploads = append(uploads, upload)
+	}
+	image.Status.Uploads = uploads
+	return nil
+}
+
+// pipelineRunSteps returns the steps of the TaskRuns of a PipelineRun by
+// name, the TaskRuns running them and the results of these TaskRuns
+func pipelineRunSteps(ctx context.Context, c client.Client, pipelineRun *tektonv1.PipelineRun) (map[string]tektonv1.StepState, map[string]string, map[string]string, error) {
+	steps := map[string]tektonv1.StepState{}
+	taskRuns := map[string]string{}
+	results := map[string]string{}
+	for _, child := range pipelineRun.Status.ChildReferences {
+		if child.Kind != "TaskRun" {
+			continue
+		}
+		taskRun := tektonv1.TaskRun{}
+		if err := c.Get(ctx, client.ObjectKey{
+			Namespace: pipelineRun.Namespace,
+			Name:      child.Name,
+		}, &taskRun); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, nil, nil, err
+		}
+		for _, step := range taskRun.Status.Steps {
+			steps[step.Name] = step
+			taskRuns[step.Name] = taskRun.Name
+		}
+		for _, result := range taskRun.Status.Results {
+			results[result.Name] = strings.TrimSpace(result.Value.StringVal)
+		}
+	}
+	return steps, taskRuns, results, nil
+}
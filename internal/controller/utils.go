@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -30,18 +32,23 @@ func CreateOrUpdateObject(ctx context.Context, client client.Client, object clie
 	return nil
 }
 
-func DeleteAllObjectsWithLabel(ctx context.Context, client client.Client, kind string, apiVersion string, label string, imageName string) error {
+func DeleteAllObjectsWithLabel(ctx context.Context, c client.Client, kind string, apiVersion string, namespace string, label string, imageName string) error {
 	logger := log.FromContext(ctx)
 	u := unstructured.UnstructuredList{}
 	u.SetKind(kind)
 	u.SetAPIVersion(apiVersion)
-	if err := client.List(ctx, &u); err != nil {
+	if err := c.List(ctx, &u, client.InNamespace(namespace)); err != nil {
+		// nothing to delete when the API is not installed, e.g. Tekton
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
 		logger.Error(err, fmt.Sprintf("Could not list objects %s/%s", kind, apiVersion))
 		return err
 	}
 	for _, item := range u.Items {
 		if item.GetLabels()[label] == imageName {
-			if err := client.Delete(ctx, &item); err != nil {
+			// the pods of a Job are not deleted along with it by default
+			if err := c.Delete(ctx, &item, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
 				logger.Error(err, fmt.Sprintf("Could not delete object %s/%s", kind, item.GetName()))
 				return err
 			}
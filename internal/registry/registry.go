@@ -0,0 +1,243 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package registry resolves the tags of container images to the digests of
+// their manifests with the HTTP API of the registries
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// maxManifestSize limits the manifests read when the registry does not
+// return their digest
+const maxManifestSize = 4 * 1024 * 1024
+
+// manifestTypes are the manifests accepted, the digest of a multi-architecture
+// image being the one of its index
+var manifestTypes = strings.Join([]string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}, ", ")
+
+// challengeParamRe matches the parameters of a WWW-Authenticate challenge
+var challengeParamRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Credentials authenticate to a registry
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Reference is an image reference split in its parts
+type Reference struct {
+	// Registry is the host of the registry, docker.io for Docker Hub
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference splits an image reference, e.g. quay.io/example/app:v1. The
+// registry defaults to Docker Hub and the tag to latest.
+func ParseReference(image string) (Reference, error) {
+	reference := Reference{}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference.Digest = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, reference.Tag = name[:i], name[i+1:]
+	}
+	reference.Registry = "docker.io"
+	if first, rest, found := strings.Cut(name, "/"); found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		reference.Registry, name = first, rest
+	}
+	if reference.Registry == "docker.io" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" || strings.HasSuffix(name, "/") {
+		return Reference{}, fmt.Errorf("invalid image reference %q", image)
+	}
+	reference.Repository = name
+	if reference.Tag == "" && reference.Digest == "" {
+		reference.Tag = "latest"
+	}
+	return reference, nil
+}
+
+// Name is the reference without its tag nor digest
+func (r Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// host is the host serving the API of the registry
+func (r Reference) host() string {
+	if r.Registry == "docker.io" {
+		return "registry-1.docker.io"
+	}
+	return r.Registry
+}
+
+// Client resolves image references
+type Client struct {
+	HTTPClient *http.Client
+	// Credentials authenticate to the registry, anonymous when nil
+	Credentials *Credentials
+}
+
+// NewClient returns a client verifying the certificates of the registry
+// unless tlsVerify is false
+func NewClient(tlsVerify bool, credentials *Credentials) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !tlsVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &Client{
+		HTTPClient:  &http.Client{Transport: transport, Timeout: defaultTimeout},
+		Credentials: credentials,
+	}
+}
+
+// Resolve returns the digest of the manifest a reference points to, the
+// digest of the reference when it has one
+func (c *Client) Resolve(ctx context.Context, reference Reference) (string, error) {
+	if reference.Digest != "" {
+		return reference.Digest, nil
+	}
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", reference.host(), reference.Repository, reference.Tag)
+	authorization := ""
+	response, err := c.request(ctx, http.MethodHead, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusUnauthorized {
+		authorization, err = c.authorize(ctx, response.Header.Get("WWW-Authenticate"), reference)
+		if err != nil {
+			return "", err
+		}
+		response, err = c.request(ctx, http.MethodHead, manifestURL, authorization)
+		if err != nil {
+			return "", err
+		}
+		response.Body.Close()
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not get the manifest of %s:%s: %s", reference.Name(), reference.Tag, response.Status)
+	}
+	if digest := response.Header.Get("Docker-Content-Digest"); strings.HasPrefix(digest, "sha256:") {
+		return digest, nil
+	}
+	// the digest is the one of the manifest as served
+	response, err = c.request(ctx, http.MethodGet, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not get the manifest of %s:%s: %s", reference.Name(), reference.Tag, response.Status)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.LimitReader(response.Body, maxManifestSize)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+// request sends a request accepting the manifests
+func (c *Client) request(ctx context.Context, method string, requestURL string, authorization string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", manifestTypes)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	return c.HTTPClient.Do(request)
+}
+
+// authorize answers the challenge of a registry, with the credentials of the
+// client for Basic, or with a token pulling the repository for Bearer
+func (c *Client) authorize(ctx context.Context, challenge string, reference Reference) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.Credentials == nil {
+			return "", fmt.Errorf("registry %s needs credentials", reference.Registry)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Credentials.Username+":"+c.Credentials.Password)), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported authentication challenge %q of registry %s", challenge, reference.Registry)
+	}
+	values := map[string]string{}
+	for _, match := range challengeParamRe.FindAllStringSubmatch(params, -1) {
+		values[match[1]] = match[2]
+	}
+	tokenURL, err := url.Parse(values["realm"])
+	if err != nil || tokenURL.Scheme == "" {
+		return "", fmt.Errorf("invalid authentication realm %q of registry %s", values["realm"], reference.Registry)
+	}
+	query := tokenURL.Query()
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull", reference.Repository))
+	tokenURL.RawQuery = query.Encode()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.Credentials != nil {
+		request.SetBasicAuth(c.Credentials.Username, c.Credentials.Password)
+	}
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not get a token of registry %s: %s", reference.Registry, response.Status)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(response.Body, maxManifestSize)).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	tektonv1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxStepLogBytes bounds the log returned for every step
+const maxStepLogBytes int64 = 256 * 1024
+
+// Summary aggregates the build state of a set of images, as shown by the
+// console plugin overview
+type Summary struct {
+	Total     int            `json:"total"`
+	Ready     int            `json:"ready"`
+	Failed    int            `json:"failed"`
+	Building  int            `json:"building"`
+	Pending   int            `json:"pending"`
+	ByStage   map[string]int `json:"byStage"`
+	Namespace string         `json:"namespace,omitempty"`
+}
+
+// StepLog is the log of a single step of a build
+type StepLog struct {
+	TaskRun string `json:"taskRun"`
+	Task    string `json:"task"`
+	Step    string `json:"step"`
+	Log     string `json:"log"`
+	// Truncated is set when the log was cut at maxStepLogBytes, only its start
+	// being returned
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// GET /api/v1/summary and /api/v1/namespaces/{namespace}/summary
+func (s *Server) summary(w http.ResponseWriter, r *http.Request, namespace string) {
+	if !s.authorize(w, r, imagesAccess("list", namespace, "")) {
+		return
+	}
+	images := osbuildv1alpha1.ImageBuilderImageList{}
+	if err := s.Client.List(r.Context(), &images, client.InNamespace(namespace)); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	summary := Summary{
+		ByStage:   map[string]int{},
+		Namespace: namespace,
+	}
+	for _, image := range images.Items {
+		state := summarize(&image)
+		summary.Total++
+		switch {
+		case state.Ready:
+			summary.Ready++
+		case state.Failed:
+			summary.Failed++
+		case state.Reason == osbuildv1alpha1.ReasonBuildRunning:
+			summary.Building++
+		default:
+			summary.Pending++
+		}
+		if state.Stage != "" {
+			summary.ByStage[state.Stage]++
+		}
+	}
+	writeJSON(w, summary)
+}
+
+// GET /api/v1/namespaces/{namespace}/images/{name}/logs returns the logs of
+// every step of the current build
+func (s *Server) buildLogs(w http.ResponseWriter, r *http.Request, namespace string, name string) {
+	if !s.authorize(w, r, imagesAccess("get", namespace, name)) {
+		return
+	}
+	if !s.authorize(w, r, authorizationv1.ResourceAttributes{Namespace: namespace, Verb: "get", Resource: "pods", Subresource: "log"}) {
+		return
+	}
+	image, _, ok := s.getImage(w, r, namespace, name)
+	if !ok {
+		return
+	}
+	logs := []StepLog{}
+	if image.Status.PipelineRun == "" {
+		writeJSON(w, logs)
+		return
+	}
+	pipelineRun := tektonv1.PipelineRun{}
+	if err := s.Client.Get(r.Context(), client.ObjectKey{Namespace: namespace, Name: image.Status.PipelineRun}, &pipelineRun); err != nil {
+		if errors.IsNotFound(err) {
+			writeJSON(w, logs)
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	for _, child := range pipelineRun.Status.ChildReferences {
+		if child.Kind != "TaskRun" {
+			continue
+		}
+		taskRun := tektonv1.TaskRun{}
+		if err := s.Client.Get(r.Context(), client.ObjectKey{Namespace: namespace, Name: child.Name}, &taskRun); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if taskRun.Status.PodName == "" {
+			continue
+		}
+		for _, step := range taskRun.Status.Steps {
+			if step.Running == nil && step.Terminated == nil {
+				continue
+			}
+			entry := StepLog{
+				TaskRun: taskRun.Name,
+				Task:    child.PipelineTaskName,
+				Step:    step.Name,
+			}
+			limit := maxStepLogBytes
+			content, err := s.Clientset.CoreV1().Pods(namespace).GetLogs(taskRun.Status.PodName, &corev1.PodLogOptions{
+				Container:  step.Container,
+				LimitBytes: &limit,
+			}).DoRaw(r.Context())
+			if err != nil {
+				entry.Log = fmt.Sprintf("could not get log: %s", err)
+			} else {
+				entry.Log = string(content)
+				entry.Truncated = int64(len(content)) >= maxStepLogBytes
+			}
+			logs = append(logs, entry)
+		}
+	}
+	writeJSON(w, logs)
+}
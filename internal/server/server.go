@@ -0,0 +1,493 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	osbuildv1alpha1 "github.com/kwozyman/osbuild-operator/api/v1alpha1"
+	"github.com/kwozyman/osbuild-operator/internal/controller"
+	routev1 "github.com/openshift/api/route/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get
+//+kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+
+// Server is a read only HTTP API listing images, their builds and artifacts,
+// so dashboards and CI can integrate without access to the Kubernetes API
+type Server struct {
+	Client client.Client
+	// Clientset is used to read build logs
+	Clientset kubernetes.Interface
+	// Address the API listens on
+	Address string
+	// TokenFile holds the accepted bearer tokens, one per line
+	TokenFile string
+	// NameTemplate must match the one used by the ImageBuilderImage controller
+	NameTemplate string
+	// KubernetesAuth also accepts Kubernetes tokens, such as the ones
+	// forwarded by the OpenShift console plugin proxy, each endpoint checking
+	// the user is allowed to read what it returns
+	KubernetesAuth bool
+
+	tokens []string
+}
+
+// ImageSummary describes an ImageBuilderImage and the state of its build
+type ImageSummary struct {
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	Generation    int64  `json:"generation"`
+	Ready         bool   `json:"ready"`
+	Failed        bool   `json:"failed"`
+	Reason        string `json:"reason,omitempty"`
+	Message       string `json:"message,omitempty"`
+	Stage         string `json:"stage,omitempty"`
+	Progress      int32  `json:"progress"`
+	PipelineRun   string `json:"pipelineRun,omitempty"`
+	BlueprintHash string `json:"blueprintHash,omitempty"`
+}
+
+// Build is an entry of the build history of an image
+type Build struct {
+	Generation         string    `json:"generation"`
+	PipelineRun        string    `json:"pipelineRun"`
+	BlueprintHash      string    `json:"blueprintHash,omitempty"`
+	BlueprintConfigMap string    `json:"blueprintConfigMap,omitempty"`
+	BlueprintSecret    string    `json:"blueprintSecret,omitempty"`
+	Created            time.Time `json:"created"`
+}
+
+// Artifact is a file produced by the build of an image
+type Artifact struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	MediaType   string `json:"mediaType,omitempty"`
+	ComposeType string `json:"composeType,omitempty"`
+	ComposeID   string `json:"composeID,omitempty"`
+	Digest      string `json:"digest,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	// URL is the public location of the artifact, when the image has a Route
+	URL string `json:"url,omitempty"`
+	// Download is the API path proxying the artifact, empty when it was not
+	// kept after the build
+	Download string `json:"download,omitempty"`
+}
+
+// ImageDetails is the full view of an image
+type ImageDetails struct {
+	ImageSummary
+	Builds    []Build    `json:"builds"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// NeedLeaderElection lets every replica serve the API
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the API until the context is cancelled
+func (s *Server) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("api")
+	if s.TokenFile != "" {
+		tokens, err := os.ReadFile(s.TokenFile)
+		if err != nil {
+			return fmt.Errorf("could not read API tokens: %w", err)
+		}
+		for _, token := range strings.Split(string(tokens), "\n") {
+			if token = strings.TrimSpace(token); token != "" {
+				s.tokens = append(s.tokens, token)
+			}
+		}
+	}
+	if len(s.tokens) == 0 && !s.KubernetesAuth {
+		return fmt.Errorf("no API tokens found in %s", s.TokenFile)
+	}
+
+	server := &http.Server{
+		Addr:              s.Address,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error(err, "Could not shut down API server")
+		}
+	}()
+	logger.Info(fmt.Sprintf("Serving API on %s", s.Address))
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Handler returns the authenticated API handler
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/images", s.listImages)
+	mux.HandleFunc("/api/v1/summary", func(w http.ResponseWriter, r *http.Request) {
+		s.summary(w, r, "")
+	})
+	mux.HandleFunc("/api/v1/namespaces/", s.namespaced)
+	return s.authenticate(mux)
+}
+
+// reviewedUser is the context key of the user a Kubernetes token belongs to,
+// unset for the static API tokens which are allowed everything
+type reviewedUser struct{}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			writeError(w, http.StatusUnauthorized, "a valid bearer token is required")
+			return
+		}
+		for _, accepted := range s.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(accepted)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if s.KubernetesAuth {
+			review := authenticationv1.TokenReview{
+				Spec: authenticationv1.TokenReviewSpec{
+					Token: token,
+				},
+			}
+			if err := s.Client.Create(r.Context(), &review); err != nil {
+				log.FromContext(r.Context()).Error(err, "Could not review API token")
+				writeError(w, http.StatusInternalServerError, "could not review token")
+				return
+			}
+			if review.Status.Authenticated {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), reviewedUser{}, review.Status.User)))
+				return
+			}
+		}
+		writeError(w, http.StatusUnauthorized, "a valid bearer token is required")
+	})
+}
+
+// authorize checks the user of a Kubernetes token is allowed the access an
+// endpoint needs, writing the error response when it is not
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, attributes authorizationv1.ResourceAttributes) bool {
+	user, found := r.Context().Value(reviewedUser{}).(authenticationv1.UserInfo)
+	if !found {
+		return true
+	}
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, values := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(values)
+	}
+	access := authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:               user.Username,
+			UID:                user.UID,
+			Groups:             user.Groups,
+			Extra:              extra,
+			ResourceAttributes: &attributes,
+		},
+	}
+	if err := s.Client.Create(r.Context(), &access); err != nil {
+		log.FromContext(r.Context()).Error(err, "Could not review API access")
+		writeError(w, http.StatusInternalServerError, "could not review access")
+		return false
+	}
+	if !access.Status.Allowed {
+		resource := attributes.Resource
+		if attributes.Subresource != "" {
+			resource = fmt.Sprintf("%s/%s", resource, attributes.Subresource)
+		}
+		if attributes.Namespace == "" {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("token is not allowed to %s %s", attributes.Verb, resource))
+		} else {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("token is not allowed to %s %s in namespace %s", attributes.Verb, resource, attributes.Namespace))
+		}
+		return false
+	}
+	return true
+}
+
+// imagesAccess is the access to the ImageBuilderImages of a namespace, or of
+// all namespaces, needed to read them
+func imagesAccess(verb string, namespace string, name string) authorizationv1.ResourceAttributes {
+	return authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      verb,
+		Group:     osbuildv1alpha1.GroupVersion.Group,
+		Resource:  "imagebuilderimages",
+		Name:      name,
+	}
+}
+
+// GET /api/v1/images lists the images of all namespaces
+func (s *Server) listImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	if !s.authorize(w, r, imagesAccess("list", "", "")) {
+		return
+	}
+	images := osbuildv1alpha1.ImageBuilderImageList{}
+	if err := s.Client.List(r.Context(), &images); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	summaries := []ImageSummary{}
+	for _, image := range images.Items {
+		summaries = append(summaries, summarize(&image))
+	}
+	writeJSON(w, summaries)
+}
+
+// namespaced routes the per image endpoints:
+//
+//	GET /api/v1/namespaces/{namespace}/images
+//	GET /api/v1/namespaces/{namespace}/images/{name}
+//	GET /api/v1/namespaces/{namespace}/summary
+//	GET /api/v1/namespaces/{namespace}/export
+//	GET /api/v1/namespaces/{namespace}/images/{name}/logs
+//	GET /api/v1/namespaces/{namespace}/images/{name}/artifacts/{path}
+func (s *Server) namespaced(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/", 5)
+	switch {
+	case len(parts) == 2 && parts[1] == "images":
+		if !s.authorize(w, r, imagesAccess("list", parts[0], "")) {
+			return
+		}
+		images := osbuildv1alpha1.ImageBuilderImageList{}
+		if err := s.Client.List(r.Context(), &images, client.InNamespace(parts[0])); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		summaries := []ImageSummary{}
+		for _, image := range images.Items {
+			summaries = append(summaries, summarize(&image))
+		}
+		writeJSON(w, summaries)
+	case len(parts) == 2 && parts[1] == "summary":
+		s.summary(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "export":
+		if !s.authorize(w, r, authorizationv1.ResourceAttributes{Namespace: parts[0], Verb: "get", Resource: "configmaps"}) {
+			return
+		}
+		bundle, err := controller.Export(r.Context(), s.Client, parts[0])
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, bundle)
+	case len(parts) == 3 && parts[1] == "images":
+		s.imageDetails(w, r, parts[0], parts[2])
+	case len(parts) == 4 && parts[1] == "images" && parts[3] == "logs":
+		s.buildLogs(w, r, parts[0], parts[2])
+	case len(parts) == 5 && parts[1] == "images" && parts[3] == "artifacts":
+		s.downloadArtifact(w, r, parts[0], parts[2], parts[4])
+	default:
+		writeError(w, http.StatusNotFound, "unknown endpoint")
+	}
+}
+
+func (s *Server) getImage(w http.ResponseWriter, r *http.Request, namespace string, name string) (*osbuildv1alpha1.ImageBuilderImage, controller.GeneratedNames, bool) {
+	image := osbuildv1alpha1.ImageBuilderImage{}
+	if err := s.Client.Get(r.Context(), client.ObjectKey{Namespace: namespace, Name: name}, &image); err != nil {
+		if errors.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("image %s/%s not found", namespace, name))
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, controller.GeneratedNames{}, false
+	}
+	names, err := controller.GenerateNames(s.NameTemplate, image.Name, image.Namespace, image.Generation)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return nil, controller.GeneratedNames{}, false
+	}
+	return &image, names, true
+}
+
+func (s *Server) imageDetails(w http.ResponseWriter, r *http.Request, namespace string, name string) {
+	if !s.authorize(w, r, imagesAccess("get", namespace, name)) {
+		return
+	}
+	image, names, ok := s.getImage(w, r, namespace, name)
+	if !ok {
+		return
+	}
+	details := ImageDetails{
+		ImageSummary: summarize(image),
+		Builds:       []Build{},
+		Artifacts:    []Artifact{},
+	}
+
+	records, err := controller.BuildRecords(r.Context(), s.Client, namespace, name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, record := range records {
+		details.Builds = append(details.Builds, Build{
+			Generation:         record.Data["generation"],
+			PipelineRun:        record.Data["pipelineRun"],
+			BlueprintHash:      record.Data["blueprintHash"],
+			BlueprintConfigMap: record.Data["blueprintConfigMap"],
+			BlueprintSecret:    record.Data["blueprintSecret"],
+			Created:            record.CreationTimestamp.Time,
+		})
+	}
+
+	host := ""
+	route := routev1.Route{}
+	if err := s.Client.Get(r.Context(), client.ObjectKey{Namespace: namespace, Name: names.WebRoute}, &route); err == nil {
+		host = route.Spec.Host
+	} else if !errors.IsNotFound(err) {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, artifact := range controller.Artifacts {
+		entry := Artifact{
+			Name:     strings.TrimSuffix(artifact, "/"),
+			Download: fmt.Sprintf("/api/v1/namespaces/%s/images/%s/artifacts/%s", namespace, name, artifact),
+		}
+		if host != "" {
+			entry.URL = fmt.Sprintf("http://%s/%s", host, artifact)
+		}
+		details.Artifacts = append(details.Artifacts, entry)
+	}
+	// the files described by the last successful build
+	for _, artifact := range image.Status.Artifacts {
+		entry := Artifact{
+			Name:        artifact.Name,
+			Type:        string(artifact.Type),
+			MediaType:   artifact.MediaType,
+			ComposeType: artifact.ComposeType,
+			ComposeID:   artifact.ComposeID,
+			Digest:      artifact.Digest,
+			Size:        artifact.Size,
+		}
+		if artifact.Location != "" {
+			entry.Download = fmt.Sprintf("/api/v1/namespaces/%s/images/%s/artifacts%s", namespace, name, artifact.Location)
+			if host != "" {
+				entry.URL = fmt.Sprintf("http://%s%s", host, artifact.Location)
+			}
+		}
+		replaced := false
+		for i := range details.Artifacts {
+			if details.Artifacts[i].Name == entry.Name {
+				details.Artifacts[i] = entry
+				replaced = true
+			}
+		}
+		if !replaced {
+			details.Artifacts = append(details.Artifacts, entry)
+		}
+	}
+	writeJSON(w, details)
+}
+
+// downloadArtifact proxies the request to the web Service of the image
+func (s *Server) downloadArtifact(w http.ResponseWriter, r *http.Request, namespace string, name string, artifact string) {
+	artifact = path.Clean("/" + artifact)
+	image, names, ok := s.getImage(w, r, namespace, name)
+	if !ok {
+		return
+	}
+	// the artifacts are files of the shared volume of the image
+	if !s.authorize(w, r, authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      "get",
+		Resource:  "persistentvolumeclaims",
+		Name:      controller.SharedVolumeName(image),
+	}) {
+		return
+	}
+	target := &url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s.%s.svc:%d", names.WebService, namespace, controller.WebServicePort),
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(request *http.Request) {
+			request.URL.Scheme = target.Scheme
+			request.URL.Host = target.Host
+			request.URL.Path = artifact
+			request.URL.RawQuery = ""
+			request.Host = target.Host
+			request.Header.Del("Authorization")
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func summarize(image *osbuildv1alpha1.ImageBuilderImage) ImageSummary {
+	summary := ImageSummary{
+		Namespace:     image.Namespace,
+		Name:          image.Name,
+		Generation:    image.Generation,
+		Stage:         string(image.Status.Stage),
+		Progress:      image.Status.Progress,
+		PipelineRun:   image.Status.PipelineRun,
+		BlueprintHash: image.Status.BlueprintHash,
+	}
+	if ready := meta.FindStatusCondition(image.Status.Conditions, osbuildv1alpha1.ConditionReady); ready != nil {
+		summary.Ready = ready.Status == "True"
+		summary.Reason = ready.Reason
+		summary.Message = ready.Message
+	}
+	summary.Failed = meta.IsStatusConditionTrue(image.Status.Conditions, osbuildv1alpha1.ConditionFailed)
+	return summary
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,651 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package composertest provides an in-memory osbuild-composer API server, so
+// reconcile tests can run the generated pipelines, or talk to composer
+// directly, without a real builder.
+//
+// The server implements the parts of the weldr API used by the operator under
+// /api/v1 and the compose endpoints of the cloud API under
+// /api/image-builder-composer/v2. Composes start in the WAITING state and move
+// forward when the test calls Advance or SetStatus, or on every status query
+// when AutoAdvance is set.
+package composertest
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the composer build reported by the status endpoint
+const Version = "composertest"
+
+// ComposeTypes are the compose types reported as enabled
+var ComposeTypes = []string{"ami", "edge-commit", "edge-container", "edge-installer", "edge-simplified-installer",
+	"gce", "image-installer", "openstack", "qcow2", "vhd", "vmdk"}
+
+// ComposeStatus is the queue status of a compose, as reported by weldr
+type ComposeStatus string
+
+const (
+	StatusWaiting  ComposeStatus = "WAITING"
+	StatusRunning  ComposeStatus = "RUNNING"
+	StatusFinished ComposeStatus = "FINISHED"
+	StatusFailed   ComposeStatus = "FAILED"
+)
+
+// Compose is a compose known to the server
+type Compose struct {
+	ID            string
+	BlueprintName string
+	ComposeType   string
+	Status        ComposeStatus
+	// Artifact is served by the image endpoint once the compose is finished
+	Artifact []byte
+	Created  time.Time
+	// Cancelled is set when the compose was cancelled through the API, which
+	// fails it and keeps it until it is deleted
+	Cancelled bool
+	// Deleted is set when the compose was deleted through the API
+	Deleted bool
+	// Upload is the upload of the image to a cloud requested with the
+	// compose, which follows the status of the compose
+	Upload *Upload
+}
+
+// Upload is the upload of the image of a compose to a cloud
+type Upload struct {
+	Provider  string
+	ImageName string
+	Region    string
+}
+
+// Server is a fake composer API server
+type Server struct {
+	*httptest.Server
+
+	// AutoAdvance moves every compose one state forward each time the queue
+	// or the status of a compose is queried
+	AutoAdvance bool
+	// FailComposes makes composes end in FAILED instead of FINISHED
+	FailComposes bool
+	// Artifacts overrides the artifact of the composes of a type, by default
+	// edge-commit composes produce a small tarball with an ostree repository
+	// layout and the other types a few bytes naming the compose
+	Artifacts map[string][]byte
+
+	mu         sync.Mutex
+	blueprints map[string]string
+	composes   map[string]*Compose
+}
+
+var blueprintNameRe = regexp.MustCompile(`(?m)^\s*name\s*=\s*"([^"]+)"`)
+
+// NewServer starts a fake composer, stop it with Close
+func NewServer() *Server {
+	s := &Server{
+		Artifacts:  map[string][]byte{},
+		blueprints: map[string]string{},
+		composes:   map[string]*Compose{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", s.handleAPIStatus)
+	mux.HandleFunc("/api/v1/blueprints/new", s.handleNewBlueprint)
+	mux.HandleFunc("/api/v1/blueprints/list", s.handleBlueprintList)
+	mux.HandleFunc("/api/v1/blueprints/info/", s.handleBlueprintInfo)
+	mux.HandleFunc("/api/v1/compose", s.handleCompose)
+	mux.HandleFunc("/api/v1/compose/queue", s.handleQueue)
+	mux.HandleFunc("/api/v1/compose/types", s.handleComposeTypes)
+	mux.HandleFunc("/api/v1/compose/finished", s.handleList(StatusFinished, "finished"))
+	mux.HandleFunc("/api/v1/compose/failed", s.handleList(StatusFailed, "failed"))
+	mux.HandleFunc("/api/v1/compose/status/", s.handleStatus)
+	mux.HandleFunc("/api/v1/compose/info/", s.handleComposeInfo)
+	mux.HandleFunc("/api/v1/compose/image/", s.handleImage)
+	mux.HandleFunc("/api/v1/compose/logs/", s.handleLogs)
+	mux.HandleFunc("/api/v1/compose/log/", s.handleLog)
+	mux.HandleFunc("/api/v1/compose/cancel/", s.handleCancel)
+	mux.HandleFunc("/api/v1/compose/delete/", s.handleDelete)
+	mux.HandleFunc("/api/image-builder-composer/v2/compose", s.handleCloudCompose)
+	mux.HandleFunc("/api/image-builder-composer/v2/composes/", s.handleCloudStatus)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// APIEndpoint returns the weldr API endpoint, as passed to the pipelines
+func (s *Server) APIEndpoint() string {
+	return s.URL + "/api/v1"
+}
+
+// Blueprint returns the last TOML pushed for a blueprint
+func (s *Server) Blueprint(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blueprint, ok := s.blueprints[name]
+	return blueprint, ok
+}
+
+// Composes returns a copy of every compose, oldest first
+func (s *Server) Composes() []Compose {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	composes := []Compose{}
+	for _, compose := range s.composes {
+		composes = append(composes, *compose)
+	}
+	sort.Slice(composes, func(i, j int) bool {
+		return composes[i].Created.Before(composes[j].Created)
+	})
+	return composes
+}
+
+// SetStatus forces the status of a compose
+func (s *Server) SetStatus(id string, status ComposeStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	compose, ok := s.composes[id]
+	if !ok {
+		return fmt.Errorf("unknown compose %s", id)
+	}
+	compose.Status = status
+	return nil
+}
+
+// Advance moves every compose that is not done one state forward
+func (s *Server) Advance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+}
+
+func (s *Server) advance() {
+	for _, compose := range s.composes {
+		switch compose.Status {
+		case StatusWaiting:
+			compose.Status = StatusRunning
+		case StatusRunning:
+			compose.Status = StatusFinished
+			if s.FailComposes {
+				compose.Status = StatusFailed
+			}
+		}
+	}
+}
+
+func (s *Server) handleNewBlueprint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		weldrError(w, http.StatusMethodNotAllowed, "HTTPError", "method not allowed")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		weldrError(w, http.StatusBadRequest, "BlueprintsError", err.Error())
+		return
+	}
+	match := blueprintNameRe.FindSubmatch(body)
+	if match == nil {
+		weldrError(w, http.StatusBadRequest, "BlueprintsError", "blueprint has no name")
+		return
+	}
+	s.mu.Lock()
+	s.blueprints[string(match[1])] = string(body)
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"status": true})
+}
+
+func (s *Server) handleBlueprintList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	names := []string{}
+	for name := range s.blueprints {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+	total := len(names)
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset > total {
+		offset = total
+	}
+	names = names[offset:]
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit < len(names) {
+		names = names[:limit]
+	}
+	writeJSON(w, map[string]interface{}{
+		"blueprints": names,
+		"total":      total,
+		"offset":     offset,
+		"limit":      len(names),
+	})
+}
+
+func (s *Server) handleBlueprintInfo(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/blueprints/info/")
+	blueprint, ok := s.Blueprint(name)
+	if !ok {
+		weldrError(w, http.StatusBadRequest, "UnknownBlueprint", name+": blueprint not found")
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-toml")
+	io.WriteString(w, blueprint)
+}
+
+func (s *Server) handleCompose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		weldrError(w, http.StatusMethodNotAllowed, "HTTPError", "method not allowed")
+		return
+	}
+	request := struct {
+		BlueprintName string `json:"blueprint_name"`
+		ComposeType   string `json:"compose_type"`
+		Upload        *struct {
+			ImageName string            `json:"image_name"`
+			Provider  string            `json:"provider"`
+			Settings  map[string]string `json:"settings"`
+		} `json:"upload"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		weldrError(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	if _, ok := s.Blueprint(request.BlueprintName); !ok {
+		weldrError(w, http.StatusBadRequest, "UnknownBlueprint", request.BlueprintName+": blueprint not found")
+		return
+	}
+	if request.ComposeType == "" {
+		weldrError(w, http.StatusBadRequest, "UnknownComposeType", "compose_type is required")
+		return
+	}
+	compose := s.newCompose(request.BlueprintName, request.ComposeType)
+	if upload := request.Upload; upload != nil {
+		s.setUpload(compose, &Upload{Provider: upload.Provider, ImageName: upload.ImageName, Region: upload.Settings["region"]})
+	}
+	writeJSON(w, map[string]interface{}{"build_id": compose.ID, "status": true})
+}
+
+func (s *Server) newCompose(blueprintName, composeType string) *Compose {
+	id := make([]byte, 16)
+	rand.Read(id)
+	compose := &Compose{
+		ID:            fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+		BlueprintName: blueprintName,
+		ComposeType:   composeType,
+		Status:        StatusWaiting,
+		Created:       time.Now(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	compose.Artifact = s.Artifacts[composeType]
+	if compose.Artifact == nil {
+		compose.Artifact = defaultArtifact(compose)
+	}
+	s.composes[compose.ID] = compose
+	return compose
+}
+
+func (s *Server) handleComposeTypes(w http.ResponseWriter, r *http.Request) {
+	types := []map[string]interface{}{}
+	for _, name := range ComposeTypes {
+		types = append(types, map[string]interface{}{"name": name, "enabled": true})
+	}
+	writeJSON(w, map[string]interface{}{"types": types})
+}
+
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.AutoAdvance {
+		s.advance()
+	}
+	writeJSON(w, map[string]interface{}{
+		"new": s.composeInfo(StatusWaiting),
+		"run": s.composeInfo(StatusRunning),
+	})
+}
+
+func (s *Server) handleList(status ComposeStatus, key string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		writeJSON(w, map[string]interface{}{key: s.composeInfo(status)})
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/compose/status/"), ",")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.AutoAdvance {
+		s.advance()
+	}
+	uuids := []map[string]interface{}{}
+	for _, id := range ids {
+		if compose, ok := s.composes[id]; ok && !compose.Deleted {
+			uuids = append(uuids, info(compose))
+		}
+	}
+	writeJSON(w, map[string]interface{}{"uuids": uuids})
+}
+
+func (s *Server) handleComposeInfo(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/compose/info/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	compose, ok := s.composes[id]
+	if !ok || compose.Deleted {
+		weldrError(w, http.StatusBadRequest, "UnknownUUID", id+" is not a valid build uuid")
+		return
+	}
+	body := info(compose)
+	uploads := []map[string]interface{}{}
+	if upload := compose.Upload; upload != nil {
+		uploads = append(uploads, map[string]interface{}{
+			"uuid":          compose.ID,
+			"status":        compose.Status,
+			"provider_name": upload.Provider,
+			"image_name":    upload.ImageName,
+			"settings":      map[string]string{"region": upload.Region},
+		})
+	}
+	body["uploads"] = uploads
+	body["deps"] = map[string]interface{}{
+		"packages": []map[string]string{
+			{"name": "rpm-ostree", "version": "2023.1", "release": "1.el9", "arch": "x86_64"},
+		},
+	}
+	writeJSON(w, body)
+}
+
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/compose/image/")
+	s.mu.Lock()
+	compose, ok := s.composes[id]
+	s.mu.Unlock()
+	if !ok || compose.Deleted {
+		weldrError(w, http.StatusBadRequest, "UnknownUUID", id+" is not a valid build uuid")
+		return
+	}
+	if compose.Status != StatusFinished {
+		weldrError(w, http.StatusBadRequest, "BuildInWrongState", fmt.Sprintf("Build %s is in wrong state: %s", id, compose.Status))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(compose.Artifact)
+}
+
+func (s *Server) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"api":            "1",
+		"backend":        "osbuild-composer",
+		"build":          Version,
+		"db_supported":   true,
+		"db_version":     "0",
+		"schema_version": "0",
+		"msgs":           []string{},
+	})
+}
+
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/compose/logs/")
+	s.mu.Lock()
+	compose, ok := s.composes[id]
+	s.mu.Unlock()
+	if !ok || compose.Deleted {
+		weldrError(w, http.StatusBadRequest, "UnknownUUID", id+" is not a valid build uuid")
+		return
+	}
+	if compose.Status != StatusFinished && compose.Status != StatusFailed {
+		weldrError(w, http.StatusBadRequest, "BuildInWrongState", fmt.Sprintf("Build %s is in wrong state: %s", id, compose.Status))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	fmt.Fprintf(w, "logs of compose %s\n", id)
+}
+
+func (s *Server) handleLog(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/compose/log/")
+	s.mu.Lock()
+	compose, ok := s.composes[id]
+	s.mu.Unlock()
+	if !ok || compose.Deleted {
+		weldrError(w, http.StatusBadRequest, "UnknownUUID", id+" is not a valid build uuid")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	switch compose.Status {
+	case StatusFailed:
+		fmt.Fprintf(w, "osbuild failed to build compose %s of blueprint %s\n", id, compose.BlueprintName)
+	case StatusFinished:
+		fmt.Fprintf(w, "osbuild built compose %s of blueprint %s\n", id, compose.BlueprintName)
+	default:
+		fmt.Fprintf(w, "Build %s in progress\n", id)
+	}
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		weldrError(w, http.StatusMethodNotAllowed, "HTTPError", "method not allowed")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/compose/cancel/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	compose, ok := s.composes[id]
+	if !ok || compose.Deleted {
+		weldrError(w, http.StatusBadRequest, "UnknownUUID", id+" is not a valid build uuid")
+		return
+	}
+	if compose.Status != StatusWaiting && compose.Status != StatusRunning {
+		weldrError(w, http.StatusBadRequest, "BuildInWrongState", "Build "+id+" is not in WAITING or RUNNING.")
+		return
+	}
+	compose.Cancelled = true
+	compose.Status = StatusFailed
+	writeJSON(w, map[string]interface{}{"uuid": id, "status": true})
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		weldrError(w, http.StatusMethodNotAllowed, "HTTPError", "method not allowed")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/compose/delete/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uuids := []map[string]interface{}{}
+	errors := []map[string]interface{}{}
+	for _, id := range strings.Split(path, ",") {
+		compose, ok := s.composes[id]
+		if !ok || compose.Deleted {
+			errors = append(errors, map[string]interface{}{"id": "UnknownUUID", "msg": id + " is not a valid build uuid"})
+			continue
+		}
+		compose.Deleted = true
+		if compose.Status == StatusWaiting || compose.Status == StatusRunning {
+			compose.Status = StatusFailed
+		}
+		uuids = append(uuids, map[string]interface{}{"uuid": id, "status": true})
+	}
+	writeJSON(w, map[string]interface{}{"uuids": uuids, "errors": errors})
+}
+
+func (s *Server) handleCloudCompose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		cloudError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	request := struct {
+		ImageRequest struct {
+			ImageType     string `json:"image_type"`
+			UploadTargets []struct {
+				Type          string `json:"type"`
+				UploadOptions struct {
+					Region    string `json:"region"`
+					ImageName string `json:"image_name"`
+				} `json:"upload_options"`
+			} `json:"upload_targets"`
+		} `json:"image_request"`
+		Customizations json.RawMessage `json:"customizations"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		cloudError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.ImageRequest.ImageType == "" {
+		cloudError(w, http.StatusBadRequest, "image_request.image_type is required")
+		return
+	}
+	compose := s.newCompose("", request.ImageRequest.ImageType)
+	for _, target := range request.ImageRequest.UploadTargets {
+		if target.Type != "local" {
+			s.setUpload(compose, &Upload{Provider: target.Type, ImageName: target.UploadOptions.ImageName, Region: target.UploadOptions.Region})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, map[string]interface{}{"href": "/api/image-builder-composer/v2/compose", "id": compose.ID, "kind": "ComposeId"})
+}
+
+func (s *Server) handleCloudStatus(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/image-builder-composer/v2/composes/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.AutoAdvance {
+		s.advance()
+	}
+	compose, ok := s.composes[id]
+	if !ok || compose.Deleted {
+		cloudError(w, http.StatusNotFound, "compose "+id+" not found")
+		return
+	}
+	status, imageStatus := "pending", "pending"
+	switch compose.Status {
+	case StatusRunning:
+		imageStatus = "building"
+	case StatusFinished:
+		status, imageStatus = "success", "success"
+	case StatusFailed:
+		status, imageStatus = "failure", "failure"
+	}
+	uploadStatuses := []map[string]interface{}{}
+	if upload := compose.Upload; upload != nil {
+		uploadStatus := map[string]string{"success": "success", "failure": "failure"}[imageStatus]
+		if uploadStatus == "" {
+			uploadStatus = "pending"
+		}
+		options := map[string]string{"region": upload.Region, "image_name": upload.ImageName}
+		if upload.Provider == "aws" {
+			options = map[string]string{"region": upload.Region, "ami": "ami-" + strings.ReplaceAll(compose.ID, "-", "")[:17]}
+		}
+		uploadStatuses = append(uploadStatuses, map[string]interface{}{"status": uploadStatus, "type": upload.Provider, "options": options})
+	}
+	writeJSON(w, map[string]interface{}{
+		"href":         r.URL.Path,
+		"id":           compose.ID,
+		"kind":         "ComposeStatus",
+		"status":       status,
+		"image_status": map[string]interface{}{"status": imageStatus, "upload_statuses": uploadStatuses},
+	})
+}
+
+// setUpload records the upload requested with a compose
+func (s *Server) setUpload(compose *Compose, upload *Upload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	compose.Upload = upload
+}
+
+// composeInfo lists the composes in a state, oldest first
+func (s *Server) composeInfo(status ComposeStatus) []map[string]interface{} {
+	composes := []*Compose{}
+	for _, compose := range s.composes {
+		if compose.Status == status && !compose.Deleted {
+			composes = append(composes, compose)
+		}
+	}
+	sort.Slice(composes, func(i, j int) bool {
+		return composes[i].Created.Before(composes[j].Created)
+	})
+	infos := []map[string]interface{}{}
+	for _, compose := range composes {
+		infos = append(infos, info(compose))
+	}
+	return infos
+}
+
+func info(compose *Compose) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           compose.ID,
+		"blueprint":    compose.BlueprintName,
+		"version":      "0.0.1",
+		"compose_type": compose.ComposeType,
+		"image_size":   len(compose.Artifact),
+		"queue_status": compose.Status,
+		"job_created":  float64(compose.Created.UnixNano()) / 1e9,
+	}
+}
+
+// defaultArtifact returns the artifact of a compose without configured one
+func defaultArtifact(compose *Compose) []byte {
+	if compose.ComposeType != "edge-commit" {
+		return []byte(fmt.Sprintf("%s image of compose %s\n", compose.ComposeType, compose.ID))
+	}
+	var buffer bytes.Buffer
+	archive := tar.NewWriter(&buffer)
+	files := map[string]string{
+		"compose.json": fmt.Sprintf(`{"ref": "rhel/9/x86_64/edge", "ostree-commit": "%s"}`, strings.ReplaceAll(compose.ID, "-", "")),
+		"repo/config":  "[core]\nrepo_version=1\nmode=archive-z2\n",
+	}
+	names := []string{"compose.json", "repo/config"}
+	for _, name := range names {
+		archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: compose.Created})
+		archive.Write([]byte(files[name]))
+	}
+	archive.Close()
+	return buffer.Bytes()
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+func weldrError(w http.ResponseWriter, code int, id, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	writeJSON(w, map[string]interface{}{
+		"status": false,
+		"errors": []map[string]string{{"id": id, "msg": message}},
+	})
+}
+
+func cloudError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	writeJSON(w, map[string]interface{}{"kind": "Error", "reason": message})
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package composertest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var composer *Server
+
+	call := func(method string, path string, body string) (int, map[string]interface{}) {
+		request, err := http.NewRequest(method, composer.APIEndpoint()+path, strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		defer response.Body.Close()
+		result := map[string]interface{}{}
+		Expect(json.NewDecoder(response.Body).Decode(&result)).To(Succeed())
+		return response.StatusCode, result
+	}
+
+	startCompose := func() string {
+		status, _ := call(http.MethodPost, "/blueprints/new", `name = "edge"`)
+		Expect(status).To(Equal(http.StatusOK))
+		status, result := call(http.MethodPost, "/compose", `{"blueprint_name": "edge", "compose_type": "edge-commit"}`)
+		Expect(status).To(Equal(http.StatusOK))
+		return result["build_id"].(string)
+	}
+
+	BeforeEach(func() {
+		composer = NewServer()
+		DeferCleanup(composer.Close)
+	})
+
+	It("stores the pushed blueprints", func() {
+		startCompose()
+		blueprint, ok := composer.Blueprint("edge")
+		Expect(ok).To(BeTrue())
+		Expect(blueprint).To(Equal(`name = "edge"`))
+	})
+
+	It("rejects composes of unknown blueprints", func() {
+		status, result := call(http.MethodPost, "/compose", `{"blueprint_name": "other", "compose_type": "edge-commit"}`)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(result["status"]).To(BeFalse())
+	})
+
+	It("advances composes until they are finished", func() {
+		id := startCompose()
+		Expect(composer.Composes()[0].Status).To(Equal(StatusWaiting))
+		composer.Advance()
+		Expect(composer.Composes()[0].Status).To(Equal(StatusRunning))
+		composer.Advance()
+		Expect(composer.Composes()[0].Status).To(Equal(StatusFinished))
+		Expect(composer.Composes()[0].ID).To(Equal(id))
+	})
+
+	It("fails cancelled composes and keeps them until they are deleted", func() {
+		id := startCompose()
+		status, _ := call(http.MethodDelete, "/compose/cancel/"+id, "")
+		Expect(status).To(Equal(http.StatusOK))
+		compose := composer.Composes()[0]
+		Expect(compose.Cancelled).To(BeTrue())
+		Expect(compose.Status).To(Equal(StatusFailed))
+		Expect(compose.Deleted).To(BeFalse())
+
+		status, result := call(http.MethodDelete, "/compose/cancel/"+id, "")
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(result["status"]).To(BeFalse())
+
+		status, _ = call(http.MethodDelete, "/compose/delete/"+id, "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(composer.Composes()[0].Deleted).To(BeTrue())
+	})
+})